@@ -0,0 +1,45 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/copium-dev/copium/algolia-consumer/inits"
+	"github.com/copium-dev/copium/algolia-consumer/job"
+	"github.com/copium-dev/copium/shared/config"
+	"github.com/copium-dev/copium/shared/consumer"
+)
+
+// indexes applications in Algolia until ctx is done; in-flight jobs then get until drain is done.
+// what main.go runs, and what dev/ runs next to the API and the BigQuery consumer
+func Run(ctx context.Context, drain context.Context, cfg *config.Config) error {
+	// create algolia client (shared across workers)
+	algoliaClient, err := inits.InitializeAlgoliaClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize algolia client: %w", err)
+	}
+
+	c, err := consumer.New(consumer.Config{
+		Name:                   "ALGOLIA",
+		Push:                   cfg.Deployed(),
+		Port:                   cfg.Port,
+		PushAuth:               cfg.PushAuth(),
+		Quarantine:             cfg.Quarantine(),
+		Bus:                    cfg.Bus(),
+		Topic:                  cfg.Topic,
+		Subscription:           cfg.AlgoliaSubscription,
+		MaxOutstandingMessages: cfg.MaxOutstandingMessages,
+		NumGoroutines:          cfg.NumGoroutines,
+		JobTimeout:             cfg.JobTimeout.Duration,
+		AdminPort:              cfg.AlgoliaAdminPort,
+		AdminToken:             cfg.AdminToken,
+	}, job.Handlers(algoliaClient, cfg.UsersIndex))
+	if err != nil {
+		return fmt.Errorf("failed to initialize consumer: %w", err)
+	}
+
+	c.Run(ctx, drain)
+	log.Println("[*] ALGOLIA [*] Shut down")
+	return nil
+}
@@ -2,11 +2,18 @@ module github.com/copium-dev/copium/algolia-consumer
 
 go 1.23.1
 
+require github.com/algolia/algoliasearch-client-go/v4 v4.12.0
+
 require (
-	cloud.google.com/go/pubsub v1.47.0
-	github.com/algolia/algoliasearch-client-go/v4 v4.12.0
-	github.com/joho/godotenv v1.5.1
-	google.golang.org/api v0.224.0
+	cloud.google.com/go/firestore v1.18.0 // indirect
+	cloud.google.com/go/longrunning v0.6.4 // indirect
+	cloud.google.com/go/pubsub v1.47.0 // indirect
+	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
+	github.com/nats-io/nats.go v1.39.1 // indirect
+	github.com/nats-io/nkeys v0.4.9 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	google.golang.org/api v0.224.0 // indirect
 )
 
 require (
@@ -15,6 +22,7 @@ require (
 	cloud.google.com/go/auth/oauth2adapt v0.2.7 // indirect
 	cloud.google.com/go/compute/metadata v0.6.0 // indirect
 	cloud.google.com/go/iam v1.4.1 // indirect
+	github.com/copium-dev/copium/shared v0.0.0
 	github.com/felixge/httpsnoop v1.0.4 // indirect
 	github.com/gabriel-vasile/mimetype v1.4.8 // indirect
 	github.com/go-logr/logr v1.4.2 // indirect
@@ -22,6 +30,7 @@ require (
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/go-playground/validator/v10 v10.24.0 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
 	github.com/google/s2a-go v0.1.9 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/googleapis/enterprise-certificate-proxy v0.3.5 // indirect
@@ -47,3 +56,5 @@ require (
 	google.golang.org/grpc v1.71.0 // indirect
 	google.golang.org/protobuf v1.36.5 // indirect
 )
+
+replace github.com/copium-dev/copium/shared => ../shared
@@ -1,84 +1,79 @@
 package job
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"context"
 
+	"github.com/copium-dev/copium/shared/consumer"
+	"github.com/copium-dev/copium/shared/events"
+
 	"github.com/algolia/algoliasearch-client-go/v4/algolia/search"
 )
 
-type Job struct {
-    ID              int32
-    Data            map[string]interface{}
-    RawData         []byte
-    Operation       string
-	AlgoliaClient  *search.APIClient
+// writes events to the applications index ("users" unless configured otherwise)
+type Indexer struct {
+	AlgoliaClient *search.APIClient
+	Index         string
 }
 
-// all this really does is unmarshal the raw data and figure out the operation
-func NewJob(data []byte, id int32, algoliaClient *search.APIClient) (*Job, error) {
-    var parsedData map[string]interface{}
-    err := json.Unmarshal(data, &parsedData)
-    if err != nil {
-        return nil, fmt.Errorf("failed to parse job data: %w", err)
-    }
-    
-    operation, ok := parsedData["operation"].(string)
-    if !ok {
-        return nil, fmt.Errorf("missing or invalid operation field")
-    }
-    
-    return &Job{
-        ID:              id,
-        RawData:         data,
-        Data:            parsedData,
-        Operation:       operation,
-		AlgoliaClient:   algoliaClient,
-    }, nil
-}
+// what the consumer runtime calls for each operation; Algolia does not support revert, so
+// it isn't registered and those messages are acked without doing anything
+func Handlers(algoliaClient *search.APIClient, index string) consumer.Handlers {
+	j := &Indexer{AlgoliaClient: algoliaClient, Index: index}
 
-func (j *Job) Process(ctx context.Context) error {
-	// context is not required for any operations but this is just a security check
-	// to make sure we dont process jobs after their context has been cancelled
-	if ctx.Err() != nil {
-		return ctx.Err()
+	return consumer.Handlers{
+		events.OpAdd:             consumer.Typed(j.addApplication),
+		events.OpEditStatus:      consumer.Typed(j.editStatus),
+		events.OpEditApplication: consumer.Typed(j.editApplicationDetails),
+		events.OpDelete:          consumer.Typed(j.deleteApplication),
+		events.OpUserDelete:      consumer.Typed(j.userDelete),
+		events.OpRevertLatest:    consumer.Typed(j.revertLatest),
 	}
+}
 
-    log.Printf("[*] Algolia [*]")
-    log.Printf("-------")
-    log.Printf("Processing Job With ID [%d] with content: [%s]", j.ID, j.Data)
-    
-    switch j.Operation {
-	case "add":
-		return j.addApplication(ctx)
-	case "editStatus", "editApplication":
-		return j.editApplication(ctx)
-	case "delete":
-		return j.deleteApplication(ctx)
-	case "userDelete":
-		return j.userDelete(ctx)
-	case "revertLatest":
-		return j.revertLatest(ctx)
-	case "revert":
-		log.Println("Algolia does not support revert, doing nothing")
-		return nil
-    default:
-        return fmt.Errorf("unknown operation: %s", j.Operation)
-    }
+func (j *Indexer) editStatus(ctx context.Context, event *events.EditStatus) error {
+	return j.editApplication(ctx, event.ObjectID, map[string]any{
+		"userID":      event.UserID,
+		"status":      event.Status,
+		"appliedDate": event.AppliedDate,
+		"timestamp":   event.Timestamp,
+	})
+}
+
+func (j *Indexer) editApplicationDetails(ctx context.Context, event *events.EditApplication) error {
+	return j.editApplication(ctx, event.ObjectID, map[string]any{
+		"userID":    event.UserID,
+		"role":      event.Role,
+		"company":   event.Company,
+		"location":  event.Location,
+		"link":      event.Link,
+		"timestamp": event.Timestamp,
+	})
 }
 
-func (j *Job) addApplication(ctx context.Context) error {
+func (j *Indexer) addApplication(ctx context.Context, event *events.Add) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
-	data := j.Data
+	// same record the API has always sent, minus the message metadata
+	// records are filtered by userID (a facet), which unlike the email never changes
+	data := map[string]any{
+		"objectID":    event.ObjectID,
+		"userID":      event.UserID,
+		"role":        event.Role,
+		"company":     event.Company,
+		"location":    event.Location,
+		"link":        event.Link,
+		"appliedDate": event.AppliedDate,
+		"status":      event.Status,
+		"timestamp":   event.Timestamp,
+	}
 
 	// add the application to algolia
 	saveRes, err := j.AlgoliaClient.SaveObject(
-		j.AlgoliaClient.NewApiSaveObjectRequest("users", data),
+		j.AlgoliaClient.NewApiSaveObjectRequest(j.Index, data),
 	)
 	if err != nil {
 		log.Printf("Failed to save object: %s", err)
@@ -86,7 +81,7 @@ func (j *Job) addApplication(ctx context.Context) error {
 	}
 
 	// wait for task to finish before exiting function
-	_, err = j.AlgoliaClient.WaitForTask("users", saveRes.TaskID)
+	_, err = j.AlgoliaClient.WaitForTask(j.Index, saveRes.TaskID)
 	if err != nil {
 		log.Printf("Error waiting for task to finish: %s", err)
 		return err
@@ -96,21 +91,15 @@ func (j *Job) addApplication(ctx context.Context) error {
 	return nil
 }
 
-func (j *Job) editApplication(ctx context.Context) error {
+// only the fields in data are updated
+func (j *Indexer) editApplication(ctx context.Context, objectID string, data map[string]any) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
-	data := j.Data
-
 	// edit the application in algolia
-	objectID, ok := data["objectID"].(string)
-	if !ok {
-		return fmt.Errorf("failed to get objectID from data")
-	}
-
 	updateRes, err := j.AlgoliaClient.PartialUpdateObject(
-		j.AlgoliaClient.NewApiPartialUpdateObjectRequest("users", objectID, data),
+		j.AlgoliaClient.NewApiPartialUpdateObjectRequest(j.Index, objectID, data),
 	)
 	if err != nil {
 		log.Printf("Failed to update object: %s", err)
@@ -118,7 +107,7 @@ func (j *Job) editApplication(ctx context.Context) error {
 	}
 
 	// wait for task to finish before exiting function
-	_, err = j.AlgoliaClient.WaitForTask("users", *updateRes.TaskID)
+	_, err = j.AlgoliaClient.WaitForTask(j.Index, *updateRes.TaskID)
 	if err != nil {
 		log.Printf("Error waiting for task to finish: %s", err)
 		return err
@@ -128,21 +117,14 @@ func (j *Job) editApplication(ctx context.Context) error {
 	return nil
 }
 
-func (j *Job) deleteApplication(ctx context.Context) error {
+func (j *Indexer) deleteApplication(ctx context.Context, event *events.Delete) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
-	data := j.Data
-
 	// delete the application from algolia
-	objectID, ok := data["objectID"].(string)
-	if !ok {
-		return fmt.Errorf("failed to get objectID from data")
-	}
-
 	deleteRes, err := j.AlgoliaClient.DeleteObject(
-		j.AlgoliaClient.NewApiDeleteObjectRequest("users", objectID),
+		j.AlgoliaClient.NewApiDeleteObjectRequest(j.Index, event.ObjectID),
 	)
 	if err != nil {
 		log.Printf("Failed to delete object: %s", err)
@@ -150,7 +132,7 @@ func (j *Job) deleteApplication(ctx context.Context) error {
 	}
 
 	// wait for task to finish before exiting function
-	_, err = j.AlgoliaClient.WaitForTask("users", deleteRes.TaskID)
+	_, err = j.AlgoliaClient.WaitForTask(j.Index, deleteRes.TaskID)
 	if err != nil {
 		log.Printf("Error waiting for task to finish: %s", err)
 		return err
@@ -161,24 +143,17 @@ func (j *Job) deleteApplication(ctx context.Context) error {
 }
 
 // note: DeleteBy is resource intensive so we should carefully monitor
-func (j *Job) userDelete(ctx context.Context) error {
+func (j *Indexer) userDelete(ctx context.Context, event *events.UserDelete) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
-	data := j.Data
-
-	// extract and delete every objectID where email == data["email"]
-	email, ok := data["email"].(string)
-	if !ok {
-		return fmt.Errorf("failed to get email from data")
-	}
-	
-	filter := fmt.Sprintf("email:%s", email)
+	// extract and delete every objectID where userID == event.UserID
+	filter := fmt.Sprintf("userID:%q", event.UserID)
 
 	res, err := j.AlgoliaClient.DeleteBy(
 		j.AlgoliaClient.NewApiDeleteByRequest(
-			"users",
+			j.Index,
 			search.NewEmptyDeleteByParams().SetFilters(filter),
 		),
 	)
@@ -188,7 +163,7 @@ func (j *Job) userDelete(ctx context.Context) error {
 	}
 
 	// wait for task to finish before exiting function
-	_, err = j.AlgoliaClient.WaitForTask("users", res.TaskID)
+	_, err = j.AlgoliaClient.WaitForTask(j.Index, res.TaskID)
 	if err != nil {
 		log.Printf("Error waiting for task to finish: %s", err)
 		return err
@@ -199,28 +174,16 @@ func (j *Job) userDelete(ctx context.Context) error {
 }
 
 // change status based on what was sent from PubSub
-func (j *Job) revertLatest(ctx context.Context) error {
+func (j *Indexer) revertLatest(ctx context.Context, event *events.RevertLatest) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
-	data := j.Data
-
-	objectID, ok := data["objectID"].(string)
-	if !ok {
-		return fmt.Errorf("failed to get objectID from data")
-	}
-
-	status, ok := data["status"].(string)
-	if !ok {
-		return fmt.Errorf("failed to get status from data")
-	}
-
     updateRes, err := j.AlgoliaClient.PartialUpdateObject(
         j.AlgoliaClient.NewApiPartialUpdateObjectRequest(
-            "users",
-            objectID,
-            map[string]any{"status": status},
+            j.Index,
+            event.ObjectID,
+            map[string]any{"status": event.Status},
         ),
     )
     if err != nil {
@@ -229,7 +192,7 @@ func (j *Job) revertLatest(ctx context.Context) error {
     }
 
 	// wait for task to finish before exiting function
-	_, err = j.AlgoliaClient.WaitForTask("users", *updateRes.TaskID)
+	_, err = j.AlgoliaClient.WaitForTask(j.Index, *updateRes.TaskID)
 	if err != nil {
 		log.Printf("Error waiting for task to finish: %s", err)
 		return err
@@ -0,0 +1,228 @@
+package aggregator
+
+// keeps each user's analytics.State in Firestore (analytics_state/{userID}) in step with the applications
+// table: adds and status edits are applied as they come in, deletes and reverts mark the state
+// stale and the next Compute rebuilds it from the table
+// which events have been applied is kept next to the ledger (analytics_applied/{eventID}, with the
+// same expireAt TTL) and written in the same transaction as the state, so a redelivery is a no-op
+// without the state document remembering event IDs. the document itself holds the jobs applied to
+// in the last year (~100 bytes each, see analytics.State.Prune), well under Firestore's limit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/copium-dev/copium/bigquery-consumer/ledger"
+	"github.com/copium-dev/copium/shared/analytics"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Aggregator struct {
+	bigQueryClient  *bigquery.Client
+	firestoreClient *firestore.Client
+	// the user's rows to rebuild from; readRows outside of tests
+	rows func(ctx context.Context, userID string) ([]analytics.Row, error)
+}
+
+func New(bqClient *bigquery.Client, fsClient *firestore.Client) *Aggregator {
+	a := &Aggregator{
+		bigQueryClient:  bqClient,
+		firestoreClient: fsClient,
+	}
+	a.rows = a.readRows
+	return a
+}
+
+func (a *Aggregator) doc(userID string) *firestore.DocumentRef {
+	return a.firestoreClient.Collection("analytics_state").Doc(userID)
+}
+
+func (a *Aggregator) applied(eventID string) *firestore.DocumentRef {
+	return a.firestoreClient.Collection("analytics_applied").Doc(eventID)
+}
+
+// applies an add or edit row; a no-op if the event was already applied or the state is stale
+// (the rebuild will pick the row up from the table)
+func (a *Aggregator) Apply(ctx context.Context, userID string, eventID string, row analytics.Row) error {
+	return a.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		state, err := a.load(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		if eventID != "" {
+			_, err := tx.Get(a.applied(eventID))
+			if err == nil {
+				return nil
+			}
+			if status.Code(err) != codes.NotFound {
+				return err
+			}
+		}
+
+		now := time.Now()
+		if !state.Stale {
+			state.Apply(row)
+			state.Prune(now)
+		}
+		state.Version++
+
+		if err := tx.Set(a.doc(userID), state); err != nil {
+			return err
+		}
+		// marked even when stale: the rebuild picks the row up from the table, and applying it
+		// again on a later redelivery would count it twice
+		if eventID != "" {
+			return tx.Set(a.applied(eventID), map[string]interface{}{
+				"processedAt": now,
+				"expireAt":    now.Add(ledger.Retention),
+			})
+		}
+		return nil
+	})
+}
+
+// for deletes and reverts; the state is rebuilt from the table on the next Compute
+func (a *Aggregator) Invalidate(ctx context.Context, userID string) error {
+	_, err := a.doc(userID).Set(ctx, map[string]interface{}{
+		"stale":   true,
+		"version": firestore.Increment(1),
+	}, firestore.MergeAll)
+	return err
+}
+
+// for userDelete
+func (a *Aggregator) Delete(ctx context.Context, userID string) error {
+	_, err := a.doc(userID).Delete(ctx)
+	return err
+}
+
+// every registered metric as of now, rebuilding the state first if it's stale
+func (a *Aggregator) Compute(ctx context.Context, userID string) (map[string]interface{}, error) {
+	snapshot, err := a.doc(userID).Get(ctx)
+	state, err := decode(snapshot, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load analytics state: %w", err)
+	}
+
+	if state.Stale {
+		state, err = a.rebuild(ctx, userID, state)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rebuild analytics state: %w", err)
+		}
+	}
+
+	return analytics.Compute(state, time.Now()), nil
+}
+
+// replays the user's rows from the table into a fresh state
+// only saved if nothing changed the state in the meantime; if something did, it's still stale
+// and the run that change scheduled rebuilds it again
+func (a *Aggregator) rebuild(ctx context.Context, userID string, stale *analytics.State) (*analytics.State, error) {
+	log.Printf("Rebuilding analytics state for [%s]", userID)
+
+	rows, err := a.rows(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	state := analytics.FromRows(rows, time.Now())
+	state.Version = stale.Version + 1
+
+	err = a.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current, err := a.load(tx, userID)
+		if err != nil {
+			return err
+		}
+		if current.Version != stale.Version {
+			log.Printf("Analytics state for [%s] changed during rebuild, not saving it", userID)
+			return nil
+		}
+		return tx.Set(a.doc(userID), state)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// same rows the SQL aggregates: not reverted, one per operationID
+func (a *Aggregator) readRows(ctx context.Context, userID string) ([]analytics.Row, error) {
+	q := a.bigQueryClient.Query(`
+		SELECT jobID, UNIX_SECONDS(event_time) AS event_time, UNIX_SECONDS(applied_date) AS applied_date, status, operation
+		FROM applications_data.applications
+		WHERE userID = @userID
+		AND operation != 'revert'
+		QUALIFY ROW_NUMBER() OVER (PARTITION BY operationID) = 1
+		ORDER BY event_time
+	`)
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "userID", Value: userID},
+	}
+
+	it, err := q.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applications: %w", err)
+	}
+
+	var rows []analytics.Row
+	for {
+		var row struct {
+			JobID       string `bigquery:"jobID"`
+			EventTime   int64  `bigquery:"event_time"`
+			AppliedDate int64  `bigquery:"applied_date"`
+			Status      string `bigquery:"status"`
+			Operation   string `bigquery:"operation"`
+		}
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read row: %w", err)
+		}
+		rows = append(rows, analytics.Row{
+			JobID:       row.JobID,
+			EventTime:   row.EventTime,
+			AppliedDate: row.AppliedDate,
+			Status:      row.Status,
+			Operation:   row.Operation,
+		})
+	}
+
+	return rows, nil
+}
+
+func (a *Aggregator) load(tx *firestore.Transaction, userID string) (*analytics.State, error) {
+	return decode(tx.Get(a.doc(userID)))
+}
+
+// a user without a state document may still have history (e.g. from before the state was kept),
+// so a missing state is a stale one
+func decode(snapshot *firestore.DocumentSnapshot, err error) (*analytics.State, error) {
+	if status.Code(err) == codes.NotFound {
+		state := analytics.NewState()
+		state.Stale = true
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := analytics.NewState()
+	if err := snapshot.DataTo(state); err != nil {
+		return nil, fmt.Errorf("failed to parse analytics state: %w", err)
+	}
+	if state.Jobs == nil {
+		state.Jobs = make(map[string]analytics.JobState)
+	}
+	return state, nil
+}
@@ -0,0 +1,120 @@
+package aggregator
+
+// runs against the Firestore emulator, e.g.
+//
+//	FIRESTORE_EMULATOR_HOST=localhost:8080 go test ./aggregator/
+//
+// skipped when FIRESTORE_EMULATOR_HOST isn't set. rebuilds read canned rows instead of BigQuery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/copium-dev/copium/shared/analytics"
+
+	"cloud.google.com/go/firestore"
+)
+
+func newTestAggregator(t *testing.T, rows *[]analytics.Row) (*Aggregator, string) {
+	t.Helper()
+
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set")
+	}
+
+	client, err := firestore.NewClient(context.Background(), "aggregator-test")
+	if err != nil {
+		t.Fatalf("firestore.NewClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	a := New(nil, client)
+	a.rows = func(ctx context.Context, userID string) ([]analytics.Row, error) {
+		return *rows, nil
+	}
+	return a, fmt.Sprintf("user-%d", time.Now().UnixNano())
+}
+
+func metric(t *testing.T, a *Aggregator, userID string, name string) interface{} {
+	t.Helper()
+
+	computed, err := a.Compute(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("Compute: %v", err)
+	}
+	return computed[name]
+}
+
+func TestApplyRedelivery(t *testing.T) {
+	var table []analytics.Row
+	a, userID := newTestAggregator(t, &table)
+	ctx := context.Background()
+
+	// a new user's state starts stale; the first Compute rebuilds it from an empty table
+	if got := metric(t, a, userID, "application_velocity"); got != int64(0) {
+		t.Fatalf("application_velocity = %v, want 0", got)
+	}
+
+	applied := time.Now().Add(-24 * time.Hour).Unix()
+	row := analytics.Row{JobID: "job-1", EventTime: applied, AppliedDate: applied, Status: "Applied", Operation: "add"}
+	eventID := userID + "-add"
+	for i := 0; i < 3; i++ {
+		if err := a.Apply(ctx, userID, eventID, row); err != nil {
+			t.Fatalf("Apply %d: %v", i, err)
+		}
+	}
+
+	if got := metric(t, a, userID, "application_velocity"); got != int64(1) {
+		t.Errorf("application_velocity = %v after redeliveries, want 1", got)
+	}
+}
+
+func TestRebuildWhenStale(t *testing.T) {
+	var table []analytics.Row
+	a, userID := newTestAggregator(t, &table)
+	ctx := context.Background()
+	metric(t, a, userID, "application_velocity")
+
+	applied := time.Now().Add(-48 * time.Hour).Unix()
+	add := analytics.Row{JobID: "job-1", EventTime: applied, AppliedDate: applied, Status: "Applied", Operation: "add"}
+	screen := analytics.Row{JobID: "job-1", EventTime: applied + 3600, AppliedDate: applied, Status: "Screen", Operation: "edit"}
+
+	if err := a.Apply(ctx, userID, userID+"-add", add); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if err := a.Invalidate(ctx, userID); err != nil {
+		t.Fatalf("Invalidate: %v", err)
+	}
+	// not applied while stale; the rebuild reads it from the table
+	if err := a.Apply(ctx, userID, userID+"-screen", screen); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	table = []analytics.Row{add, screen}
+	if got := metric(t, a, userID, "resume_effectiveness"); got != int64(1) {
+		t.Fatalf("resume_effectiveness = %v after rebuild, want 1", got)
+	}
+	if got := metric(t, a, userID, "application_velocity"); got != int64(1) {
+		t.Fatalf("application_velocity = %v after rebuild, want 1", got)
+	}
+
+	// both events are in the rebuilt state already
+	for _, redelivered := range []struct {
+		eventID string
+		row     analytics.Row
+	}{{userID + "-add", add}, {userID + "-screen", screen}} {
+		if err := a.Apply(ctx, userID, redelivered.eventID, redelivered.row); err != nil {
+			t.Fatalf("Apply: %v", err)
+		}
+	}
+	if got := metric(t, a, userID, "application_velocity"); got != int64(1) {
+		t.Errorf("application_velocity = %v after redelivery, want 1", got)
+	}
+
+	if err := a.Delete(ctx, userID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/copium-dev/copium/bigquery-consumer/aggregator"
+	"github.com/copium-dev/copium/bigquery-consumer/debounce"
+	"github.com/copium-dev/copium/bigquery-consumer/inits"
+	"github.com/copium-dev/copium/bigquery-consumer/job"
+	"github.com/copium-dev/copium/bigquery-consumer/ledger"
+	"github.com/copium-dev/copium/bigquery-consumer/writer"
+	"github.com/copium-dev/copium/shared/config"
+	"github.com/copium-dev/copium/shared/consumer"
+)
+
+// writes the application timeline to BigQuery and keeps analytics up to date until ctx is done;
+// in-flight jobs, buffered rows and pending analytics then share drain. what main.go runs, and
+// what dev/ runs next to the API and the Algolia consumer
+func Run(ctx context.Context, drain context.Context, cfg *config.Config) error {
+	// create bigquery client (shared across workers)
+	bigQueryClient, err := inits.InitializeBigQueryClient(cfg.ProjectID)
+	if err != nil {
+		return fmt.Errorf("failed to initialize BigQuery client: %w", err)
+	}
+	defer bigQueryClient.Close()
+
+	// create firestore client (shared across workers)
+	firestoreClient, err := inits.InitializeFirestoreClient(cfg.ProjectID)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Firestore client: %w", err)
+	}
+	defer firestoreClient.Close()
+
+	// events already processed, so redeliveries and replays are skipped
+	// LEDGER_STORE=memory keeps it in memory for local dev (forgotten on restart)
+	var processed ledger.Ledger
+	if cfg.LedgerStore == config.StoreMemory {
+		log.Println("LEDGER_STORE=memory; processed events are kept in memory only")
+		processed = ledger.NewMemoryLedger()
+	} else {
+		processed = ledger.NewFirestoreLedger(firestoreClient)
+	}
+
+	// timeline rows are buffered across messages and written in batches with the Storage Write API
+	sink, err := writer.NewStorageWriteSink(context.Background(), bigQueryClient.Project(), "applications_data", "applications")
+	if err != nil {
+		return fmt.Errorf("failed to initialize BigQuery writer: %w", err)
+	}
+	rows := writer.NewBatcher(sink, cfg.BatchMaxRows, cfg.BatchMaxDelay.Duration)
+
+	// per-user analytics state, updated event by event instead of rescanning the user's history
+	// ANALYTICS_SHADOW_CHECK=true also runs the full SQL recalculation and logs any difference
+	analyticsState := aggregator.New(bigQueryClient, firestoreClient)
+	shadowCheck := cfg.AnalyticsShadowCheck
+	if shadowCheck {
+		log.Println("ANALYTICS_SHADOW_CHECK=true; checking incremental analytics against the full recalculation")
+	}
+
+	// analytics are recalculated once per burst of events for a user instead of once per event
+	scheduler := debounce.New(func(ctx context.Context, userID string) error {
+		return job.RefreshAnalytics(ctx, bigQueryClient, firestoreClient, processed, analyticsState, shadowCheck, userID)
+	}, cfg.AnalyticsDebounce.Duration, cfg.AnalyticsMaxWait.Duration)
+
+	// users still marked pending were acked but never refreshed (an instance stopped first, or
+	// every attempt failed); another instance may be on them already, which just refreshes twice
+	pendingUsers, err := processed.PendingAnalytics(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read pending analytics: %w", err)
+	}
+	for _, userID := range pendingUsers {
+		scheduler.Schedule(userID)
+	}
+	if len(pendingUsers) > 0 {
+		log.Printf("Refreshing analytics still pending for %d users", len(pendingUsers))
+	}
+
+	c, err := consumer.New(consumer.Config{
+		Name:                   "BIGQUERY",
+		Push:                   cfg.Deployed(),
+		Port:                   cfg.Port,
+		PushAuth:               cfg.PushAuth(),
+		Quarantine:             cfg.Quarantine(),
+		Bus:                    cfg.Bus(),
+		Topic:                  cfg.Topic,
+		Subscription:           cfg.BigQuerySubscription,
+		MaxOutstandingMessages: cfg.MaxOutstandingMessages,
+		NumGoroutines:          cfg.NumGoroutines,
+		JobTimeout:             cfg.JobTimeout.Duration,
+		AdminPort:              cfg.BigQueryAdminPort,
+		AdminToken:             cfg.AdminToken,
+	}, job.Handlers(&job.Processor{
+		BigQueryClient:  bigQueryClient,
+		FirestoreClient: firestoreClient,
+		Ledger:          processed,
+		Writer:          rows,
+		Analytics:       scheduler,
+		Aggregator:      analyticsState,
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to initialize consumer: %w", err)
+	}
+
+	c.Run(ctx, drain)
+
+	// jobs have drained; write what's still buffered, then run what's still pending (analytics
+	// rebuilds read the rows). clients close after, in reverse order of creation
+	if err := rows.Close(drain); err != nil {
+		log.Printf("Error flushing BigQuery writer: %v", err)
+	}
+	if err := scheduler.Close(drain); err != nil {
+		log.Printf("Error flushing analytics scheduler: %v", err)
+	}
+	log.Println("[*] BIGQUERY [*] Shut down")
+	return nil
+}
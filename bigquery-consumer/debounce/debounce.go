@@ -0,0 +1,176 @@
+package debounce
+
+// collapses bursts of work for the same key into a single run
+// every Schedule call for a key restarts its quiet period; once the key has been quiet for
+// `quiet` (or `maxWait` has passed since the first call, so a steady stream can't starve it)
+// the work runs once. Schedule doesn't wait for the run, so callers that need it to happen
+// must record that themselves (the BigQuery consumer marks the user pending in its ledger)
+// a failed run is tried again after another quiet period, up to maxAttempts times in all
+// runs for the same key never overlap, so a slow run can't overwrite a newer one's result
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultQuiet   = 2 * time.Second
+	DefaultMaxWait = 10 * time.Second
+
+	// runs per burst, counting the first, before a failing key is given up on
+	maxAttempts = 3
+
+	// how long a single run may take; not tied to any one caller's context since a run
+	// is shared by every caller that scheduled it
+	runTimeout = time.Minute
+)
+
+// returned by Schedule after Close
+var ErrClosed = errors.New("scheduler closed")
+
+// the work to do for a key
+type Func func(ctx context.Context, key string) error
+
+type pending struct {
+	first time.Time
+	timer *time.Timer
+	// failed runs so far
+	failures int
+}
+
+type Scheduler struct {
+	run     Func
+	quiet   time.Duration
+	maxWait time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pending
+	running map[string]bool
+	closed  bool
+	// every pending run that hasn't finished yet
+	runs sync.WaitGroup
+}
+
+func New(run Func, quiet time.Duration, maxWait time.Duration) *Scheduler {
+	if quiet <= 0 {
+		quiet = DefaultQuiet
+	}
+	if maxWait <= 0 {
+		maxWait = DefaultMaxWait
+	}
+	if maxWait < quiet {
+		maxWait = quiet
+	}
+	return &Scheduler{
+		run:     run,
+		quiet:   quiet,
+		maxWait: maxWait,
+		pending: make(map[string]*pending),
+		running: make(map[string]bool),
+	}
+}
+
+// schedules a run for key, or pushes back the one already pending; doesn't wait for it
+func (s *Scheduler) Schedule(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	if p, ok := s.pending[key]; ok {
+		p.timer.Reset(s.delay(p))
+		return nil
+	}
+	s.add(key, &pending{first: time.Now()}, s.quiet)
+	return nil
+}
+
+// must hold mu
+func (s *Scheduler) add(key string, p *pending, after time.Duration) {
+	s.pending[key] = p
+	s.runs.Add(1)
+	p.timer = time.AfterFunc(after, func() {
+		s.fire(key, p)
+	})
+}
+
+// quiet period, cut short so the run never happens later than maxWait after the first call
+func (s *Scheduler) delay(p *pending) time.Duration {
+	remaining := s.maxWait - time.Since(p.first)
+	if remaining < 0 {
+		return 0
+	}
+	if remaining < s.quiet {
+		return remaining
+	}
+	return s.quiet
+}
+
+func (s *Scheduler) fire(key string, p *pending) {
+	s.mu.Lock()
+	if s.pending[key] != p {
+		// timer was reset after it had already fired; this run is done or underway
+		s.mu.Unlock()
+		return
+	}
+	if s.running[key] {
+		// previous run still going; check again once it's had a moment to finish
+		p.timer.Reset(s.quiet)
+		s.mu.Unlock()
+		return
+	}
+	delete(s.pending, key)
+	s.running[key] = true
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), runTimeout)
+	err := s.run(ctx, key)
+	cancel()
+
+	s.mu.Lock()
+	delete(s.running, key)
+	if err != nil {
+		p.failures++
+		_, scheduled := s.pending[key]
+		switch {
+		case scheduled:
+			// scheduled again while running; that run covers this one
+			log.Printf("Debounced run for [%s] failed, runs again with the next one: %v", key, err)
+		case s.closed || p.failures >= maxAttempts:
+			log.Printf("Debounced run for [%s] failed, giving up after %d attempts: %v", key, p.failures, err)
+		default:
+			log.Printf("Debounced run for [%s] failed, retrying: %v", key, err)
+			s.add(key, &pending{first: time.Now(), failures: p.failures}, s.quiet)
+		}
+	}
+	s.mu.Unlock()
+	s.runs.Done()
+}
+
+// runs every pending key now instead of after its quiet period and waits for all runs to
+// finish (or ctx); failed runs aren't retried and Schedule fails with ErrClosed afterwards
+func (s *Scheduler) Close(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for _, p := range s.pending {
+		p.timer.Reset(0)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.runs.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,193 @@
+package debounce
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counts runs per key; fails a key's first failures runs
+type recorder struct {
+	mu       sync.Mutex
+	runs     map[string]int
+	failures int
+}
+
+func newRecorder() *recorder {
+	return &recorder{runs: make(map[string]int)}
+}
+
+func (r *recorder) run(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs[key]++
+	if r.runs[key] <= r.failures {
+		return errors.New("refresh failed")
+	}
+	return nil
+}
+
+func (r *recorder) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runs[key]
+}
+
+// waits for key to have run want times, then a little longer to catch any extra runs
+func waitRuns(t *testing.T, r *recorder, key string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for r.count(key) < want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := r.count(key); got != want {
+		t.Fatalf("%s ran %d times, want %d", key, got, want)
+	}
+}
+
+// a user's messages are acked as soon as they're scheduled, so a burst arrives back to back
+func TestScheduleBurst(t *testing.T) {
+	r := newRecorder()
+	s := New(r.run, 20*time.Millisecond, time.Second)
+
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		if err := s.Schedule("user-1"); err != nil {
+			t.Fatalf("Schedule: %v", err)
+		}
+	}
+	if waited := time.Since(start); waited > 10*time.Millisecond {
+		t.Errorf("scheduling took %s, want it not to wait for the run", waited)
+	}
+	if got := r.count("user-1"); got != 0 {
+		t.Errorf("ran %d times before the quiet period, want 0", got)
+	}
+
+	waitRuns(t, r, "user-1", 1)
+}
+
+func TestScheduleKeys(t *testing.T) {
+	r := newRecorder()
+	s := New(r.run, 10*time.Millisecond, time.Second)
+
+	for i := 0; i < 5; i++ {
+		s.Schedule("user-1")
+		s.Schedule("user-2")
+	}
+
+	waitRuns(t, r, "user-1", 1)
+	waitRuns(t, r, "user-2", 1)
+}
+
+// a steady stream keeps resetting the quiet period, but still runs once maxWait is up
+func TestScheduleMaxWait(t *testing.T) {
+	r := newRecorder()
+	s := New(r.run, 30*time.Millisecond, 100*time.Millisecond)
+
+	start := time.Now()
+	for time.Since(start) < 250*time.Millisecond {
+		s.Schedule("user-1")
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if got := r.count("user-1"); got < 2 {
+		t.Errorf("ran %d times during 250ms of events with a 100ms maxWait, want at least 2", got)
+	}
+}
+
+func TestScheduleRetries(t *testing.T) {
+	r := newRecorder()
+	r.failures = 1
+	s := New(r.run, 10*time.Millisecond, time.Second)
+
+	s.Schedule("user-1")
+	waitRuns(t, r, "user-1", 2)
+}
+
+func TestScheduleGivesUp(t *testing.T) {
+	r := newRecorder()
+	r.failures = maxAttempts + 1
+	s := New(r.run, 10*time.Millisecond, time.Second)
+
+	s.Schedule("user-1")
+	waitRuns(t, r, "user-1", maxAttempts)
+}
+
+// pending runs happen right away instead of after their quiet period
+func TestClose(t *testing.T) {
+	r := newRecorder()
+	s := New(r.run, time.Hour, time.Hour)
+
+	s.Schedule("user-1")
+	s.Schedule("user-2")
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if r.count("user-1") != 1 || r.count("user-2") != 1 {
+		t.Errorf("ran %d and %d times, want both once by the time Close returns", r.count("user-1"), r.count("user-2"))
+	}
+
+	if err := s.Schedule("user-1"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Schedule after Close = %v, want ErrClosed", err)
+	}
+}
+
+// runs for the same key never overlap: one scheduled during a run waits for it
+func TestScheduleDuringRun(t *testing.T) {
+	var mu sync.Mutex
+	running, overlapped, runs := false, false, 0
+	release := make(chan struct{})
+	s := New(func(ctx context.Context, key string) error {
+		mu.Lock()
+		if running {
+			overlapped = true
+		}
+		running = true
+		runs++
+		first := runs == 1
+		mu.Unlock()
+
+		if first {
+			<-release
+		}
+
+		mu.Lock()
+		running = false
+		mu.Unlock()
+		return nil
+	}, 5*time.Millisecond, time.Second)
+
+	s.Schedule("user-1")
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		started := runs == 1
+		mu.Unlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first run never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	s.Schedule("user-1")
+	time.Sleep(30 * time.Millisecond)
+	close(release)
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if overlapped {
+		t.Error("runs for the same key overlapped")
+	}
+	if runs != 2 {
+		t.Errorf("ran %d times, want 2", runs)
+	}
+}
@@ -2,11 +2,20 @@ package job
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"github.com/copium-dev/copium/bigquery-consumer/aggregator"
+	"github.com/copium-dev/copium/bigquery-consumer/debounce"
+	"github.com/copium-dev/copium/bigquery-consumer/ledger"
+	"github.com/copium-dev/copium/bigquery-consumer/writer"
+	"github.com/copium-dev/copium/shared/analytics"
+	"github.com/copium-dev/copium/shared/consumer"
+	"github.com/copium-dev/copium/shared/events"
+
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/firestore"
 	"github.com/google/uuid"
@@ -20,205 +29,370 @@ type MonthlyTrend struct {
     Offers       int64  `bigquery:"offers"`
 }
 
-type Job struct {
-	ID              int32
-	Data            map[string]interface{}
-	RawData         []byte
-	Operation       string
+// everything processing an event needs; shared across workers
+type Processor struct {
 	BigQueryClient  *bigquery.Client
 	FirestoreClient *firestore.Client
+	Ledger          ledger.Ledger
+	Writer          *writer.Batcher
+	Analytics       *debounce.Scheduler
+	Aggregator      *aggregator.Aggregator
 }
 
-// all this really does is unmarshal the raw data and figure out the operation
-func NewJob(data []byte, id int32, bqClient *bigquery.Client, fsClient *firestore.Client) (*Job, error) {
-	var parsedData map[string]interface{}
-	err := json.Unmarshal(data, &parsedData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse job data: %w", err)
+// what the consumer runtime calls for each operation; BigQuery does not support editApplication,
+// so it isn't registered and those messages are acked without doing anything
+func Handlers(p *Processor) consumer.Handlers {
+	return consumer.Handlers{
+		events.OpAdd:          consumer.Typed(p.addApplication),
+		events.OpEditStatus:   consumer.Typed(p.editStatus),
+		events.OpDelete:       consumer.Typed(p.deleteApplication),
+		events.OpUserDelete:   consumer.Typed(p.userDelete),
+		events.OpRevert:       consumer.Typed(p.revert),
+		events.OpRevertLatest: consumer.Typed(p.revertLatest),
 	}
+}
 
-	operation, ok := parsedData["operation"].(string)
-	if !ok {
-		return nil, fmt.Errorf("missing or invalid operation field")
-	}
+// one event being processed
+type Job struct {
+	EventID         string
+	UserID          string
+	Operation       events.Operation
+	BigQueryClient  *bigquery.Client
+	FirestoreClient *firestore.Client
+	Ledger          ledger.Ledger
+	Writer          *writer.Batcher
+	Analytics       *debounce.Scheduler
+	Aggregator      *aggregator.Aggregator
+}
 
+func (p *Processor) newJob(event events.Event) *Job {
+	header := event.EventHeader()
 	return &Job{
-		ID:              id,
-		RawData:         data,
-		Data:            parsedData,
-		Operation:       operation,
-		BigQueryClient:  bqClient,
-		FirestoreClient: fsClient,
-	}, nil
+		EventID:         header.EventID,
+		UserID:          header.UserID,
+		Operation:       header.Operation,
+		BigQueryClient:  p.BigQueryClient,
+		FirestoreClient: p.FirestoreClient,
+		Ledger:          p.Ledger,
+		Writer:          p.Writer,
+		Analytics:       p.Analytics,
+		Aggregator:      p.Aggregator,
+	}
+}
+
+func (p *Processor) addApplication(ctx context.Context, event *events.Add) error {
+	j := p.newJob(event)
+	return j.run(ctx, func(ctx context.Context) error {
+		return j.appendEvent(ctx, event.ObjectID, event.Timestamp, event.AppliedDate, event.Status, "add")
+	})
+}
+
+func (p *Processor) editStatus(ctx context.Context, event *events.EditStatus) error {
+	j := p.newJob(event)
+	return j.run(ctx, func(ctx context.Context) error {
+		return j.appendEvent(ctx, event.ObjectID, event.Timestamp, event.AppliedDate, event.Status, "edit")
+	})
 }
 
-// Process handles the job based on its operation type
+func (p *Processor) deleteApplication(ctx context.Context, event *events.Delete) error {
+	j := p.newJob(event)
+	return j.run(ctx, func(ctx context.Context) error {
+		if err := j.deleteJob(ctx, event.ObjectID); err != nil {
+			return fmt.Errorf("failed to process job: %w", err)
+		}
+		return j.invalidateAnalytics(ctx)
+	})
+}
+
+func (p *Processor) userDelete(ctx context.Context, event *events.UserDelete) error {
+	j := p.newJob(event)
+	return j.run(ctx, func(ctx context.Context) error {
+		if err := j.deleteUser(ctx); err != nil {
+			return fmt.Errorf("failed to process job: %w", err)
+		}
+		if err := j.Aggregator.Delete(ctx, j.UserID); err != nil {
+			return fmt.Errorf("failed to update analytics state: %w", err)
+		}
+		return nil
+	})
+}
+
+// no need to differentiate revert & revertLatest; they both send the UUID
+func (p *Processor) revert(ctx context.Context, event *events.Revert) error {
+	return p.revertOperation(ctx, event, event.ObjectID, event.OperationID)
+}
+
+func (p *Processor) revertLatest(ctx context.Context, event *events.RevertLatest) error {
+	return p.revertOperation(ctx, event, event.ObjectID, event.OperationID)
+}
+
+func (p *Processor) revertOperation(ctx context.Context, event events.Event, jobID string, operationID string) error {
+	j := p.newJob(event)
+	return j.run(ctx, func(ctx context.Context) error {
+		if err := j.revert(ctx, jobID, operationID); err != nil {
+			return fmt.Errorf("failed to process job: %w", err)
+		}
+		return j.invalidateAnalytics(ctx)
+	})
+}
+
+// what every operation shares around process, which updates the table and the user's incremental
+// analytics state
 // dataset: applications_data
 // table: applications
 // schema:
 //
 // operationID (primary key)
-// email (identifier for analytics and deletes)
+// userID (identifier for analytics and deletes)
+// email (only on rows from before user IDs; cmd/migrate-user-ids in the API filled in their userID)
 // jobID (identifier to do job-specific analytics)
 // event_time (time of event in unix seconds, required to knwow which state came first)
 // applied_date (time of application in unix seconds, required to know where to place in timeline)
 // status (current state of the application)
 // operation (add/edit, not strictly necessary but might be useful later)
-func (j *Job) Process(ctx context.Context) error {
-	log.Printf("[*] BigQuery [*]")
-	log.Printf("-------")
-	log.Printf("Processing Job With ID [%d] with content: [%s]", j.ID, j.Data)
-
-	var err error
-
-	switch j.Operation {
-	case "add", "editStatus":
-		err = j.appendJob(ctx)
-	case "delete":
-		err = j.deleteJob(ctx)
-	case "userDelete":
-		err = j.deleteUser(ctx)
-	// no need to differentiate revert & revertLatest; they both send the UUID
-	case "revert", "revertLatest":
-		err = j.revert(ctx)
-	case "editApplication":
-		log.Println("BigQuery does not support editApplication, doing nothing")
-		return nil
-	default:
-		err = fmt.Errorf("unknown operation: %s", j.Operation)
+func (j *Job) run(ctx context.Context, process func(ctx context.Context) error) error {
+	// redelivery or replay of an event we've already processed; messages published before
+	// event IDs existed can't be deduplicated and are always processed
+	if j.EventID != "" {
+		processed, err := j.Ledger.Processed(ctx, j.EventID)
+		if err != nil {
+			return fmt.Errorf("failed to check processed events: %w", err)
+		}
+		if processed {
+			log.Printf("Event [%s] already processed, skipping", j.EventID)
+			return nil
+		}
 	}
 
-	if err != nil {
-		return fmt.Errorf("failed to process job: %w", err)
+	if err := process(ctx); err != nil {
+		return err
 	}
 
 	// don't recalculate on userDelete
-	if j.Operation == "userDelete" {
-		return nil
+	if j.Operation == events.OpUserDelete {
+		return j.markProcessed(ctx)
+	}
+
+	// a burst of events for the same user shares one recalculation, which runs after the message
+	// is acked; the user stays marked pending until it's done (see RefreshAnalytics), so a
+	// recalculation lost to a restart still happens
+	if err := j.Ledger.MarkAnalyticsPending(ctx, j.UserID); err != nil {
+		return fmt.Errorf("failed to mark analytics pending: %w", err)
+	}
+	if err := j.Analytics.Schedule(j.UserID); err != nil {
+		return fmt.Errorf("failed to schedule analytics: %w", err)
+	}
+
+	return j.markProcessed(ctx)
+}
+
+// appends the row for an add or editStatus and applies it to the user's analytics state
+func (j *Job) appendEvent(ctx context.Context, jobID string, eventTime int64, appliedDate int64, applicationStatus string, operation string) error {
+	if err := j.appendJob(ctx, jobID, eventTime, appliedDate, applicationStatus, operation); err != nil {
+		return fmt.Errorf("failed to process job: %w", err)
+	}
+
+	// keep the user's incremental analytics state in step with the table
+	err := j.Aggregator.Apply(ctx, j.UserID, j.EventID, analytics.Row{
+		JobID:       jobID,
+		EventTime:   eventTime,
+		AppliedDate: appliedDate,
+		Status:      applicationStatus,
+		Operation:   operation,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update analytics state: %w", err)
+	}
+	return nil
+}
+
+// deletes and reverts can't be undone incrementally, so the state is rebuilt
+func (j *Job) invalidateAnalytics(ctx context.Context) error {
+	if err := j.Aggregator.Invalidate(ctx, j.UserID); err != nil {
+		return fmt.Errorf("failed to update analytics state: %w", err)
+	}
+	return nil
+}
+
+// computes a user's analytics from their incremental state, writes them to Firestore and clears
+// the user's pending mark; what the debounce scheduler runs
+// with shadowCheck the full SQL recalculation runs as well and any difference is logged
+func RefreshAnalytics(ctx context.Context, bqClient *bigquery.Client, fsClient *firestore.Client, processed ledger.Ledger, analyticsState *aggregator.Aggregator, shadowCheck bool, userID string) error {
+	j := &Job{
+		UserID:          userID,
+		BigQueryClient:  bqClient,
+		FirestoreClient: fsClient,
+	}
+
+	// read before computing: an event marked after this is one the computation may have missed
+	markedAt, err := processed.AnalyticsPending(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to read pending analytics: %w", err)
 	}
 
-	analytics, err := j.recalculateAnalytics(ctx)
+	computed, err := analyticsState.Compute(ctx, userID)
 	if err != nil {
-		return fmt.Errorf("failed to recalculate analytics: %w", err)
+		return fmt.Errorf("failed to compute analytics: %w", err)
 	}
 
-	log.Printf("Analytics recalculated: %v", analytics)
+	log.Printf("Analytics computed: %v", computed)
 
-	err = j.updateFirestore(ctx, analytics)
+	if shadowCheck {
+		j.checkParity(ctx, computed)
+	}
+
+	err = j.updateFirestore(ctx, computed)
 	if err != nil {
 		return fmt.Errorf("failed to update Firestore: %w", err)
 	}
 
 	log.Printf("Firestore updated successfully")
 
+	if err := processed.ClearAnalyticsPending(ctx, userID, markedAt); err != nil {
+		return fmt.Errorf("failed to clear pending analytics: %w", err)
+	}
 	return nil
 }
 
-// appends a job to the applications table
-func (j *Job) appendJob(ctx context.Context) error {
-	q := j.BigQueryClient.Query(`
-		INSERT INTO applications_data.applications 
-  			(operationID, email, jobID, event_time, applied_date, status, operation)
-		VALUES 
-  			(@operationID,
-			@email,
-			@jobID,
-			TIMESTAMP_SECONDS(@event_time),
-			TIMESTAMP_SECONDS(@applied_date),
-			@status,
-			@operation
-		)
-	`)
-	if j.Operation == "editStatus" {
-		j.Operation = "edit"
+func (j *Job) markProcessed(ctx context.Context) error {
+	if j.EventID == "" {
+		return nil
 	}
-	
-	q.Parameters = []bigquery.QueryParameter{
-		{Name: "operationID", Value: uuid.New().String()},
-		{Name: "email", Value: j.Data["email"]},
-		{Name: "jobID", Value: j.Data["objectID"]},
-		// json assumes all numbers are floats, so we need to cast to int64 (our schema requires it)
-		{Name: "event_time", Value: int64(j.Data["timestamp"].(float64))},
-		{Name: "applied_date", Value: int64(j.Data["appliedDate"].(float64))},
-		{Name: "status", Value: j.Data["status"]},
-		{Name: "operation", Value: j.Operation},
+	if err := j.Ledger.MarkProcessed(ctx, j.EventID); err != nil {
+		return fmt.Errorf("failed to mark event as processed: %w", err)
 	}
+	return nil
+}
 
-	job, err := q.Run(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to insert record: %w", err)
+// appends a job to the applications table through the batching writer; returns once the row is durable
+// operation is what BigQuery stores: "add" or "edit" (editStatus)
+// the operationID is the event ID assigned by the API. the default stream has no insert-time
+// dedup, so the ledger remembers the row once it's written and a redelivery doesn't append it
+// again; should a duplicate still get in (an append that timed out but landed), readers drop it
+func (j *Job) appendJob(ctx context.Context, jobID string, eventTime int64, appliedDate int64, applicationStatus string, operation string) error {
+	// messages published before the API assigned event IDs
+	operationID := j.EventID
+	if operationID == "" {
+		operationID = uuid.New().String()
+	} else {
+		written, err := j.Ledger.RowWritten(ctx, operationID)
+		if err != nil {
+			return fmt.Errorf("failed to check written rows: %w", err)
+		}
+		if written {
+			log.Printf("Job [%v] row for [%v] already written, skipping insert", jobID, operationID)
+			return nil
+		}
 	}
 
-	status, err := job.Wait(ctx)
+	err := j.Writer.Write(ctx, writer.Row{
+		OperationID: operationID,
+		UserID:      j.UserID,
+		JobID:       jobID,
+		EventTime:   eventTime,
+		AppliedDate: appliedDate,
+		Status:      applicationStatus,
+		Operation:   operation,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to wait for job: %w", err)
+		return fmt.Errorf("failed to insert record: %w", err)
 	}
 
-	if err := status.Err(); err != nil {
-		return fmt.Errorf("job completed with error: %w", err)
+	if j.EventID != "" {
+		if err := j.Ledger.MarkRowWritten(ctx, operationID); err != nil {
+			return fmt.Errorf("failed to mark row as written: %w", err)
+		}
 	}
 
-	log.Printf("Job [%v] inserted successfully with UUID [%v]", j.Data["objectID"], q.Parameters[0].Value)
+	log.Printf("Job [%v] inserted successfully with UUID [%v]", jobID, operationID)
 
 	return nil
 }
 
-// delete anything matching this user and the job ID (chance that job ID is not unique so we also need email)
-func (j *Job) deleteJob(ctx context.Context) error {
-	q := j.BigQueryClient.Query(`
-		DELETE FROM applications_data.applications
-		WHERE email = @email
-		AND jobID = @jobID
-	`)
-	q.Parameters = []bigquery.QueryParameter{
-		{Name: "email", Value: j.Data["email"]},
-		{Name: "jobID", Value: j.Data["objectID"]},
+// rows still buffered in the writer have to land before a DML statement that might touch them
+func (j *Job) flushWriter(ctx context.Context) error {
+	if err := j.Writer.Flush(ctx); err != nil {
+		return fmt.Errorf("failed to flush buffered rows: %w", err)
 	}
+	return nil
+}
+
+// DML can't touch rows BigQuery still holds in its streaming buffer. the Storage Write API
+// normally makes them available right away, but when it doesn't the statement fails with this.
+// nothing was changed, so the message is just retried; if the rows are stuck for longer than
+// MAX_DELIVERY_ATTEMPTS lasts it's quarantined and can be replayed later (see shared/quarantine)
+var ErrStreamingBuffer = errors.New("rows are still in the streaming buffer")
 
+// runs an UPDATE or DELETE and waits for it to finish
+func runDML(ctx context.Context, q *bigquery.Query) error {
 	job, err := q.Run(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to delete record: %w", err)
+		return dmlError(err)
 	}
 
 	status, err := job.Wait(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to wait for job: %w", err)
+		return dmlError(fmt.Errorf("failed to wait for job: %w", err))
 	}
 
 	if err := status.Err(); err != nil {
-		return fmt.Errorf("job completed with error: %w", err)
+		return dmlError(fmt.Errorf("job completed with error: %w", err))
 	}
-
-	log.Printf("Job [%v] deleted successfully for email [%v]", j.Data["objectID"], j.Data["email"])
-
 	return nil
 }
 
-// delete all records matching the user ID
-func (j *Job) deleteUser(ctx context.Context) error {
+func dmlError(err error) error {
+	if strings.Contains(err.Error(), "streaming buffer") {
+		log.Printf("DML hit rows in the streaming buffer, will retry: %v", err)
+		return fmt.Errorf("%w: %w", ErrStreamingBuffer, err)
+	}
+	return err
+}
+
+// delete anything matching this user and the job ID (chance that job ID is not unique so we also need the user ID)
+func (j *Job) deleteJob(ctx context.Context, jobID string) error {
+	if err := j.flushWriter(ctx); err != nil {
+		return err
+	}
+
 	q := j.BigQueryClient.Query(`
 		DELETE FROM applications_data.applications
-		WHERE email = @email
+		WHERE userID = @userID
+		AND jobID = @jobID
 	`)
 	q.Parameters = []bigquery.QueryParameter{
-		{Name: "email", Value: j.Data["email"]},
+		{Name: "userID", Value: j.UserID},
+		{Name: "jobID", Value: jobID},
 	}
 
-	job, err := q.Run(ctx)
-	if err != nil {
+	if err := runDML(ctx, q); err != nil {
 		return fmt.Errorf("failed to delete record: %w", err)
 	}
 
-	status, err := job.Wait(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to wait for job: %w", err)
+	log.Printf("Job [%v] deleted successfully for user [%v]", jobID, j.UserID)
+
+	return nil
+}
+
+// delete all records matching the user ID
+func (j *Job) deleteUser(ctx context.Context) error {
+	if err := j.flushWriter(ctx); err != nil {
+		return err
 	}
 
-	if err := status.Err(); err != nil {
-		return fmt.Errorf("job completed with error: %w", err)
+	q := j.BigQueryClient.Query(`
+		DELETE FROM applications_data.applications
+		WHERE userID = @userID
+	`)
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "userID", Value: j.UserID},
+	}
+
+	if err := runDML(ctx, q); err != nil {
+		return fmt.Errorf("failed to delete record: %w", err)
 	}
 
-	log.Printf("All jobs deleted successfully for email [%v]", j.Data["email"])
+	log.Printf("All jobs deleted successfully for user [%v]", j.UserID)
 
 	return nil
 }
@@ -227,41 +401,36 @@ func (j *Job) deleteUser(ctx context.Context) error {
 // reverts only the most recent operation for a given job ID. Algolia and Firestore do not know the UUIDs made in BigQuery
 // so we have to rely on event_time. However, Go enforces (1) no duplicate status updates and (2) no duplicate reverts
 // so this is actually safe to do
-func (j *Job) revert(ctx context.Context) error {
+func (j *Job) revert(ctx context.Context, jobID string, operationID string) error {
+	if err := j.flushWriter(ctx); err != nil {
+		return err
+	}
+
 	q := j.BigQueryClient.Query(`
 		UPDATE applications_data.applications
 		SET operation = 'revert'
-		WHERE email = @email
+		WHERE userID = @userID
 		AND jobID = @jobID
 		AND operationID = @operationID
 	`)
 	q.Parameters = []bigquery.QueryParameter{
-		{Name: "email", Value: j.Data["email"]},
-		{Name: "jobID", Value: j.Data["objectID"]},
-		{Name: "operationID", Value: j.Data["operationID"]},
+		{Name: "userID", Value: j.UserID},
+		{Name: "jobID", Value: jobID},
+		{Name: "operationID", Value: operationID},
 	}
 
-	job, err := q.Run(ctx)
-	if err != nil {
+	if err := runDML(ctx, q); err != nil {
 		return fmt.Errorf("failed to revert record: %w", err)
 	}
 
-	status, err := job.Wait(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to wait for job: %w", err)
-	}
-
-	if err := status.Err(); err != nil {
-		return fmt.Errorf("job completed with error: %w", err)
-	}
-
-	log.Printf("Job [%v] reverted successfully for email [%v]", j.Data["objectID"], j.Data["email"])
+	log.Printf("Job [%v] reverted successfully for user [%v]", jobID, j.UserID)
 
 	return nil
 }
 
 // each key in the map is the name of the analytic (identical to Firestore field name)
-// this is so that we can easily add more analytics in the future if we want
+// analytics are computed from the metrics registered in shared/analytics now; this is only
+// kept as the reference the shadow parity check compares the built-in metrics against
 // let's batch run all queries instead of running them one by one
 // by batch run I mean a huge query that does every calculation lol
 func (j *Job) recalculateAnalytics(ctx context.Context) (map[string]interface{}, error) {
@@ -272,7 +441,7 @@ func (j *Job) recalculateAnalytics(ctx context.Context) (map[string]interface{},
 		WITH UserApplications AS (
 			SELECT 
 				jobID,
-				email,
+				userID,
 				event_time,
 				applied_date,
 				status,
@@ -288,8 +457,10 @@ func (j *Job) recalculateAnalytics(ctx context.Context) (map[string]interface{},
 				AND applied_date < TIMESTAMP_SUB(CURRENT_TIMESTAMP(), INTERVAL 30 DAY)) AS in_previous_period,
 				FORMAT_TIMESTAMP('%Y-%m', applied_date) AS month
 			FROM applications_data.applications
-			WHERE email = @email
+			WHERE userID = @userID
 			AND operation != 'revert'
+			-- the writer is at-least-once, so the same operation can (rarely) be stored twice
+			QUALIFY ROW_NUMBER() OVER (PARTITION BY operationID) = 1
 		),
 
 		-- calculate avg time to first response 
@@ -396,7 +567,7 @@ func (j *Job) recalculateAnalytics(ctx context.Context) (map[string]interface{},
 		FROM UserApplications ua
     `)
 	q.Parameters = []bigquery.QueryParameter{
-		{Name: "email", Value: j.Data["email"]},
+		{Name: "userID", Value: j.UserID},
 	}
 
 	job, err := q.Run(ctx)
@@ -468,7 +639,7 @@ func (j *Job) recalculateAnalytics(ctx context.Context) (map[string]interface{},
 
 // update the Firestore document with the new analytics
 func (j *Job) updateFirestore(ctx context.Context, analytics map[string]interface{}) error {
-	doc := j.FirestoreClient.Collection("users").Doc(j.Data["email"].(string))
+	doc := j.FirestoreClient.Collection("users").Doc(j.UserID)
 
 	_, err := doc.Set(ctx, analytics, firestore.MergeAll)
 	if err != nil {
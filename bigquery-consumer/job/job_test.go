@@ -0,0 +1,121 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/copium-dev/copium/bigquery-consumer/debounce"
+	"github.com/copium-dev/copium/bigquery-consumer/ledger"
+	"github.com/copium-dev/copium/shared/consumer"
+	"github.com/copium-dev/copium/shared/events"
+)
+
+func TestDMLError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "streaming buffer",
+			err:  errors.New("UPDATE or DELETE statement over table applications_data.applications would affect rows in the streaming buffer, which is not supported"),
+			want: true,
+		},
+		{
+			name: "anything else",
+			err:  errors.New("Exceeded rate limits: too many table dml insert operations for this table"),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dmlError(tt.err)
+			if got := errors.Is(err, ErrStreamingBuffer); got != tt.want {
+				t.Errorf("errors.Is(dmlError(%q), ErrStreamingBuffer) = %v, want %v", tt.err, got, tt.want)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("dmlError dropped the original error: %v", err)
+			}
+		})
+	}
+}
+
+// every operation BigQuery stores has its own handler; editApplication has none and is acked as is
+func TestHandlers(t *testing.T) {
+	handlers := Handlers(&Processor{})
+
+	for _, op := range []events.Operation{events.OpAdd, events.OpEditStatus, events.OpDelete, events.OpUserDelete, events.OpRevert, events.OpRevertLatest} {
+		if handlers[op] == nil {
+			t.Errorf("no handler for %s", op)
+		}
+	}
+	if handlers[events.OpEditApplication] != nil {
+		t.Errorf("editApplication has a handler")
+	}
+}
+
+// a redelivered event is skipped before anything touches BigQuery, Firestore or the analytics
+func TestHandlersSkipProcessedEvents(t *testing.T) {
+	ctx := context.Background()
+	processed := ledger.NewMemoryLedger()
+	if err := processed.MarkProcessed(ctx, "event-1"); err != nil {
+		t.Fatalf("MarkProcessed: %v", err)
+	}
+	handlers := Handlers(&Processor{Ledger: processed})
+
+	header := events.Header{EventID: "event-1", UserID: "user-1"}
+	tests := []struct {
+		op    events.Operation
+		event events.Event
+	}{
+		{events.OpAdd, &events.Add{Header: header, ObjectID: "job-1", Status: "Applied"}},
+		{events.OpEditStatus, &events.EditStatus{Header: header, ObjectID: "job-1", Status: "Screen"}},
+		{events.OpDelete, &events.Delete{Header: header, ObjectID: "job-1"}},
+		{events.OpUserDelete, &events.UserDelete{Header: header}},
+		{events.OpRevert, &events.Revert{Header: header, ObjectID: "job-1", OperationID: "op-1"}},
+		{events.OpRevertLatest, &events.RevertLatest{Header: header, ObjectID: "job-1", OperationID: "op-1"}},
+	}
+
+	for _, tt := range tests {
+		if err := handlers[tt.op](ctx, &consumer.Job{Event: tt.event, Operation: tt.op}); err != nil {
+			t.Errorf("%s: %v", tt.op, err)
+		}
+	}
+}
+
+// the message is done once the user is marked pending and the recalculation is scheduled; the
+// recalculation itself runs later
+func TestRunSchedulesAnalytics(t *testing.T) {
+	ctx := context.Background()
+	processed := ledger.NewMemoryLedger()
+	refreshed := make(chan string, 1)
+	scheduler := debounce.New(func(ctx context.Context, userID string) error {
+		refreshed <- userID
+		return nil
+	}, time.Hour, time.Hour)
+
+	j := &Job{UserID: "user-1", EventID: "event-1", Operation: events.OpAdd, Ledger: processed, Analytics: scheduler}
+	if err := j.run(ctx, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if users, _ := processed.PendingAnalytics(ctx); len(users) != 1 || users[0] != "user-1" {
+		t.Errorf("PendingAnalytics = %q, want user-1", users)
+	}
+	if done, _ := processed.Processed(ctx, "event-1"); !done {
+		t.Error("event not marked processed")
+	}
+	select {
+	case userID := <-refreshed:
+		t.Fatalf("refreshed %s before the quiet period", userID)
+	default:
+	}
+
+	scheduler.Close(ctx)
+	if userID := <-refreshed; userID != "user-1" {
+		t.Errorf("refreshed %s, want user-1", userID)
+	}
+}
@@ -0,0 +1,71 @@
+package job
+
+import (
+	"context"
+	"log"
+	"math"
+	"reflect"
+
+	"github.com/copium-dev/copium/shared/analytics"
+)
+
+// compares incrementally computed analytics with the full SQL recalculation and logs any field
+// that differs. only used when ANALYTICS_SHADOW_CHECK is set, since it costs the full scan the
+// incremental path is there to avoid
+// both sides use "now" a moment apart, so an event right on a window boundary can show up as a
+// one-off difference
+func (j *Job) checkParity(ctx context.Context, computed map[string]interface{}) {
+	expected, err := j.recalculateAnalytics(ctx)
+	if err != nil {
+		log.Printf("Analytics parity check for [%s] failed to run: %v", j.UserID, err)
+		return
+	}
+
+	mismatches := 0
+	for field, want := range expected {
+		if field == "last_updated" {
+			continue
+		}
+		got := computed[field]
+		if !sameValue(normalize(want), normalize(got)) {
+			log.Printf("Analytics parity mismatch for [%s] on %s: SQL %v, incremental %v", j.UserID, field, want, got)
+			mismatches++
+		}
+	}
+
+	if mismatches == 0 {
+		log.Printf("Analytics parity check for [%s] passed", j.UserID)
+	}
+}
+
+// the SQL path uses int and job.MonthlyTrend, the incremental one int64 and analytics.MonthlyTrend
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case []MonthlyTrend:
+		if v == nil {
+			return []analytics.MonthlyTrend(nil)
+		}
+		trends := make([]analytics.MonthlyTrend, 0, len(v))
+		for _, trend := range v {
+			trends = append(trends, analytics.MonthlyTrend{
+				Month:        trend.Month,
+				Applications: trend.Applications,
+				Interviews:   trend.Interviews,
+				Offers:       trend.Offers,
+			})
+		}
+		return trends
+	}
+	return value
+}
+
+func sameValue(want interface{}, got interface{}) bool {
+	wantFloat, wantOk := want.(float64)
+	gotFloat, gotOk := got.(float64)
+	if wantOk && gotOk {
+		return math.Abs(wantFloat-gotFloat) < 1e-9
+	}
+	return reflect.DeepEqual(want, got)
+}
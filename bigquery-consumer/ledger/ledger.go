@@ -0,0 +1,223 @@
+package ledger
+
+// records which events have already been processed so that Pub/Sub redeliveries and
+// quarantine replays of the same event are no-ops. keyed by the event ID the API assigns
+// (shared/events Header.EventID), which is also the event's operationID in BigQuery
+// an event is only marked processed once everything it triggers (insert, analytics, Firestore)
+// is done, so a crash halfway means the event is processed again; every step is idempotent
+// on its own, the ledger just saves redoing them
+// the insert isn't quite: the Storage Write API's default stream has no insert-time dedup, so
+// the row is also marked written as soon as its append succeeds and isn't appended twice. an
+// append that times out but still lands can slip through; readers drop those by operationID
+// analytics are refreshed after the event is acked (debounced per user), so the ledger also
+// marks the user pending until a refresh that saw the event is done; pending users are
+// refreshed again on startup in case an instance stopped before getting to them
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// how long an event stays in the ledger; Pub/Sub stops redelivering after 7 days anyway
+const Retention = 30 * 24 * time.Hour
+
+type Ledger interface {
+	Processed(ctx context.Context, eventID string) (bool, error)
+	MarkProcessed(ctx context.Context, eventID string) error
+	// whether the event's row is already in the applications table
+	RowWritten(ctx context.Context, eventID string) (bool, error)
+	MarkRowWritten(ctx context.Context, eventID string) error
+	// marks the user's analytics as needing a refresh
+	MarkAnalyticsPending(ctx context.Context, userID string) error
+	// when the user was last marked pending; zero if they aren't
+	AnalyticsPending(ctx context.Context, userID string) (time.Time, error)
+	// clears the mark if it hasn't been set again since markedAt (what AnalyticsPending returned
+	// before the refresh started), so an event that came in during the refresh keeps it
+	ClearAnalyticsPending(ctx context.Context, userID string, markedAt time.Time) error
+	// every user still marked pending
+	PendingAnalytics(ctx context.Context) ([]string, error)
+}
+
+// processed_events/{eventID} and written_rows/{eventID}; configure a TTL policy on expireAt in
+// both (and in analytics_applied, which the aggregator writes the same way) so old entries are
+// cleaned up. pending users are analytics_pending/{userID}, deleted once refreshed
+type FirestoreLedger struct {
+	client *firestore.Client
+}
+
+func NewFirestoreLedger(client *firestore.Client) *FirestoreLedger {
+	return &FirestoreLedger{
+		client: client,
+	}
+}
+
+func (l *FirestoreLedger) Processed(ctx context.Context, eventID string) (bool, error) {
+	return l.exists(ctx, "processed_events", eventID)
+}
+
+func (l *FirestoreLedger) MarkProcessed(ctx context.Context, eventID string) error {
+	return l.mark(ctx, "processed_events", eventID)
+}
+
+func (l *FirestoreLedger) RowWritten(ctx context.Context, eventID string) (bool, error) {
+	return l.exists(ctx, "written_rows", eventID)
+}
+
+func (l *FirestoreLedger) MarkRowWritten(ctx context.Context, eventID string) error {
+	return l.mark(ctx, "written_rows", eventID)
+}
+
+func (l *FirestoreLedger) MarkAnalyticsPending(ctx context.Context, userID string) error {
+	_, err := l.client.Collection("analytics_pending").Doc(userID).Set(ctx, map[string]interface{}{
+		"markedAt": firestore.ServerTimestamp,
+	})
+	return err
+}
+
+// the document's update time, which the delete below is conditioned on; Firestore sets it, so
+// instances with different clocks agree on it
+func (l *FirestoreLedger) AnalyticsPending(ctx context.Context, userID string) (time.Time, error) {
+	doc, err := l.client.Collection("analytics_pending").Doc(userID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return doc.UpdateTime, nil
+}
+
+func (l *FirestoreLedger) ClearAnalyticsPending(ctx context.Context, userID string, markedAt time.Time) error {
+	if markedAt.IsZero() {
+		return nil
+	}
+	_, err := l.client.Collection("analytics_pending").Doc(userID).Delete(ctx, firestore.LastUpdateTime(markedAt))
+	// marked again since (FailedPrecondition), or already cleared (NotFound)
+	if code := status.Code(err); code == codes.FailedPrecondition || code == codes.NotFound {
+		return nil
+	}
+	return err
+}
+
+func (l *FirestoreLedger) PendingAnalytics(ctx context.Context) ([]string, error) {
+	refs, err := l.client.Collection("analytics_pending").DocumentRefs(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		userIDs = append(userIDs, ref.ID)
+	}
+	return userIDs, nil
+}
+
+func (l *FirestoreLedger) exists(ctx context.Context, collection string, eventID string) (bool, error) {
+	_, err := l.client.Collection(collection).Doc(eventID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *FirestoreLedger) mark(ctx context.Context, collection string, eventID string) error {
+	now := time.Now()
+	_, err := l.client.Collection(collection).Doc(eventID).Set(ctx, map[string]interface{}{
+		"processedAt": now,
+		"expireAt":    now.Add(Retention),
+	})
+	return err
+}
+
+// for local dev; forgets everything on restart
+type MemoryLedger struct {
+	mu        sync.Mutex
+	processed map[string]time.Time
+	written   map[string]time.Time
+	pending   map[string]time.Time
+}
+
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{
+		processed: make(map[string]time.Time),
+		written:   make(map[string]time.Time),
+		pending:   make(map[string]time.Time),
+	}
+}
+
+func (l *MemoryLedger) Processed(ctx context.Context, eventID string) (bool, error) {
+	return l.exists(l.processed, eventID), nil
+}
+
+func (l *MemoryLedger) MarkProcessed(ctx context.Context, eventID string) error {
+	l.mark(l.processed, eventID)
+	return nil
+}
+
+func (l *MemoryLedger) RowWritten(ctx context.Context, eventID string) (bool, error) {
+	return l.exists(l.written, eventID), nil
+}
+
+func (l *MemoryLedger) MarkRowWritten(ctx context.Context, eventID string) error {
+	l.mark(l.written, eventID)
+	return nil
+}
+
+func (l *MemoryLedger) MarkAnalyticsPending(ctx context.Context, userID string) error {
+	l.mark(l.pending, userID)
+	return nil
+}
+
+func (l *MemoryLedger) AnalyticsPending(ctx context.Context, userID string) (time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.pending[userID], nil
+}
+
+func (l *MemoryLedger) ClearAnalyticsPending(ctx context.Context, userID string, markedAt time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.pending[userID]; ok && current.Equal(markedAt) {
+		delete(l.pending, userID)
+	}
+	return nil
+}
+
+func (l *MemoryLedger) PendingAnalytics(ctx context.Context) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	userIDs := make([]string, 0, len(l.pending))
+	for userID := range l.pending {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, nil
+}
+
+func (l *MemoryLedger) exists(entries map[string]time.Time, eventID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	markedAt, ok := entries[eventID]
+	if ok && time.Since(markedAt) > Retention {
+		delete(entries, eventID)
+		return false
+	}
+	return ok
+}
+
+func (l *MemoryLedger) mark(entries map[string]time.Time, eventID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries[eventID] = time.Now()
+}
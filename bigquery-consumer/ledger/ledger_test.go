@@ -0,0 +1,64 @@
+package ledger
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// processed and written are tracked separately: an event whose row is in the table may still
+// need its analytics done
+func TestMemoryLedger(t *testing.T) {
+	l := NewMemoryLedger()
+	ctx := context.Background()
+
+	if err := l.MarkRowWritten(ctx, "event-1"); err != nil {
+		t.Fatalf("MarkRowWritten: %v", err)
+	}
+	if written, _ := l.RowWritten(ctx, "event-1"); !written {
+		t.Error("RowWritten = false after MarkRowWritten")
+	}
+	if processed, _ := l.Processed(ctx, "event-1"); processed {
+		t.Error("Processed = true after only MarkRowWritten")
+	}
+
+	if err := l.MarkProcessed(ctx, "event-1"); err != nil {
+		t.Fatalf("MarkProcessed: %v", err)
+	}
+	if processed, _ := l.Processed(ctx, "event-1"); !processed {
+		t.Error("Processed = false after MarkProcessed")
+	}
+	if written, _ := l.RowWritten(ctx, "event-2"); written {
+		t.Error("RowWritten = true for an unknown event")
+	}
+}
+
+// a mark set again during a refresh survives clearing with the one read before it
+func TestMemoryLedgerAnalyticsPending(t *testing.T) {
+	l := NewMemoryLedger()
+	ctx := context.Background()
+
+	if markedAt, _ := l.AnalyticsPending(ctx, "user-1"); !markedAt.IsZero() {
+		t.Errorf("AnalyticsPending = %s before any mark, want zero", markedAt)
+	}
+
+	l.MarkAnalyticsPending(ctx, "user-1")
+	before, _ := l.AnalyticsPending(ctx, "user-1")
+	time.Sleep(time.Millisecond)
+	l.MarkAnalyticsPending(ctx, "user-1")
+
+	if err := l.ClearAnalyticsPending(ctx, "user-1", before); err != nil {
+		t.Fatalf("ClearAnalyticsPending: %v", err)
+	}
+	if users, _ := l.PendingAnalytics(ctx); len(users) != 1 || users[0] != "user-1" {
+		t.Fatalf("PendingAnalytics = %q after clearing a stale mark, want user-1", users)
+	}
+
+	latest, _ := l.AnalyticsPending(ctx, "user-1")
+	if err := l.ClearAnalyticsPending(ctx, "user-1", latest); err != nil {
+		t.Fatalf("ClearAnalyticsPending: %v", err)
+	}
+	if users, _ := l.PendingAnalytics(ctx); len(users) != 0 {
+		t.Errorf("PendingAnalytics = %q, want none", users)
+	}
+}
@@ -0,0 +1,135 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// must match the applications table; the Storage Write API rejects rows that don't
+// (the table's email column is only set on rows from before user IDs; new rows leave it null)
+var applicationsSchema = bigquery.Schema{
+	{Name: "operationID", Type: bigquery.StringFieldType},
+	{Name: "userID", Type: bigquery.StringFieldType},
+	{Name: "jobID", Type: bigquery.StringFieldType},
+	{Name: "event_time", Type: bigquery.TimestampFieldType},
+	{Name: "applied_date", Type: bigquery.TimestampFieldType},
+	{Name: "status", Type: bigquery.StringFieldType},
+	{Name: "operation", Type: bigquery.StringFieldType},
+}
+
+// writes batches to the table's default stream with the Storage Write API
+// rows are committed as soon as an append succeeds, and unlike legacy streaming inserts they
+// can normally be updated and deleted by DML right away (revert, delete); see job.ErrStreamingBuffer
+// for when they can't. the default stream is at-least-once, see the ledger for how
+// redeliveries are kept from appending a row twice
+type StorageWriteSink struct {
+	client     *managedwriter.Client
+	stream     *managedwriter.ManagedStream
+	descriptor protoreflect.MessageDescriptor
+}
+
+func NewStorageWriteSink(ctx context.Context, projectID string, datasetID string, tableID string) (*StorageWriteSink, error) {
+	messageDescriptor, normalized, err := rowDescriptor()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := managedwriter.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Storage Write client: %w", err)
+	}
+
+	stream, err := client.NewManagedStream(ctx,
+		managedwriter.WithDestinationTable(managedwriter.TableParentFromParts(projectID, datasetID, tableID)),
+		managedwriter.WithType(managedwriter.DefaultStream),
+		managedwriter.WithSchemaDescriptor(normalized),
+	)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to open write stream: %w", err)
+	}
+
+	return &StorageWriteSink{
+		client:     client,
+		stream:     stream,
+		descriptor: messageDescriptor,
+	}, nil
+}
+
+// the descriptor rows are encoded with, and its normalized form for the stream
+func rowDescriptor() (protoreflect.MessageDescriptor, *descriptorpb.DescriptorProto, error) {
+	tableSchema, err := adapt.BQSchemaToStorageTableSchema(applicationsSchema)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to convert schema: %w", err)
+	}
+
+	descriptor, err := adapt.StorageSchemaToProto2Descriptor(tableSchema, "root")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build row descriptor: %w", err)
+	}
+	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, nil, fmt.Errorf("row descriptor is not a message descriptor")
+	}
+
+	normalized, err := adapt.NormalizeDescriptor(messageDescriptor)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to normalize row descriptor: %w", err)
+	}
+	return messageDescriptor, normalized, nil
+}
+
+// a single append is atomic, so either every row in the batch is written or none are
+func (s *StorageWriteSink) Write(ctx context.Context, rows []Row) error {
+	data := make([][]byte, 0, len(rows))
+	for _, row := range rows {
+		encoded, err := s.encode(row)
+		if err != nil {
+			return fmt.Errorf("failed to encode row %s: %w", row.OperationID, err)
+		}
+		data = append(data, encoded)
+	}
+
+	result, err := s.stream.AppendRows(ctx, data)
+	if err != nil {
+		return fmt.Errorf("failed to append rows: %w", err)
+	}
+
+	if _, err := result.GetResult(ctx); err != nil {
+		return fmt.Errorf("append failed: %w", err)
+	}
+
+	return nil
+}
+
+func (s *StorageWriteSink) Close() error {
+	if err := s.stream.Close(); err != nil {
+		s.client.Close()
+		return err
+	}
+	return s.client.Close()
+}
+
+// TIMESTAMP columns are sent as microseconds since the epoch
+func (s *StorageWriteSink) encode(row Row) ([]byte, error) {
+	fields := s.descriptor.Fields()
+	message := dynamicpb.NewMessage(s.descriptor)
+
+	message.Set(fields.ByName("operationID"), protoreflect.ValueOfString(row.OperationID))
+	message.Set(fields.ByName("userID"), protoreflect.ValueOfString(row.UserID))
+	message.Set(fields.ByName("jobID"), protoreflect.ValueOfString(row.JobID))
+	message.Set(fields.ByName("event_time"), protoreflect.ValueOfInt64(row.EventTime*1_000_000))
+	message.Set(fields.ByName("applied_date"), protoreflect.ValueOfInt64(row.AppliedDate*1_000_000))
+	message.Set(fields.ByName("status"), protoreflect.ValueOfString(row.Status))
+	message.Set(fields.ByName("operation"), protoreflect.ValueOfString(row.Operation))
+
+	return proto.Marshal(message)
+}
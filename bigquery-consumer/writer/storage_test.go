@@ -0,0 +1,49 @@
+package writer
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestEncode(t *testing.T) {
+	descriptor, _, err := rowDescriptor()
+	if err != nil {
+		t.Fatalf("rowDescriptor: %v", err)
+	}
+	sink := &StorageWriteSink{descriptor: descriptor}
+
+	data, err := sink.encode(Row{
+		OperationID: "op-1",
+		UserID:      "user-1",
+		JobID:       "job-1",
+		EventTime:   1700000001,
+		AppliedDate: 1700000000,
+		Status:      "Applied",
+		Operation:   "add",
+	})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	message := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(data, message); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	fields := descriptor.Fields()
+
+	for name, want := range map[string]string{"operationID": "op-1", "userID": "user-1", "jobID": "job-1", "status": "Applied", "operation": "add"} {
+		if got := message.Get(fields.ByName(protoreflect.Name(name))).String(); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	// TIMESTAMP columns take microseconds
+	if got := message.Get(fields.ByName("event_time")).Int(); got != 1700000001_000_000 {
+		t.Errorf("event_time = %d, want microseconds", got)
+	}
+	if got := message.Get(fields.ByName("applied_date")).Int(); got != 1700000000_000_000 {
+		t.Errorf("applied_date = %d, want microseconds", got)
+	}
+}
@@ -0,0 +1,198 @@
+package writer
+
+// buffers timeline rows across messages and writes them to BigQuery in batches, instead of
+// running one DML INSERT per event (slow, and runs into DML quotas under bursty load)
+// a batch is written once it has maxRows rows or its first row has waited maxDelay, whichever
+// comes first. Write blocks until the row's batch is durable (or failed), so consumers still
+// only ack a message once its row is actually in BigQuery
+// writes are at-least-once: a batch that times out may still land, and the message is then
+// retried, so readers of the table deduplicate on operationID
+//
+// sizing: messages are delivered in order per user, and a user's next message only arrives once
+// the last one is acked, so a batch holds at most one row per user. batches fill from many
+// users' messages at once (up to NUM_GOROUTINES in pull mode, the instance's request concurrency
+// for push) and a message waits at most maxDelay for its batch. so maxDelay is what one user's
+// burst pays per event and is kept short; maxRows only needs to cover the rows in flight at once,
+// so a busy consumer writes a full batch without waiting for the timer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// the consumer's default NUM_GOROUTINES, so a full house of messages is one batch
+	DefaultMaxRows  = 100
+	DefaultMaxDelay = 100 * time.Millisecond
+
+	// how long writing a single batch may take; not tied to any one message's context since
+	// a batch holds rows from many messages
+	writeTimeout = 30 * time.Second
+)
+
+var ErrClosed = errors.New("writer closed")
+
+// one row of applications_data.applications; times are unix seconds
+type Row struct {
+	OperationID string
+	UserID      string
+	JobID       string
+	EventTime   int64
+	AppliedDate int64
+	Status      string
+	Operation   string
+}
+
+// where batches end up; a write either stores every row or fails
+type Sink interface {
+	Write(ctx context.Context, rows []Row) error
+	Close() error
+}
+
+type batch struct {
+	rows []Row
+	// closed once the batch is written; err is set before that
+	done chan struct{}
+	err  error
+}
+
+type Batcher struct {
+	sink     Sink
+	maxRows  int
+	maxDelay time.Duration
+
+	mu      sync.Mutex
+	current *batch
+	timer   *time.Timer
+	// batches being written, so Flush can wait for them
+	inflight map[*batch]struct{}
+	closed   bool
+}
+
+func NewBatcher(sink Sink, maxRows int, maxDelay time.Duration) *Batcher {
+	if maxRows <= 0 {
+		maxRows = DefaultMaxRows
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+	return &Batcher{
+		sink:     sink,
+		maxRows:  maxRows,
+		maxDelay: maxDelay,
+		inflight: make(map[*batch]struct{}),
+	}
+}
+
+// adds the row to the current batch and waits until that batch has been written
+// if ctx is done first the row may still be written later
+func (b *Batcher) Write(ctx context.Context, row Row) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+
+	if b.current == nil {
+		pending := &batch{done: make(chan struct{})}
+		b.current = pending
+		b.timer = time.AfterFunc(b.maxDelay, func() {
+			b.flushBatch(pending)
+		})
+	}
+
+	pending := b.current
+	pending.rows = append(pending.rows, row)
+	if len(pending.rows) >= b.maxRows {
+		b.detach()
+	}
+	b.mu.Unlock()
+
+	return wait(ctx, pending)
+}
+
+// writes whatever is buffered and waits for every batch that is still being written
+// called before DML statements so they see every row written before them
+func (b *Batcher) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	if b.current != nil {
+		b.detach()
+	}
+	pending := make([]*batch, 0, len(b.inflight))
+	for inflight := range b.inflight {
+		pending = append(pending, inflight)
+	}
+	b.mu.Unlock()
+
+	for _, inflight := range pending {
+		if err := wait(ctx, inflight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushes and closes the sink; Write fails with ErrClosed afterwards
+func (b *Batcher) Close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	flushErr := b.Flush(ctx)
+	if err := b.sink.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// timer callback; the batch may have been detached already (full or flushed)
+func (b *Batcher) flushBatch(pending *batch) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.current == pending {
+		b.detach()
+	}
+}
+
+// starts writing the current batch; must hold mu
+func (b *Batcher) detach() {
+	pending := b.current
+	b.current = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	b.inflight[pending] = struct{}{}
+	go b.write(pending)
+}
+
+func (b *Batcher) write(pending *batch) {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	pending.err = b.sink.Write(ctx, pending.rows)
+	if pending.err != nil {
+		log.Printf("Failed to write batch of %d rows: %v", len(pending.rows), pending.err)
+	} else {
+		log.Printf("Wrote batch of %d rows", len(pending.rows))
+	}
+	close(pending.done)
+
+	b.mu.Lock()
+	delete(b.inflight, pending)
+	b.mu.Unlock()
+}
+
+func wait(ctx context.Context, pending *batch) error {
+	select {
+	case <-pending.done:
+		return pending.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
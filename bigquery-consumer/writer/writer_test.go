@@ -0,0 +1,272 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// records every batch; a write waits for release if it's set and fails with err if that's set
+type fakeSink struct {
+	mu      sync.Mutex
+	batches [][]Row
+	closed  bool
+	err     error
+	release chan struct{}
+}
+
+func (s *fakeSink) Write(ctx context.Context, rows []Row) error {
+	if s.release != nil {
+		<-s.release
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, rows)
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeSink) written() [][]Row {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]Row(nil), s.batches...)
+}
+
+func row(i int) Row {
+	return Row{OperationID: fmt.Sprintf("op-%d", i), UserID: fmt.Sprintf("user-%d", i), Operation: "add"}
+}
+
+// writes each row from its own goroutine, the way messages for different users arrive
+func writeAll(b *Batcher, rows int) []error {
+	errs := make([]error, rows)
+	var wg sync.WaitGroup
+	for i := 0; i < rows; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = b.Write(context.Background(), row(i))
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// blocks until the current batch holds rows rows
+func waitBuffered(t *testing.T, b *Batcher, rows int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		buffered := 0
+		if b.current != nil {
+			buffered = len(b.current.rows)
+		}
+		b.mu.Unlock()
+		if buffered == rows {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d rows never buffered", rows)
+}
+
+// a full batch is written right away; the timer would never fire here
+func TestBatcherMaxRows(t *testing.T) {
+	sink := &fakeSink{}
+	b := NewBatcher(sink, 10, time.Hour)
+
+	for i, err := range writeAll(b, 10) {
+		if err != nil {
+			t.Errorf("Write %d: %v", i, err)
+		}
+	}
+
+	batches := sink.written()
+	if len(batches) != 1 || len(batches[0]) != 10 {
+		t.Fatalf("wrote %d batches, want one of 10 rows", len(batches))
+	}
+}
+
+func TestBatcherMaxDelay(t *testing.T) {
+	sink := &fakeSink{}
+	b := NewBatcher(sink, 100, 20*time.Millisecond)
+
+	start := time.Now()
+	if err := b.Write(context.Background(), row(1)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond || waited > time.Second {
+		t.Errorf("Write returned after %s, want about maxDelay", waited)
+	}
+	if batches := sink.written(); len(batches) != 1 || len(batches[0]) != 1 {
+		t.Errorf("wrote %v, want one batch of one row", batches)
+	}
+}
+
+// what the package comment describes: one user's messages come one at a time, so each of their
+// rows is its own batch and pays maxDelay, while rows from many users at once share one
+func TestBatcherOrderedDelivery(t *testing.T) {
+	sink := &fakeSink{}
+	b := NewBatcher(sink, 100, 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if err := b.Write(context.Background(), Row{OperationID: fmt.Sprintf("op-%d", i), UserID: "user-1"}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if batches := sink.written(); len(batches) != 3 {
+		t.Fatalf("one user's rows went out in %d batches, want 3", len(batches))
+	}
+
+	writeAll(b, 50)
+	batches := sink.written()[3:]
+	rows := 0
+	for _, batch := range batches {
+		rows += len(batch)
+	}
+	if rows != 50 || len(batches) > 2 {
+		t.Errorf("50 users' rows went out in %d batches (%d rows), want them to share one", len(batches), rows)
+	}
+}
+
+func TestBatcherFlush(t *testing.T) {
+	sink := &fakeSink{release: make(chan struct{})}
+	b := NewBatcher(sink, 100, time.Hour)
+
+	written := make(chan error, 1)
+	go func() {
+		written <- b.Write(context.Background(), row(1))
+	}()
+	waitBuffered(t, b, 1)
+
+	// the batch is being written but the sink hasn't finished, so Flush has to wait for it
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- b.Flush(context.Background())
+	}()
+	select {
+	case err := <-flushed:
+		t.Fatalf("Flush returned %v before the batch was written", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(sink.release)
+	if err := <-flushed; err != nil {
+		t.Errorf("Flush: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Errorf("Write: %v", err)
+	}
+	if batches := sink.written(); len(batches) != 1 {
+		t.Errorf("wrote %d batches, want 1", len(batches))
+	}
+}
+
+func TestBatcherFlushEmpty(t *testing.T) {
+	b := NewBatcher(&fakeSink{}, 100, time.Hour)
+	if err := b.Flush(context.Background()); err != nil {
+		t.Errorf("Flush: %v", err)
+	}
+}
+
+func TestBatcherClose(t *testing.T) {
+	sink := &fakeSink{}
+	b := NewBatcher(sink, 100, time.Hour)
+
+	written := make(chan error, 1)
+	go func() {
+		written <- b.Write(context.Background(), row(1))
+	}()
+	waitBuffered(t, b, 1)
+
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Errorf("Write: %v", err)
+	}
+	if batches := sink.written(); len(batches) != 1 {
+		t.Errorf("wrote %d batches, want the buffered row written on Close", len(batches))
+	}
+	if !sink.closed {
+		t.Error("sink not closed")
+	}
+
+	if err := b.Write(context.Background(), row(2)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Write after Close = %v, want ErrClosed", err)
+	}
+}
+
+// every row in a failed batch gets the error, and so does a Flush waiting on it
+func TestBatcherError(t *testing.T) {
+	failed := errors.New("append failed")
+	sink := &fakeSink{err: failed, release: make(chan struct{})}
+	b := NewBatcher(sink, 3, time.Hour)
+
+	written := make(chan []error, 1)
+	go func() {
+		written <- writeAll(b, 3)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.mu.Lock()
+		inflight := len(b.inflight)
+		b.mu.Unlock()
+		if inflight == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("batch never started writing")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- b.Flush(context.Background())
+	}()
+	// let Flush pick up the batch before it's done
+	time.Sleep(20 * time.Millisecond)
+	close(sink.release)
+
+	for i, err := range <-written {
+		if !errors.Is(err, failed) {
+			t.Errorf("Write %d = %v, want the sink's error", i, err)
+		}
+	}
+	if err := <-flushed; !errors.Is(err, failed) {
+		t.Errorf("Flush = %v, want the sink's error", err)
+	}
+}
+
+// the caller stops waiting, but the row stays in its batch
+func TestBatcherWriteContext(t *testing.T) {
+	sink := &fakeSink{}
+	b := NewBatcher(sink, 100, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.Write(ctx, row(1)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Write = %v, want context.Canceled", err)
+	}
+
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if batches := sink.written(); len(batches) != 1 || len(batches[0]) != 1 {
+		t.Errorf("wrote %v, want the row written anyway", batches)
+	}
+}
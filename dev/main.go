@@ -0,0 +1,70 @@
+package main
+
+// the API and both consumers in one process, connected by the in-memory bus (see shared/bus), so
+// there's no Pub/Sub emulator or NATS server to start:
+//
+//	cd dev && go run .
+//
+// everything else is configured as for the separate binaries (.env, CONFIG_FILE, environment
+// variables) except MESSAGE_BUS, which is always memory here. messages still on the bus when it
+// stops are lost, since the outbox let go of them once they were published
+//
+// APPLICATION_STORE=postgres keeps its own event log and analytics, so the BigQuery consumer
+// doesn't run with it. the consumers' admin endpoints are on ALGOLIA_ADMIN_PORT and
+// BIGQUERY_ADMIN_PORT as usual
+
+import (
+	"context"
+	"log"
+
+	algolia "github.com/copium-dev/copium/algolia-consumer/app"
+	bigquery "github.com/copium-dev/copium/bigquery-consumer/app"
+	"github.com/copium-dev/copium/go/cmd/api"
+	"github.com/copium-dev/copium/shared/bus"
+	"github.com/copium-dev/copium/shared/config"
+	"github.com/copium-dev/copium/shared/shutdown"
+)
+
+type runFunc func(ctx context.Context, drain context.Context, cfg *config.Config) error
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.Deployed() {
+		log.Fatalf("dev only runs with ENVIRONMENT=%s, not %s", config.Dev, cfg.Environment)
+	}
+	cfg.MessageBus = bus.KindMemory
+
+	// SIGINT/SIGTERM stops everything at once; SHUTDOWN_TIMEOUT is shared the same way
+	ctx, stop := shutdown.Signal()
+	defer stop()
+	drain, cancelDrain := shutdown.Deadline(ctx, cfg.ShutdownTimeout.Duration)
+	defer cancelDrain()
+
+	// the subscriptions have to exist before the API publishes, or what it publishes never
+	// reaches them
+	runs := []runFunc{api.Run}
+	subscriptions := map[string]runFunc{cfg.AlgoliaSubscription: algolia.Run}
+	if cfg.ApplicationStore != config.StorePostgres {
+		subscriptions[cfg.BigQuerySubscription] = bigquery.Run
+	}
+	for subscription, run := range subscriptions {
+		bus.DefaultMemory.CreateSubscription(cfg.Topic, subscription)
+		runs = append(runs, run)
+	}
+
+	errs := make(chan error, len(runs))
+	for _, run := range runs {
+		go func() {
+			errs <- run(ctx, drain, cfg)
+		}()
+	}
+	for range runs {
+		if err := <-errs; err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Println("[*] DEV [*] Shut down")
+}
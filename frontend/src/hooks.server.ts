@@ -1,15 +1,120 @@
-import type { Handle } from '@sveltejs/kit';
+import type { Handle, HandleFetch } from '@sveltejs/kit';
+import { BACKEND_URL } from '$env/static/private';
+
+// access tokens only live for 15 minutes; the refresh token (30 days, rotated on every
+// use) gets a new pair from the backend. refresh a bit early so a token doesn't expire
+// between here and the backend
+const EXPIRY_MARGIN_SECONDS = 60;
+const REFRESH_TOKEN_MAX_AGE = 30 * 24 * 60 * 60;
+// same as the backend's reuseGracePeriod
+const REFRESH_REUSE_MS = 30 * 1000;
+
+// reads exp from the JWT payload; the signature is the backend's business
+function expiresSoon(token: string): boolean {
+    try {
+        const payload = token.split('.')[1].replace(/-/g, '+').replace(/_/g, '/');
+        const { exp } = JSON.parse(atob(payload));
+        return typeof exp !== 'number' || exp - EXPIRY_MARGIN_SECONDS < Date.now() / 1000;
+    } catch {
+        return true;
+    }
+}
+
+type Tokens = { accessToken: string; refreshToken: string; expiresIn: number };
+
+// null if the refresh token was rejected (revoked, expired or reused long after rotating)
+// undefined if it couldn't be refreshed right now, including 409: another request just rotated
+// the same token, and its response is setting the new one in the browser
+async function refresh(refreshToken: string): Promise<Tokens | null | undefined> {
+    try {
+        const response = await fetch(`${BACKEND_URL}/auth/refresh`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refreshToken }),
+        });
+        if (response.status === 401) {
+            return null;
+        }
+        if (!response.ok) {
+            return undefined;
+        }
+        return await response.json();
+    } catch (err) {
+        // backend unreachable; keep the refresh token and try again next request
+        console.error('failed to refresh session', err);
+        return undefined;
+    }
+}
+
+// a page load fires several requests at once, all carrying the same refresh token cookie. only the
+// first may rotate it; the rest (and any that arrive with the old cookie shortly after) share its
+// result instead of getting a 409. the backend still answers 409 when the race is between
+// two server instances
+const refreshes = new Map<string, Promise<Tokens | null | undefined>>();
+
+function sharedRefresh(refreshToken: string): Promise<Tokens | null | undefined> {
+    let pending = refreshes.get(refreshToken);
+    if (!pending) {
+        pending = refresh(refreshToken);
+        refreshes.set(refreshToken, pending);
+        pending.then((tokens) => {
+            // nothing to share if it failed; let the next request try again
+            if (tokens === undefined) {
+                refreshes.delete(refreshToken);
+            } else {
+                setTimeout(() => refreshes.delete(refreshToken), REFRESH_REUSE_MS);
+            }
+        });
+    }
+    return pending;
+}
 
 // since this is a hook it runs for every request
 // we need this to allow any server-side action/load function to access
 // via locals.authToken
 export const handle: Handle = async ({ event, resolve }) => {
-    // get token from cookie (set in auth-complete)
-    const authToken = event.cookies.get('authToken');
-    
+    // get tokens from cookies (set in auth-complete)
+    let authToken = event.cookies.get('authToken');
+    const refreshToken = event.cookies.get('refreshToken');
+
+    if (refreshToken && (!authToken || expiresSoon(authToken))) {
+        const tokens = await sharedRefresh(refreshToken);
+        if (tokens) {
+            authToken = tokens.accessToken;
+            event.cookies.set('authToken', tokens.accessToken, {
+                path: '/', maxAge: REFRESH_TOKEN_MAX_AGE, sameSite: 'lax', secure: true, httpOnly: false,
+            });
+            event.cookies.set('refreshToken', tokens.refreshToken, {
+                path: '/', maxAge: REFRESH_TOKEN_MAX_AGE, sameSite: 'lax', secure: true, httpOnly: true,
+            });
+        } else if (tokens === null) {
+            // logged out (possibly from another device); pages redirect to login
+            authToken = undefined;
+            event.cookies.delete('authToken', { path: '/' });
+            event.cookies.delete('refreshToken', { path: '/' });
+        }
+    }
+
     if (authToken) {
         event.locals.authToken = authToken;
     }
     
     return resolve(event);
-};
\ No newline at end of file
+};
+
+// with AUTH_MODE=cookie on the backend (same-domain deployments) the session is the backend's
+// copium_session cookie instead of locals.authToken. event.fetch passes the browser's cookies on
+// to the backend since it's on our domain; unsafe requests also need the CSRF cookie echoed back
+export const handleFetch: HandleFetch = async ({ event, request, fetch }) => {
+    if (request.url.startsWith(BACKEND_URL)) {
+        // pages always send `Bearer ${locals.authToken}`, which is undefined with a cookie session
+        if (request.headers.get('Authorization') === 'Bearer undefined') {
+            request.headers.delete('Authorization');
+        }
+        const csrfToken = event.cookies.get('copium_csrf');
+        if (csrfToken && !['GET', 'HEAD', 'OPTIONS'].includes(request.method)) {
+            request.headers.set('X-CSRF-Token', csrfToken);
+        }
+    }
+    return fetch(request);
+};
@@ -25,6 +25,8 @@ export const load: PageServerLoad = async ({ fetch, url, locals }) => {
     const startDate = url.searchParams.get('startDate');
     const endDate = url.searchParams.get('endDate');
     const hitsPerPage = url.searchParams.get('hits');
+    // someone who shared their applications with this user (read-only)
+    const owner = url.searchParams.get('user');
 
     const params = new URLSearchParams();
     if (page) params.set('page', page);
@@ -36,6 +38,7 @@ export const load: PageServerLoad = async ({ fetch, url, locals }) => {
     if (startDate) params.set('startDate', startDate);
     if (endDate) params.set('endDate', endDate);
     if (hitsPerPage) params.set('hits', hitsPerPage);
+    if (owner) params.set('user', owner);
 
     const dashboardURL = `${BACKEND_URL}/user/dashboard?${params.toString()}`;
 
@@ -46,7 +49,7 @@ export const load: PageServerLoad = async ({ fetch, url, locals }) => {
     });
     
     if (!response.ok) {
-        throw redirect(303, `${BACKEND_URL}/auth/google`);
+        throw redirect(303, '/');
     }
 
     const data = await response.json();
@@ -68,6 +71,7 @@ export const load: PageServerLoad = async ({ fetch, url, locals }) => {
         currentPage,
         totalPages,
         clientParams,
+        owner,
     };
 };
 
@@ -7,7 +7,11 @@ export const POST: RequestHandler = async ({ request, fetch, locals }) => {
         id: formData.get('id'),
     }
 
-    const response = await fetch(`${BACKEND_URL}/user/getApplicationTimeline`, {
+    // set when reading a timeline someone shared with this user
+    const owner = formData.get('user');
+    const query = owner ? `?user=${encodeURIComponent(owner as string)}` : '';
+
+    const response = await fetch(`${BACKEND_URL}/user/getApplicationTimeline${query}`, {
         method: 'POST',
         headers: {
             'Content-Type': 'application/json',
@@ -41,7 +41,7 @@ export const load: PageServerLoad = async ({ fetch, url, locals }) => {
     });
     
     if (!response.ok) {
-        throw redirect(303, `${BACKEND_URL}/auth/google`);
+        throw redirect(303, '/');
     }
 
     const data = await response.json();
@@ -1,24 +1,66 @@
 import type { PageServerLoad } from './$types';
 import { BACKEND_URL } from '$env/static/private';
-import { redirect } from '@sveltejs/kit';
+import { fail, redirect } from '@sveltejs/kit';
 import type { Actions } from './$types';
 
 // load function 
-export const load: PageServerLoad = async ({ fetch, locals }) => {
-    const response = await fetch(`${BACKEND_URL}/user/profile`, {
+export const load: PageServerLoad = async ({ fetch, locals, url }) => {
+    // someone who shared their analytics with this user (read-only)
+    const owner = url.searchParams.get('user');
+    const profileURL = owner
+        ? `${BACKEND_URL}/user/profile?user=${encodeURIComponent(owner)}`
+        : `${BACKEND_URL}/user/profile`;
+
+    const response = await fetch(profileURL, {
         headers: {
         'Authorization': `Bearer ${locals.authToken}`
         }
     });
     
     if (!response.ok) {
-        throw redirect(303, `${BACKEND_URL}/auth/google`);
+        throw redirect(303, '/');
     }
 
     const data = await response.json();
 
+    // linked login providers, every provider that could be linked, personal access tokens and sharing
+    const [identitiesResponse, providersResponse, tokensResponse, sharingResponse] = await Promise.all([
+        fetch(`${BACKEND_URL}/auth/identities`, {
+            headers: {
+                'Authorization': `Bearer ${locals.authToken}`
+            }
+        }),
+        fetch(`${BACKEND_URL}/auth/providers`),
+        fetch(`${BACKEND_URL}/auth/tokens`, {
+            headers: {
+                'Authorization': `Bearer ${locals.authToken}`
+            }
+        }),
+        fetch(`${BACKEND_URL}/user/sharing`, {
+            headers: {
+                'Authorization': `Bearer ${locals.authToken}`
+            }
+        }),
+    ]);
+    const identities = identitiesResponse.ok ? await identitiesResponse.json() : [];
+    const providers = providersResponse.ok ? (await providersResponse.json()).providers : [];
+    const tokens = tokensResponse.ok ? await tokensResponse.json() : [];
+    const sharing = sharingResponse.ok
+        ? await sharingResponse.json()
+        : { invitations: [], given: [], received: [] };
+
     return {
         email: data.email,
+        identities,
+        providers,
+        tokens,
+        sharing,
+        owner,
+        // ?invite={id} from an invitation link
+        invite: url.searchParams.get('invite'),
+        // ?linkError= from auth-complete when linking a provider failed
+        linkError: url.searchParams.get('linkError'),
+        origin: url.origin,
         applicationsCount: data.applicationsCount,
         analytics: {
             application_velocity: data.application_velocity,
@@ -57,4 +99,190 @@ export const actions = {
             throw new Error('Failed to delete user');
         }
     },
+    // starts a login with the provider that links it to this account (instead of signing in)
+    // the nonce stays with this browser (auth-complete sends it back to confirm the link), so a
+    // link code that ends up in another browser can't link that browser's login to this account
+    link: async ({ cookies, fetch, locals, request }) => {
+        const provider = (await request.formData()).get('provider') as string;
+        const nonce = crypto.randomUUID();
+        const response = await fetch(`${BACKEND_URL}/auth/link/${encodeURIComponent(provider)}`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${locals.authToken}`
+            },
+            body: JSON.stringify({ nonce }),
+        });
+
+        if (!response.ok) {
+            return fail(response.status, { linkError: await response.text() });
+        }
+
+        // as long as the backend's link code lasts
+        cookies.set('copium_link', nonce, {
+            path: '/', maxAge: 10 * 60, sameSite: 'lax', secure: true, httpOnly: true,
+        });
+
+        const { code } = await response.json();
+        throw redirect(303, `${BACKEND_URL}/auth/${encodeURIComponent(provider)}?link=${encodeURIComponent(code)}`);
+    },
+    unlink: async ({ fetch, locals, request }) => {
+        const formData = await request.formData();
+        const response = await fetch(`${BACKEND_URL}/auth/unlink`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${locals.authToken}`
+            },
+            body: JSON.stringify({
+                provider: formData.get('provider'),
+                subject: formData.get('subject'),
+            }),
+        });
+
+        if (!response.ok) {
+            return fail(response.status, { linkError: await response.text() });
+        }
+    },
+    // makes a linked provider's (verified) email the account's email; nothing else changes
+    setEmail: async ({ fetch, locals, request }) => {
+        const email = (await request.formData()).get('email');
+        const response = await fetch(`${BACKEND_URL}/auth/email`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${locals.authToken}`
+            },
+            body: JSON.stringify({ email }),
+        });
+
+        if (!response.ok) {
+            return fail(response.status, { linkError: await response.text() });
+        }
+    },
+    // the new token is only returned once, so it's passed back to the page to show
+    createToken: async ({ fetch, locals, request }) => {
+        const formData = await request.formData();
+        const response = await fetch(`${BACKEND_URL}/auth/tokens`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${locals.authToken}`
+            },
+            body: JSON.stringify({
+                name: formData.get('name'),
+                access: formData.get('write') ? 'write' : 'read',
+                expiresInDays: Number(formData.get('expiresInDays') || 0),
+            }),
+        });
+
+        if (!response.ok) {
+            return fail(response.status, { tokenError: await response.text() });
+        }
+
+        const { token } = await response.json();
+        return { newToken: token as string };
+    },
+    revokeToken: async ({ fetch, locals, request }) => {
+        const id = (await request.formData()).get('id');
+        const response = await fetch(`${BACKEND_URL}/auth/tokens/revoke`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${locals.authToken}`
+            },
+            body: JSON.stringify({ id }),
+        });
+
+        if (!response.ok) {
+            return fail(response.status, { tokenError: await response.text() });
+        }
+    },
+    // invites someone to read this user's applications and/or analytics; they get a link to accept
+    invite: async ({ fetch, locals, request }) => {
+        const formData = await request.formData();
+        const scopes = [];
+        if (formData.get('applications')) scopes.push('applications:read');
+        if (formData.get('analytics')) scopes.push('analytics:read');
+
+        const response = await fetch(`${BACKEND_URL}/user/sharing/invite`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${locals.authToken}`
+            },
+            body: JSON.stringify({
+                email: formData.get('email'),
+                role: formData.get('role'),
+                scopes,
+            }),
+        });
+
+        if (!response.ok) {
+            return fail(response.status, { sharingError: await response.text() });
+        }
+    },
+    acceptInvite: async ({ fetch, locals, request }) => {
+        const id = (await request.formData()).get('id');
+        const response = await fetch(`${BACKEND_URL}/user/sharing/accept`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${locals.authToken}`
+            },
+            body: JSON.stringify({ id }),
+        });
+
+        if (!response.ok) {
+            return fail(response.status, { sharingError: await response.text() });
+        }
+        throw redirect(303, '/profile');
+    },
+    cancelInvite: async ({ fetch, locals, request }) => {
+        const id = (await request.formData()).get('id');
+        const response = await fetch(`${BACKEND_URL}/user/sharing/cancel`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${locals.authToken}`
+            },
+            body: JSON.stringify({ id }),
+        });
+
+        if (!response.ok) {
+            return fail(response.status, { sharingError: await response.text() });
+        }
+    },
+    // the owner stops sharing (granteeID), or the grantee gives access back (ownerID)
+    revokeGrant: async ({ fetch, locals, request }) => {
+        const formData = await request.formData();
+        const response = await fetch(`${BACKEND_URL}/user/sharing/revoke`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${locals.authToken}`
+            },
+            body: JSON.stringify({
+                ownerID: formData.get('ownerID') || undefined,
+                granteeID: formData.get('granteeID') || undefined,
+            }),
+        });
+
+        if (!response.ok) {
+            return fail(response.status, { sharingError: await response.text() });
+        }
+    },
+    // revokes every session of this user, on every device
+    logoutAll: async ({ fetch, locals }) => {
+        const response = await fetch(`${BACKEND_URL}/auth/logoutAll`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${locals.authToken}`
+            },
+        });
+
+        if (!response.ok) {
+            throw new Error('Failed to log out of all devices');
+        }
+    },
 } satisfies Actions;
\ No newline at end of file
@@ -0,0 +1,16 @@
+import type { PageServerLoad } from './$types';
+import { BACKEND_URL } from '$env/static/private';
+
+// which "sign in with ..." buttons to show; google is always available
+export const load: PageServerLoad = async ({ fetch }) => {
+    try {
+        const response = await fetch(`${BACKEND_URL}/auth/providers`);
+        if (response.ok) {
+            const { providers } = await response.json();
+            return { providers: providers as string[] };
+        }
+    } catch (err) {
+        console.error('failed to load login providers', err);
+    }
+    return { providers: ['google'] };
+};
@@ -9,28 +9,78 @@
 //  cookies. so, no fetch requests are changed from frontend, only SvelteKit server-side
 //  functions have been modified to use the cookie set here
 import type { RequestHandler } from '@sveltejs/kit';
+import { BACKEND_URL } from '$env/static/private';
 
-export const GET: RequestHandler = ({ url }) => {
-    const token = url.searchParams.get('token');
-    // token exists -- redirect to dashboard with token in a cookie
-    // the browser sets the cookie to be gotten by hooks which are then used in +page.server.ts
-    if (token) {
-        console.log('authed', token)
-        return new Response(null, {
-            status: 302,
+//  The backend redirects here with a one-time code rather than the tokens themselves (so they
+//  never show up in browser history or logs); it's traded for the tokens server-side.
+//  The access token is short-lived; the refresh token (HttpOnly) is used by hooks.server.ts
+//  to get a new one when it expires.
+async function redeem(code: string): Promise<{ accessToken: string; refreshToken: string } | null> {
+    try {
+        const response = await fetch(`${BACKEND_URL}/auth/token`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ code }),
+        });
+        if (!response.ok) {
+            console.error('failed to redeem login code', response.status);
+            return null;
+        }
+        return await response.json();
+    } catch (err) {
+        console.error('failed to redeem login code', err);
+        return null;
+    }
+}
+
+//  Linking a provider from the profile page comes back here with ?link={code} instead. The
+//  link only goes through with this browser's session and the nonce the profile page gave it,
+//  so a link started by someone else can't attach this browser's login to their account.
+async function confirmLink(fetch: typeof globalThis.fetch, authToken: string | undefined, code: string, nonce: string | undefined): Promise<string | null> {
+    try {
+        const response = await fetch(`${BACKEND_URL}/auth/link/complete`, {
+            method: 'POST',
             headers: {
-                'Location': '/dashboard',
-                'Set-Cookie': `authToken=${token}; Path=/; Max-Age=${30*24*60*60}; SameSite=Lax; Secure`
-            }
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${authToken}`
+            },
+            body: JSON.stringify({ code, nonce: nonce ?? '' }),
         });
+        if (!response.ok) {
+            return await response.text();
+        }
+        return null;
+    } catch (err) {
+        console.error('failed to confirm link', err);
+        return 'Failed to link account';
+    }
+}
+
+export const GET: RequestHandler = async ({ url, cookies, fetch, locals }) => {
+    const linkCode = url.searchParams.get('link');
+    if (linkCode) {
+        const linkError = await confirmLink(fetch, locals.authToken, linkCode, cookies.get('copium_link'));
+        cookies.delete('copium_link', { path: '/' });
+        const location = linkError ? `/profile?linkError=${encodeURIComponent(linkError.trim())}` : '/profile';
+        return new Response(null, { status: 302, headers: { 'Location': location, 'Referrer-Policy': 'no-referrer' } });
+    }
+
+    const code = url.searchParams.get('code');
+    const tokens = code ? await redeem(code) : null;
+    // tokens exist -- redirect to dashboard with tokens in cookies
+    // the browser sets the cookies to be gotten by hooks which are then used in +page.server.ts
+    if (tokens) {
+        const headers = new Headers({ 'Location': '/dashboard' });
+        headers.append('Set-Cookie', `authToken=${tokens.accessToken}; Path=/; Max-Age=${30*24*60*60}; SameSite=Lax; Secure`);
+        headers.append('Set-Cookie', `refreshToken=${tokens.refreshToken}; Path=/; Max-Age=${30*24*60*60}; SameSite=Lax; Secure; HttpOnly`);
+        // don't let the code leak either (it's single-use, but still)
+        headers.append('Referrer-Policy', 'no-referrer');
+        return new Response(null, { status: 302, headers });
     }
     
-    // no token -- redirect home. set cookie to nothing just in case
-    return new Response(null, {
-        status: 302,
-        headers: {
-            'Location': '/',
-            'Set-Cookie': `authToken=; Path=/; Max-Age=0; SameSite=Lax; Secure`
-        }
-    });
+    // no token -- redirect home. set cookies to nothing just in case
+    const headers = new Headers({ 'Location': '/' });
+    headers.append('Set-Cookie', `authToken=; Path=/; Max-Age=0; SameSite=Lax; Secure`);
+    headers.append('Set-Cookie', `refreshToken=; Path=/; Max-Age=0; SameSite=Lax; Secure; HttpOnly`);
+    return new Response(null, { status: 302, headers });
 };
\ No newline at end of file
@@ -0,0 +1,8 @@
+import { redirect } from '@sveltejs/kit';
+import { BACKEND_URL } from '$env/static/private';
+import type { RequestHandler } from './$types';
+
+// the backend rejects providers it doesn't have configured
+export const GET: RequestHandler = async ({ params }) => {
+    throw redirect(303, `${BACKEND_URL}/auth/${encodeURIComponent(params.provider)}`);
+};
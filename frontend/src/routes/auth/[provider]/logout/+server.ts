@@ -0,0 +1,23 @@
+import { redirect } from '@sveltejs/kit';
+import { BACKEND_URL } from '$env/static/private';
+import type { RequestHandler } from './$types';
+
+// revoke the session on the backend first so the tokens stop working everywhere,
+// not just in this browser; logging out still goes ahead if that fails
+// (event.fetch, so a cookie session goes along too; see handleFetch in hooks.server.ts)
+export const GET: RequestHandler = async ({ fetch, cookies, locals, params }) => {
+    if (locals.authToken || cookies.get('copium_session')) {
+        try {
+            await fetch(`${BACKEND_URL}/auth/logout`, {
+                method: 'POST',
+                headers: {
+                    'Authorization': `Bearer ${locals.authToken}`
+                },
+            });
+        } catch (err) {
+            console.error('failed to revoke session', err);
+        }
+    }
+
+    throw redirect(303, `${BACKEND_URL}/auth/${encodeURIComponent(params.provider)}/logout`);
+};
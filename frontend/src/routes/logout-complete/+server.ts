@@ -1,12 +1,9 @@
 import type { RequestHandler } from '@sveltejs/kit';
 
-// remove cookie and redirect home 
+// remove cookies and redirect home 
 export const GET: RequestHandler = () => {
-    return new Response(null, {
-        status: 302,
-        headers: {
-            'Location': '/',
-            'Set-Cookie': `authToken=; Path=/; Max-Age=0; SameSite=Lax; Secure`
-        }
-    });
+    const headers = new Headers({ 'Location': '/' });
+    headers.append('Set-Cookie', `authToken=; Path=/; Max-Age=0; SameSite=Lax; Secure`);
+    headers.append('Set-Cookie', `refreshToken=; Path=/; Max-Age=0; SameSite=Lax; Secure; HttpOnly`);
+    return new Response(null, { status: 302, headers });
 };
\ No newline at end of file
@@ -1,69 +1,92 @@
 package api
 
 import (
+    "context"
+    "fmt"
     "log"
     "net/http"
 	
+	"github.com/copium-dev/copium/go/service/auth/authkeys"
+	"github.com/copium-dev/copium/go/service/auth/authstore"
 	"github.com/copium-dev/copium/go/service/user"
+	"github.com/copium-dev/copium/go/service/user/outbox"
+	"github.com/copium-dev/copium/go/service/user/userstore"
     "github.com/copium-dev/copium/go/service/auth"
 	"github.com/copium-dev/copium/go/service/postings"
     "github.com/copium-dev/copium/go/utils"
+	"github.com/copium-dev/copium/shared/config"
     
-	"cloud.google.com/go/firestore"
-	"cloud.google.com/go/bigquery"
 	"github.com/gorilla/mux"
     "github.com/rs/cors"
 	"github.com/algolia/algoliasearch-client-go/v4/algolia/search"
-	"cloud.google.com/go/pubsub"
 )
 
 type APIServer struct {
     addr string
-    firestoreClient *firestore.Client
+	config *config.Config
+    store userstore.ApplicationStore
+	grants userstore.GrantStore
+	events userstore.EventLog
+	authStores authstore.Stores
+	signingKeys *authkeys.KeySet
 	algoliaClient *search.APIClient
-	bigQueryClient *bigquery.Client
     authHandler *utils.AuthHandler
-	pubsubTopic *pubsub.Topic
-	orderingKey string
+	relay *outbox.Relay
 }
 
 func NewAPIServer(addr string,
-	firestoreClient *firestore.Client,
+	config *config.Config,
+	store userstore.ApplicationStore,
+	grants userstore.GrantStore,
+	events userstore.EventLog,
+	authStores authstore.Stores,
+	signingKeys *authkeys.KeySet,
 	algoliaClient *search.APIClient,
-	bigQueryClient *bigquery.Client,
 	authHandler *utils.AuthHandler,
-	pubsubTopic *pubsub.Topic,
-	orderingKey string,
+	relay *outbox.Relay,
 ) *APIServer {
     return &APIServer{
         addr: addr,
-        firestoreClient: firestoreClient,
+		config: config,
+        store: store,
+		grants: grants,
+		events: events,
+		authStores: authStores,
+		signingKeys: signingKeys,
 		algoliaClient: algoliaClient,
-		bigQueryClient: bigQueryClient,
         authHandler: authHandler,
-		pubsubTopic: pubsubTopic,
-		orderingKey: orderingKey,
+		relay: relay,
     }
 }
 
 // initialize router, database, and other dependencies
-func (s *APIServer) Run() error {
+// serves until ctx is done, then stops taking connections and waits for in-flight requests
+// until drain is done
+func (s *APIServer) Run(ctx context.Context, drain context.Context) error {
     router := mux.NewRouter()
 
+	// sessions, personal access tokens and cookies; shared by the middleware and the handlers
+	authenticator, err := auth.NewAuthenticator(s.authStores, s.signingKeys, s.config.AuthMode, s.authHandler.Store, s.config.Deployed(), s.config.CookieDomain, s.config.AdminUserIDs)
+	if err != nil {
+		return fmt.Errorf("failed to initialize authenticator: %w", err)
+	}
+	// authenticates every request once, per each route's requirement (see auth/middleware.go)
+	router.Use(authenticator.Middleware)
+
     log.Println("Listening on", s.addr)
 
-    userHandler := user.NewHandler(s.firestoreClient, s.algoliaClient, s.bigQueryClient, s.pubsubTopic, s.orderingKey)
+    userHandler := user.NewHandler(s.store, s.grants, s.events, s.algoliaClient, s.config.UsersIndex, s.relay, authenticator)
     userHandler.RegisterRoutes(router)
 
-    authHandler := auth.NewHandler(s.firestoreClient, s.authHandler)
+    authHandler := auth.NewHandler(s.store, s.authHandler, s.authStores, authenticator, s.config.FrontendURL)
     authHandler.RegisterRoutes(router)
 
-	postingsHandler := postings.NewHandler(s.algoliaClient)
+	postingsHandler := postings.NewHandler(s.algoliaClient, s.config.PostingsIndex)
 	postingsHandler.RegisterRoutes(router)
 
     // create new CORS handler
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"https://www.copium.dev", "https://copium.dev", "http://localhost:5173"},
+		AllowedOrigins:   s.config.CORSOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
 		AllowCredentials: true, 
@@ -73,5 +96,23 @@ func (s *APIServer) Run() error {
     // wrap router with the CORS handler
     handler := c.Handler(router)
 
-    return http.ListenAndServe(s.addr, handler)
+    server := &http.Server{Addr: s.addr, Handler: handler}
+
+    errs := make(chan error, 1)
+    go func() {
+        errs <- server.ListenAndServe()
+    }()
+
+    select {
+    case err := <-errs:
+        return err
+    case <-ctx.Done():
+    }
+
+    log.Println("Shutting down server on", s.addr)
+    if err := server.Shutdown(drain); err != nil {
+        server.Close()
+        return err
+    }
+    return nil
 }
\ No newline at end of file
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/copium-dev/copium/go/service/auth/authkeys"
+	"github.com/copium-dev/copium/go/service/auth/authstore"
+	"github.com/copium-dev/copium/go/service/user/outbox"
+	"github.com/copium-dev/copium/go/service/user/userstore"
+	"github.com/copium-dev/copium/go/utils"
+	"github.com/copium-dev/copium/shared/bus"
+	"github.com/copium-dev/copium/shared/config"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/firestore"
+	firebase "firebase.google.com/go"
+	"github.com/algolia/algoliasearch-client-go/v4/algolia/search"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// sets up the API for cfg and serves it until ctx is done; in-flight requests and the last outbox
+// messages then share drain, and clients close after (deferred, in reverse order). what
+// cmd/main.go runs, and what dev/ runs next to the consumers
+func Run(ctx context.Context, drain context.Context, cfg *config.Config) error {
+	// the settings only the API needs (SESSION_SECRET, login providers) are checked before anything is started
+	if err := cfg.ValidateAPI(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	// initialize application store; Firestore uses service account credentials so nothing to do
+	// APPLICATION_STORE=memory runs without Firestore at all (nothing is persisted across restarts)
+	// APPLICATION_STORE=postgres replaces Firestore AND BigQuery (event log + analytics) with DATABASE_URL
+	// sharing grants, login sessions, codes, linked identities and personal access tokens are kept in the same backend as the store
+	// (AUTH_CODE_STORE=memory keeps codes in memory instead; only for a single instance)
+	store, grants, events, authStores, closeStore, err := initializeApplicationStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize application store: %w", err)
+	}
+	defer closeStore()
+
+	// initialize auth handler; its cookie store is keyed with SESSION_SECRET
+	authHandler := utils.NewAuthHandler(cfg)
+
+	// keys access tokens are signed with (JWT_KEYS_DIR, or JWT_SECRET as a fallback)
+	signingKeys, err := authkeys.FromEnv(cfg.Deployed())
+	if err != nil {
+		return fmt.Errorf("failed to load JWT signing keys: %w", err)
+	}
+
+	// initialize bigquery client; only needed when the store doesn't keep its own event log
+	if events == nil {
+		bigQueryClient, err := initializeBigQueryClient(cfg.ProjectID)
+		if err != nil {
+			return fmt.Errorf("failed to initialize BigQuery client: %w", err)
+		}
+		defer bigQueryClient.Close()
+		events = userstore.NewBigQueryEventLog(bigQueryClient)
+	}
+
+	// connect to the topic (algolia and bigquery both subscribe to it)
+	// MESSAGE_BUS picks the transport: pubsub (default; emulator on port 8085 if PUBSUB_EMULATOR_HOST
+	// is set) or nats (NATS_URL); dev/ uses memory
+	publisher, err := bus.NewPublisher(context.Background(), cfg.Bus(), cfg.Topic)
+	if err != nil {
+		return fmt.Errorf("failed to initialize message bus: %w", err)
+	}
+
+	// after server stops, flush anything still being published and clean up the connection
+	defer publisher.Close()
+
+	// initialize algolia client (read-only)
+	algoliaClient, err := initializeAlgoliaClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize Algolia client: %w", err)
+	}
+
+	// start publishing the store's outbox; handlers wake the relay after every write and it also
+	// picks up anything left over from a previous run (crash or failed publish)
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	relay := outbox.NewRelay(store, publisher)
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		relay.Run(relayCtx)
+	}()
+
+	// cloud run will provide PORT 8080 by default in env
+	log.Printf("Starting server on port %s", cfg.Port)
+
+	server := NewAPIServer(":"+cfg.Port, cfg, store, grants, events, authStores, signingKeys, algoliaClient, authHandler, relay)
+	if err := server.Run(ctx, drain); err != nil {
+		if ctx.Err() == nil {
+			// never started (e.g. port in use)
+			return err
+		}
+		log.Printf("Error shutting down server: %v", err)
+	}
+
+	// no more writes can come in; stop the relay and publish what the last requests wrote.
+	// anything that doesn't make it stays in the outbox for the next start
+	stopRelay()
+	<-relayDone
+	relay.Flush(drain)
+	return nil
+}
+
+func initializeAlgoliaClient() (*search.APIClient, error) {
+	appID := os.Getenv("ALGOLIA_APP_ID")
+	searchApiKey := os.Getenv("ALGOLIA_SEARCH_API_KEY")
+
+	algoliaClient, err := search.NewClient(appID, searchApiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return algoliaClient, nil
+}
+
+func initializeBigQueryClient(projectID string) (*bigquery.Client, error) {
+	// use service account credentials, no need to pass in anything
+	ctx := context.Background()
+
+	client, err := bigquery.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// returns the store and session store along with a function to release their resources
+// the event log is only returned if the store keeps one itself (Postgres); otherwise it's nil and BigQuery is used
+func initializeApplicationStore(cfg *config.Config) (userstore.ApplicationStore, userstore.GrantStore, userstore.EventLog, authstore.Stores, func(), error) {
+	var (
+		store      userstore.ApplicationStore
+		grants     userstore.GrantStore
+		events     userstore.EventLog
+		authStores authstore.Stores
+		closeStore func()
+	)
+
+	switch cfg.ApplicationStore {
+	case config.StoreMemory:
+		log.Println("APPLICATION_STORE=memory; using in-memory application store")
+		store = userstore.NewMemoryStore()
+		grants = userstore.NewMemoryGrantStore()
+		authStores = authstore.Stores{
+			Sessions:   authstore.NewMemorySessionStore(),
+			Codes:      authstore.NewMemoryCodeStore(),
+			Identities: authstore.NewMemoryIdentityStore(),
+			Tokens:     authstore.NewMemoryTokenStore(),
+		}
+		closeStore = func() {}
+	case config.StorePostgres:
+		pool, err := initializePostgresPool(cfg.DatabaseURL)
+		if err != nil {
+			return nil, nil, nil, authstore.Stores{}, nil, err
+		}
+		postgresStore := userstore.NewPostgresStore(pool)
+		store, events = postgresStore, postgresStore
+		grants = userstore.NewPostgresGrantStore(pool)
+		authStores = authstore.Stores{
+			Sessions:   authstore.NewPostgresSessionStore(pool),
+			Codes:      authstore.NewPostgresCodeStore(pool),
+			Identities: authstore.NewPostgresIdentityStore(pool),
+			Tokens:     authstore.NewPostgresTokenStore(pool),
+		}
+		closeStore = pool.Close
+	case config.StoreFirestore:
+		firestoreClient, err := initializeFirestoreClient(cfg.ProjectID)
+		if err != nil {
+			return nil, nil, nil, authstore.Stores{}, nil, err
+		}
+		store = userstore.NewFirestoreStore(firestoreClient)
+		grants = userstore.NewFirestoreGrantStore(firestoreClient)
+		authStores = authstore.Stores{
+			Sessions:   authstore.NewFirestoreSessionStore(firestoreClient),
+			Codes:      authstore.NewFirestoreCodeStore(firestoreClient),
+			Identities: authstore.NewFirestoreIdentityStore(firestoreClient),
+			Tokens:     authstore.NewFirestoreTokenStore(firestoreClient),
+		}
+		closeStore = func() { firestoreClient.Close() }
+	default:
+		return nil, nil, nil, authstore.Stores{}, nil, fmt.Errorf("unknown APPLICATION_STORE: %s", cfg.ApplicationStore)
+	}
+
+	if cfg.AuthCodeStore == config.StoreMemory {
+		log.Println("AUTH_CODE_STORE=memory; keeping login codes in memory")
+		authStores.Codes = authstore.NewMemoryCodeStore()
+	}
+
+	return store, grants, events, authStores, closeStore, nil
+}
+
+// connects to DATABASE_URL (config.Load already made sure it's set) and brings the schema up
+// to date before serving anything
+func initializePostgresPool(databaseURL string) (*pgxpool.Pool, error) {
+	ctx := context.Background()
+
+	pool, err := pgxpool.New(ctx, databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	if err := userstore.Migrate(ctx, pool); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+func initializeFirestoreClient(projectID string) (*firestore.Client, error) {
+	ctx := context.Background()
+
+	conf := &firebase.Config{
+		ProjectID: projectID,
+	}
+
+	if firestoreEmulatorHost := os.Getenv("FIRESTORE_EMULATOR_HOST"); firestoreEmulatorHost != "" {
+		log.Printf("Connecting to Firestore emulator at %s", firestoreEmulatorHost)
+		conf.DatabaseURL = "http://" + firestoreEmulatorHost
+	} else {
+		log.Println("FIRESTORE_EMULATOR_HOST not set; using service account credentials, nothing to pass in")
+	}
+
+	app, err := firebase.NewApp(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	firestoreClient, err := app.Firestore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return firestoreClient, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+// generates a signing key for access tokens as {kid}.pem, ready for JWT_KEYS_DIR
+//
+//	go run ./cmd/jwtkey -alg EdDSA -dir ./keys
+//
+// the kid defaults to today's date plus a random suffix; print the public half with
+// `openssl pkey -in keys/{kid}.pem -pubout` if a verification-only copy is needed
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/copium-dev/copium/go/service/auth/authkeys"
+)
+
+func main() {
+	alg := flag.String("alg", authkeys.AlgEdDSA, "EdDSA or RS256")
+	bits := flag.Int("bits", 3072, "RSA key size")
+	dir := flag.String("dir", ".", "directory to write the key to")
+	kid := flag.String("kid", "", "key ID (default: date and random suffix)")
+	flag.Parse()
+
+	if *kid == "" {
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			log.Fatal(err)
+		}
+		*kid = time.Now().UTC().Format("2006-01-02") + "-" + hex.EncodeToString(suffix)
+	}
+
+	var private crypto.PrivateKey
+	var err error
+	switch *alg {
+	case authkeys.AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case authkeys.AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, *bits)
+	default:
+		log.Fatalf("unsupported algorithm %s", *alg)
+	}
+	if err != nil {
+		log.Fatal("Failed to generate key: ", err)
+	}
+
+	key, err := authkeys.NewSigningKey(*kid, private)
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := authkeys.MarshalPEM(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	path := filepath.Join(*dir, *kid+".pem")
+	// O_EXCL so an existing key is never overwritten
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(path)
+	fmt.Printf("set JWT_SIGNING_KEY_ID=%s to start signing with it\n", *kid)
+}
@@ -0,0 +1,455 @@
+package main
+
+// re-keys users from before user IDs: users/{email} becomes users/{userID} (a new ID per user) and
+// everything keyed by the email is moved over (applications, linked identities, BigQuery rows, Algolia
+// records). safe to run again: a user whose re-key was interrupted keeps the same user ID, and
+// users that are already re-keyed are skipped
+//
+// rollout, in this order:
+//  1. go run ./cmd/migrate-user-ids -step prepare
+//     adds the userID column to BigQuery and makes userID an Algolia facet; nothing reads them yet
+//  2. deploy bigquery-consumer and algolia-consumer. they accept the API's old (email keyed)
+//     messages too, and apply them to the email keyed data
+//  3. stop the API and let the outbox and both subscriptions drain
+//  4. go run ./cmd/migrate-user-ids -step rekey
+//  5. deploy the API. everyone has to log in again (access tokens now carry the user ID)
+//
+// needs ALGOLIA_APP_ID and ALGOLIA_WRITE_API_KEY, plus the same Google credentials as the consumers
+// (or FIRESTORE_EMULATOR_HOST). the project and index come from shared/config like everywhere else
+// (so set ENVIRONMENT), -project and -index override them. -dry-run only lists the users that would
+// be re-keyed
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/copium-dev/copium/go/service/user/userstore"
+	"github.com/copium-dev/copium/shared/config"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/algolia/algoliasearch-client-go/v4/algolia/search"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// number of users whose BigQuery rows are re-keyed per UPDATE; each UPDATE scans the table
+const bigQueryBatchSize = 500
+
+type migrator struct {
+	firestore *firestore.Client
+	bigquery  *bigquery.Client
+	algolia   *search.APIClient
+	index     string
+	dryRun    bool
+}
+
+// one re-keyed user, for the BigQuery UPDATE
+type mapping struct {
+	Email  string `bigquery:"email"`
+	UserID string `bigquery:"userID"`
+}
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	step := flag.String("step", "", "prepare or rekey")
+	projectID := flag.String("project", cfg.ProjectID, "Google Cloud project (GCP_PROJECT_ID)")
+	index := flag.String("index", cfg.UsersIndex, "Algolia index (ALGOLIA_USERS_INDEX)")
+	dryRun := flag.Bool("dry-run", false, "only list the users that would be re-keyed")
+	flag.Parse()
+
+	log.Printf("Re-keying in project %s (ENVIRONMENT=%s), Algolia index %s", *projectID, cfg.Environment, *index)
+
+	ctx := context.Background()
+
+	firestoreClient, err := firestore.NewClient(ctx, *projectID)
+	if err != nil {
+		log.Fatal("Failed to initialize Firestore client: ", err)
+	}
+	defer firestoreClient.Close()
+
+	bigQueryClient, err := bigquery.NewClient(ctx, *projectID)
+	if err != nil {
+		log.Fatal("Failed to initialize BigQuery client: ", err)
+	}
+	defer bigQueryClient.Close()
+
+	algoliaClient, err := search.NewClient(os.Getenv("ALGOLIA_APP_ID"), os.Getenv("ALGOLIA_WRITE_API_KEY"))
+	if err != nil {
+		log.Fatal("Failed to initialize Algolia client: ", err)
+	}
+
+	m := &migrator{
+		firestore: firestoreClient,
+		bigquery:  bigQueryClient,
+		algolia:   algoliaClient,
+		index:     *index,
+		dryRun:    *dryRun,
+	}
+
+	switch *step {
+	case "prepare":
+		err = m.prepare(ctx)
+	case "rekey":
+		err = m.rekey(ctx)
+	default:
+		log.Fatalf("unknown step %q, expected prepare or rekey", *step)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// adds what the new consumers write and the new API reads, before any of them is deployed
+func (m *migrator) prepare(ctx context.Context) error {
+	if m.dryRun {
+		log.Println("Would add the userID column and the userID facet")
+		return nil
+	}
+
+	err := m.runQuery(ctx, m.bigquery.Query(`ALTER TABLE applications_data.applications ADD COLUMN IF NOT EXISTS userID STRING`))
+	if err != nil {
+		return fmt.Errorf("failed to add userID column: %w", err)
+	}
+	log.Println("BigQuery: userID column added")
+
+	settings, err := m.algolia.GetSettings(m.algolia.NewApiGetSettingsRequest(m.index))
+	if err != nil {
+		return fmt.Errorf("failed to get index settings: %w", err)
+	}
+	facets := settings.GetAttributesForFaceting()
+	for _, facet := range facets {
+		if facet == "filterOnly(userID)" || facet == "userID" {
+			log.Println("Algolia: userID is already a facet")
+			return nil
+		}
+	}
+
+	res, err := m.algolia.SetSettings(m.algolia.NewApiSetSettingsRequest(
+		m.index,
+		search.NewEmptyIndexSettings().SetAttributesForFaceting(append(facets, "filterOnly(userID)")),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to set index settings: %w", err)
+	}
+	if _, err := m.algolia.WaitForTask(m.index, res.TaskID); err != nil {
+		return fmt.Errorf("failed to wait for settings: %w", err)
+	}
+	log.Println("Algolia: userID facet added")
+
+	return nil
+}
+
+func (m *migrator) rekey(ctx context.Context) error {
+	// documents keyed by user ID never contain an @
+	docs, err := m.firestore.Collection("users").Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+	var legacy []*firestore.DocumentSnapshot
+	for _, doc := range docs {
+		if strings.Contains(doc.Ref.ID, "@") {
+			legacy = append(legacy, doc)
+		}
+	}
+	log.Printf("%d user(s) to re-key", len(legacy))
+
+	if m.dryRun {
+		for _, doc := range legacy {
+			log.Println(doc.Ref.ID)
+		}
+		return nil
+	}
+
+	// firestore first; the email keyed documents are only deleted once BigQuery and Algolia are done,
+	// so an interrupted run finds them again
+	var mappings []mapping
+	for _, doc := range legacy {
+		email := doc.Ref.ID
+		userID, err := m.rekeyFirestore(ctx, doc)
+		if err != nil {
+			return fmt.Errorf("failed to re-key %s: %w", email, err)
+		}
+		mappings = append(mappings, mapping{Email: email, UserID: userID})
+		log.Printf("Firestore: %s -> %s", email, userID)
+	}
+
+	for start := 0; start < len(mappings); start += bigQueryBatchSize {
+		end := min(start+bigQueryBatchSize, len(mappings))
+		if err := m.rekeyBigQuery(ctx, mappings[start:end]); err != nil {
+			return err
+		}
+		log.Printf("BigQuery: re-keyed rows of %d user(s)", end-start)
+	}
+
+	for _, mapping := range mappings {
+		if err := m.rekeyAlgolia(mapping); err != nil {
+			return fmt.Errorf("failed to re-key %s in Algolia: %w", mapping.Email, err)
+		}
+	}
+	log.Println("Algolia: records re-keyed")
+
+	// a user whose copy doesn't check out keeps the email keyed documents; the next run copies
+	// it again
+	var incomplete []string
+	for _, mapping := range mappings {
+		if err := m.verifyCopy(ctx, mapping); err != nil {
+			log.Printf("Firestore: not deleting users/%s: %v", mapping.Email, err)
+			incomplete = append(incomplete, mapping.Email)
+			continue
+		}
+		if err := m.deleteLegacyUser(ctx, mapping.Email); err != nil {
+			return fmt.Errorf("failed to delete users/%s: %w", mapping.Email, err)
+		}
+	}
+	if len(incomplete) > 0 {
+		return fmt.Errorf("%d user(s) weren't copied completely, run rekey again: %s", len(incomplete), strings.Join(incomplete, ", "))
+	}
+	log.Println("Firestore: email keyed users deleted")
+
+	// access tokens and codes name the user by email; the new API rejects them anyway
+	for _, collection := range []string{"sessions", "auth_codes"} {
+		if err := m.deleteCollection(ctx, m.firestore.Collection(collection)); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", collection, err)
+		}
+	}
+	log.Println("Firestore: sessions and codes deleted")
+
+	log.Printf("Re-keyed %d user(s)", len(mappings))
+	return nil
+}
+
+// claims emails/{email} for a new user ID (or the one a previous run claimed), then copies the user
+// document and its applications and points the user's identities at the new ID
+func (m *migrator) rekeyFirestore(ctx context.Context, doc *firestore.DocumentSnapshot) (string, error) {
+	email := doc.Ref.ID
+	emailRef := m.firestore.Collection("emails").Doc(email)
+
+	var userID string
+	err := m.firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claim, err := tx.Get(emailRef)
+		if err == nil {
+			userID, _ = claim.Data()["userID"].(string)
+			return nil
+		}
+		if status.Code(err) != codes.NotFound {
+			return err
+		}
+		userID = userstore.NewUserID()
+		return tx.Create(emailRef, map[string]interface{}{"userID": userID})
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to claim email: %w", err)
+	}
+	if userID == "" {
+		return "", fmt.Errorf("emails/%s has no userID", email)
+	}
+
+	userRef := m.firestore.Collection("users").Doc(userID)
+	fields := doc.Data()
+	fields["email"] = email
+	if _, err := userRef.Set(ctx, fields); err != nil {
+		return "", fmt.Errorf("failed to copy user document: %w", err)
+	}
+
+	applications, err := doc.Ref.Collection("applications").Documents(ctx).GetAll()
+	if err != nil {
+		return "", fmt.Errorf("failed to list applications: %w", err)
+	}
+	bulkWriter := m.firestore.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for _, application := range applications {
+		job, err := bulkWriter.Set(userRef.Collection("applications").Doc(application.Ref.ID), application.Data())
+		if err != nil {
+			bulkWriter.End()
+			return "", fmt.Errorf("failed to copy application %s: %w", application.Ref.ID, err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := endBulkWriter(bulkWriter, jobs); err != nil {
+		return "", fmt.Errorf("failed to copy applications: %w", err)
+	}
+
+	// identities linked before user IDs; the account's email was the one its Google login verified
+	identities, err := m.firestore.Collection("identities").Where("email", "==", email).Documents(ctx).GetAll()
+	if err != nil {
+		return "", fmt.Errorf("failed to list identities: %w", err)
+	}
+	for _, identity := range identities {
+		providerEmail, _ := identity.Data()["providerEmail"].(string)
+		_, err := identity.Ref.Update(ctx, []firestore.Update{
+			{Path: "userID", Value: userID},
+			{Path: "emailVerified", Value: providerEmail == email},
+			{Path: "email", Value: firestore.Delete},
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to update identity %s: %w", identity.Ref.ID, err)
+		}
+	}
+
+	return userID, nil
+}
+
+// rows written before the consumers were updated have only the email; rows the updated consumers
+// wrote from the old API's messages have the email in userID
+func (m *migrator) rekeyBigQuery(ctx context.Context, mappings []mapping) error {
+	q := m.bigquery.Query(`
+		UPDATE applications_data.applications t
+		SET userID = m.userID
+		FROM UNNEST(@mappings) m
+		WHERE t.email = m.email OR t.userID = m.email
+	`)
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "mappings", Value: mappings},
+	}
+
+	if err := m.runQuery(ctx, q); err != nil {
+		return fmt.Errorf("failed to re-key rows: %w", err)
+	}
+	return nil
+}
+
+// same two cases as BigQuery: records with only the email, and records with the email in userID
+func (m *migrator) rekeyAlgolia(mapping mapping) error {
+	params := search.NewEmptyBrowseParamsObject().
+		SetFilters(fmt.Sprintf("email:%q OR userID:%q", mapping.Email, mapping.Email)).
+		SetAttributesToRetrieve([]string{"objectID"})
+
+	var updates []map[string]any
+	for {
+		res, err := m.algolia.Browse(m.algolia.NewApiBrowseRequest(m.index).WithBrowseParams(search.BrowseParamsObjectAsBrowseParams(params)))
+		if err != nil {
+			return err
+		}
+		for _, hit := range res.Hits {
+			updates = append(updates, map[string]any{
+				"objectID": hit.ObjectID,
+				"userID":   mapping.UserID,
+			})
+		}
+		if res.Cursor == nil {
+			break
+		}
+		params.SetCursor(*res.Cursor)
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+	_, err := m.algolia.PartialUpdateObjects(m.index, updates, search.WithCreateIfNotExists(false), search.WithWaitForTasks(true))
+	return err
+}
+
+// the user document and every application made it to users/{userID}
+func (m *migrator) verifyCopy(ctx context.Context, mapping mapping) error {
+	if _, err := m.firestore.Collection("users").Doc(mapping.UserID).Get(ctx); err != nil {
+		return fmt.Errorf("failed to get users/%s: %w", mapping.UserID, err)
+	}
+
+	legacy, err := m.count(ctx, m.firestore.Collection("users").Doc(mapping.Email).Collection("applications"))
+	if err != nil {
+		return err
+	}
+	copied, err := m.count(ctx, m.firestore.Collection("users").Doc(mapping.UserID).Collection("applications"))
+	if err != nil {
+		return err
+	}
+	if copied != legacy {
+		return fmt.Errorf("%d of %d applications copied", copied, legacy)
+	}
+	return nil
+}
+
+func (m *migrator) count(ctx context.Context, collection *firestore.CollectionRef) (int64, error) {
+	result, err := collection.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count %s: %w", collection.Path, err)
+	}
+	count, ok := result["count"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("failed to count %s: unexpected result %v", collection.Path, result["count"])
+	}
+	return count.GetIntegerValue(), nil
+}
+
+// users/{email}, its applications and its analytics state (analytics_state/{userID} is rebuilt
+// from BigQuery the next time the user's analytics are recalculated)
+func (m *migrator) deleteLegacyUser(ctx context.Context, email string) error {
+	userRef := m.firestore.Collection("users").Doc(email)
+	if err := m.deleteCollection(ctx, userRef.Collection("applications")); err != nil {
+		return err
+	}
+	if _, err := userRef.Delete(ctx); err != nil {
+		return err
+	}
+	_, err := m.firestore.Collection("analytics_state").Doc(email).Delete(ctx)
+	return err
+}
+
+func (m *migrator) deleteCollection(ctx context.Context, collection *firestore.CollectionRef) error {
+	bulkWriter := m.firestore.BulkWriter(ctx)
+	iter := collection.Documents(ctx)
+	defer iter.Stop()
+
+	var jobs []*firestore.BulkWriterJob
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			bulkWriter.End()
+			return err
+		}
+		job, err := bulkWriter.Delete(doc.Ref)
+		if err != nil {
+			bulkWriter.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	return endBulkWriter(bulkWriter, jobs)
+}
+
+// End waits for every write, so by then each job has its result; returns the first failure
+// (a BulkWriter only reports them per job)
+func endBulkWriter(bulkWriter *firestore.BulkWriter, jobs []*firestore.BulkWriterJob) error {
+	bulkWriter.End()
+
+	var failed int
+	var firstErr error
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%d of %d writes failed: %w", failed, len(jobs), firstErr)
+	}
+	return nil
+}
+
+func (m *migrator) runQuery(ctx context.Context, q *bigquery.Query) error {
+	job, err := q.Run(ctx)
+	if err != nil {
+		return err
+	}
+	jobStatus, err := job.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	return jobStatus.Err()
+}
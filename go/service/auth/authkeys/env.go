@@ -0,0 +1,184 @@
+package authkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// loads the key set from the environment
+//   - JWT_KEYS_DIR: directory of PEM files named {kid}.pem, each a PKCS#8 private key (RSA or
+//     Ed25519) or a PKIX public key (verification only). generate them with `go run ./cmd/jwtkey`
+//   - JWT_SIGNING_KEY_ID: which key signs new tokens; optional if the directory has one private key
+//   - JWT_HS256_FALLBACK=true: also accept HS256 tokens signed with JWT_SECRET, so tokens handed out
+//     before switching to key files keep working until they expire
+//
+// without JWT_KEYS_DIR tokens are signed with JWT_SECRET (HS256) like before; without either, a
+// throwaway Ed25519 key is generated (not when deployed) so everyone is logged out on restart
+//
+// to rotate: add the new key file, point JWT_SIGNING_KEY_ID at it and deploy; delete the old file
+// once tokens signed with it have expired (AccessTokenTTL)
+func FromEnv(deployed bool) (*KeySet, error) {
+	secret := os.Getenv("JWT_SECRET")
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if secret != "" {
+			log.Println("JWT_KEYS_DIR not set, signing tokens with JWT_SECRET (HS256)")
+			key, err := NewSecretKey([]byte(secret))
+			if err != nil {
+				return nil, err
+			}
+			return NewKeySet(key)
+		}
+
+		if deployed {
+			return nil, fmt.Errorf("JWT_KEYS_DIR or JWT_SECRET must be set")
+		}
+
+		log.Println("Neither JWT_KEYS_DIR nor JWT_SECRET set, using a temporary signing key")
+		return Ephemeral()
+	}
+
+	keys, err := LoadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	signing, err := pickSigningKey(keys, os.Getenv("JWT_SIGNING_KEY_ID"))
+	if err != nil {
+		return nil, err
+	}
+
+	if os.Getenv("JWT_HS256_FALLBACK") == "true" {
+		key, err := NewSecretKey([]byte(secret))
+		if err != nil {
+			return nil, fmt.Errorf("JWT_HS256_FALLBACK needs JWT_SECRET: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	var others []*Key
+	for _, key := range keys {
+		if key != signing {
+			others = append(others, key)
+		}
+	}
+
+	log.Printf("Signing tokens with key %s (%s), %d other key(s) accepted", signing.ID, signing.Algorithm, len(others))
+	return NewKeySet(signing, others...)
+}
+
+// a key set with a freshly generated Ed25519 key
+func Ephemeral() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	key, err := NewSigningKey("ephemeral", private)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet(key)
+}
+
+// reads every {kid}.pem in dir, sorted by kid
+func LoadDir(dir string) ([]*Key, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .pem keys in %s", dir)
+	}
+	sort.Strings(paths)
+
+	var keys []*Key
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".pem")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %s: %w", id, err)
+		}
+		key, err := ParsePEM(id, data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// parses a PKCS#8 private key ("PRIVATE KEY") or PKIX public key ("PUBLIC KEY")
+func ParsePEM(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: no PEM block found", id)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		return NewSigningKey(id, private)
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		return NewVerificationKey(id, public)
+	default:
+		return nil, fmt.Errorf("key %s: unsupported PEM block %q", id, block.Type)
+	}
+}
+
+// MarshalPEM encodes a private key the way ParsePEM reads it
+func MarshalPEM(key *Key) ([]byte, error) {
+	if key.private == nil {
+		return nil, fmt.Errorf("key %s has no private key", key.ID)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key.private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func pickSigningKey(keys []*Key, id string) (*Key, error) {
+	if id != "" {
+		for _, key := range keys {
+			if key.ID == id {
+				if !key.CanSign() {
+					return nil, fmt.Errorf("signing key %s is a public key", id)
+				}
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("signing key %s not found", id)
+	}
+
+	var signing *Key
+	for _, key := range keys {
+		if !key.CanSign() {
+			continue
+		}
+		if signing != nil {
+			return nil, fmt.Errorf("several private keys found, set JWT_SIGNING_KEY_ID")
+		}
+		signing = key
+	}
+	if signing == nil {
+		return nil, fmt.Errorf("no private key found")
+	}
+	return signing, nil
+}
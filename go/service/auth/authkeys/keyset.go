@@ -0,0 +1,250 @@
+package authkeys
+
+// the keys access tokens are signed and verified with
+// tokens are signed with one key (RS256 or EdDSA) and carry its ID in the "kid" header; any key
+// in the set can verify, so during a rotation the old key stays in the set (verification only)
+// until the last token it signed has expired. public keys are published as a JWKS
+// (GET /.well-known/jwks.json) so other services can verify our tokens without a shared secret
+//
+// HS256 with JWT_SECRET is still supported as a fallback for setups without key files, but those
+// tokens can't be verified by anyone else (the secret is never published)
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+	AlgHS256 = "HS256"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type Key struct {
+	// the "kid" header of tokens signed with it; empty for the HS256 fallback key
+	ID        string
+	Algorithm string
+	// nil for verification-only keys
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+	// HS256 only
+	secret []byte
+}
+
+// a key that can sign; private must be an *rsa.PrivateKey or ed25519.PrivateKey
+func NewSigningKey(id string, private crypto.PrivateKey) (*Key, error) {
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("key %s: RSA keys must be at least 2048 bits", id)
+		}
+		return &Key{ID: id, Algorithm: AlgRS256, private: k, public: &k.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: id, Algorithm: AlgEdDSA, private: k, public: k.Public()}, nil
+	default:
+		return nil, fmt.Errorf("key %s: unsupported private key type %T", id, private)
+	}
+}
+
+// a verification-only key (e.g. a retired key whose private half was already deleted)
+func NewVerificationKey(id string, public crypto.PublicKey) (*Key, error) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return &Key{ID: id, Algorithm: AlgRS256, public: k}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: id, Algorithm: AlgEdDSA, public: k}, nil
+	default:
+		return nil, fmt.Errorf("key %s: unsupported public key type %T", id, public)
+	}
+}
+
+// the HS256 fallback; tokens signed with it have no kid
+func NewSecretKey(secret []byte) (*Key, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("JWT secret is empty")
+	}
+	return &Key{Algorithm: AlgHS256, secret: secret}, nil
+}
+
+func (k *Key) CanSign() bool {
+	return k.private != nil || k.secret != nil
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	switch k.Algorithm {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+func (k *Key) verificationKey() interface{} {
+	if k.secret != nil {
+		return k.secret
+	}
+	return k.public
+}
+
+type KeySet struct {
+	signing *Key
+	// by kid; the HS256 fallback (if accepted) is kept separately since it has no kid
+	keys   map[string]*Key
+	secret *Key
+}
+
+// signing must be able to sign; it is also used for verification, along with every other key
+func NewKeySet(signing *Key, others ...*Key) (*KeySet, error) {
+	if signing == nil || !signing.CanSign() {
+		return nil, fmt.Errorf("no signing key")
+	}
+
+	set := &KeySet{signing: signing, keys: make(map[string]*Key)}
+	for _, key := range append([]*Key{signing}, others...) {
+		if key.Algorithm == AlgHS256 {
+			set.secret = key
+			continue
+		}
+		if key.ID == "" {
+			return nil, fmt.Errorf("%s key has no ID", key.Algorithm)
+		}
+		if _, ok := set.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %s", key.ID)
+		}
+		set.keys[key.ID] = key
+	}
+
+	return set, nil
+}
+
+func (s *KeySet) SigningKey() *Key {
+	return s.signing
+}
+
+// signs the claims with the signing key, setting the kid header
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.method(), claims)
+	if s.signing.ID != "" {
+		token.Header["kid"] = s.signing.ID
+	}
+
+	var key interface{} = s.signing.private
+	if s.signing.secret != nil {
+		key = s.signing.secret
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// checks the token's signature (with the key named by its kid) and expiry
+func (s *KeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, s.keyfunc,
+		jwt.WithValidMethods(s.algorithms()),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("token is not valid")
+	}
+	return claims, nil
+}
+
+func (s *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	var key *Key
+	if kid == "" {
+		key = s.secret
+	} else {
+		key = s.keys[kid]
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+
+	// a token can't pick how its key is used (e.g. an RSA public key as an HMAC secret)
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+
+	return key.verificationKey(), nil
+}
+
+func (s *KeySet) algorithms() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range s.keys {
+		if !seen[key.Algorithm] {
+			seen[key.Algorithm] = true
+			algs = append(algs, key.Algorithm)
+		}
+	}
+	if s.secret != nil {
+		algs = append(algs, AlgHS256)
+	}
+	return algs
+}
+
+// https://datatracker.ietf.org/doc/html/rfc7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// the public keys of the set, sorted by kid; the HS256 fallback is never included
+func (s *KeySet) JWKS() JWKS {
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, id := range ids {
+		key := s.keys[id]
+		jwk := JWK{KeyID: key.ID, Algorithm: key.Algorithm, Use: "sig"}
+		switch k := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,89 @@
+package authstore
+
+// one-time login (and account linking) codes. after the OAuth callback the browser is redirected to the frontend with
+// a code instead of the tokens themselves (which would end up in browser history, referrers and
+// proxy logs); the frontend server redeems it once, within a minute, for the real token pair
+// only the hash of the code is stored
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// the code doesn't exist, was already redeemed or has expired; all look the same to the caller
+var ErrCodeNotFound = errors.New("authorization code not found")
+
+const (
+	// redeemed for a token pair by the frontend after login
+	PurposeLogin = "login"
+	// carried through a provider's login to link it to the account that asked for the code
+	PurposeLink = "link"
+	// a provider login waiting to be linked until the browser that asked for the link confirms it
+	PurposeLinkConfirm = "link_confirm"
+)
+
+type AuthCode struct {
+	Hash      string
+	UserID    string
+	Purpose   string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// link codes: hash of a nonce only the browser that asked for the link has
+	Binding string
+	// link confirmation codes: the provider login to link (Provider, Subject, ProviderEmail and
+	// EmailVerified; the rest is filled in when it's linked)
+	Identity *Identity
+}
+
+type CodeStore interface {
+	Save(ctx context.Context, code AuthCode) error
+	// returns the code and deletes it, so a code can only be redeemed once; expired codes return
+	// ErrCodeNotFound
+	Redeem(ctx context.Context, hash string) (*AuthCode, error)
+}
+
+// for local dev; codes only work on the instance that issued them
+type MemoryCodeStore struct {
+	mu    sync.Mutex
+	codes map[string]AuthCode
+}
+
+func NewMemoryCodeStore() *MemoryCodeStore {
+	return &MemoryCodeStore{
+		codes: make(map[string]AuthCode),
+	}
+}
+
+func (s *MemoryCodeStore) Save(ctx context.Context, code AuthCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// codes that were never redeemed would otherwise pile up
+	now := time.Now()
+	for hash, c := range s.codes {
+		if !now.Before(c.ExpiresAt) {
+			delete(s.codes, hash)
+		}
+	}
+
+	s.codes[code.Hash] = code
+	return nil
+}
+
+func (s *MemoryCodeStore) Redeem(ctx context.Context, hash string) (*AuthCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code, ok := s.codes[hash]
+	if !ok {
+		return nil, ErrCodeNotFound
+	}
+	delete(s.codes, hash)
+
+	if !time.Now().Before(code.ExpiresAt) {
+		return nil, ErrCodeNotFound
+	}
+	return &code, nil
+}
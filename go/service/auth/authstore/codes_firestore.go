@@ -0,0 +1,103 @@
+package authstore
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// auth_codes/{hash}; configure a TTL policy on expiresAt so codes that were never redeemed are
+// cleaned up
+type FirestoreCodeStore struct {
+	client *firestore.Client
+}
+
+func NewFirestoreCodeStore(client *firestore.Client) *FirestoreCodeStore {
+	return &FirestoreCodeStore{
+		client: client,
+	}
+}
+
+type firestoreCode struct {
+	UserID    string             `firestore:"userID"`
+	Purpose   string             `firestore:"purpose"`
+	CreatedAt time.Time          `firestore:"createdAt"`
+	ExpiresAt time.Time          `firestore:"expiresAt"`
+	Binding   string             `firestore:"binding,omitempty"`
+	Identity  *firestoreIdentity `firestore:"identity,omitempty"`
+}
+
+func (s *FirestoreCodeStore) codes() *firestore.CollectionRef {
+	return s.client.Collection("auth_codes")
+}
+
+func (s *FirestoreCodeStore) Save(ctx context.Context, code AuthCode) error {
+	stored := firestoreCode{
+		UserID:    code.UserID,
+		Purpose:   code.Purpose,
+		CreatedAt: code.CreatedAt,
+		ExpiresAt: code.ExpiresAt,
+		Binding:   code.Binding,
+	}
+	if code.Identity != nil {
+		stored.Identity = &firestoreIdentity{
+			Provider:      code.Identity.Provider,
+			Subject:       code.Identity.Subject,
+			ProviderEmail: code.Identity.ProviderEmail,
+			EmailVerified: code.Identity.EmailVerified,
+		}
+	}
+
+	_, err := s.codes().Doc(code.Hash).Create(ctx, stored)
+	return err
+}
+
+// read and delete in one transaction so two concurrent redemptions can't both succeed
+func (s *FirestoreCodeStore) Redeem(ctx context.Context, hash string) (*AuthCode, error) {
+	ref := s.codes().Doc(hash)
+
+	var code *AuthCode
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return ErrCodeNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var stored firestoreCode
+		if err := doc.DataTo(&stored); err != nil {
+			return err
+		}
+		code = &AuthCode{
+			Hash:      hash,
+			UserID:    stored.UserID,
+			Purpose:   stored.Purpose,
+			CreatedAt: stored.CreatedAt,
+			ExpiresAt: stored.ExpiresAt,
+			Binding:   stored.Binding,
+		}
+		if stored.Identity != nil {
+			code.Identity = &Identity{
+				Provider:      stored.Identity.Provider,
+				Subject:       stored.Identity.Subject,
+				ProviderEmail: stored.Identity.ProviderEmail,
+				EmailVerified: stored.Identity.EmailVerified,
+			}
+		}
+
+		return tx.Delete(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !time.Now().Before(code.ExpiresAt) {
+		return nil, ErrCodeNotFound
+	}
+	return code, nil
+}
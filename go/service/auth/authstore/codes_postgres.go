@@ -0,0 +1,71 @@
+package authstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// auth_codes table, see userstore/migrations/0004_auth_codes.sql and 0005_identities.sql
+type PostgresCodeStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresCodeStore(pool *pgxpool.Pool) *PostgresCodeStore {
+	return &PostgresCodeStore{
+		pool: pool,
+	}
+}
+
+func (s *PostgresCodeStore) Save(ctx context.Context, code AuthCode) error {
+	// clean up codes that were never redeemed while we're here
+	if _, err := s.pool.Exec(ctx, `DELETE FROM auth_codes WHERE expires_at < now()`); err != nil {
+		return err
+	}
+
+	var identity []byte
+	if code.Identity != nil {
+		var err error
+		identity, err = json.Marshal(code.Identity)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO auth_codes (code_hash, user_id, purpose, created_at, expires_at, binding, identity)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, code.Hash, code.UserID, code.Purpose, code.CreatedAt, code.ExpiresAt, code.Binding, identity)
+	return err
+}
+
+// DELETE ... RETURNING so only one redemption can get the row
+func (s *PostgresCodeStore) Redeem(ctx context.Context, hash string) (*AuthCode, error) {
+	code := AuthCode{Hash: hash}
+	var identity []byte
+	err := s.pool.QueryRow(ctx, `
+		DELETE FROM auth_codes WHERE code_hash = $1
+		RETURNING user_id, purpose, created_at, expires_at, binding, identity
+	`, hash).Scan(&code.UserID, &code.Purpose, &code.CreatedAt, &code.ExpiresAt, &code.Binding, &identity)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		code.Identity = &Identity{}
+		if err := json.Unmarshal(identity, code.Identity); err != nil {
+			return nil, err
+		}
+	}
+
+	if !time.Now().Before(code.ExpiresAt) {
+		return nil, ErrCodeNotFound
+	}
+	return &code, nil
+}
@@ -0,0 +1,120 @@
+package authstore
+
+// which login (provider + the provider's user ID) belongs to which copium account (the user ID that
+// keys users/{userID}). one account can have several, so signing in with GitHub reaches the same
+// data as signing in with Google
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+	// the provider account is already linked to a different copium account
+	ErrIdentityLinked = errors.New("identity is linked to another account")
+)
+
+type Identity struct {
+	Provider string
+	// the provider's ID for the user (goth.User.UserID); unlike the email it never changes
+	Subject string
+	// the copium account
+	UserID string
+	// the email the provider reported, which can differ from the account's
+	ProviderEmail string
+	// whether the provider verified ProviderEmail; only verified emails can become the account's email
+	EmailVerified bool
+	LinkedAt      time.Time
+}
+
+type IdentityStore interface {
+	// returns ErrIdentityNotFound if the identity isn't linked to any account
+	GetIdentity(ctx context.Context, provider string, subject string) (*Identity, error)
+	// links the identity to identity.UserID; linking it to the same account again is a no-op,
+	// linking it to another account returns ErrIdentityLinked
+	Link(ctx context.Context, identity Identity) error
+	// the account's identities, oldest first
+	ListIdentities(ctx context.Context, userID string) ([]Identity, error)
+	// returns ErrIdentityNotFound if the identity isn't linked to this account
+	Unlink(ctx context.Context, userID string, provider string, subject string) error
+}
+
+// for local dev
+type MemoryIdentityStore struct {
+	mu         sync.RWMutex
+	identities map[string]Identity
+}
+
+func NewMemoryIdentityStore() *MemoryIdentityStore {
+	return &MemoryIdentityStore{
+		identities: make(map[string]Identity),
+	}
+}
+
+func identityKey(provider string, subject string) string {
+	return provider + ":" + subject
+}
+
+func (s *MemoryIdentityStore) GetIdentity(ctx context.Context, provider string, subject string) (*Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identity, ok := s.identities[identityKey(provider, subject)]
+	if !ok {
+		return nil, ErrIdentityNotFound
+	}
+	return &identity, nil
+}
+
+func (s *MemoryIdentityStore) Link(ctx context.Context, identity Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := identityKey(identity.Provider, identity.Subject)
+	if existing, ok := s.identities[key]; ok {
+		if existing.UserID != identity.UserID {
+			return ErrIdentityLinked
+		}
+		return nil
+	}
+
+	s.identities[key] = identity
+	return nil
+}
+
+func (s *MemoryIdentityStore) ListIdentities(ctx context.Context, userID string) ([]Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var identities []Identity
+	for _, identity := range s.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sortIdentities(identities)
+	return identities, nil
+}
+
+func (s *MemoryIdentityStore) Unlink(ctx context.Context, userID string, provider string, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := identityKey(provider, subject)
+	identity, ok := s.identities[key]
+	if !ok || identity.UserID != userID {
+		return ErrIdentityNotFound
+	}
+	delete(s.identities, key)
+	return nil
+}
+
+func sortIdentities(identities []Identity) {
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].LinkedAt.Before(identities[j].LinkedAt)
+	})
+}
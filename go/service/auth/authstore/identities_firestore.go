@@ -0,0 +1,125 @@
+package authstore
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// identities/{provider}:{subject}
+type FirestoreIdentityStore struct {
+	client *firestore.Client
+}
+
+func NewFirestoreIdentityStore(client *firestore.Client) *FirestoreIdentityStore {
+	return &FirestoreIdentityStore{
+		client: client,
+	}
+}
+
+type firestoreIdentity struct {
+	Provider      string    `firestore:"provider"`
+	Subject       string    `firestore:"subject"`
+	UserID        string    `firestore:"userID"`
+	ProviderEmail string    `firestore:"providerEmail"`
+	EmailVerified bool      `firestore:"emailVerified"`
+	LinkedAt      time.Time `firestore:"linkedAt"`
+}
+
+func (s *FirestoreIdentityStore) identities() *firestore.CollectionRef {
+	return s.client.Collection("identities")
+}
+
+// subjects are opaque strings from the provider; escaped so a "/" can't end up in the path
+func (s *FirestoreIdentityStore) doc(provider string, subject string) *firestore.DocumentRef {
+	return s.identities().Doc(provider + ":" + url.PathEscape(subject))
+}
+
+func (s *FirestoreIdentityStore) GetIdentity(ctx context.Context, provider string, subject string) (*Identity, error) {
+	doc, err := s.doc(provider, subject).Get(ctx)
+	return decodeIdentity(doc, err)
+}
+
+func (s *FirestoreIdentityStore) Link(ctx context.Context, identity Identity) error {
+	ref := s.doc(identity.Provider, identity.Subject)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		existing, err := decodeIdentity(tx.Get(ref))
+		if err == nil {
+			if existing.UserID != identity.UserID {
+				return ErrIdentityLinked
+			}
+			return nil
+		}
+		if err != ErrIdentityNotFound {
+			return err
+		}
+
+		return tx.Create(ref, firestoreIdentity{
+			Provider:      identity.Provider,
+			Subject:       identity.Subject,
+			UserID:        identity.UserID,
+			ProviderEmail: identity.ProviderEmail,
+			EmailVerified: identity.EmailVerified,
+			LinkedAt:      identity.LinkedAt,
+		})
+	})
+}
+
+func (s *FirestoreIdentityStore) ListIdentities(ctx context.Context, userID string) ([]Identity, error) {
+	docs, err := s.identities().Where("userID", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	identities := make([]Identity, 0, len(docs))
+	for _, doc := range docs {
+		identity, err := decodeIdentity(doc, nil)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *identity)
+	}
+	sortIdentities(identities)
+	return identities, nil
+}
+
+func (s *FirestoreIdentityStore) Unlink(ctx context.Context, userID string, provider string, subject string) error {
+	ref := s.doc(provider, subject)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		identity, err := decodeIdentity(tx.Get(ref))
+		if err != nil {
+			return err
+		}
+		if identity.UserID != userID {
+			return ErrIdentityNotFound
+		}
+		return tx.Delete(ref)
+	})
+}
+
+func decodeIdentity(doc *firestore.DocumentSnapshot, err error) (*Identity, error) {
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored firestoreIdentity
+	if err := doc.DataTo(&stored); err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Provider:      stored.Provider,
+		Subject:       stored.Subject,
+		UserID:        stored.UserID,
+		ProviderEmail: stored.ProviderEmail,
+		EmailVerified: stored.EmailVerified,
+		LinkedAt:      stored.LinkedAt,
+	}, nil
+}
@@ -0,0 +1,86 @@
+package authstore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// identities table, see userstore/migrations/0005_identities.sql
+type PostgresIdentityStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresIdentityStore(pool *pgxpool.Pool) *PostgresIdentityStore {
+	return &PostgresIdentityStore{
+		pool: pool,
+	}
+}
+
+func (s *PostgresIdentityStore) GetIdentity(ctx context.Context, provider string, subject string) (*Identity, error) {
+	identity := Identity{Provider: provider, Subject: subject}
+	err := s.pool.QueryRow(ctx, `
+		SELECT user_id, provider_email, email_verified, linked_at FROM identities
+		WHERE provider = $1 AND subject = $2
+	`, provider, subject).Scan(&identity.UserID, &identity.ProviderEmail, &identity.EmailVerified, &identity.LinkedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (s *PostgresIdentityStore) Link(ctx context.Context, identity Identity) error {
+	// on conflict the row is only "updated" (to itself) if it belongs to the same account, so
+	// no row back means it's linked to someone else
+	var userID string
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO identities (provider, subject, user_id, provider_email, email_verified, linked_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (provider, subject) DO UPDATE SET user_id = identities.user_id
+		WHERE identities.user_id = EXCLUDED.user_id
+		RETURNING user_id
+	`, identity.Provider, identity.Subject, identity.UserID, identity.ProviderEmail, identity.EmailVerified, identity.LinkedAt).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrIdentityLinked
+	}
+	return err
+}
+
+func (s *PostgresIdentityStore) ListIdentities(ctx context.Context, userID string) ([]Identity, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT provider, subject, provider_email, email_verified, linked_at FROM identities
+		WHERE user_id = $1 ORDER BY linked_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		identity := Identity{UserID: userID}
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.ProviderEmail, &identity.EmailVerified, &identity.LinkedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (s *PostgresIdentityStore) Unlink(ctx context.Context, userID string, provider string, subject string) error {
+	tag, err := s.pool.Exec(ctx, `
+		DELETE FROM identities WHERE provider = $1 AND subject = $2 AND user_id = $3
+	`, provider, subject, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
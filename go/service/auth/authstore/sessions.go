@@ -0,0 +1,132 @@
+package authstore
+
+// server-side state for logins. every login creates a Session; the access tokens handed out
+// for it carry its ID (the "sid" claim) and are only accepted while the session is active, so
+// revoking a session logs that device out right away instead of whenever its token expires
+// a session also holds the (hash of the) current refresh token, which is replaced on every
+// refresh; only hashes are stored so a leaked store can't be used to log in
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	// the session's refresh token changed since it was read (another refresh won the race)
+	ErrSessionConflict = errors.New("session was modified concurrently")
+)
+
+type Session struct {
+	ID     string
+	UserID string
+	// hash of the current refresh token
+	RefreshHash string
+	// hash of the token the current one replaced; seeing it again means a refresh token was
+	// used twice, i.e. it was probably stolen
+	PreviousHash string
+	CreatedAt    time.Time
+	RotatedAt    time.Time
+	// refresh tokens stop working after this; extended on every refresh
+	ExpiresAt time.Time
+	// zero while the session is active
+	RevokedAt time.Time
+}
+
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt.IsZero() && now.Before(s.ExpiresAt)
+}
+
+type SessionStore interface {
+	Create(ctx context.Context, session Session) error
+	// returns ErrSessionNotFound if there is no such session
+	GetSession(ctx context.Context, id string) (*Session, error)
+	// replaces the refresh token hash if it is still currentHash, otherwise ErrSessionConflict
+	Rotate(ctx context.Context, id string, currentHash string, newHash string, expiresAt time.Time) error
+	// revoking an already revoked session is a no-op
+	Revoke(ctx context.Context, id string) error
+	// revokes every active session of the user ("log out all devices")
+	RevokeAll(ctx context.Context, userID string) error
+}
+
+// for local dev; everyone is logged out on restart
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]Session
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]Session),
+	}
+}
+
+func (s *MemorySessionStore) Create(ctx context.Context, session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *MemorySessionStore) GetSession(ctx context.Context, id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (s *MemorySessionStore) Rotate(ctx context.Context, id string, currentHash string, newHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if session.RefreshHash != currentHash {
+		return ErrSessionConflict
+	}
+
+	session.PreviousHash = session.RefreshHash
+	session.RefreshHash = newHash
+	session.RotatedAt = time.Now()
+	session.ExpiresAt = expiresAt
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *MemorySessionStore) Revoke(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if session.RevokedAt.IsZero() {
+		session.RevokedAt = time.Now()
+		s.sessions[id] = session
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) RevokeAll(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, session := range s.sessions {
+		if session.UserID == userID && session.RevokedAt.IsZero() {
+			session.RevokedAt = now
+			s.sessions[id] = session
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package authstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sessions/{id}; configure a TTL policy on expiresAt so expired sessions are cleaned up
+type FirestoreSessionStore struct {
+	client *firestore.Client
+}
+
+func NewFirestoreSessionStore(client *firestore.Client) *FirestoreSessionStore {
+	return &FirestoreSessionStore{
+		client: client,
+	}
+}
+
+type firestoreSession struct {
+	UserID       string    `firestore:"userID"`
+	RefreshHash  string    `firestore:"refreshHash"`
+	PreviousHash string    `firestore:"previousHash"`
+	CreatedAt    time.Time `firestore:"createdAt"`
+	RotatedAt    time.Time `firestore:"rotatedAt"`
+	ExpiresAt    time.Time `firestore:"expiresAt"`
+	Revoked      bool      `firestore:"revoked"`
+	RevokedAt    time.Time `firestore:"revokedAt"`
+}
+
+func (s *FirestoreSessionStore) sessions() *firestore.CollectionRef {
+	return s.client.Collection("sessions")
+}
+
+func (s *FirestoreSessionStore) Create(ctx context.Context, session Session) error {
+	_, err := s.sessions().Doc(session.ID).Create(ctx, firestoreSession{
+		UserID:       session.UserID,
+		RefreshHash:  session.RefreshHash,
+		PreviousHash: session.PreviousHash,
+		CreatedAt:    session.CreatedAt,
+		RotatedAt:    session.RotatedAt,
+		ExpiresAt:    session.ExpiresAt,
+	})
+	return err
+}
+
+func (s *FirestoreSessionStore) GetSession(ctx context.Context, id string) (*Session, error) {
+	doc, err := s.sessions().Doc(id).Get(ctx)
+	return decodeSession(doc, err)
+}
+
+func (s *FirestoreSessionStore) Rotate(ctx context.Context, id string, currentHash string, newHash string, expiresAt time.Time) error {
+	ref := s.sessions().Doc(id)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		session, err := decodeSession(tx.Get(ref))
+		if err != nil {
+			return err
+		}
+		if session.RefreshHash != currentHash {
+			return ErrSessionConflict
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "previousHash", Value: currentHash},
+			{Path: "refreshHash", Value: newHash},
+			{Path: "rotatedAt", Value: time.Now()},
+			{Path: "expiresAt", Value: expiresAt},
+		})
+	})
+}
+
+func (s *FirestoreSessionStore) Revoke(ctx context.Context, id string) error {
+	ref := s.sessions().Doc(id)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		session, err := decodeSession(tx.Get(ref))
+		if err != nil {
+			return err
+		}
+		if !session.RevokedAt.IsZero() {
+			return nil
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "revoked", Value: true},
+			{Path: "revokedAt", Value: time.Now()},
+		})
+	})
+}
+
+func (s *FirestoreSessionStore) RevokeAll(ctx context.Context, userID string) error {
+	iter := s.sessions().Where("userID", "==", userID).Where("revoked", "==", false).Documents(ctx)
+	defer iter.Stop()
+
+	now := time.Now()
+	bulkWriter := s.client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			bulkWriter.End()
+			return err
+		}
+		job, err := bulkWriter.Update(doc.Ref, []firestore.Update{
+			{Path: "revoked", Value: true},
+			{Path: "revokedAt", Value: now},
+		})
+		if err != nil {
+			bulkWriter.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	return endBulkWriter(bulkWriter, jobs)
+}
+
+// End waits for every write, so by then each job has its result; returns the first failure
+// (a BulkWriter only reports them per job)
+func endBulkWriter(bulkWriter *firestore.BulkWriter, jobs []*firestore.BulkWriterJob) error {
+	bulkWriter.End()
+
+	var failed int
+	var firstErr error
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%d of %d writes failed: %w", failed, len(jobs), firstErr)
+	}
+	return nil
+}
+
+func decodeSession(doc *firestore.DocumentSnapshot, err error) (*Session, error) {
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored firestoreSession
+	if err := doc.DataTo(&stored); err != nil {
+		return nil, err
+	}
+
+	session := &Session{
+		ID:           doc.Ref.ID,
+		UserID:       stored.UserID,
+		RefreshHash:  stored.RefreshHash,
+		PreviousHash: stored.PreviousHash,
+		CreatedAt:    stored.CreatedAt,
+		RotatedAt:    stored.RotatedAt,
+		ExpiresAt:    stored.ExpiresAt,
+	}
+	if stored.Revoked {
+		session.RevokedAt = stored.RevokedAt
+	}
+	return session, nil
+}
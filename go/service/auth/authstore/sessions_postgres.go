@@ -0,0 +1,87 @@
+package authstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// sessions table, see userstore/migrations/0003_sessions.sql
+type PostgresSessionStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresSessionStore(pool *pgxpool.Pool) *PostgresSessionStore {
+	return &PostgresSessionStore{
+		pool: pool,
+	}
+}
+
+func (s *PostgresSessionStore) Create(ctx context.Context, session Session) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO sessions (id, user_id, refresh_hash, previous_hash, created_at, rotated_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, session.ID, session.UserID, session.RefreshHash, session.PreviousHash, session.CreatedAt, session.RotatedAt, session.ExpiresAt)
+	return err
+}
+
+func (s *PostgresSessionStore) GetSession(ctx context.Context, id string) (*Session, error) {
+	session := Session{ID: id}
+	var revokedAt *time.Time
+	err := s.pool.QueryRow(ctx, `
+		SELECT user_id, refresh_hash, previous_hash, created_at, rotated_at, expires_at, revoked_at
+		FROM sessions WHERE id = $1
+	`, id).Scan(&session.UserID, &session.RefreshHash, &session.PreviousHash, &session.CreatedAt, &session.RotatedAt, &session.ExpiresAt, &revokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt != nil {
+		session.RevokedAt = *revokedAt
+	}
+	return &session, nil
+}
+
+func (s *PostgresSessionStore) Rotate(ctx context.Context, id string, currentHash string, newHash string, expiresAt time.Time) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE sessions
+		SET previous_hash = refresh_hash, refresh_hash = $3, rotated_at = now(), expires_at = $4
+		WHERE id = $1 AND refresh_hash = $2
+	`, id, currentHash, newHash, expiresAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		// either the session is gone or the hash changed
+		if _, err := s.GetSession(ctx, id); err != nil {
+			return err
+		}
+		return ErrSessionConflict
+	}
+	return nil
+}
+
+func (s *PostgresSessionStore) Revoke(ctx context.Context, id string) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE sessions SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1
+	`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *PostgresSessionStore) RevokeAll(ctx context.Context, userID string) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+	return err
+}
@@ -0,0 +1,10 @@
+package authstore
+
+// everything the auth service keeps, so adding a store doesn't change every constructor between
+// cmd/main.go and the auth handler
+type Stores struct {
+	Sessions   SessionStore
+	Codes      CodeStore
+	Identities IdentityStore
+	Tokens     TokenStore
+}
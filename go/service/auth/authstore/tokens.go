@@ -0,0 +1,135 @@
+package authstore
+
+// personal access tokens: long-lived tokens a user makes for scripts and browser extensions, sent as
+// "Authorization: Bearer cpm_..." like an access token. each has a name and scopes (read-only or
+// read/write) and can be revoked on its own. only the hash of the secret is stored
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrTokenNotFound = errors.New("personal access token not found")
+
+type PersonalAccessToken struct {
+	ID     string
+	UserID string
+	Name   string
+	Scopes []string
+	// hash of the secret half of the token
+	Hash      string
+	CreatedAt time.Time
+	// zero if the token never expires
+	ExpiresAt time.Time
+	// zero until the token is first used; only updated every few minutes
+	LastUsedAt time.Time
+}
+
+func (t *PersonalAccessToken) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+type TokenStore interface {
+	CreateToken(ctx context.Context, token PersonalAccessToken) error
+	// returns ErrTokenNotFound if there is no such token
+	GetToken(ctx context.Context, id string) (*PersonalAccessToken, error)
+	// the user's tokens, newest first
+	ListTokens(ctx context.Context, userID string) ([]PersonalAccessToken, error)
+	// returns ErrTokenNotFound if the token isn't the user's
+	DeleteToken(ctx context.Context, userID string, id string) error
+	// for deleted accounts
+	DeleteAllTokens(ctx context.Context, userID string) error
+	// records when the token was last used
+	TouchToken(ctx context.Context, id string, at time.Time) error
+}
+
+// for local dev
+type MemoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]PersonalAccessToken
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: make(map[string]PersonalAccessToken),
+	}
+}
+
+func (s *MemoryTokenStore) CreateToken(ctx context.Context, token PersonalAccessToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.ID] = token
+	return nil
+}
+
+func (s *MemoryTokenStore) GetToken(ctx context.Context, id string) (*PersonalAccessToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	return &token, nil
+}
+
+func (s *MemoryTokenStore) ListTokens(ctx context.Context, userID string) ([]PersonalAccessToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := []PersonalAccessToken{}
+	for _, token := range s.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	sortTokens(tokens)
+	return tokens, nil
+}
+
+func (s *MemoryTokenStore) DeleteToken(ctx context.Context, userID string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok || token.UserID != userID {
+		return ErrTokenNotFound
+	}
+	delete(s.tokens, id)
+	return nil
+}
+
+func (s *MemoryTokenStore) DeleteAllTokens(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.tokens {
+		if token.UserID == userID {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryTokenStore) TouchToken(ctx context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	token.LastUsedAt = at
+	s.tokens[id] = token
+	return nil
+}
+
+func sortTokens(tokens []PersonalAccessToken) {
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+}
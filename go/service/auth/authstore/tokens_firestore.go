@@ -0,0 +1,145 @@
+package authstore
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// access_tokens/{id}
+type FirestoreTokenStore struct {
+	client *firestore.Client
+}
+
+func NewFirestoreTokenStore(client *firestore.Client) *FirestoreTokenStore {
+	return &FirestoreTokenStore{
+		client: client,
+	}
+}
+
+type firestoreToken struct {
+	UserID     string    `firestore:"userID"`
+	Name       string    `firestore:"name"`
+	Scopes     []string  `firestore:"scopes"`
+	Hash       string    `firestore:"hash"`
+	CreatedAt  time.Time `firestore:"createdAt"`
+	ExpiresAt  time.Time `firestore:"expiresAt"`
+	LastUsedAt time.Time `firestore:"lastUsedAt"`
+}
+
+func (s *FirestoreTokenStore) tokens() *firestore.CollectionRef {
+	return s.client.Collection("access_tokens")
+}
+
+func (s *FirestoreTokenStore) CreateToken(ctx context.Context, token PersonalAccessToken) error {
+	_, err := s.tokens().Doc(token.ID).Create(ctx, firestoreToken{
+		UserID:    token.UserID,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		Hash:      token.Hash,
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+	})
+	return err
+}
+
+func (s *FirestoreTokenStore) GetToken(ctx context.Context, id string) (*PersonalAccessToken, error) {
+	doc, err := s.tokens().Doc(id).Get(ctx)
+	return decodeToken(doc, err)
+}
+
+func (s *FirestoreTokenStore) ListTokens(ctx context.Context, userID string) ([]PersonalAccessToken, error) {
+	docs, err := s.tokens().Where("userID", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]PersonalAccessToken, 0, len(docs))
+	for _, doc := range docs {
+		token, err := decodeToken(doc, nil)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	sortTokens(tokens)
+	return tokens, nil
+}
+
+func (s *FirestoreTokenStore) DeleteToken(ctx context.Context, userID string, id string) error {
+	ref := s.tokens().Doc(id)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		token, err := decodeToken(tx.Get(ref))
+		if err != nil {
+			return err
+		}
+		if token.UserID != userID {
+			return ErrTokenNotFound
+		}
+		return tx.Delete(ref)
+	})
+}
+
+func (s *FirestoreTokenStore) DeleteAllTokens(ctx context.Context, userID string) error {
+	iter := s.tokens().Where("userID", "==", userID).Documents(ctx)
+	defer iter.Stop()
+
+	bulkWriter := s.client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			bulkWriter.End()
+			return err
+		}
+		job, err := bulkWriter.Delete(doc.Ref)
+		if err != nil {
+			bulkWriter.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	return endBulkWriter(bulkWriter, jobs)
+}
+
+func (s *FirestoreTokenStore) TouchToken(ctx context.Context, id string, at time.Time) error {
+	_, err := s.tokens().Doc(id).Update(ctx, []firestore.Update{
+		{Path: "lastUsedAt", Value: at},
+	})
+	if status.Code(err) == codes.NotFound {
+		return ErrTokenNotFound
+	}
+	return err
+}
+
+func decodeToken(doc *firestore.DocumentSnapshot, err error) (*PersonalAccessToken, error) {
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored firestoreToken
+	if err := doc.DataTo(&stored); err != nil {
+		return nil, err
+	}
+
+	return &PersonalAccessToken{
+		ID:         doc.Ref.ID,
+		UserID:     stored.UserID,
+		Name:       stored.Name,
+		Scopes:     stored.Scopes,
+		Hash:       stored.Hash,
+		CreatedAt:  stored.CreatedAt,
+		ExpiresAt:  stored.ExpiresAt,
+		LastUsedAt: stored.LastUsedAt,
+	}, nil
+}
@@ -0,0 +1,114 @@
+package authstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// access_tokens table, see userstore/migrations/0007_access_tokens.sql
+type PostgresTokenStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresTokenStore(pool *pgxpool.Pool) *PostgresTokenStore {
+	return &PostgresTokenStore{
+		pool: pool,
+	}
+}
+
+func (s *PostgresTokenStore) CreateToken(ctx context.Context, token PersonalAccessToken) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO access_tokens (id, user_id, name, scopes, token_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, token.ID, token.UserID, token.Name, token.Scopes, token.Hash, token.CreatedAt, nullTime(token.ExpiresAt))
+	return err
+}
+
+func (s *PostgresTokenStore) GetToken(ctx context.Context, id string) (*PersonalAccessToken, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, user_id, name, scopes, token_hash, created_at, expires_at, last_used_at
+		FROM access_tokens WHERE id = $1
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := scanTokens(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, ErrTokenNotFound
+	}
+	return &tokens[0], nil
+}
+
+func (s *PostgresTokenStore) ListTokens(ctx context.Context, userID string) ([]PersonalAccessToken, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, user_id, name, scopes, token_hash, created_at, expires_at, last_used_at
+		FROM access_tokens WHERE user_id = $1 ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanTokens(rows)
+}
+
+func (s *PostgresTokenStore) DeleteToken(ctx context.Context, userID string, id string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM access_tokens WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+func (s *PostgresTokenStore) DeleteAllTokens(ctx context.Context, userID string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM access_tokens WHERE user_id = $1`, userID)
+	return err
+}
+
+func (s *PostgresTokenStore) TouchToken(ctx context.Context, id string, at time.Time) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE access_tokens SET last_used_at = $2 WHERE id = $1`, id, at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+func scanTokens(rows pgx.Rows) ([]PersonalAccessToken, error) {
+	defer rows.Close()
+
+	tokens := []PersonalAccessToken{}
+	for rows.Next() {
+		var token PersonalAccessToken
+		var expiresAt, lastUsedAt *time.Time
+		err := rows.Scan(&token.ID, &token.UserID, &token.Name, &token.Scopes, &token.Hash, &token.CreatedAt, &expiresAt, &lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		if expiresAt != nil {
+			token.ExpiresAt = *expiresAt
+		}
+		if lastUsedAt != nil {
+			token.LastUsedAt = *lastUsedAt
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// zero times are stored as NULL
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,78 @@
+package auth
+
+// the OAuth callback hands the frontend a one-time code rather than tokens: tokens in a redirect
+// URL end up in browser history, referrers and proxy logs. the frontend server then trades the
+// code for the token pair with POST /auth/token. a code works once, within AuthCodeTTL
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/copium-dev/copium/go/service/auth/authstore"
+)
+
+const (
+	AuthCodeTTL = time.Minute
+	// a link code has to last through the provider's login page
+	LinkCodeTTL = 10 * time.Minute
+)
+
+type tokenRequest struct {
+	Code string `json:"code"`
+}
+
+// stores a new code (the user, purpose and whatever else the purpose needs from stored) and returns it
+func (h *Handler) issueCode(ctx context.Context, stored authstore.AuthCode, ttl time.Duration) (string, error) {
+	code := randomString(32)
+
+	now := time.Now()
+	stored.Hash = hashSecret(code)
+	stored.CreatedAt = now
+	stored.ExpiresAt = now.Add(ttl)
+	err := h.codes.Save(ctx, stored)
+	if err != nil {
+		return "", fmt.Errorf("failed to save authorization code: %w", err)
+	}
+
+	return code, nil
+}
+
+// POST /auth/token {"code": "..."} -> first token pair of a new session
+func (h *Handler) Token(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] Token [*]")
+	log.Println("-----------------")
+
+	var request tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	code, err := h.codes.Redeem(r.Context(), hashSecret(request.Code))
+	if errors.Is(err, authstore.ErrCodeNotFound) || (err == nil && code.Purpose != authstore.PurposeLogin) {
+		http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error redeeming code", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := h.authenticator.startSession(r.Context(), code.UserID)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error starting session", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Code redeemed")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
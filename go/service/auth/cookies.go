@@ -0,0 +1,125 @@
+package auth
+
+// AUTH_MODE=cookie is for self-hosted deployments where the frontend and the API share a domain
+// (the cross-domain problem in AuthProviderCallback doesn't apply). the browser session then lives
+// in an HttpOnly cookie signed by the AuthHandler's store (the one gothic uses for the OAuth dance)
+// instead of a bearer JWT. the cookie only holds the user and session IDs, so revoking the session
+// still logs the browser out right away. bearer tokens (personal access tokens, and JWTs from
+// POST /auth/token) keep working next to it
+//
+// the browser sends cookies with every request, including ones another site triggers, so unsafe
+// methods need a CSRF token too: a random one is kept in the signed session and copied to a cookie
+// the frontend can read, and it has to come back in the X-CSRF-Token header (double submit)
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	cookiestore "github.com/gorilla/sessions"
+)
+
+const (
+	AuthModeBearer = "bearer"
+	AuthModeCookie = "cookie"
+
+	SessionCookieName = "copium_session"
+	CSRFCookieName    = "copium_csrf"
+	CSRFHeaderName    = "X-CSRF-Token"
+)
+
+var ErrInvalidCSRFToken = errors.New("missing or invalid CSRF token")
+
+func (a *Authenticator) cookieMode() bool {
+	return a.cookieSessions != nil
+}
+
+// the session and CSRF cookies share these
+func (a *Authenticator) cookieOptions(maxAge int, httpOnly bool) *cookiestore.Options {
+	return &cookiestore.Options{
+		Path:     "/",
+		Domain:   a.cookieDomain,
+		MaxAge:   maxAge,
+		Secure:   a.cookiesSecure,
+		HttpOnly: httpOnly,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// logs the browser in to an already created session
+func (a *Authenticator) setCookieSession(w http.ResponseWriter, r *http.Request, userID string, sessionID string) error {
+	session, err := a.cookieSessions.New(r, SessionCookieName)
+	if err != nil && session == nil {
+		return fmt.Errorf("failed to create session cookie: %w", err)
+	}
+
+	csrfToken := randomString(32)
+	session.Values["uid"] = userID
+	session.Values["sid"] = sessionID
+	session.Values["csrf"] = csrfToken
+	// the session itself expires after RefreshTokenTTL (checked in authenticateCookie)
+	session.Options = a.cookieOptions(int(RefreshTokenTTL.Seconds()), true)
+	if err := session.Save(r, w); err != nil {
+		return fmt.Errorf("failed to save session cookie: %w", err)
+	}
+
+	http.SetCookie(w, cookiestore.NewCookie(CSRFCookieName, csrfToken, a.cookieOptions(int(RefreshTokenTTL.Seconds()), false)))
+	return nil
+}
+
+func (a *Authenticator) clearCookieSession(w http.ResponseWriter) {
+	http.SetCookie(w, cookiestore.NewCookie(SessionCookieName, "", a.cookieOptions(-1, true)))
+	http.SetCookie(w, cookiestore.NewCookie(CSRFCookieName, "", a.cookieOptions(-1, false)))
+}
+
+func (a *Authenticator) hasCookieSession(r *http.Request) bool {
+	if !a.cookieMode() {
+		return false
+	}
+	_, err := r.Cookie(SessionCookieName)
+	return err == nil
+}
+
+// the cookie counterpart of authenticate; unsafe methods also need the CSRF header
+func (a *Authenticator) authenticateCookie(r *http.Request) (*Principal, error) {
+	session, err := a.cookieSessions.Get(r, SessionCookieName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session cookie: %w", err)
+	}
+
+	userID, _ := session.Values["uid"].(string)
+	sessionID, _ := session.Values["sid"].(string)
+	csrfToken, _ := session.Values["csrf"].(string)
+	if userID == "" || sessionID == "" {
+		return nil, fmt.Errorf("no session cookie")
+	}
+
+	if !safeMethod(r.Method) {
+		presented := r.Header.Get(CSRFHeaderName)
+		if csrfToken == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(csrfToken)) != 1 {
+			return nil, ErrInvalidCSRFToken
+		}
+	}
+
+	stored, err := a.checkSession(r.Context(), userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	// unlike a JWT the cookie has no expiry of its own
+	if !stored.Active(time.Now()) {
+		return nil, ErrSessionRevoked
+	}
+
+	return &Principal{
+		UserID:    userID,
+		SessionID: sessionID,
+		Scopes:    []string{ScopeRead, ScopeWrite, ScopeAccount},
+		Admin:     a.admins[userID],
+	}, nil
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
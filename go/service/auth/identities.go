@@ -0,0 +1,406 @@
+package auth
+
+// one copium account (users/{userID}) can be signed into with several providers. the callback
+// works out the account from the provider's user ID:
+//   - the browser started the login from "link account" on the profile page: nothing yet. the
+//     frontend confirms the link (POST /auth/link/complete) with the account's session and the
+//     nonce it gave the browser when asking for the link code, so a link code someone else got
+//     for their account can't link your login to it
+//   - already linked: that account
+//   - otherwise the account with the provider's email (or a new one, i.e. signing up), but only if
+//     the provider says the email is verified. an unverified email could belong to anyone, so those
+//     providers have to be linked from the profile first
+//
+// the account's email is just a field on the account, so it can be changed to any verified email of
+// a linked provider (POST /auth/email) without touching any data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/copium-dev/copium/go/service/auth/authstore"
+	"github.com/copium-dev/copium/go/service/user/userstore"
+
+	"github.com/gorilla/mux"
+	"github.com/markbates/goth"
+)
+
+// the gothic session key the link code is kept under during the provider's login
+const linkSessionKey = "copium_link_code"
+
+var (
+	ErrUnverifiedEmail = errors.New("this provider did not verify your email; sign in another way and link it from your profile")
+	ErrLinkExpired     = errors.New("account link request expired, try again from your profile")
+)
+
+type identityResponse struct {
+	Provider      string    `json:"provider"`
+	Subject       string    `json:"subject"`
+	ProviderEmail string    `json:"providerEmail"`
+	EmailVerified bool      `json:"emailVerified"`
+	LinkedAt      time.Time `json:"linkedAt"`
+}
+
+type unlinkRequest struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+type emailRequest struct {
+	Email string `json:"email"`
+}
+
+type linkRequest struct {
+	// random, kept by the browser asking for the link (in a frontend cookie) until it confirms it
+	Nonce string `json:"nonce"`
+}
+
+type completeLinkRequest struct {
+	Code  string `json:"code"`
+	Nonce string `json:"nonce"`
+}
+
+// returns the ID of the copium account this provider login belongs to, creating the account or
+// linking the identity if needed
+func (h *Handler) resolveAccount(ctx context.Context, provider string, user goth.User) (string, error) {
+	if user.UserID == "" {
+		return "", fmt.Errorf("provider %s returned no user ID", provider)
+	}
+
+	identity, err := h.identities.GetIdentity(ctx, provider, user.UserID)
+	if err != nil && !errors.Is(err, authstore.ErrIdentityNotFound) {
+		return "", fmt.Errorf("failed to look up identity: %w", err)
+	}
+
+	if identity != nil {
+		exists, err := h.store.UserExists(ctx, identity.UserID)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up user: %w", err)
+		}
+		if exists {
+			return identity.UserID, nil
+		}
+
+		// the account was deleted; signing in again is signing up again
+		if err := h.identities.Unlink(ctx, identity.UserID, provider, user.UserID); err != nil && !errors.Is(err, authstore.ErrIdentityNotFound) {
+			return "", fmt.Errorf("failed to unlink identity: %w", err)
+		}
+	}
+
+	verified := user.Email != "" && emailVerified(provider, user)
+	if !verified {
+		return "", ErrUnverifiedEmail
+	}
+	userID, err := h.findOrCreateUser(ctx, user.Email)
+	if err != nil {
+		return "", err
+	}
+
+	err = h.link(ctx, userID, authstore.Identity{
+		Provider:      provider,
+		Subject:       user.UserID,
+		ProviderEmail: user.Email,
+		EmailVerified: verified,
+	})
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// redeems the link code the login was started with and returns a code (valid for AuthCodeTTL)
+// that links the login once the browser that asked for the link confirms it
+func (h *Handler) startLink(ctx context.Context, provider string, user goth.User, linkCode string) (string, error) {
+	if user.UserID == "" {
+		return "", fmt.Errorf("provider %s returned no user ID", provider)
+	}
+
+	link, err := h.codes.Redeem(ctx, hashSecret(linkCode))
+	if errors.Is(err, authstore.ErrCodeNotFound) || (err == nil && link.Purpose != authstore.PurposeLink) {
+		return "", ErrLinkExpired
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to redeem link code: %w", err)
+	}
+
+	return h.issueCode(ctx, authstore.AuthCode{
+		UserID:  link.UserID,
+		Purpose: authstore.PurposeLinkConfirm,
+		Binding: link.Binding,
+		Identity: &authstore.Identity{
+			Provider:      provider,
+			Subject:       user.UserID,
+			ProviderEmail: user.Email,
+			EmailVerified: user.Email != "" && emailVerified(provider, user),
+		},
+	}, AuthCodeTTL)
+}
+
+func (h *Handler) link(ctx context.Context, userID string, identity authstore.Identity) error {
+	identity.UserID = userID
+	identity.LinkedAt = time.Now()
+	if err := h.identities.Link(ctx, identity); err != nil {
+		return err
+	}
+
+	log.Printf("Linked %s identity to %s", identity.Provider, userID)
+	return nil
+}
+
+// the account with this email, or a new account (with a new user ID) if there is none
+func (h *Handler) findOrCreateUser(ctx context.Context, email string) (string, error) {
+	userID, err := h.store.FindUserByEmail(ctx, email)
+	if err == nil {
+		return userID, nil
+	}
+	if !errors.Is(err, userstore.ErrUserNotFound) {
+		return "", fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	// no need to create a default application subcollection since it will be created on first add application request
+	userID = userstore.NewUserID()
+	err = h.store.CreateUser(ctx, userID, email)
+	if errors.Is(err, userstore.ErrEmailTaken) {
+		// another login with the same email signed up first
+		return h.store.FindUserByEmail(ctx, email)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create user: %w", err)
+	}
+
+	log.Printf("Created user %s", userID)
+	return userID, nil
+}
+
+// whether the provider vouches for the email it returned
+func emailVerified(provider string, user goth.User) bool {
+	switch provider {
+	case "google":
+		verified, _ := user.RawData["verified_email"].(bool)
+		return verified
+	case "github":
+		// goth only returns the primary email, and errors out if it isn't verified
+		return true
+	default:
+		// OpenID Connect claim; other providers (e.g. microsoft) don't send it and need linking
+		verified, _ := user.RawData["email_verified"].(bool)
+		return verified
+	}
+}
+
+func providerConfigured(provider string) bool {
+	_, err := goth.GetProvider(provider)
+	return err == nil
+}
+
+// GET /auth/providers; the login providers the frontend can offer
+func (h *Handler) Providers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{
+		"providers": h.AuthHandler.Providers,
+	})
+}
+
+// GET /auth/identities; the providers linked to the account
+func (h *Handler) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] List Identities [*]")
+	log.Println("-----------------")
+
+	userID := UserID(r)
+
+	identities, err := h.identities.ListIdentities(r.Context(), userID)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error listing linked accounts", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]identityResponse, 0, len(identities))
+	for _, identity := range identities {
+		response = append(response, identityResponse{
+			Provider:      identity.Provider,
+			Subject:       identity.Subject,
+			ProviderEmail: identity.ProviderEmail,
+			EmailVerified: identity.EmailVerified,
+			LinkedAt:      identity.LinkedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// POST /auth/link/{provider} {"nonce": "..."} -> {"code": "..."}; the browser then goes to
+// /auth/{provider}?link={code}, and the provider account it signs in with is linked to this one
+// once the same browser confirms it with the nonce (see CompleteLink)
+func (h *Handler) Link(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] Link [*]")
+	log.Println("-----------------")
+
+	userID := UserID(r)
+
+	provider := mux.Vars(r)["provider"]
+	if !providerConfigured(provider) {
+		http.Error(w, "Invalid provider", http.StatusBadRequest)
+		return
+	}
+
+	var request linkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Nonce == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	code, err := h.issueCode(r.Context(), authstore.AuthCode{
+		UserID:  userID,
+		Purpose: authstore.PurposeLink,
+		Binding: hashSecret(request.Nonce),
+	}, LinkCodeTTL)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error linking account", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"code": code})
+}
+
+// POST /auth/link/complete {"code": "...", "nonce": "..."}; code is what the callback redirected
+// to the frontend with, nonce what the browser was given in Link. both the session and the nonce
+// have to belong to whoever asked for the link
+func (h *Handler) CompleteLink(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] Complete Link [*]")
+	log.Println("-----------------")
+
+	userID := UserID(r)
+
+	var request completeLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	code, err := h.codes.Redeem(r.Context(), hashSecret(request.Code))
+	if errors.Is(err, authstore.ErrCodeNotFound) || (err == nil && (code.Purpose != authstore.PurposeLinkConfirm || code.Identity == nil)) {
+		http.Error(w, ErrLinkExpired.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error linking account", http.StatusInternalServerError)
+		return
+	}
+
+	if code.UserID != userID || !sameHash(hashSecret(request.Nonce), code.Binding) {
+		log.Printf("Link for %s confirmed by another browser or account, ignoring it", code.UserID)
+		http.Error(w, "This link was started from another browser or account", http.StatusForbidden)
+		return
+	}
+
+	err = h.link(r.Context(), userID, *code.Identity)
+	if errors.Is(err, authstore.ErrIdentityLinked) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error linking account", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Link complete")
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /auth/unlink {"provider": "...", "subject": "..."}; the last identity can't be unlinked
+func (h *Handler) Unlink(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] Unlink [*]")
+	log.Println("-----------------")
+
+	userID := UserID(r)
+
+	var request unlinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	identities, err := h.identities.ListIdentities(r.Context(), userID)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error unlinking account", http.StatusInternalServerError)
+		return
+	}
+	if len(identities) <= 1 {
+		http.Error(w, "Can't unlink the only way to sign in", http.StatusConflict)
+		return
+	}
+
+	err = h.identities.Unlink(r.Context(), userID, request.Provider, request.Subject)
+	if errors.Is(err, authstore.ErrIdentityNotFound) {
+		http.Error(w, "Linked account not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error unlinking account", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Identity unlinked")
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /auth/email {"email": "..."}; changes the account's email to the verified email of one of
+// its linked providers. nothing else is keyed by the email, so no data moves
+func (h *Handler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] Change Email [*]")
+	log.Println("-----------------")
+
+	userID := UserID(r)
+
+	var request emailRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	identities, err := h.identities.ListIdentities(r.Context(), userID)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error changing email", http.StatusInternalServerError)
+		return
+	}
+
+	allowed := false
+	for _, identity := range identities {
+		if identity.EmailVerified && identity.ProviderEmail == request.Email {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		http.Error(w, "Email must be a verified email of a linked account", http.StatusForbidden)
+		return
+	}
+
+	err = h.store.SetEmail(r.Context(), userID, request.Email)
+	if errors.Is(err, userstore.ErrEmailTaken) {
+		http.Error(w, "Email is already used by another account", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error changing email", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Email changed")
+	w.WriteHeader(http.StatusOK)
+}
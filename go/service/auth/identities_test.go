@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/copium-dev/copium/go/service/auth/authstore"
+
+	"github.com/markbates/goth"
+)
+
+func completeLink(t *testing.T, h *Handler, userID string, request completeLinkRequest) int {
+	t.Helper()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/auth/link/complete", bytes.NewReader(body))
+	r = r.WithContext(WithPrincipal(r.Context(), &Principal{UserID: userID, Scopes: []string{ScopeAccount}}))
+
+	w := httptest.NewRecorder()
+	h.CompleteLink(w, r)
+	return w.Code
+}
+
+// someone who asks for a link code and gets another person's browser through the provider's login
+// mustn't end up with that person's login linked to their account
+func TestCompleteLink(t *testing.T) {
+	const (
+		requester = "user-1"
+		nonce     = "browser-nonce"
+	)
+
+	tests := []struct {
+		name       string
+		userID     string
+		nonce      string
+		wantCode   int
+		wantLinked bool
+	}{
+		{"the browser that asked", requester, nonce, http.StatusOK, true},
+		{"another browser", requester, "", http.StatusForbidden, false},
+		{"another browser with a nonce of its own", requester, "other-nonce", http.StatusForbidden, false},
+		{"another account", "user-2", nonce, http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := &Handler{
+				codes:      authstore.NewMemoryCodeStore(),
+				identities: authstore.NewMemoryIdentityStore(),
+			}
+
+			linkCode, err := h.issueCode(ctx, authstore.AuthCode{
+				UserID:  requester,
+				Purpose: authstore.PurposeLink,
+				Binding: hashSecret(nonce),
+			}, LinkCodeTTL)
+			if err != nil {
+				t.Fatalf("issueCode: %v", err)
+			}
+			confirmCode, err := h.startLink(ctx, "github", goth.User{UserID: "gh-1", Email: "someone@example.com"}, linkCode)
+			if err != nil {
+				t.Fatalf("startLink: %v", err)
+			}
+
+			if code := completeLink(t, h, tt.userID, completeLinkRequest{Code: confirmCode, Nonce: tt.nonce}); code != tt.wantCode {
+				t.Fatalf("status code = %d, want %d", code, tt.wantCode)
+			}
+
+			identity, err := h.identities.GetIdentity(ctx, "github", "gh-1")
+			if !tt.wantLinked {
+				if !errors.Is(err, authstore.ErrIdentityNotFound) {
+					t.Errorf("GetIdentity = %+v, %v; want ErrIdentityNotFound", identity, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetIdentity: %v", err)
+			}
+			if identity.UserID != requester || identity.ProviderEmail != "someone@example.com" {
+				t.Errorf("linked %+v, want it on %s", identity, requester)
+			}
+
+			// the confirmation works once
+			if code := completeLink(t, h, tt.userID, completeLinkRequest{Code: confirmCode, Nonce: tt.nonce}); code != http.StatusForbidden {
+				t.Errorf("second confirmation status code = %d, want %d", code, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestStartLinkExpired(t *testing.T) {
+	h := &Handler{
+		codes:      authstore.NewMemoryCodeStore(),
+		identities: authstore.NewMemoryIdentityStore(),
+	}
+	ctx := context.Background()
+
+	// a login code isn't a link code
+	code, err := h.issueCode(ctx, authstore.AuthCode{UserID: "user-1", Purpose: authstore.PurposeLogin}, AuthCodeTTL)
+	if err != nil {
+		t.Fatalf("issueCode: %v", err)
+	}
+
+	for _, linkCode := range []string{code, "unknown"} {
+		if _, err := h.startLink(ctx, "github", goth.User{UserID: "gh-1"}, linkCode); !errors.Is(err, ErrLinkExpired) {
+			t.Errorf("startLink(%q) = %v, want ErrLinkExpired", linkCode, err)
+		}
+	}
+}
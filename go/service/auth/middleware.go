@@ -0,0 +1,196 @@
+package auth
+
+// authentication happens once per request, in Authenticator.Middleware (installed on the whole
+// router in cmd/api). it checks the route's requirement, then puts the Principal in the request
+// context so handlers just call UserID(r) or PrincipalFrom(r)
+//
+// requirements are set next to the route when it's registered:
+//
+//	auth.Public(router.HandleFunc("/auth/providers", h.Providers))
+//	auth.Require(router.HandleFunc("/user/addApplication", h.AddApplication), auth.ScopeWrite)
+//	auth.RequireAdmin(router.HandleFunc("/admin/...", ...))
+//
+// a route without a requirement needs ScopeAccount, which personal access tokens never have, so
+// forgetting one fails closed: only a browser session can call it
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/copium-dev/copium/go/service/auth/authkeys"
+	"github.com/copium-dev/copium/go/service/auth/authstore"
+
+	"github.com/gorilla/mux"
+	cookiestore "github.com/gorilla/sessions"
+)
+
+type Level int
+
+const (
+	// no credentials needed; valid ones still put a Principal in the context
+	LevelPublic Level = iota
+	// a signed in user (session or personal access token) with the route's scope
+	LevelUser
+	// a signed in user listed in ADMIN_USER_IDS, on a browser session
+	LevelAdmin
+)
+
+type Requirement struct {
+	Level Level
+	// LevelAdmin routes always need ScopeAccount
+	Scope string
+}
+
+type principalKey struct{}
+
+var (
+	requirementsMu sync.RWMutex
+	requirements   = map[*mux.Route]Requirement{}
+)
+
+// checks the credentials on a request (Authenticate) and manages them: sessions (sessions.go),
+// personal access tokens (tokens.go) and, with AUTH_MODE=cookie, the session cookie (cookies.go)
+// cmd/api makes one and shares it between Middleware, the auth Handler and the user Handler
+type Authenticator struct {
+	sessions    authstore.SessionStore
+	tokens      authstore.TokenStore
+	signingKeys *authkeys.KeySet
+	// user IDs allowed on LevelAdmin routes, from ADMIN_USER_IDS
+	admins map[string]bool
+
+	// set when AUTH_MODE=cookie; nil in bearer mode
+	cookieSessions *cookiestore.CookieStore
+	// only over HTTPS when deployed
+	cookiesSecure bool
+	// from COOKIE_DOMAIN; only needed when the API is on a subdomain of the frontend
+	// (e.g. api.copium.dev for copium.dev)
+	cookieDomain string
+}
+
+// sessions and personal access tokens come from stores and access tokens are signed with keys;
+// mode is AUTH_MODE, and the cookie settings only matter when it's cookie (see shared/config)
+func NewAuthenticator(
+	stores authstore.Stores,
+	keys *authkeys.KeySet,
+	mode string,
+	cookies *cookiestore.CookieStore,
+	secureCookies bool,
+	cookieDomain string,
+	adminUserIDs []string,
+) (*Authenticator, error) {
+	a := &Authenticator{
+		sessions:    stores.Sessions,
+		tokens:      stores.Tokens,
+		signingKeys: keys,
+		admins:      adminSet(adminUserIDs),
+	}
+
+	switch mode {
+	case AuthModeBearer:
+	case AuthModeCookie:
+		a.cookieSessions = cookies
+		a.cookiesSecure = secureCookies
+		a.cookieDomain = cookieDomain
+	default:
+		return nil, fmt.Errorf("unknown AUTH_MODE %q (expected %q or %q)", mode, AuthModeBearer, AuthModeCookie)
+	}
+	return a, nil
+}
+
+// anyone can call the route
+func Public(route *mux.Route) *mux.Route {
+	return setRequirement(route, Requirement{Level: LevelPublic})
+}
+
+// the route needs a signed in user whose credentials have the scope
+func Require(route *mux.Route, scope string) *mux.Route {
+	return setRequirement(route, Requirement{Level: LevelUser, Scope: scope})
+}
+
+// the route needs an admin signed in with a browser session
+func RequireAdmin(route *mux.Route) *mux.Route {
+	return setRequirement(route, Requirement{Level: LevelAdmin, Scope: ScopeAccount})
+}
+
+func setRequirement(route *mux.Route, requirement Requirement) *mux.Route {
+	requirementsMu.Lock()
+	defer requirementsMu.Unlock()
+
+	requirements[route] = requirement
+	return route
+}
+
+func requirementFor(route *mux.Route) Requirement {
+	requirementsMu.RLock()
+	defer requirementsMu.RUnlock()
+
+	if requirement, ok := requirements[route]; ok {
+		return requirement
+	}
+	return Requirement{Level: LevelUser, Scope: ScopeAccount}
+}
+
+func adminSet(userIDs []string) map[string]bool {
+	ids := map[string]bool{}
+	for _, id := range userIDs {
+		ids[id] = true
+	}
+	return ids
+}
+
+// router middleware; mux only runs it for matched routes, so mux.CurrentRoute is always set
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requirement := requirementFor(mux.CurrentRoute(r))
+
+		if requirement.Level == LevelPublic {
+			// an expired token shouldn't stop someone from logging in again, so errors are ignored
+			if bearerToken(r) != "" || a.hasCookieSession(r) {
+				if principal, err := a.Authenticate(r); err == nil {
+					r = r.WithContext(WithPrincipal(r.Context(), principal))
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := a.Authenticate(r)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !principal.HasScope(requirement.Scope) || (requirement.Level == LevelAdmin && !principal.Admin) {
+			fmt.Printf("Error: %v\n", ErrInsufficientScope)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		log.Println("User authenticated")
+
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// what Middleware attaches for the handlers behind it; also lets tests call handlers directly
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// the request's Principal; nil on a public route called without (valid) credentials
+func PrincipalFrom(r *http.Request) *Principal {
+	principal, _ := r.Context().Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// the signed in user's ID; for handlers behind a LevelUser or LevelAdmin route, where it's never empty
+func UserID(r *http.Request) string {
+	if principal := PrincipalFrom(r); principal != nil {
+		return principal.UserID
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// a router behind Middleware with one route per kind of requirement; each handler reports the
+// Principal it was given
+func newTestRouter(authenticator *Authenticator) (*mux.Router, map[string]*Principal) {
+	seen := map[string]*Principal{}
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			seen[name] = PrincipalFrom(r)
+		}
+	}
+
+	router := mux.NewRouter()
+	router.Use(authenticator.Middleware)
+	Public(router.HandleFunc("/public", handler("public")))
+	Require(router.HandleFunc("/read", handler("read")), ScopeRead)
+	Require(router.HandleFunc("/write", handler("write")), ScopeWrite)
+	RequireAdmin(router.HandleFunc("/admin", handler("admin")))
+	router.HandleFunc("/undeclared", handler("undeclared"))
+	return router, seen
+}
+
+func serve(router *mux.Router, path, token string) int {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w.Code
+}
+
+// a browser session's access token and a read only personal access token, both for userID
+func testCredentials(t *testing.T, userID string, adminUserIDs ...string) (*mux.Router, map[string]*Principal, string, string) {
+	t.Helper()
+
+	h, authenticator := newTestAuth(t, adminUserIDs...)
+	session, err := authenticator.startSession(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("startSession: %v", err)
+	}
+	code, token := createToken(t, h, userID, createTokenRequest{Name: "script", Access: ScopeRead})
+	if code != http.StatusCreated {
+		t.Fatalf("CreateToken = %d, want 201", code)
+	}
+
+	router, seen := newTestRouter(authenticator)
+	return router, seen, session.AccessToken, token.Token
+}
+
+func TestMiddlewarePublic(t *testing.T) {
+	router, seen, session, _ := testCredentials(t, "user-1")
+
+	if code := serve(router, "/public", ""); code != http.StatusOK {
+		t.Fatalf("without credentials = %d, want 200", code)
+	}
+	if seen["public"] != nil {
+		t.Errorf("Principal = %+v without credentials, want nil", *seen["public"])
+	}
+
+	if code := serve(router, "/public", session); code != http.StatusOK {
+		t.Fatalf("with a session = %d, want 200", code)
+	}
+	if principal := seen["public"]; principal == nil || principal.UserID != "user-1" {
+		t.Errorf("Principal = %v with a session, want user-1", principal)
+	}
+
+	// someone whose session expired still gets to log in again
+	delete(seen, "public")
+	if code := serve(router, "/public", "not-a-token"); code != http.StatusOK {
+		t.Fatalf("with a bad token = %d, want 200", code)
+	}
+	if seen["public"] != nil {
+		t.Error("bad token put a Principal in the context")
+	}
+}
+
+func TestMiddlewareScope(t *testing.T) {
+	router, seen, session, readToken := testCredentials(t, "user-1")
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{"no credentials", "/read", "", http.StatusUnauthorized},
+		{"bad token", "/read", "not-a-token", http.StatusUnauthorized},
+		{"read token on a read route", "/read", readToken, http.StatusOK},
+		{"read token on a write route", "/write", readToken, http.StatusForbidden},
+		{"session on a write route", "/write", session, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := serve(router, tt.path, tt.token); code != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.path, code, tt.want)
+			}
+		})
+	}
+	if _, ok := seen["write"]; !ok {
+		t.Error("write handler never ran")
+	}
+}
+
+func TestMiddlewareAdmin(t *testing.T) {
+	router, _, session, token := testCredentials(t, "admin-1", "admin-1")
+	if code := serve(router, "/admin", session); code != http.StatusOK {
+		t.Errorf("admin session = %d, want 200", code)
+	}
+	// an admin's personal access token is never admin
+	if code := serve(router, "/admin", token); code != http.StatusForbidden {
+		t.Errorf("admin's token = %d, want 403", code)
+	}
+
+	router, _, session, _ = testCredentials(t, "user-1", "admin-1")
+	if code := serve(router, "/admin", session); code != http.StatusForbidden {
+		t.Errorf("non-admin session = %d, want 403", code)
+	}
+	if code := serve(router, "/admin", ""); code != http.StatusUnauthorized {
+		t.Errorf("no credentials = %d, want 401", code)
+	}
+}
+
+// a route registered without Public or Require only takes a browser session
+func TestMiddlewareUndeclared(t *testing.T) {
+	h, authenticator := newTestAuth(t)
+	session, err := authenticator.startSession(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("startSession: %v", err)
+	}
+	code, token := createToken(t, h, "user-1", createTokenRequest{Name: "script", Access: ScopeWrite})
+	if code != http.StatusCreated {
+		t.Fatalf("CreateToken = %d, want 201", code)
+	}
+	router, seen := newTestRouter(authenticator)
+
+	if code := serve(router, "/undeclared", ""); code != http.StatusUnauthorized {
+		t.Errorf("no credentials = %d, want 401", code)
+	}
+	if code := serve(router, "/undeclared", token.Token); code != http.StatusForbidden {
+		t.Errorf("write token = %d, want 403", code)
+	}
+	if code := serve(router, "/undeclared", session.AccessToken); code != http.StatusOK {
+		t.Errorf("session = %d, want 200", code)
+	}
+	if principal := seen["undeclared"]; principal == nil || principal.UserID != "user-1" {
+		t.Errorf("Principal = %v, want user-1", principal)
+	}
+}
@@ -2,50 +2,80 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
 	"strings"
-	"time"
 
+	"github.com/copium-dev/copium/go/service/auth/authstore"
+	"github.com/copium-dev/copium/go/service/user/userstore"
 	"github.com/copium-dev/copium/go/utils"
 
-	"cloud.google.com/go/firestore"
 	"github.com/gorilla/mux"
 	"github.com/markbates/goth/gothic"
-	"github.com/golang-jwt/jwt/v5"
-	
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type Handler struct {
-	AuthHandler     *utils.AuthHandler
-	firestoreClient *firestore.Client
+	AuthHandler *utils.AuthHandler
+	store       userstore.ApplicationStore
+	codes       authstore.CodeStore
+	identities  authstore.IdentityStore
+	// sessions, personal access tokens and the session cookie (see NewAuthenticator)
+	authenticator *Authenticator
+	// where logins and logouts redirect back to
+	frontendURL string
 }
 
-// initialize a new handler with an AuthHandler (implementation in utils/main.go) and Firestore client
+// initialize a new handler with an AuthHandler (implementation in utils/main.go), the user store,
+// the auth stores, the Authenticator Middleware checks requests with, and where to redirect after logins
 // authHandler parameter passed in from cmd/main.go
 //
 //	reason: gorilla/mux spins up a new goroutine for each request
 //	        so, we pass in the same AuthHandler to each handler to ensure global state is maintained
 func NewHandler(
-	firestoreClient *firestore.Client,
+	store userstore.ApplicationStore,
 	authHandler *utils.AuthHandler,
+	stores authstore.Stores,
+	authenticator *Authenticator,
+	frontendURL string,
 ) *Handler {
 	return &Handler{
-		AuthHandler:     authHandler,
-		firestoreClient: firestoreClient,
+		AuthHandler:   authHandler,
+		store:         store,
+		codes:         stores.Codes,
+		identities:    stores.Identities,
+		authenticator: authenticator,
+		frontendURL:   frontendURL,
 	}
 }
 
-// {provider} is a variable that can be anything (if we want more providers in the future)
-// in this case, we only support google
+// {provider} is any provider registered in utils.NewAuthHandler (google, plus github, microsoft
+// and an OIDC provider when configured)
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/auth/{provider}", h.Auth).Methods("GET").Name("auth")
-	router.HandleFunc("/auth/{provider}/callback", h.AuthProviderCallback).Methods("GET").Name("authProviderCallback")
-	router.HandleFunc("/auth/{provider}/logout", h.Logout).Methods("GET").Name("logout")
+	// public keys for other services verifying our tokens
+	Public(router.HandleFunc("/.well-known/jwks.json", h.JWKS).Methods("GET").Name("jwks"))
+	// registered first so they aren't taken for a provider
+	Public(router.HandleFunc("/auth/providers", h.Providers).Methods("GET").Name("providers"))
+	// managing the account needs a browser session, never a personal access token
+	Require(router.HandleFunc("/auth/identities", h.ListIdentities).Methods("GET").Name("listIdentities"), ScopeAccount)
+	// registered before /auth/link/{provider} so "complete" isn't taken for a provider
+	Require(router.HandleFunc("/auth/link/complete", h.CompleteLink).Methods("POST").Name("completeLink"), ScopeAccount)
+	Require(router.HandleFunc("/auth/link/{provider}", h.Link).Methods("POST").Name("link"), ScopeAccount)
+	Require(router.HandleFunc("/auth/unlink", h.Unlink).Methods("POST").Name("unlink"), ScopeAccount)
+	Require(router.HandleFunc("/auth/email", h.ChangeEmail).Methods("POST").Name("changeEmail"), ScopeAccount)
+	Require(router.HandleFunc("/auth/tokens", h.ListTokens).Methods("GET").Name("listTokens"), ScopeAccount)
+	Require(router.HandleFunc("/auth/tokens", h.CreateToken).Methods("POST").Name("createToken"), ScopeAccount)
+	Require(router.HandleFunc("/auth/tokens/revoke", h.RevokeToken).Methods("POST").Name("revokeToken"), ScopeAccount)
+	Public(router.HandleFunc("/auth/token", h.Token).Methods("POST").Name("token"))
+	Public(router.HandleFunc("/auth/refresh", h.Refresh).Methods("POST").Name("refresh"))
+	Require(router.HandleFunc("/auth/logout", h.RevokeSession).Methods("POST").Name("revokeSession"), ScopeAccount)
+	Require(router.HandleFunc("/auth/logoutAll", h.RevokeAllSessions).Methods("POST").Name("revokeAllSessions"), ScopeAccount)
+	Public(router.HandleFunc("/auth/{provider}", h.Auth).Methods("GET").Name("auth"))
+	Public(router.HandleFunc("/auth/{provider}/callback", h.AuthProviderCallback).Methods("GET").Name("authProviderCallback"))
+	Public(router.HandleFunc("/auth/{provider}/logout", h.Logout).Methods("GET").Name("logout"))
 }
 
 // gothic is JUST to handle oauth flow, since cross-domain cookies are a pain to deal with
@@ -54,18 +84,24 @@ func (h *Handler) Auth(w http.ResponseWriter, r *http.Request) {
 	log.Println("[*] Auth [*]")
 	log.Println("-----------------")
 	provider := mux.Vars(r)["provider"]
+	if !providerConfigured(provider) {
+		http.Error(w, "Invalid provider", http.StatusBadRequest)
+		return
+	}
 	r = r.WithContext(context.WithValue(r.Context(), "provider", provider))
 
 	// if the user is already authenticated, redirect them to their dashboard
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "http://localhost:5173"
+	if principal := PrincipalFrom(r); principal != nil {
+		fmt.Println("user already authenticated", principal.UserID)
+		http.Redirect(w, r, h.frontendURL + "/dashboard", http.StatusFound)
+		return
 	}
 
-	user, err := IsAuthenticated(r)
-	if err == nil {
-		fmt.Println("user already authenticated", user)
-		http.Redirect(w, r, frontendURL + "/dashboard", http.StatusFound)
+	// ?link={code} (from POST /auth/link/{provider}) links this login to an existing account;
+	// kept in the gothic session until the callback. always written so a stale one is cleared
+	if err := gothic.StoreInSession(linkSessionKey, r.URL.Query().Get("link"), r, w); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -77,13 +113,16 @@ func (h *Handler) Auth(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) AuthProviderCallback(w http.ResponseWriter, r *http.Request) {
 	provider := mux.Vars(r)["provider"]
-	if provider != "google" {
+	if !providerConfigured(provider) {
 		http.Error(w, "Invalid provider", http.StatusBadRequest)
 		return
 	}
 
 	r = r.WithContext(context.WithValue(r.Context(), "provider", provider))
 
+	// read before CompleteUserAuth, which clears the gothic session
+	linkCode, _ := gothic.GetFromSession(linkSessionKey, r)
+
 	user, err := gothic.CompleteUserAuth(w, r)
 	if err != nil {
 		fmt.Printf("Auth error: %v\n", err)
@@ -91,51 +130,71 @@ func (h *Handler) AuthProviderCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// this sucks but in prod we can't send cookies across domains, and Cloud Run custom domains
-	// are only in preview mode, so we have to make and sign a JWT and send to frontend
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"email": user.Email,
-		"exp":   time.Now().Add(time.Hour * 24 * 30).Unix(),
-	})
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// at this point, user is verified to be authed and we have made a session (locally with gothic)
+
+	// a login to link: the browser that asked for the link has to confirm it first (see identities.go)
+	if linkCode != "" {
+		confirmCode, err := h.startLink(r.Context(), provider, user, linkCode)
+		if errors.Is(err, ErrLinkExpired) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error starting link: %v\n", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		query := url.Values{}
+		query.Set("link", confirmCode)
+		http.Redirect(w, r, h.frontendURL + "/auth-complete?" + query.Encode(), http.StatusFound)
 		return
 	}
 
-	// at this point, user is verified to be authed and we have
-	// 1. made a session (locally with gothic)
-	// 2. made a JWT (to send to frontend)
-
-	// check if user exists in Firestore
-	userExists, err := checkUserExists(user.Email, h.firestoreClient, r.Context())
+	// which account this login belongs to, creating it on first login (see identities.go)
+	userID, err := h.resolveAccount(r.Context(), provider, user)
+	if errors.Is(err, ErrUnverifiedEmail) || errors.Is(err, authstore.ErrIdentityLinked) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		fmt.Println("Error checking if user exists:", err)
+		fmt.Printf("Error resolving account: %v\n", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if !userExists {
-		// add user to firestore (gmail document id)
-		// by default, firestore will create a new document if it doesnt exist
-		// no need to create a default application subcollection since it will be created on first add application request
-		_, err = h.firestoreClient.Collection("users").Doc(user.Email).Set(r.Context(), map[string]interface{}{
-			"email":             user.Email,
-			"applicationsCount": 0,
-		})
+	// same-domain deployments can skip all of the below and use a cookie session (see cookies.go)
+	if h.authenticator.cookieMode() {
+		sessionID, _, err := h.authenticator.newSession(r.Context(), userID)
 		if err != nil {
-			fmt.Printf("Error adding user to Firestore: %v\n", err)
+			fmt.Printf("Error starting session: %v\n", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := h.authenticator.setCookieSession(w, r, userID, sessionID); err != nil {
+			fmt.Printf("Error setting session cookie: %v\n", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+
+		http.Redirect(w, r, h.frontendURL + "/dashboard", http.StatusFound)
+		return
 	}
 
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "http://localhost:5173"
+	// this sucks but in prod we can't send cookies across domains, and Cloud Run custom domains
+	// are only in preview mode, so we have to make and sign a JWT and send to frontend
+	// (along with a refresh token to get a new one once it expires; see sessions.go)
+	// the tokens don't go in the redirect URL though, the frontend trades a one-time code for them (see codes.go)
+	code, err := h.issueCode(r.Context(), authstore.AuthCode{UserID: userID, Purpose: authstore.PurposeLogin}, AuthCodeTTL)
+	if err != nil {
+		fmt.Printf("Error issuing code: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	http.Redirect(w, r, frontendURL + "/auth-complete?token=" + tokenString, http.StatusFound)
+	query := url.Values{}
+	query.Set("code", code)
+	http.Redirect(w, r, h.frontendURL + "/auth-complete?" + query.Encode(), http.StatusFound)
 }
 
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
@@ -143,82 +202,74 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 	log.Println("-----------------")
 
 	provider := mux.Vars(r)["provider"]
-	if provider != "google" {
+	if !providerConfigured(provider) {
 		http.Error(w, "Invalid provider", http.StatusBadRequest)
 		return
 	}
 
 	r = r.WithContext(context.WithValue(r.Context(), "provider", provider))
 
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "http://localhost:5173"
+	// the browser comes through here on its way out, so this is where a cookie session's cookies
+	// get cleared; the session itself is revoked by POST /auth/logout
+	if h.authenticator.cookieMode() {
+		h.authenticator.clearCookieSession(w)
 	}
+
 	
-	http.Redirect(w, r, frontendURL + "/logout-complete", http.StatusTemporaryRedirect)
+	http.Redirect(w, r, h.frontendURL + "/logout-complete", http.StatusTemporaryRedirect)
 }
 
-// check for authentication using JWT
+// authentication uses our own JWTs (checked here, then against the session in authenticate)
 // the key change here vs. the original is that we don't use gothic for auth verification or session management
 // since we create our own JWTs. so, gothic is JUST to handle the oauth flow
-func IsAuthenticated(r *http.Request) (string, error) {
-	log.Println("[*] IsAuthenticated [*]")
-	log.Println("-----------------")
-
+// checks the JWT's signature and expiry and returns its user ID and session ID
+func (a *Authenticator) parseAccessToken(r *http.Request) (string, string, error) {
     // get token from Authorization header
-    authHeader := r.Header.Get("Authorization")
-    if !strings.HasPrefix(authHeader, "Bearer ") {
-        return "", fmt.Errorf("no token provided")
+    tokenString := bearerToken(r)
+    if tokenString == "" {
+        return "", "", fmt.Errorf("no token provided")
     }
     
-    // extract token value
-    tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-    
-    // parse and validate token
-    token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-        if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-            return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-        }
-        return []byte(os.Getenv("JWT_SECRET")), nil
-    })
-    
-    if err != nil {
-        return "", fmt.Errorf("invalid token: %v", err)
+    if a.signingKeys == nil {
+        return "", "", fmt.Errorf("signing keys not configured")
     }
-    
-	// checks if token was signed w/ secret key and not tampered
+
+    // parse and validate token
+	// checks if token was signed by one of our keys (picked by its kid) and not tampered
 	// also checks if not expired
-    if !token.Valid {
-        return "", fmt.Errorf("token is not valid")
-    }
-    
-	// get claims so we can extract email
-    claims, ok := token.Claims.(jwt.MapClaims)
-    if !ok {
-        return "", fmt.Errorf("invalid token claims")
+    claims, err := a.signingKeys.Parse(tokenString)
+    if err != nil {
+        return "", "", fmt.Errorf("invalid token: %v", err)
     }
     
-    email, ok := claims["email"].(string)
-    if !ok || email == "" {
-        return "", fmt.Errorf("email not found in token")
+    // tokens from before user IDs have the email but no sub; they are rejected too
+    userID, ok := claims["sub"].(string)
+    if !ok || userID == "" {
+        return "", "", fmt.Errorf("user ID not found in token")
     }
-    
-    log.Println("Authenticated via JWT")
-    log.Println("-----------------")
-    
-    return email, nil
-}
 
-func checkUserExists(userEmail string, firestoreClient *firestore.Client, ctx context.Context) (bool, error) {
-	_, err := firestoreClient.Collection("users").Doc(userEmail).Get(ctx) // queries users collection for document username
+	// tokens from before sessions existed have no sid; they are rejected so users log in again
+	sessionID, ok := claims["sid"].(string)
+	if !ok || sessionID == "" {
+		return "", "", fmt.Errorf("session not found in token")
+	}
+
+    return userID, sessionID, nil
+}
 
-	if err != nil {
-		if status.Code(err) == codes.NotFound { // not a real error
-			return false, nil
-		} else { // any other error
-			return false, err
-		}
-	} else { // no error == user found
-		return true, nil
+// the token in "Authorization: Bearer {token}", or "" if there isn't one
+func bearerToken(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return ""
 	}
-}
\ No newline at end of file
+	return strings.TrimPrefix(authHeader, "Bearer ")
+}
+
+// GET /.well-known/jwks.json; the public keys access tokens can be verified with
+// retired keys stay listed until they're removed from the key set, so caching for a few minutes is fine
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(h.authenticator.signingKeys.JWKS())
+}
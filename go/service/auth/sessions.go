@@ -0,0 +1,293 @@
+package auth
+
+// short-lived access tokens plus rotating refresh tokens
+// - access token: JWT (signed with the key set, see authkeys) with the user's ID ("sub") and session ID ("sid"), valid for AccessTokenTTL.
+//   Authenticate checks its signature and expiry AND that the session is still active,
+//   so logging out revokes it immediately
+// - refresh token: opaque "{sessionID}.{secret}", only its hash is stored. POST /auth/refresh
+//   trades it for a new access token and a new refresh token; the old one stops working.
+//   presenting an old refresh token again means it was copied, so the whole session is revoked.
+//   two refreshes racing with the same token get 409 instead of 401 for the one that lost, so
+//   the frontend knows it isn't logged out and keeps the token the winner sets
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/copium-dev/copium/go/service/auth/authstore"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	// an old refresh token seen again this soon after it was rotated is most likely two requests
+	// refreshing at once rather than a stolen token, so it's rejected without revoking the session
+	reuseGracePeriod = 30 * time.Second
+)
+
+var ErrSessionRevoked = errors.New("session is no longer active")
+
+type tokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	// seconds until the access token expires
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// creates a session for a user who just logged in and returns its first token pair
+func (a *Authenticator) startSession(ctx context.Context, userID string) (*tokenResponse, error) {
+	sessionID, refreshToken, err := a.newSession(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := a.newAccessToken(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// stores a new session for the user; returns its ID and first refresh token
+func (a *Authenticator) newSession(ctx context.Context, userID string) (string, string, error) {
+	sessionID := randomString(16)
+	refreshToken, refreshHash := newRefreshToken(sessionID)
+
+	now := time.Now()
+	err := a.sessions.Create(ctx, authstore.Session{
+		ID:          sessionID,
+		UserID:      userID,
+		RefreshHash: refreshHash,
+		CreatedAt:   now,
+		RotatedAt:   now,
+		ExpiresAt:   now.Add(RefreshTokenTTL),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create session: %w", err)
+	}
+	return sessionID, refreshToken, nil
+}
+
+// POST /auth/refresh {"refreshToken": "..."} -> new token pair
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] Refresh [*]")
+	log.Println("-----------------")
+
+	var request refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sessionID, presentedHash, err := parseRefreshToken(request.RefreshToken)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := h.authenticator.sessions.GetSession(r.Context(), sessionID)
+	if errors.Is(err, authstore.ErrSessionNotFound) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error refreshing session", http.StatusInternalServerError)
+		return
+	}
+
+	if !session.Active(time.Now()) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !sameHash(presentedHash, session.RefreshHash) {
+		if sameHash(presentedHash, session.PreviousHash) {
+			if time.Since(session.RotatedAt) <= reuseGracePeriod {
+				http.Error(w, "Refresh token already rotated", http.StatusConflict)
+				return
+			}
+			log.Printf("Refresh token reused for session %s, revoking it", sessionID)
+			if err := h.authenticator.sessions.Revoke(r.Context(), sessionID); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	refreshToken, refreshHash := newRefreshToken(sessionID)
+	err = h.authenticator.sessions.Rotate(r.Context(), sessionID, session.RefreshHash, refreshHash, time.Now().Add(RefreshTokenTTL))
+	if errors.Is(err, authstore.ErrSessionConflict) {
+		// another request refreshed with the same token first
+		http.Error(w, "Refresh token already rotated", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error refreshing session", http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := h.authenticator.newAccessToken(session.UserID, sessionID)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error refreshing session", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Session refreshed")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(AccessTokenTTL.Seconds()),
+	})
+}
+
+// POST /auth/logout with the access token; revokes this session only
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] Revoke Session [*]")
+	log.Println("-----------------")
+
+	sessionID := PrincipalFrom(r).SessionID
+
+	if err := h.authenticator.sessions.Revoke(r.Context(), sessionID); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error logging out", http.StatusInternalServerError)
+		return
+	}
+
+	if h.authenticator.cookieMode() {
+		h.authenticator.clearCookieSession(w)
+	}
+
+	log.Println("Session revoked")
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /auth/logoutAll with the access token; revokes every session of the user, on every device
+func (h *Handler) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] Revoke All Sessions [*]")
+	log.Println("-----------------")
+
+	userID := UserID(r)
+
+	if err := h.authenticator.sessions.RevokeAll(r.Context(), userID); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error logging out", http.StatusInternalServerError)
+		return
+	}
+
+	if h.authenticator.cookieMode() {
+		h.authenticator.clearCookieSession(w)
+	}
+
+	log.Println("All sessions revoked")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *Authenticator) newAccessToken(userID string, sessionID string) (string, error) {
+	now := time.Now()
+	tokenString, err := a.signingKeys.Sign(jwt.MapClaims{
+		"sub": userID,
+		"sid": sessionID,
+		"iat": now.Unix(),
+		"exp": now.Add(AccessTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign access token: %w", err)
+	}
+	return tokenString, nil
+}
+
+// checks the access token and that its session is still active; returns the user ID and session ID
+func (a *Authenticator) authenticate(r *http.Request) (string, string, error) {
+	userID, sessionID, err := a.parseAccessToken(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := a.checkSession(r.Context(), userID, sessionID); err != nil {
+		return "", "", err
+	}
+
+	return userID, sessionID, nil
+}
+
+// returns ErrSessionRevoked unless the session exists, belongs to the user and hasn't been revoked
+func (a *Authenticator) checkSession(ctx context.Context, userID string, sessionID string) (*authstore.Session, error) {
+	if a.sessions == nil {
+		return nil, fmt.Errorf("session store not configured")
+	}
+
+	session, err := a.sessions.GetSession(ctx, sessionID)
+	if errors.Is(err, authstore.ErrSessionNotFound) {
+		return nil, ErrSessionRevoked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check session: %w", err)
+	}
+	if !session.RevokedAt.IsZero() || session.UserID != userID {
+		return nil, ErrSessionRevoked
+	}
+
+	return session, nil
+}
+
+// returns "{sessionID}.{secret}" and the hash to store
+func newRefreshToken(sessionID string) (string, string) {
+	secret := randomString(32)
+	return sessionID + "." + secret, hashSecret(secret)
+}
+
+// returns the session ID and the hash of the secret
+func parseRefreshToken(token string) (string, string, error) {
+	sessionID, secret, ok := strings.Cut(token, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", "", fmt.Errorf("malformed refresh token")
+	}
+	return sessionID, hashSecret(secret), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func sameHash(a string, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// n random bytes, base64url encoded
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/copium-dev/copium/go/service/auth/authkeys"
+	"github.com/copium-dev/copium/go/service/auth/authstore"
+)
+
+// a bearer mode Authenticator over memory stores, and a Handler using it
+func newTestAuth(t *testing.T, adminUserIDs ...string) (*Handler, *Authenticator) {
+	t.Helper()
+
+	keys, err := authkeys.Ephemeral()
+	if err != nil {
+		t.Fatalf("Ephemeral: %v", err)
+	}
+	stores := authstore.Stores{
+		Sessions:   authstore.NewMemorySessionStore(),
+		Codes:      authstore.NewMemoryCodeStore(),
+		Identities: authstore.NewMemoryIdentityStore(),
+		Tokens:     authstore.NewMemoryTokenStore(),
+	}
+	authenticator, err := NewAuthenticator(stores, keys, AuthModeBearer, nil, false, "", adminUserIDs)
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+	return NewHandler(nil, nil, stores, authenticator, "http://localhost:3000"), authenticator
+}
+
+func refresh(t *testing.T, h *Handler, refreshToken string) (int, tokenResponse) {
+	t.Helper()
+
+	body, err := json.Marshal(refreshRequest{RefreshToken: refreshToken})
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	w := httptest.NewRecorder()
+	h.Refresh(w, httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewReader(body)))
+
+	var response tokenResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+	return w.Code, response
+}
+
+func bearerRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/user/dashboard", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestRefreshRotation(t *testing.T) {
+	h, authenticator := newTestAuth(t)
+	first, err := authenticator.startSession(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("startSession: %v", err)
+	}
+
+	code, second := refresh(t, h, first.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("Refresh = %d, want 200", code)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("Refresh returned the same refresh token")
+	}
+	principal, err := authenticator.Authenticate(bearerRequest(second.AccessToken))
+	if err != nil {
+		t.Fatalf("Authenticate with the new access token: %v", err)
+	}
+	if principal.UserID != "user-1" {
+		t.Errorf("UserID = %q, want user-1", principal.UserID)
+	}
+
+	// the old token again right away is another tab refreshing at the same time: rejected, but
+	// the session stays
+	if code, _ := refresh(t, h, first.RefreshToken); code != http.StatusConflict {
+		t.Errorf("Refresh with the rotated token = %d, want 409", code)
+	}
+	if code, _ := refresh(t, h, second.RefreshToken); code != http.StatusOK {
+		t.Errorf("Refresh with the new token = %d, want 200", code)
+	}
+}
+
+// a refresh token that was rotated a while ago showing up again was copied; the whole session goes
+func TestRefreshReuseRevokesSession(t *testing.T) {
+	ctx := context.Background()
+	h, authenticator := newTestAuth(t)
+	first, err := authenticator.startSession(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("startSession: %v", err)
+	}
+	code, second := refresh(t, h, first.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("Refresh = %d, want 200", code)
+	}
+
+	sessionID, _, _ := parseRefreshToken(first.RefreshToken)
+	session, err := authenticator.sessions.GetSession(ctx, sessionID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	session.RotatedAt = time.Now().Add(-2 * reuseGracePeriod)
+	authenticator.sessions.Create(ctx, *session)
+
+	if code, _ := refresh(t, h, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("Refresh with the reused token = %d, want 401", code)
+	}
+
+	session, err = authenticator.sessions.GetSession(ctx, sessionID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if session.RevokedAt.IsZero() {
+		t.Fatal("session not revoked")
+	}
+	// everything issued for the session stops working, not just the reused token
+	if code, _ := refresh(t, h, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("Refresh with the latest token = %d, want 401", code)
+	}
+	if _, err := authenticator.Authenticate(bearerRequest(second.AccessToken)); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Authenticate = %v, want ErrSessionRevoked", err)
+	}
+}
+
+func TestRefreshInvalidToken(t *testing.T) {
+	h, _ := newTestAuth(t)
+
+	for _, token := range []string{"", "no-secret", "unknown-session.secret"} {
+		if code, _ := refresh(t, h, token); code != http.StatusUnauthorized {
+			t.Errorf("Refresh(%q) = %d, want 401", token, code)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package auth
+
+// personal access tokens for scripts and browser extensions: "cpm_{id}.{secret}", sent in the
+// Authorization header just like an access token. Authenticate accepts both, so every route behind
+// Middleware takes either one
+// a token only has the scopes it was created with (read, or read and write); managing the account
+// itself (tokens, linked logins, email, deleting it) needs a browser session (ScopeAccount)
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/copium-dev/copium/go/service/auth/authstore"
+)
+
+const (
+	// read applications, the profile and timelines
+	ScopeRead = "read"
+	// add, edit and delete applications
+	ScopeWrite = "write"
+	// everything only a browser session can do; never given to a personal access token
+	ScopeAccount = "account"
+
+	PersonalAccessTokenPrefix = "cpm_"
+
+	maxTokensPerUser     = 20
+	maxTokenNameLength   = 100
+	maxTokenLifetimeDays = 365
+	// last used times are only written this often, so a busy script doesn't write on every request
+	touchInterval = 5 * time.Minute
+)
+
+var ErrInsufficientScope = errors.New("token does not have the required scope")
+
+// who a request is from, and what it may do
+type Principal struct {
+	UserID string
+	// set for browser sessions
+	SessionID string
+	// set for personal access tokens
+	TokenID string
+	Scopes  []string
+	// in ADMIN_USER_IDS; only ever set for browser sessions
+	Admin bool
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+type createTokenRequest struct {
+	Name string `json:"name"`
+	// "read" or "write" (write includes read)
+	Access string `json:"access"`
+	// 0 for a token that never expires
+	ExpiresInDays int `json:"expiresInDays"`
+}
+
+type tokenMetadata struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+type createTokenResponse struct {
+	tokenMetadata
+	// only ever returned here; the API can't show it again
+	Token string `json:"token"`
+}
+
+type revokeTokenRequest struct {
+	ID string `json:"id"`
+}
+
+// checks the request's access token or personal access token
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if strings.HasPrefix(bearerToken(r), PersonalAccessTokenPrefix) {
+		return a.authenticateToken(r.Context(), bearerToken(r))
+	}
+	if bearerToken(r) == "" && a.hasCookieSession(r) {
+		return a.authenticateCookie(r)
+	}
+
+	userID, sessionID, err := a.authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{
+		UserID:    userID,
+		SessionID: sessionID,
+		Scopes:    []string{ScopeRead, ScopeWrite, ScopeAccount},
+		Admin:     a.admins[userID],
+	}, nil
+}
+
+func (a *Authenticator) authenticateToken(ctx context.Context, presented string) (*Principal, error) {
+	if a.tokens == nil {
+		return nil, fmt.Errorf("token store not configured")
+	}
+
+	id, hash, err := parsePersonalAccessToken(presented)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := a.tokens.GetToken(ctx, id)
+	if errors.Is(err, authstore.ErrTokenNotFound) {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check token: %w", err)
+	}
+
+	now := time.Now()
+	if !sameHash(hash, token.Hash) || token.Expired(now) {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	if now.Sub(token.LastUsedAt) > touchInterval {
+		if err := a.tokens.TouchToken(ctx, id, now); err != nil {
+			log.Printf("Failed to update last used time of token %s: %v", id, err)
+		}
+	}
+
+	return &Principal{
+		UserID:  token.UserID,
+		TokenID: token.ID,
+		Scopes:  token.Scopes,
+	}, nil
+}
+
+// returns "cpm_{id}.{secret}" and the hash to store
+func newPersonalAccessToken(id string) (string, string) {
+	secret := randomString(32)
+	return PersonalAccessTokenPrefix + id + "." + secret, hashSecret(secret)
+}
+
+// returns the token ID and the hash of the secret
+func parsePersonalAccessToken(token string) (string, string, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(token, PersonalAccessTokenPrefix), ".")
+	if !ok || id == "" || secret == "" {
+		return "", "", fmt.Errorf("malformed personal access token")
+	}
+	return id, hashSecret(secret), nil
+}
+
+// revokes every session and deletes every personal access token of the user; for deleted accounts
+func (a *Authenticator) RevokeCredentials(ctx context.Context, userID string) error {
+	if err := a.sessions.RevokeAll(ctx, userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	if err := a.tokens.DeleteAllTokens(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete personal access tokens: %w", err)
+	}
+	return nil
+}
+
+// POST /auth/tokens {"name": "...", "access": "read" | "write", "expiresInDays": 90}
+// -> the token (shown once) and its metadata
+func (h *Handler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] Create Token [*]")
+	log.Println("-----------------")
+
+	userID := UserID(r)
+
+	var request createTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > maxTokenNameLength {
+		http.Error(w, fmt.Sprintf("Name must be between 1 and %d characters", maxTokenNameLength), http.StatusBadRequest)
+		return
+	}
+
+	var scopes []string
+	switch request.Access {
+	case ScopeRead:
+		scopes = []string{ScopeRead}
+	case ScopeWrite:
+		scopes = []string{ScopeRead, ScopeWrite}
+	default:
+		http.Error(w, "Access must be read or write", http.StatusBadRequest)
+		return
+	}
+
+	if request.ExpiresInDays < 0 || request.ExpiresInDays > maxTokenLifetimeDays {
+		http.Error(w, fmt.Sprintf("Tokens can last at most %d days", maxTokenLifetimeDays), http.StatusBadRequest)
+		return
+	}
+
+	existing, err := h.authenticator.tokens.ListTokens(r.Context(), userID)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error creating token", http.StatusInternalServerError)
+		return
+	}
+	if len(existing) >= maxTokensPerUser {
+		http.Error(w, fmt.Sprintf("At most %d tokens, revoke one first", maxTokensPerUser), http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	token := authstore.PersonalAccessToken{
+		ID:        randomString(12),
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if request.ExpiresInDays > 0 {
+		token.ExpiresAt = now.AddDate(0, 0, request.ExpiresInDays)
+	}
+	secret, hash := newPersonalAccessToken(token.ID)
+	token.Hash = hash
+
+	if err := h.authenticator.tokens.CreateToken(r.Context(), token); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error creating token", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Token created")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createTokenResponse{
+		tokenMetadata: metadata(token),
+		Token:         secret,
+	})
+}
+
+// GET /auth/tokens; the user's tokens, newest first (never the tokens themselves)
+func (h *Handler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] List Tokens [*]")
+	log.Println("-----------------")
+
+	userID := UserID(r)
+
+	list, err := h.authenticator.tokens.ListTokens(r.Context(), userID)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error listing tokens", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]tokenMetadata, 0, len(list))
+	for _, token := range list {
+		response = append(response, metadata(token))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// POST /auth/tokens/revoke {"id": "..."}; the token stops working right away
+func (h *Handler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] Revoke Token [*]")
+	log.Println("-----------------")
+
+	userID := UserID(r)
+
+	var request revokeTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := h.authenticator.tokens.DeleteToken(r.Context(), userID, request.ID)
+	if errors.Is(err, authstore.ErrTokenNotFound) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error revoking token", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Token revoked")
+	w.WriteHeader(http.StatusOK)
+}
+
+func metadata(token authstore.PersonalAccessToken) tokenMetadata {
+	response := tokenMetadata{
+		ID:        token.ID,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+	}
+	if !token.ExpiresAt.IsZero() {
+		response.ExpiresAt = &token.ExpiresAt
+	}
+	if !token.LastUsedAt.IsZero() {
+		response.LastUsedAt = &token.LastUsedAt
+	}
+	return response
+}
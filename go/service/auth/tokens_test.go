@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/copium-dev/copium/go/service/auth/authstore"
+)
+
+// POST /auth/tokens as userID from a browser session
+func createToken(t *testing.T, h *Handler, userID string, request createTokenRequest) (int, createTokenResponse) {
+	t.Helper()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/auth/tokens", bytes.NewReader(body))
+	r = r.WithContext(WithPrincipal(r.Context(), &Principal{UserID: userID, SessionID: "session-1", Scopes: []string{ScopeRead, ScopeWrite, ScopeAccount}}))
+
+	w := httptest.NewRecorder()
+	h.CreateToken(w, r)
+
+	var response createTokenResponse
+	if w.Code == http.StatusCreated {
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+	return w.Code, response
+}
+
+func TestParsePersonalAccessToken(t *testing.T) {
+	token, hash := newPersonalAccessToken("token-1")
+	if !strings.HasPrefix(token, PersonalAccessTokenPrefix+"token-1.") {
+		t.Fatalf("newPersonalAccessToken = %q, want cpm_token-1.{secret}", token)
+	}
+
+	id, parsedHash, err := parsePersonalAccessToken(token)
+	if err != nil {
+		t.Fatalf("parsePersonalAccessToken: %v", err)
+	}
+	if id != "token-1" || parsedHash != hash {
+		t.Errorf("parsePersonalAccessToken = %q, %q, want token-1 and the stored hash", id, parsedHash)
+	}
+
+	for _, malformed := range []string{"cpm_", "cpm_token-1", "cpm_.secret", "cpm_token-1."} {
+		if _, _, err := parsePersonalAccessToken(malformed); err == nil {
+			t.Errorf("parsePersonalAccessToken(%q) succeeded", malformed)
+		}
+	}
+}
+
+func TestSameHash(t *testing.T) {
+	hash := hashSecret("secret")
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{"same", hash, hashSecret("secret"), true},
+		{"different secret", hash, hashSecret("other"), false},
+		// a token stored without a hash mustn't match a presented one without a secret
+		{"both empty", "", "", false},
+		{"prefix", hash[:10], hash, false},
+	}
+
+	for _, tt := range tests {
+		if got := sameHash(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: sameHash = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	// even an admin's token is never admin
+	h, authenticator := newTestAuth(t, "user-1")
+	code, created := createToken(t, h, "user-1", createTokenRequest{Name: "script", Access: ScopeRead})
+	if code != http.StatusCreated {
+		t.Fatalf("CreateToken = %d, want 201", code)
+	}
+
+	principal, err := authenticator.Authenticate(bearerRequest(created.Token))
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if principal.UserID != "user-1" || principal.TokenID != created.ID || principal.SessionID != "" || principal.Admin {
+		t.Errorf("Principal = %+v, want user-1's token and nothing else", *principal)
+	}
+	if !principal.HasScope(ScopeRead) || principal.HasScope(ScopeWrite) || principal.HasScope(ScopeAccount) {
+		t.Errorf("Scopes = %q, want only read", principal.Scopes)
+	}
+
+	stored, err := authenticator.tokens.GetToken(context.Background(), created.ID)
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+	if stored.LastUsedAt.IsZero() {
+		t.Error("LastUsedAt not set on first use")
+	}
+
+	// the right ID with someone else's secret
+	forged, _ := newPersonalAccessToken(created.ID)
+	if _, err := authenticator.Authenticate(bearerRequest(forged)); err == nil {
+		t.Error("Authenticate accepted a token with the wrong secret")
+	}
+	if _, err := authenticator.Authenticate(bearerRequest(PersonalAccessTokenPrefix + "unknown.secret")); err == nil {
+		t.Error("Authenticate accepted an unknown token")
+	}
+}
+
+func TestAuthenticateExpiredToken(t *testing.T) {
+	_, authenticator := newTestAuth(t)
+	token, hash := newPersonalAccessToken("token-1")
+
+	now := time.Now()
+	err := authenticator.tokens.CreateToken(context.Background(), authstore.PersonalAccessToken{
+		ID:        "token-1",
+		UserID:    "user-1",
+		Scopes:    []string{ScopeRead},
+		Hash:      hash,
+		CreatedAt: now.Add(-48 * time.Hour),
+		ExpiresAt: now.Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	if _, err := authenticator.Authenticate(bearerRequest(token)); err == nil {
+		t.Error("Authenticate accepted an expired token")
+	}
+}
+
+func TestCreateTokenScopes(t *testing.T) {
+	tests := []struct {
+		access     string
+		wantCode   int
+		wantScopes []string
+	}{
+		{ScopeRead, http.StatusCreated, []string{ScopeRead}},
+		{ScopeWrite, http.StatusCreated, []string{ScopeRead, ScopeWrite}},
+		// a token can never manage the account
+		{ScopeAccount, http.StatusBadRequest, nil},
+		{"", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.access, func(t *testing.T) {
+			h, _ := newTestAuth(t)
+			code, created := createToken(t, h, "user-1", createTokenRequest{Name: "script", Access: tt.access})
+			if code != tt.wantCode {
+				t.Fatalf("CreateToken = %d, want %d", code, tt.wantCode)
+			}
+			if code == http.StatusCreated && !slices.Equal(created.Scopes, tt.wantScopes) {
+				t.Errorf("Scopes = %q, want %q", created.Scopes, tt.wantScopes)
+			}
+		})
+	}
+}
+
+func TestCreateTokenLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		request createTokenRequest
+	}{
+		{"no name", createTokenRequest{Name: "  ", Access: ScopeRead}},
+		{"long name", createTokenRequest{Name: strings.Repeat("a", maxTokenNameLength+1), Access: ScopeRead}},
+		{"negative lifetime", createTokenRequest{Name: "script", Access: ScopeRead, ExpiresInDays: -1}},
+		{"long lifetime", createTokenRequest{Name: "script", Access: ScopeRead, ExpiresInDays: maxTokenLifetimeDays + 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestAuth(t)
+			if code, _ := createToken(t, h, "user-1", tt.request); code != http.StatusBadRequest {
+				t.Errorf("CreateToken = %d, want 400", code)
+			}
+		})
+	}
+}
+
+func TestMaxTokensPerUser(t *testing.T) {
+	h, _ := newTestAuth(t)
+	request := createTokenRequest{Name: "script", Access: ScopeRead}
+
+	var first createTokenResponse
+	for i := 0; i < maxTokensPerUser; i++ {
+		code, created := createToken(t, h, "user-1", request)
+		if code != http.StatusCreated {
+			t.Fatalf("CreateToken %d = %d, want 201", i+1, code)
+		}
+		if i == 0 {
+			first = created
+		}
+	}
+
+	if code, _ := createToken(t, h, "user-1", request); code != http.StatusConflict {
+		t.Fatalf("CreateToken past the limit = %d, want 409", code)
+	}
+	// the limit is per user
+	if code, _ := createToken(t, h, "user-2", request); code != http.StatusCreated {
+		t.Errorf("CreateToken for another user = %d, want 201", code)
+	}
+
+	body, _ := json.Marshal(revokeTokenRequest{ID: first.ID})
+	r := httptest.NewRequest(http.MethodPost, "/auth/tokens/revoke", bytes.NewReader(body))
+	r = r.WithContext(WithPrincipal(r.Context(), &Principal{UserID: "user-1", Scopes: []string{ScopeAccount}}))
+	w := httptest.NewRecorder()
+	h.RevokeToken(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("RevokeToken = %d, want 200", w.Code)
+	}
+
+	if code, _ := createToken(t, h, "user-1", request); code != http.StatusCreated {
+		t.Errorf("CreateToken after revoking one = %d, want 201", code)
+	}
+}
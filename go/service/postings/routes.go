@@ -18,6 +18,7 @@ import (
 
 type Handler struct {
 	algoliaClient *search.APIClient
+	index         string
 }
 
 type AlgoliaResponse struct {
@@ -35,30 +36,22 @@ type PostingsResponse struct {
 	CurrentPage  int               `json:"currentPage"`
 }
 
-func NewHandler(algoliaClient *search.APIClient) *Handler {
+func NewHandler(algoliaClient *search.APIClient, index string) *Handler {
 	return &Handler{
 		algoliaClient: algoliaClient,
+		index:         index,
 	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/postings", h.GetPostings).Methods("GET")
+	// the ONLY point of auth is so that only logged in users can access this endpoint
+	auth.Require(router.HandleFunc("/postings", h.GetPostings).Methods("GET"), auth.ScopeRead)
 }
 
 func (h *Handler) GetPostings(w http.ResponseWriter, r *http.Request) {
 	log.Println("[*] GetPostings [*]")
 	log.Println("-----------------")
 
-	// the ONLY point of auth is so that only logged in users can access this endpoint
-	_, err := auth.IsAuthenticated(r)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	log.Println("User authenticated")
-
 	// 1.a) extract search query from request
 	// 		we need a different function than userutils.ParseQuery (so maybe make a postingutils package)
 	queryText, filtersString, err := postingsutils.ParseQuery(r)
@@ -127,7 +120,7 @@ func (h *Handler) GetPostings(w http.ResponseWriter, r *http.Request) {
 
 	// 3. query algolia w/ search params
 	response, err := h.algoliaClient.SearchSingleIndex(
-		h.algoliaClient.NewApiSearchSingleIndexRequest("postings").WithSearchParams(searchParams),
+		h.algoliaClient.NewApiSearchSingleIndexRequest(h.index).WithSearchParams(searchParams),
 	)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
@@ -0,0 +1,174 @@
+package outbox
+
+// the relay drains the outbox (see userstore/outbox.go) into the applications topic
+// handlers call Wake after every write so messages normally go out immediately; the
+// poll interval only matters for messages left behind by a crash or a failed publish
+// ordering: messages with the same ordering key are published strictly in the order they
+// were written. if one of them can't be published (leased by another relay or waiting to be
+// retried) everything behind it with the same key waits too. each user has their own key
+// (see user.Handler.newMessage), so that only ever holds up that one user
+// the relay publishes through a bus.Publisher, so it doesn't care which transport is configured
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/copium-dev/copium/go/service/user/userstore"
+	"github.com/copium-dev/copium/shared/bus"
+)
+
+const (
+	// how often the outbox is checked when nobody calls Wake
+	pollInterval = 30 * time.Second
+	// messages read from the outbox per pass
+	batchSize = 100
+	// how long a relay owns a message while publishing it; must be longer than publishTimeout
+	leaseDuration = 30 * time.Second
+	// same timeout the handlers used when they published directly
+	publishTimeout = 10 * time.Second
+	// retry backoff starts at minBackoff and doubles per attempt up to maxBackoff
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+type Relay struct {
+	store userstore.Outbox
+	topic bus.Publisher
+	wake  chan struct{}
+}
+
+func NewRelay(store userstore.Outbox, topic bus.Publisher) *Relay {
+	return &Relay{
+		store: store,
+		topic: topic,
+		// buffered so Wake never blocks; one pending wake-up is enough
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// asks the relay to drain the outbox now instead of at the next poll
+func (r *Relay) Wake() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// blocks until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		r.drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishes whatever is pending now; for shutdown, once Run has returned, so messages written
+// by the last requests go out before the process exits instead of on the next start
+func (r *Relay) Flush(ctx context.Context) {
+	r.drain(ctx)
+}
+
+// publishes every pending message that can go out right now. it pages through the whole outbox,
+// so one user with more than batchSize blocked messages can't keep everyone behind them waiting
+func (r *Relay) drain(ctx context.Context) {
+	// once a key is blocked it stays blocked for the rest of the pass, keeping its order
+	blocked := make(map[string]bool)
+	var after *userstore.OutboxMessage
+
+	for {
+		msgs, err := r.store.PendingMessages(ctx, after, batchSize)
+		if err != nil {
+			fmt.Printf("Error reading outbox: %v\n", err)
+			return
+		}
+
+		for _, msg := range msgs {
+			if ctx.Err() != nil {
+				return
+			}
+			if blocked[msg.OrderingKey] {
+				continue
+			}
+			if !r.relay(ctx, msg) {
+				blocked[msg.OrderingKey] = true
+			}
+		}
+
+		if len(msgs) < batchSize {
+			return
+		}
+		after = &msgs[len(msgs)-1]
+	}
+}
+
+// returns whether the message was published and removed from the outbox
+func (r *Relay) relay(ctx context.Context, msg userstore.OutboxMessage) bool {
+	if msg.LeasedUntil.After(time.Now()) {
+		return false
+	}
+
+	claimed, err := r.store.ClaimMessage(ctx, msg.ID, time.Now().Add(leaseDuration))
+	if err != nil {
+		fmt.Printf("Error claiming outbox message %s: %v\n", msg.ID, err)
+		return false
+	}
+	if !claimed {
+		return false
+	}
+
+	id, err := r.publish(ctx, msg)
+	if err != nil {
+		fmt.Printf("Error publishing outbox message %s (attempt %d): %v\n", msg.ID, msg.Attempts+1, err)
+
+		retryAt := time.Now().Add(backoff(msg.Attempts))
+		if err := r.store.RetryMessage(ctx, msg.ID, retryAt, err.Error()); err != nil {
+			// the lease still expires on its own, so the message is retried either way
+			fmt.Printf("Error scheduling retry for outbox message %s: %v\n", msg.ID, err)
+		}
+		return false
+	}
+
+	fmt.Printf("Published message with ID: %s\n", id)
+
+	// if this fails the message is published again once the lease expires; consumers
+	// already have to handle redelivery from the bus so a duplicate is harmless
+	if err := r.store.DeleteMessage(ctx, msg.ID); err != nil {
+		fmt.Printf("Error deleting outbox message %s: %v\n", msg.ID, err)
+	}
+
+	return true
+}
+
+func (r *Relay) publish(ctx context.Context, msg userstore.OutboxMessage) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	// the outbox ID doubles as the bus message ID so transports that deduplicate (NATS) drop
+	// a message published again after its outbox row failed to delete
+	return r.topic.Publish(ctx, bus.Message{
+		ID:          msg.ID,
+		Data:        msg.Data,
+		OrderingKey: msg.OrderingKey,
+	})
+}
+
+func backoff(attempts int) time.Duration {
+	delay := minBackoff
+	for i := 0; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
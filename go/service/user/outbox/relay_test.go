@@ -0,0 +1,232 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/copium-dev/copium/go/service/user/userstore"
+	"github.com/copium-dev/copium/shared/bus"
+)
+
+// records what it publishes and fails every message whose ordering key is in failing
+type fakePublisher struct {
+	mu        sync.Mutex
+	store     *userstore.MemoryStore
+	failing   map[string]bool
+	published []string
+	// IDs that were no longer in the outbox when they were published
+	missing []string
+}
+
+func newFakePublisher(store *userstore.MemoryStore, failing ...string) *fakePublisher {
+	p := &fakePublisher{
+		store:   store,
+		failing: make(map[string]bool),
+	}
+	for _, key := range failing {
+		p.failing[key] = true
+	}
+	return p
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, msg bus.Message) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failing[msg.OrderingKey] {
+		return "", errors.New("publish failed")
+	}
+
+	// the row has to stay until the bus has the message
+	if _, ok := pendingByID(ctx, p.store)[msg.ID]; !ok {
+		p.missing = append(p.missing, msg.ID)
+	}
+	p.published = append(p.published, string(msg.Data))
+	return msg.ID, nil
+}
+
+func (p *fakePublisher) Close() error {
+	return nil
+}
+
+func (p *fakePublisher) setFailing(key string, failing bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failing[key] = failing
+}
+
+func pendingByID(ctx context.Context, store *userstore.MemoryStore) map[string]userstore.OutboxMessage {
+	msgs, _ := store.PendingMessages(ctx, nil, batchSize)
+	byID := make(map[string]userstore.OutboxMessage)
+	for _, msg := range msgs {
+		byID[msg.ID] = msg
+	}
+	return byID
+}
+
+// enqueues one message per data/key pair, oldest first
+func enqueue(t *testing.T, store *userstore.MemoryStore, messages ...[2]string) []userstore.OutboxMessage {
+	t.Helper()
+
+	created := time.Now().Add(-time.Minute)
+	var msgs []userstore.OutboxMessage
+	for i, message := range messages {
+		msg := userstore.NewOutboxMessage([]byte(message[0]), message[1])
+		msg.CreatedAt = created.Add(time.Duration(i) * time.Second)
+		msgs = append(msgs, msg)
+	}
+	if err := store.Enqueue(context.Background(), msgs...); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	return msgs
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDrainBlocksFailedOrderingKey(t *testing.T) {
+	ctx := context.Background()
+	store := userstore.NewMemoryStore()
+	msgs := enqueue(t, store,
+		[2]string{"a1", "user-a"},
+		[2]string{"b1", "user-b"},
+		[2]string{"a2", "user-a"},
+		[2]string{"b2", "user-b"},
+	)
+	publisher := newFakePublisher(store, "user-a")
+
+	NewRelay(store, publisher).drain(ctx)
+
+	if want := []string{"b1", "b2"}; !equal(publisher.published, want) {
+		t.Errorf("published %v, want %v", publisher.published, want)
+	}
+
+	pending := pendingByID(ctx, store)
+	if len(pending) != 2 {
+		t.Fatalf("%d messages left in the outbox, want 2", len(pending))
+	}
+
+	// the one that failed waits out its backoff
+	failed := pending[msgs[0].ID]
+	if failed.Attempts != 1 || failed.LastError == "" {
+		t.Errorf("failed message has %d attempts and error %q, want 1 and the publish error", failed.Attempts, failed.LastError)
+	}
+	if wait := time.Until(failed.LeasedUntil); wait <= 0 || wait > minBackoff {
+		t.Errorf("failed message leased for another %v, want up to %v", wait, minBackoff)
+	}
+
+	// the one behind it wasn't even tried
+	behind := pending[msgs[2].ID]
+	if behind.Attempts != 0 || behind.LeasedUntil.After(time.Now()) {
+		t.Errorf("message behind the failed one has %d attempts and is leased until %v, want untouched", behind.Attempts, behind.LeasedUntil)
+	}
+}
+
+func TestDrainRetriesAfterBackoff(t *testing.T) {
+	ctx := context.Background()
+	store := userstore.NewMemoryStore()
+	msgs := enqueue(t, store,
+		[2]string{"a1", "user-a"},
+		[2]string{"a2", "user-a"},
+	)
+	publisher := newFakePublisher(store, "user-a")
+	relay := NewRelay(store, publisher)
+
+	relay.drain(ctx)
+	publisher.setFailing("user-a", false)
+
+	// still backing off
+	relay.drain(ctx)
+	if len(publisher.published) != 0 {
+		t.Fatalf("published %v during the backoff", publisher.published)
+	}
+
+	time.Sleep(time.Until(pendingByID(ctx, store)[msgs[0].ID].LeasedUntil) + 10*time.Millisecond)
+	relay.drain(ctx)
+
+	if want := []string{"a1", "a2"}; !equal(publisher.published, want) {
+		t.Errorf("published %v, want %v", publisher.published, want)
+	}
+	if len(publisher.missing) != 0 {
+		t.Errorf("messages %v were deleted from the outbox before they were published", publisher.missing)
+	}
+	if pending := pendingByID(ctx, store); len(pending) != 0 {
+		t.Errorf("%d messages left in the outbox after publishing", len(pending))
+	}
+}
+
+// a message another relay holds blocks its key here too
+func TestDrainSkipsLeasedMessages(t *testing.T) {
+	ctx := context.Background()
+	store := userstore.NewMemoryStore()
+	msgs := enqueue(t, store,
+		[2]string{"a1", "user-a"},
+		[2]string{"a2", "user-a"},
+		[2]string{"b1", "user-b"},
+	)
+	if claimed, err := store.ClaimMessage(ctx, msgs[0].ID, time.Now().Add(leaseDuration)); err != nil || !claimed {
+		t.Fatalf("ClaimMessage = %v, %v", claimed, err)
+	}
+	publisher := newFakePublisher(store)
+
+	NewRelay(store, publisher).drain(ctx)
+
+	if want := []string{"b1"}; !equal(publisher.published, want) {
+		t.Errorf("published %v, want %v", publisher.published, want)
+	}
+}
+
+// more than a batch of one user's messages blocked at the front of the outbox doesn't hold up
+// the next user's message behind them
+func TestDrainPagesPastBlockedMessages(t *testing.T) {
+	ctx := context.Background()
+	store := userstore.NewMemoryStore()
+
+	var messages [][2]string
+	for i := 0; i < batchSize+10; i++ {
+		messages = append(messages, [2]string{"a", "user-a"})
+	}
+	msgs := enqueue(t, store, append(messages, [2]string{"b1", "user-b"})...)
+	if claimed, err := store.ClaimMessage(ctx, msgs[0].ID, time.Now().Add(leaseDuration)); err != nil || !claimed {
+		t.Fatalf("ClaimMessage = %v, %v", claimed, err)
+	}
+	publisher := newFakePublisher(store)
+
+	NewRelay(store, publisher).drain(ctx)
+
+	if want := []string{"b1"}; !equal(publisher.published, want) {
+		t.Errorf("published %v, want %v", publisher.published, want)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{8, 256 * time.Second},
+		{9, maxBackoff},
+		{100, maxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -4,21 +4,23 @@ package user
 // it contains the following handlers:
 // (R) - Dashboard: queries Algolia for applications based on search query
 // (R) - Profile: (for now) returns simply email and app count; once we figure out what kind of data analytics we want to show, it will be updated
-// (C) - AddApplication: adds an application to Firestore and publishes a message to PubSub
-// (D) - DeleteApplication: deletes an application from Firestore and publishes a message to PubSub
-// (U) - EditStatus: edits the status of an application in Firestore and publishes a message to PubSub
-// (U) - EditApplication: edits an application in Firestore and publishes a message to PubSub
-// (D) - DeleteUser: deletes a user from Firestore and publishes a message to PubSub to delete all applications from Algolia
+// (R) - GetApplicationTimeline: the status history of one application
+// Dashboard, Profile and GetApplicationTimeline can also read a user who shared with the caller (sharing.go)
+// (C) - AddApplication: adds an application to Firestore and queues a message for PubSub
+// (D) - DeleteApplication: deletes an application from Firestore and queues a message for PubSub
+// (U) - EditStatus: edits the status of an application in Firestore and queues a message for PubSub
+// (U) - EditApplication: edits an application in Firestore and queues a message for PubSub
+// (D) - DeleteUser: deletes a user from Firestore and queues a message for PubSub to delete all applications from Algolia
 // this file contains the following utility functions:
-// - deleteUserFromFirestore: deletes a user from Firestore, including all applications
-// - publishMessage: publishes a message to PubSub with publish and connection retries
-//     (relies on utils.PublishWithRetry)
+// - newMessage: encodes an event (shared/events) into the outbox message that is written together with a store change
+// - incrementCounters: applies counter deltas, skipping the write when they cancel out
+// - messageError: responds to a newMessage error, 400 for validation failures
 // NOTE: all CRUD operations (AddApplication, DeleteApplication, EditStatus, EditApplication, DeleteUser) are idempotent
 //       and can be retried without side effects. This is why there is no timestamping or versioning.
-// NOTE: all CRUD operations are NOT commutative. We rely on an optimistic but strong consistency model. So,
-//	 	 every user request is fulfilled to DB, but in the rare event of publishing failure, we can quickly revert.
-//		 Actually, this does not add any latency because we have frontend send previous state in the delete or edit request
-//       so there's no need to query the DB for the previous state.
+// NOTE: all CRUD operations are NOT commutative. Every store write carries its PubSub message with it
+//       (transactional outbox, see userstore/outbox.go) and the outbox relay publishes it in the background.
+//       so once a write succeeds the message WILL be published eventually, and if the write fails nothing
+//       is published. there is nothing to revert, no matter where the process dies
 // Q: why not use event sourcing?
 // A: all that matters is latest state; rebuilding history is not necessary. HOWEVER, compliance and auditing
 //    may require event sourcing in the future if this project takes off
@@ -30,28 +32,32 @@ package user
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/copium-dev/copium/go/service/auth"
+	"github.com/copium-dev/copium/go/service/user/outbox"
+	"github.com/copium-dev/copium/go/service/user/userstore"
 	"github.com/copium-dev/copium/go/service/user/userutils"
 	"github.com/copium-dev/copium/go/utils"
+	"github.com/copium-dev/copium/shared/analytics"
+	"github.com/copium-dev/copium/shared/events"
 
-	"cloud.google.com/go/firestore"
-	"cloud.google.com/go/pubsub"
-	"cloud.google.com/go/bigquery"
 	"github.com/gorilla/mux"
-	"google.golang.org/api/iterator"
 
 	"github.com/algolia/algoliasearch-client-go/v4/algolia/search"
 )
 
 type ApplicationStatus = userutils.ApplicationStatus
 
+// the request would make an event that fails validation (see shared/events), e.g. an unknown
+// status or a missing applied date. that's on the client, so it's a 400 and not a 500
+var ErrInvalidRequest = errors.New("invalid request")
+
 type AddApplicationRequest struct {
 	Role        string `json:"role"`
 	Company     string `json:"company"`
@@ -122,7 +128,7 @@ type EditApplicationRequest struct {
 	OldCompany     string `json:"oldCompany"`
 	OldLocation    string `json:"oldLocation"`
 	OldLink        string `json:"oldLink"`
-	Status         ApplicationStatus `json:status`
+	Status         ApplicationStatus `json:"status"`
 }
 
 type RevertApplicationStatusRequest struct {
@@ -135,64 +141,81 @@ type ApplicationTimelineRequest struct {
 }
 
 type Handler struct {
-	FirestoreClient *firestore.Client
+	store           userstore.ApplicationStore
+	grants          userstore.GrantStore
+	events          userstore.EventLog
 	algoliaClient   *search.APIClient
-	bigQueryClient *bigquery.Client
-	pubsubTopic     *pubsub.Topic
-	orderingKey     string
+	usersIndex      string
+	relay           *outbox.Relay
+	authenticator   *auth.Authenticator
 }
 
+// store is where applications and per-user counters live (Firestore in prod, in-memory for local dev)
+// grants is who the user has shared their data with (see sharing.go)
+// events is the application history (BigQuery, or Postgres); writes carry their event log row on the
+// outbox message (OutboxMessage.Event) for Postgres, which records it in the same transaction
+// usersIndex is the Algolia index applications are searched in (see shared/config)
+// relay publishes the store's outbox; the handler only wakes it up after a write
+// authenticator revokes a deleted user's sessions and personal access tokens
 func NewHandler(
-	firestoreClient *firestore.Client,
+	store userstore.ApplicationStore,
+	grants userstore.GrantStore,
+	events userstore.EventLog,
 	algoliaClient *search.APIClient,
-	bigQueryClient *bigquery.Client,
-	pubsubTopic *pubsub.Topic,
-	orderingKey string,
+	usersIndex string,
+	relay *outbox.Relay,
+	authenticator *auth.Authenticator,
 ) *Handler {
 	return &Handler{
-		FirestoreClient: firestoreClient,
+		store:           store,
+		grants:          grants,
+		events:          events,
 		algoliaClient:   algoliaClient,
-		bigQueryClient: bigQueryClient,
-		pubsubTopic:     pubsubTopic,
-		orderingKey:     orderingKey,
+		usersIndex:      usersIndex,
+		relay:           relay,
+		authenticator:   authenticator,
 	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/user/dashboard", h.Dashboard).Methods("GET").Name("dashboard")
-	router.HandleFunc("/user/profile", h.Profile).Methods("GET").Name("profile")
-	router.HandleFunc("/user/addApplication", h.AddApplication).Methods("POST").Name("addApplication")
-	router.HandleFunc("/user/deleteApplication", h.DeleteApplication).Methods("POST").Name("deleteApplication")
-	router.HandleFunc("/user/editStatus", h.EditStatus).Methods("POST").Name("editStatus")
-	router.HandleFunc("/user/editApplication", h.EditApplication).Methods("POST").Name("editApplication")
-	router.HandleFunc("/user/deleteUser", h.DeleteUser).Methods("POST").Name("deleteUser")
-	router.HandleFunc("/user/revertStatus", h.RevertStatus).Methods("POST").Name("revertStatus")
-	router.HandleFunc("/user/getApplicationTimeline", h.GetApplicationTimeline).Methods("POST").Name("getApplicationTimeline")
+	// every route needs a signed in user (see auth.Authenticator.Middleware); reads work with any token,
+	// changes need the write scope and deleting the account needs a browser session
+	auth.Require(router.HandleFunc("/user/dashboard", h.Dashboard).Methods("GET").Name("dashboard"), auth.ScopeRead)
+	auth.Require(router.HandleFunc("/user/profile", h.Profile).Methods("GET").Name("profile"), auth.ScopeRead)
+	auth.Require(router.HandleFunc("/user/addApplication", h.AddApplication).Methods("POST").Name("addApplication"), auth.ScopeWrite)
+	auth.Require(router.HandleFunc("/user/deleteApplication", h.DeleteApplication).Methods("POST").Name("deleteApplication"), auth.ScopeWrite)
+	auth.Require(router.HandleFunc("/user/editStatus", h.EditStatus).Methods("POST").Name("editStatus"), auth.ScopeWrite)
+	auth.Require(router.HandleFunc("/user/editApplication", h.EditApplication).Methods("POST").Name("editApplication"), auth.ScopeWrite)
+	auth.Require(router.HandleFunc("/user/deleteUser", h.DeleteUser).Methods("POST").Name("deleteUser"), auth.ScopeAccount)
+	auth.Require(router.HandleFunc("/user/revertStatus", h.RevertStatus).Methods("POST").Name("revertStatus"), auth.ScopeWrite)
+	auth.Require(router.HandleFunc("/user/getApplicationTimeline", h.GetApplicationTimeline).Methods("POST").Name("getApplicationTimeline"), auth.ScopeRead)
+	// sharing (see sharing.go); listing is a read, everything that changes who can see what needs a browser session
+	auth.Require(router.HandleFunc("/user/sharing", h.ListSharing).Methods("GET").Name("listSharing"), auth.ScopeRead)
+	auth.Require(router.HandleFunc("/user/sharing/invite", h.CreateInvitation).Methods("POST").Name("createInvitation"), auth.ScopeAccount)
+	auth.Require(router.HandleFunc("/user/sharing/accept", h.AcceptInvitation).Methods("POST").Name("acceptInvitation"), auth.ScopeAccount)
+	auth.Require(router.HandleFunc("/user/sharing/cancel", h.CancelInvitation).Methods("POST").Name("cancelInvitation"), auth.ScopeAccount)
+	auth.Require(router.HandleFunc("/user/sharing/revoke", h.RevokeGrant).Methods("POST").Name("revokeGrant"), auth.ScopeAccount)
 }
 
 func (h *Handler) Profile(w http.ResponseWriter, r *http.Request) {
 	log.Println("[*] Profile [*]")
 	log.Println("-----------------")
 
-	email, err := auth.IsAuthenticated(r)
+	// ?user= reads someone who shared their analytics with the caller (see sharing.go)
+	userID, err := h.targetUser(r, userstore.GrantScopeAnalytics)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		targetUserError(w, err)
 		return
 	}
 
-	log.Println("User authenticated")
-
 	// get user's applications count
-	doc, err := h.FirestoreClient.Collection("users").Doc(email).Get(r.Context())
+	userData, err := h.store.GetUser(r.Context(), userID)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		http.Error(w, "Error retrieving user data", http.StatusInternalServerError)
 		return
 	}
 
-	userData := doc.Data()
-
 	applicationsCount := int64(0)
 	if countVal, exists := userData["applicationsCount"]; exists && countVal != nil {
 		if count, ok := countVal.(int64); ok {
@@ -200,23 +223,20 @@ func (h *Handler) Profile(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// the email is just a field on the user; it can change (see auth/identities.go)
+	email, _ := userData["email"].(string)
+
 	response := map[string]interface{}{
 		"email":             email,
 		"applicationsCount": applicationsCount,
 	}
 
-	analyticsFields := []string{
-		"application_velocity_trend", "application_velocity",
-		"resume_effectiveness_trend", "resume_effectiveness",
-		"interview_effectiveness_trend", "interview_effectiveness",
-		"avg_response_time_trend", "avg_response_time",
-		"monthly_trends", "rejected_count", "ghosted_count", "applied_count",
-		"screen_count", "interviewing_count", "offer_count", "last_updated",
-	}
+	// every registered metric (computed by the consumer) plus the status counters we maintain here
+	analyticsFields := append(analytics.Fields(), userstore.StatusCounters()...)
 
 	// loop over each field and add to response if it exists
 	for _, field := range analyticsFields {
-		if val, exists := doc.Data()[field]; exists {
+		if val, exists := userData[field]; exists {
 			response[field] = val
 		}
 	}
@@ -233,15 +253,13 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 	log.Println("[*] Dashboard [*]")
 	log.Println("-----------------")
 
-	email, err := auth.IsAuthenticated(r)
+	// ?user= reads someone who shared their applications with the caller (see sharing.go)
+	userID, err := h.targetUser(r, userstore.GrantScopeApplications)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		targetUserError(w, err)
 		return
 	}
 
-	log.Println("User authenticated")
-
 	// 1. extract search query from request and parse
 	queryText, filtersString, err := userutils.ParseQuery(r)
 	// any invalid query params will return a 400 error
@@ -288,8 +306,8 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 
 	// 2. build a search params object
 	searchParamsObject := &search.SearchParamsObject{
-		Facets:       []string{"email"},
-		FacetFilters: &search.FacetFilters{String: utils.StringPtr("email:" + email)},
+		Facets:       []string{"userID"},
+		FacetFilters: &search.FacetFilters{String: utils.StringPtr("userID:" + userID)},
 		HitsPerPage:  utils.IntPtr(int32(hitsPerPageInt)),
 		Filters:      utils.StringPtr(filtersString),
 		Page:         utils.IntPtr(int32(page)),
@@ -307,7 +325,7 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 
 	// 3. query Algolia with the search params
 	response, err := h.algoliaClient.SearchSingleIndex(
-		h.algoliaClient.NewApiSearchSingleIndexRequest("users").WithSearchParams(searchParams),
+		h.algoliaClient.NewApiSearchSingleIndexRequest(h.usersIndex).WithSearchParams(searchParams),
 	)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
@@ -348,81 +366,78 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(responseObject)
 }
 
-// consistency: if publish fails, delete the application from Firestore
+// consistency: the application and its message are written together
 func (h *Handler) AddApplication(w http.ResponseWriter, r *http.Request) {
 	log.Println("[*] AddApplication [*]")
 	log.Println("-----------------")
 
-	email, err := auth.IsAuthenticated(r)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	log.Println("User authenticated")
+	userID := auth.UserID(r)
 
 	// extract json from request body
 	var addApplicationRequest AddApplicationRequest
-	err = json.NewDecoder(r.Body).Decode(&addApplicationRequest)
+	err := json.NewDecoder(r.Body).Decode(&addApplicationRequest)
 	if err != nil {
 		http.Error(w, "Error decoding request body", http.StatusBadRequest)
 		return
 	}
 
-	// add application to Firestore using users/{email} where jobs is a document within the user's collection
-	doc, _, err := h.FirestoreClient.Collection("users").Doc(email).Collection("applications").Add(r.Context(), map[string]interface{}{
-		"role":        addApplicationRequest.Role,
-		"company":     addApplicationRequest.Company,
-		"location":    addApplicationRequest.Location,
-		"appliedDate": addApplicationRequest.AppliedDate,
-		"status":      addApplicationRequest.Status,
-		"link":        addApplicationRequest.Link,
+	// the message needs the objectID before the application is written, so generate it here
+	applicationID := userstore.NewID()
+	// also the operationID in the event log, consumers deduplicate redeliveries on it
+	eventID := events.NewEventID()
+	timestamp := time.Now().Add(12 * time.Hour).Unix()
+
+	message, err := h.newMessage(&events.Add{
+		Header:      events.Header{EventID: eventID, UserID: userID},
+		ObjectID:    applicationID,
+		Role:        addApplicationRequest.Role,
+		Company:     addApplicationRequest.Company,
+		Location:    addApplicationRequest.Location,
+		Link:        addApplicationRequest.Link,
+		AppliedDate: addApplicationRequest.AppliedDate,
+		Status:      string(addApplicationRequest.Status),
+		Timestamp:   timestamp,
 	})
 	if err != nil {
-		fmt.Printf("Error adding application: %v\n", err)
-		http.Error(w, "Error adding application", http.StatusInternalServerError)
+		messageError(w, err, "Error adding application")
 		return
 	}
-
-	log.Println("Application added")
-
-	message := map[string]interface{}{
-		"operation":   "add",
-		"email":       email,
-		"appliedDate": addApplicationRequest.AppliedDate,
-		"company":     addApplicationRequest.Company,
-		"link":        addApplicationRequest.Link,
-		"location":    addApplicationRequest.Location,
-		"role":        addApplicationRequest.Role,
-		"status":      addApplicationRequest.Status,
-		"timestamp":   time.Now().Add(12 * time.Hour).Unix(),
-		"objectID":    doc.ID,
+	// the event log row, for stores that record it with the write (Postgres)
+	message.Event = &userstore.Event{
+		OperationID: eventID,
+		UserID:      userID,
+		JobID:       applicationID,
+		EventTime:   time.Unix(timestamp, 0),
+		AppliedDate: time.Unix(addApplicationRequest.AppliedDate, 0),
+		Status:      string(addApplicationRequest.Status),
+		Operation:   "add",
 	}
 
-	err = h.publishMessage(message)
+	// add application to the store (users/{userID}/applications in Firestore)
+	_, err = h.store.AddApplication(r.Context(), userID, userstore.Application{
+		ID:          applicationID,
+		Role:        addApplicationRequest.Role,
+		Company:     addApplicationRequest.Company,
+		Location:    addApplicationRequest.Location,
+		AppliedDate: addApplicationRequest.AppliedDate,
+		Status:      addApplicationRequest.Status,
+		Link:        addApplicationRequest.Link,
+	}, message)
 	if err != nil {
-		// delete added application if publish fails
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		_, err = h.FirestoreClient.Collection("users").Doc(email).Collection("applications").Doc(doc.ID).Delete(ctx)
-		if err != nil {
-			fmt.Printf("Error deleting application: %v\n", err)
-			http.Error(w, "Error reverting application add", http.StatusInternalServerError)
-		}
-		log.Println("AddApplication reverted because of publish failure")
-		http.Error(w, "Error publishing message", http.StatusInternalServerError)
+		fmt.Printf("Error adding application: %v\n", err)
+		http.Error(w, "Error adding application", http.StatusInternalServerError)
 		return
 	}
 
-	// to reduce amount of reads in this single request, increment applicationCount AFTER verifying publish success
-	// this means we don't have to revert applicationCount on top of reverting the add operation. this DOES
-	// introduce small window of inconsistency but this is reducing costs and reducing complexity
-	userDoc := h.FirestoreClient.Collection("users").Doc(email)
-	_, err = userDoc.Update(r.Context(), []firestore.Update{
-		{Path: "applicationsCount", Value: firestore.Increment(1)},
-		{Path: "applied_count", Value: firestore.Increment(1)},
+	h.relay.Wake()
+
+	log.Println("Application added")
+
+	// counters are updated AFTER the application is written. this DOES introduce a small window
+	// of inconsistency if this fails but this is reducing costs and reducing complexity
+	err = h.store.IncrementCounters(r.Context(), userID, map[string]int64{
+		"applicationsCount": 1,
+		"applied_count":     1,
 	})
 	if err != nil {
 		fmt.Printf("Error updating applications count: %v\n", err)
@@ -431,32 +446,26 @@ func (h *Handler) AddApplication(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Println("Applications count updated, added by 1")
-	log.Println("DB and PubSub operations success, returning ID for eager loading")
 
-	// return doc.ID to user for eager loading
+	log.Println("DB operations success, returning ID for eager loading")
+
+	// return applicationID to user for eager loading
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"objectID": doc.ID,
+		"objectID": applicationID,
 	})
 }
 
-// consistency: save current application status; if publish fails, revert status
+// consistency: the delete and its message are written together
 func (h *Handler) DeleteApplication(w http.ResponseWriter, r *http.Request) {
 	log.Println("[*] DeleteApplication [*]")
 	log.Println("-----------------")
 
-	email, err := auth.IsAuthenticated(r)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	log.Println("User authenticated")
+	userID := auth.UserID(r)
 
 	// extract json from request body
 	var deleteApplicationRequest DeleteApplicationRequest
-	err = json.NewDecoder(r.Body).Decode(&deleteApplicationRequest)
+	err := json.NewDecoder(r.Body).Decode(&deleteApplicationRequest)
 	if err != nil {
 		fmt.Println(err)
 		http.Error(w, "Error decoding request body", http.StatusBadRequest)
@@ -465,95 +474,49 @@ func (h *Handler) DeleteApplication(w http.ResponseWriter, r *http.Request) {
 
 	applicationID := deleteApplicationRequest.ID
 
-	// delete application from Firestore
-	_, err = h.FirestoreClient.Collection("users").Doc(email).Collection("applications").Doc(applicationID).Delete(r.Context())
+	message, err := h.newMessage(&events.Delete{
+		Header:   events.Header{UserID: userID},
+		ObjectID: applicationID,
+	})
 	if err != nil {
-		fmt.Printf("Error deleting application: %v\n", err)
-		http.Error(w, "Error deleting application", http.StatusInternalServerError)
+		messageError(w, err, "Error deleting application")
 		return
 	}
-
-	log.Println("Application deleted")
-
-	message := map[string]interface{}{
-		"operation": "delete",
-		"email":     email,
-		"objectID":  applicationID,
+	// the event log row, for stores that record it with the write (Postgres)
+	message.Event = &userstore.Event{
+		UserID:    userID,
+		JobID:     applicationID,
+		Operation: "delete",
 	}
 
-	err = h.publishMessage(message)
+	// delete application from the store
+	err = h.store.DeleteApplication(r.Context(), userID, applicationID, message)
 	if err != nil {
-		// revert status if publish fails
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		_, err = h.FirestoreClient.Collection("users").Doc(email).Collection("applications").Doc(applicationID).Set(ctx, map[string]interface{}{
-			"role":        deleteApplicationRequest.Role,
-			"company":     deleteApplicationRequest.Company,
-			"location":    deleteApplicationRequest.Location,
-			"appliedDate": deleteApplicationRequest.AppliedDate,
-			"status":      deleteApplicationRequest.Status,
-			"link":        deleteApplicationRequest.Link,
-		})
-		if err != nil {
-			fmt.Printf("Error reverting application: %v\n", err)
-			http.Error(w, "Error reverting application delete", http.StatusInternalServerError)
-			return
-		}
-		log.Println("DeleteApplication reverted because of publish failure")
-		http.Error(w, "Error publishing message", http.StatusInternalServerError)
+		fmt.Printf("Error deleting application: %v\n", err)
+		http.Error(w, "Error deleting application", http.StatusInternalServerError)
 		return
 	}
 
-	// to reduce amount of reads in this single request, decrement applicationCount AFTER verifying publish success
-	// this means we don't have to revert applicationCount on top of reverting the delete operation. this DOES
-	// introduce small window of inconsistency but this is reducing costs and reducing complexity
-	// transaction is used to ensure that if an increment fails, decrement wont happen and vice versa
-	err = h.FirestoreClient.RunTransaction(r.Context(), func(ctx context.Context, tx *firestore.Transaction) error {
-		userDoc := h.FirestoreClient.Collection("users").Doc(email)
-		doc, err := tx.Get(userDoc)
-		if err != nil {
-			return err
-		}
-		
-		statusCount := 0
-		countKey := fmt.Sprintf("%s_count", strings.ToLower(string(deleteApplicationRequest.Status)))
-		if val, exists := doc.Data()[countKey]; exists {
-			if count, ok := val.(int64); ok {
-				statusCount = int(count)
-			}
-		}
+	h.relay.Wake()
 
-		applicationsCount := 0
-		if val, exists := doc.Data()["applicationsCount"]; exists {
-			if count, ok := val.(int64); ok {
-				applicationsCount = int(count)
-			}
-		}
-
-		var updates []firestore.Update
+	log.Println("Application deleted")
 
-		if applicationsCount > 0 {
-			updates = append(updates, firestore.Update{
-				Path: "applicationsCount", Value: firestore.Increment(-1),
-			})
-		}
-		
-		if statusCount > 0 {
-			updates = append(updates, firestore.Update{
-				Path: countKey, Value: firestore.Increment(-1),
-			})
-		}
-		
-		return tx.Update(userDoc, updates)
+	// counters are updated AFTER the application is deleted. this DOES introduce a small window
+	// of inconsistency if this fails but this is reducing costs and reducing complexity
+	// the store applies both decrements atomically and never lets a count go below 0
+	err = h.store.IncrementCounters(r.Context(), userID, map[string]int64{
+		"applicationsCount": -1,
+		userstore.StatusCounter(deleteApplicationRequest.Status): -1,
 	})
 	if err != nil {
 		fmt.Printf("Error updating applications count: %v\n", err)
 		http.Error(w, "Error updating application count", http.StatusInternalServerError)
+		return
 	}
 
 	log.Println("Applications count updated, decremented by 1")
-	log.Println("DB and PubSub operations success, returning success for eager loading")
+
+	log.Println("DB operations success, returning success for eager loading")
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -564,18 +527,11 @@ func (h *Handler) EditStatus(w http.ResponseWriter, r *http.Request) {
 	log.Println("[*] EditStatus [*]")
 	log.Println("-----------------")
 
-	email, err := auth.IsAuthenticated(r)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	log.Println("User authenticated")
+	userID := auth.UserID(r)
 
 	// extract json from request body
 	var EditApplicationStatusRequest EditApplicationStatusRequest
-	err = json.NewDecoder(r.Body).Decode(&EditApplicationStatusRequest)
+	err := json.NewDecoder(r.Body).Decode(&EditApplicationStatusRequest)
 	if err != nil {
 		http.Error(w, "Error decoding request body", http.StatusBadRequest)
 		return
@@ -592,92 +548,54 @@ func (h *Handler) EditStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = h.FirestoreClient.Collection("users").Doc(email).Collection("applications").Doc(applicationID).Update(r.Context(), []firestore.Update{
-		{
-			Path:  "status",
-			Value: newStatus,
-		},
+	// since appliedDate is always using noon as the time, we need to ensure
+	// that the timestamp sent to PubSub is always at or after noon. this is because
+	// a user can edit status of an application at 11:59 AM and the appliedDate is 12:00 PM
+	// so this will cause response time metrics to be incorrect
+	// so, simply add 12 hours to guarantee it's always at or after noon
+	timestamp := time.Now().Add(12 * time.Hour).Unix()
+	// also the operationID in the event log, consumers deduplicate redeliveries on it
+	eventID := events.NewEventID()
+
+	message, err := h.newMessage(&events.EditStatus{
+		Header:      events.Header{EventID: eventID, UserID: userID},
+		ObjectID:    applicationID,
+		Status:      string(newStatus),
+		AppliedDate: appliedDate,	// just to satisfy BigQuery schema
+		Timestamp:   timestamp,
 	})
 	if err != nil {
-		fmt.Printf("Error editing application: %v\n", err)
-		http.Error(w, "Error editing application", http.StatusInternalServerError)
+		messageError(w, err, "Error editing application")
 		return
 	}
-
-	log.Println("Application status edited")
-
-	message := map[string]interface{}{
-		"operation":   "editStatus",
-		"email":       email,
-		"objectID":    applicationID,
-		"status":      newStatus,
-		"appliedDate": appliedDate,	// just to satisfy BigQuery schema
-		// since appliedDate is always using noon as the time, we need to ensure
-		// that the timestamp sent to PubSub is always at or after noon. this is because
-		// a user can edit status of an application at 11:59 AM and the appliedDate is 12:00 PM
-		// so this will cause response time metrics to be incorrect
-		// so, simply add 12 hours to guarantee it's always at or after noon
-		"timestamp": time.Now().Add(12 * time.Hour).Unix(),
+	// the event log row, for stores that record it with the write (Postgres)
+	message.Event = &userstore.Event{
+		OperationID: eventID,
+		UserID:      userID,
+		JobID:       applicationID,
+		EventTime:   time.Unix(timestamp, 0),
+		AppliedDate: time.Unix(appliedDate, 0),
+		Status:      string(newStatus),
+		Operation:   "edit",
 	}
 
-	err = h.publishMessage(message)
+	err = h.store.UpdateApplication(r.Context(), userID, applicationID, map[string]interface{}{
+		"status": newStatus,
+	}, message)
 	if err != nil {
-		// revert status if publish fails
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		_, err = h.FirestoreClient.Collection("users").Doc(email).Collection("applications").Doc(applicationID).Update(ctx, []firestore.Update{
-			{
-				Path:  "status",
-				Value: EditApplicationStatusRequest.OldStatus,
-			},
-		})
-		if err != nil {
-			fmt.Printf("Error reverting status: %v\n", err)
-			http.Error(w, "Error reverting status", http.StatusInternalServerError)
-			return
-		}
-		log.Println("EditStatus reverted because of publish failure")
-		http.Error(w, "Error publishing message", http.StatusInternalServerError)
+		fmt.Printf("Error editing application: %v\n", err)
+		http.Error(w, "Error editing application", http.StatusInternalServerError)
 		return
 	}
 
-	log.Println("Status edited")
+	h.relay.Wake()
 
-	// to reduce amount of reads in this single request, update status count AFTER verifying publish success
-	// this means we don't have to revert status count on top of reverting the edit operation. this DOES
-	// introduce small window of inconsistency but this is reducing costs and reducing complexity
-	// transaction is used to ensure that if an increment fails, decrement wont happen and vice versa
-	err = h.FirestoreClient.RunTransaction(r.Context(), func(ctx context.Context, tx *firestore.Transaction) error {
-		userDoc := h.FirestoreClient.Collection("users").Doc(email)
-		doc, err := tx.Get(userDoc)
-		if err != nil {
-			return err
-		}
-		
-		// get old status count
-		oldStatusCount := 0
-		countKey := fmt.Sprintf("%s_count", strings.ToLower(string(EditApplicationStatusRequest.OldStatus)))
-		if val, exists := doc.Data()[countKey]; exists {
-			if count, ok := val.(int64); ok {
-				oldStatusCount = int(count)
-			}
-		}
+	log.Println("Status edited")
 
-		// no block for new status count because it will always be incremented
-		updates := []firestore.Update{
-			{Path: fmt.Sprintf("%s_count", strings.ToLower(string(newStatus))), Value: firestore.Increment(1)},
-		}
-		
-		// only decrement old status count if it was greater than 0
-		if oldStatusCount > 0 {
-			updates = append(updates, firestore.Update{
-				Path: countKey, Value: firestore.Increment(-1),
-			})
-		}
-		
-		return tx.Update(userDoc, updates)
-	})
+	// status counts are updated AFTER the status is written. this DOES introduce a small window
+	// of inconsistency if this fails but this is reducing costs and reducing complexity
+	// new status count is always incremented, old status count only decremented if it was greater than 0
+	err = h.incrementCounters(r.Context(), userID, userstore.StatusChange(EditApplicationStatusRequest.OldStatus, newStatus))
 	if err != nil {
 		fmt.Printf("Error updating status count: %v\n", err)
 		http.Error(w, "Error updating status count", http.StatusInternalServerError)
@@ -691,18 +609,11 @@ func (h *Handler) EditApplication(w http.ResponseWriter, r *http.Request) {
 	log.Println("[*] EditApplication [*]")
 	log.Println("-----------------")
 
-	email, err := auth.IsAuthenticated(r)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	log.Println("User authenticated")
+	userID := auth.UserID(r)
 
 	// extract json from request body
 	var editApplicationRequest EditApplicationRequest
-	err = json.NewDecoder(r.Body).Decode(&editApplicationRequest)
+	err := json.NewDecoder(r.Body).Decode(&editApplicationRequest)
 	if err != nil {
 		http.Error(w, "Error decoding request body", http.StatusBadRequest)
 		return
@@ -715,7 +626,7 @@ func (h *Handler) EditApplication(w http.ResponseWriter, r *http.Request) {
 	// unfortunately though Algolia **does** require all fields to be sent regardless
 	// so this is just a little optimization on the Firestore side
 	// NOTE: the EditApplicationRequest struct's fields are still required for the publish message
-	// and possible rollbacks, so frontend cannot make the optimization of what to send
+	// so frontend cannot make the optimization of what to send
 	changedFields := make(map[string]interface{}, 0)
 
 	// no loops or function calls to reduce memory overhead, big ugly if statements
@@ -738,62 +649,35 @@ func (h *Handler) EditApplication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// create updates array for Firestore
-	updates := make([]firestore.Update, len(changedFields))
-	i := 0
-	for key, value := range changedFields {
-		updates[i] = firestore.Update{Path: key, Value: value}
-		i++
+	// bigquery does nothing on application edits, only status changes
+	// this is why we need a diffentiating operation for application edits
+	// is this wasted data transfer? yea... but its not a lot of data and
+	// not worth setting up different messaging pipeline when just one operation is not supported by BigQuery
+	message, err := h.newMessage(&events.EditApplication{
+		Header:    events.Header{UserID: userID},
+		ObjectID:  applicationID,
+		Role:      editApplicationRequest.Role,
+		Company:   editApplicationRequest.Company,
+		Location:  editApplicationRequest.Location,
+		Link:      editApplicationRequest.Link,
+		Timestamp: time.Now().Add(12 * time.Hour).Unix(),
+	})
+	if err != nil {
+		messageError(w, err, "Error editing application")
+		return
 	}
 
-	_, err = h.FirestoreClient.Collection("users").Doc(email).Collection("applications").Doc(applicationID).Update(r.Context(), updates)
+	err = h.store.UpdateApplication(r.Context(), userID, applicationID, changedFields, message)
 	if err != nil {
 		fmt.Printf("Error editing application: %v\n", err)
 		http.Error(w, "Error editing application", http.StatusInternalServerError)
 		return
 	}
 
-	log.Println("Application edited")
-
-	// bigquery does nothing on application edits, only status changes
-	// this is why we need a diffentiating operation for application edits
-	// is this wasted data transfer? yea... but its not a lot of data and
-	// not worth setting up different messaging pipeline when just one operation is not supported by BigQuery
-	message := map[string]interface{}{
-		"operation":   "editApplication",
-		"email":       email,
-		"company":     editApplicationRequest.Company,
-		"link":        editApplicationRequest.Link,
-		"location":    editApplicationRequest.Location,
-		"role":        editApplicationRequest.Role,
-		"objectID":    applicationID,
-		"timestamp":   time.Now().Add(12 * time.Hour).Unix(),
-	}
-
-	err = h.publishMessage(message)
-	if err != nil {
-		// revert status if publish fails
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		_, err = h.FirestoreClient.Collection("users").Doc(email).Collection("applications").Doc(applicationID).Update(ctx, []firestore.Update{
-			{Path: "role", Value: editApplicationRequest.OldRole},
-			{Path: "company", Value: editApplicationRequest.OldCompany},
-			{Path: "location", Value: editApplicationRequest.OldLocation},
-			{Path: "link", Value: editApplicationRequest.OldLink},
-		})
-		if err != nil {
-			fmt.Printf("Error reverting application: %v\n", err)
-			http.Error(w, "Error reverting application edit", http.StatusInternalServerError)
-			return
-		}
-		log.Println("EditApplication reverted because of publish failure")
-		http.Error(w, "Error publishing message", http.StatusInternalServerError)
-		return
-	}
+	h.relay.Wake()
 
 	log.Println("Application edited")
-	log.Println("DB and PubSub operations success, returning success for eager loading")
+	log.Println("DB operations success, returning success for eager loading")
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -802,21 +686,14 @@ func (h *Handler) RevertStatus(w http.ResponseWriter, r *http.Request) {
 	log.Println("[*] RevertStatus [*]")
 	log.Println("-----------------")
 
-	email, err := auth.IsAuthenticated(r)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	log.Println("User authenticated")
+	userID := auth.UserID(r)
 
 	// extract the jobID requested to revert, revert it in database and send to PubSub
 	// NOTE: BigQuery is not optimized for single-row deletes, so we should simply set a 
 	// "reverted" flag to the most recent event and ensure analytics only reads non-flagged events
 	// DeleteApplication and DeleteUser are okay because they are multi-row deletes and much less frequent
 	var revertApplicationStatusRequest RevertApplicationStatusRequest
-	err = json.NewDecoder(r.Body).Decode(&revertApplicationStatusRequest)
+	err := json.NewDecoder(r.Body).Decode(&revertApplicationStatusRequest)
 	if err != nil {
 		http.Error(w, "Error decoding request body", http.StatusBadRequest)
 		return
@@ -824,73 +701,29 @@ func (h *Handler) RevertStatus(w http.ResponseWriter, r *http.Request) {
 
 	// two cases:
 	// 1: operation is most recent (get from BigQuery); we have to update Algolia and Firestore to the previous status
-	//	  compensating transaction is needed here because latest state may have changed
 	// 2: operation not most recent; simply flag the operation as "reverted" in BigQuery. Algolia and Firestore still have most recent status
-	//	  compensating transaction is not needed here because latest state never changed
 	operationID := revertApplicationStatusRequest.OperationID
 	jobID := revertApplicationStatusRequest.ID
 
-	// get the two max event times; the first is to determine if case 2, the second is to revert if case 1
-	q := h.bigQueryClient.Query(`
-		SELECT operationID, status, event_time, operation
-		FROM applications_data.applications
-		WHERE email = @email
-		AND jobID = @jobID
-		AND operation NOT IN ('revert', 'add') -- cannot revert a revert or an add
-		ORDER BY event_time DESC
-		LIMIT 2
-	`)
-	q.Parameters = []bigquery.QueryParameter{
-		{Name: "email", Value: email},
-		{Name: "jobID", Value: jobID},
-	}
-
-	job, err := q.Run(r.Context())
-	if err != nil {
-		fmt.Printf("Error getting max event time: %v\n", err)
-		http.Error(w, "Error reverting status", http.StatusInternalServerError)
-		return
-	}
-
-	_, err = job.Wait(r.Context())
+	// get the two latest status changes; the first is to determine if case 2, the second is to revert if case 1
+	history, err := h.events.StatusHistory(r.Context(), userID, jobID, 2)
 	if err != nil {
 		fmt.Printf("Error getting max event time: %v\n", err)
 		http.Error(w, "Error reverting status", http.StatusInternalServerError)
 		return
 	}
 
-	it, err := job.Read(r.Context())
-	if err != nil {
-		fmt.Printf("Error reading query results: %v\n", err)
-		http.Error(w, "Error reverting status", http.StatusInternalServerError)
-		return
-	}
-
 	var latestOperationID string
-	var currStatus string	// just in case we need to rollback
+	var currStatus string	// for status counts
 	var prevStatus string
-	rowCount := 0
+	rowCount := len(history)
 
-	for {
-		var row []bigquery.Value
-		err := it.Next(&row)
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			fmt.Printf("Error getting max event time: %v\n", err)
-			http.Error(w, "Error reverting status", http.StatusInternalServerError)
-			return
-		}	
-
-		if rowCount == 0{
-			latestOperationID = row[0].(string)    
-			currStatus = row[1].(string)           
-		} else if rowCount == 1 {
-			prevStatus = row[1].(string)          
-		}
-
-		rowCount++
+	if rowCount > 0 {
+		latestOperationID = history[0].OperationID
+		currStatus = history[0].Status
+	}
+	if rowCount > 1 {
+		prevStatus = history[1].Status
 	}
 
 	// if there's no non-revert/add operations, can't revert
@@ -901,61 +734,60 @@ func (h *Handler) RevertStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var operation string
+	var event events.Event
 
 	if latestOperationID != operationID {
 		// case 2: flag as reverted in BQ. Algolia and Firestore are already up to date
 		operation = "revert"
+		event = &events.Revert{
+			Header:      events.Header{UserID: userID},
+			ObjectID:    jobID,
+			OperationID: operationID,
+			Status:      prevStatus,
+		}
 		fmt.Println("Case 2: Reverting deeper in history -- only BigQuery needs to be updated")
 	} else {
 		// case 1: Firestore and Algolia need to be updated to previous status (secondLatestOperation)
 		operation = "revertLatest"
+		event = &events.RevertLatest{
+			Header:      events.Header{UserID: userID},
+			ObjectID:    jobID,
+			OperationID: operationID,
+			Status:      prevStatus,
+		}
 		fmt.Println("Case 1: Reverting most recent operation -- Firestore and Algolia need to be updated as well")
 	}
 
-	if operation == "revertLatest" {
-		// try to revert status in Firestore
-		_, err = h.FirestoreClient.Collection("users").Doc(email).Collection("applications").Doc(jobID).Update(r.Context(), []firestore.Update{
-			{Path: "status", Value: prevStatus},
-		})
-		// failed to revert, don't send message. at this point we haven't done anything
-		// to other services so we can just return an error
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			http.Error(w, "Error reverting status", http.StatusInternalServerError)
-			return
-		}
+	message, err := h.newMessage(event)
+	if err != nil {
+		messageError(w, err, "Error reverting status")
+		return
 	}
-
-	log.Println("Status reverted in Firestore, continuing to publish message")
-
-	message := map[string]interface{}{
-		"operation": operation,
-		"email":     email,
-		"objectID":  jobID,
-		"operationID": operationID,
-		"status":    prevStatus,
+	// the event log row, for stores that record it with the write (Postgres)
+	message.Event = &userstore.Event{
+		OperationID: operationID,
+		UserID:      userID,
+		JobID:       jobID,
+		Operation:   "revert",
 	}
 
-	err = h.publishMessage(message)
-	// revertLatest is a special case -- need to revert Firestore status if publish fails
-	if err != nil && operation == "revertLatest" {
-		// revert status if publish fails
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		_, err = h.FirestoreClient.Collection("users").Doc(email).Collection("applications").Doc(jobID).Update(ctx, []firestore.Update{
-			{Path: "status", Value: currStatus},
-		})
-		if err != nil {
-			fmt.Printf("Error reverting application: %v\n", err)
-			http.Error(w, "Error reverting application edit", http.StatusInternalServerError)
-			return
-		}
-		log.Println("RevertStatus reverted because of publish failure")
-		http.Error(w, "Error publishing message", http.StatusInternalServerError)
+	if operation == "revertLatest" {
+		// revert status in Firestore along with the message
+		err = h.store.UpdateApplication(r.Context(), userID, jobID, map[string]interface{}{
+			"status": prevStatus,
+		}, message)
+	} else {
+		// nothing changes in Firestore, only the message is stored
+		err = h.store.Enqueue(r.Context(), message)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error reverting status", http.StatusInternalServerError)
 		return
 	}
 
+	h.relay.Wake()
+
 	log.Println("RevertStatus success")
 	// finally, we can decrement status counts (only if we're reverting latest operation
 	// remember: we store latest status in Firestore. so, if we're reverting latest operation,
@@ -963,42 +795,18 @@ func (h *Handler) RevertStatus(w http.ResponseWriter, r *http.Request) {
 	// if reverting deep, nothing to do because Firestore only stores latest state
 	if operation == "revertLatest" {
 		log.Println("Latest operation reverted, decrementing/incrementing status counts")
-		// run a transaction to ensure consistency in decrementing/incrementing status counts
-		err = h.FirestoreClient.RunTransaction(r.Context(), func(ctx context.Context, tx *firestore.Transaction) error {
-			userDoc := h.FirestoreClient.Collection("users").Doc(email)
-			doc, err := tx.Get(userDoc)
-			if err != nil {
-				return err
-			}
-			
-			currentStatusCount := 0
-			currentCountKey := fmt.Sprintf("%s_count", strings.ToLower(currStatus))
-			if val, exists := doc.Data()[currentCountKey]; exists {
-				if count, ok := val.(int64); ok {
-					currentStatusCount = int(count)
-				}
-			}
-			
-			// no blocking on incrementing previous state
-			updates := []firestore.Update{
-				{Path: fmt.Sprintf("%s_count", strings.ToLower(prevStatus)), Value: firestore.Increment(1)},
-			}
-			
-			// only decrement if current status count > 0
-			if currentStatusCount > 0 {
-				updates = append(updates, firestore.Update{
-					Path: currentCountKey, Value: firestore.Increment(-1),
-				})
-			}
-			
-			return tx.Update(userDoc, updates)
-		})
+		// the store applies both atomically; no blocking on incrementing previous state
+		// and current status count is only decremented if > 0
+		err = h.incrementCounters(r.Context(), userID, userstore.StatusChange(ApplicationStatus(currStatus), ApplicationStatus(prevStatus)))
 		if err != nil {
 			fmt.Printf("Error updating status count: %v\n", err)
 			http.Error(w, "Error updating status count", http.StatusInternalServerError)
 			return
 		}
+	}
 
+	// both revert cases only flag the operation in the event log
+	if operation == "revertLatest" {
 		// if latest status decrement, send to frontend for optimistic ui
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -1014,38 +822,44 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	log.Println("[*] DeleteUser [*]")
 	log.Println("-----------------")
 
-	email, err := auth.IsAuthenticated(r)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	log.Println("User authenticated")
+	userID := auth.UserID(r)
 
 	// send to algolia to delete all applications associated with this user
-	message := map[string]interface{}{
-		"operation": "userDelete",
-		"email":     email,
-	}
-
-	err = h.publishMessage(message)
+	message, err := h.newMessage(&events.UserDelete{
+		Header: events.Header{UserID: userID},
+	})
 	if err != nil {
-		fmt.Printf("Error publishing message: %v\n", err)
-		http.Error(w, "Error deleting user", http.StatusInternalServerError)
+		messageError(w, err, "Error deleting user")
 		return
 	}
+	// the event log row, for stores that record it with the write (Postgres)
+	message.Event = &userstore.Event{
+		UserID:    userID,
+		Operation: "userDelete",
+	}
 
-	// since we can't exactly revert a user deletion, we will delete only if publish is successful
-	err = h.deleteUserFromFirestore(email, 10)
+	// a user might just close the tab after running delete, so we need to ensure
+	// that the context is not cancelled and the delete still goes through
+	err = h.store.DeleteUser(context.Background(), userID, message)
 	if err != nil {
 		fmt.Printf("Error deleting user: %v\n", err)
 		http.Error(w, "Error deleting user", http.StatusInternalServerError)
 		return
 	}
 
+	h.relay.Wake()
+
 	log.Println("User deleted")
 
+	// the account is gone, so its sessions and personal access tokens go too
+	if err := h.authenticator.RevokeCredentials(context.Background(), userID); err != nil {
+		fmt.Printf("Error revoking credentials: %v\n", err)
+	}
+	// and so does everything they shared or were shared
+	if err := h.grants.DeleteUserGrants(context.Background(), userID); err != nil {
+		fmt.Printf("Error deleting grants: %v\n", err)
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -1053,16 +867,13 @@ func (h *Handler) GetApplicationTimeline(w http.ResponseWriter, r *http.Request)
 	log.Println("[*] GetApplicationTimeline [*]")
 	log.Println("-----------------")
 
-	email, err := auth.IsAuthenticated(r)
+	// ?user= reads someone who shared their applications with the caller (see sharing.go)
+	userID, err := h.targetUser(r, userstore.GrantScopeApplications)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		targetUserError(w, err)
 		return
 	}
 
-	log.Println("User authenticated")
-
-
 	var getApplicationTimelineRequest ApplicationTimelineRequest
 	err = json.NewDecoder(r.Body).Decode(&getApplicationTimelineRequest)
 	if err != nil {
@@ -1072,71 +883,21 @@ func (h *Handler) GetApplicationTimeline(w http.ResponseWriter, r *http.Request)
 
 	jobID := getApplicationTimelineRequest.ID
 
-	q := h.bigQueryClient.Query(`
-		SELECT operationID, operation, status, event_time
-		FROM applications_data.applications
-		WHERE email = @email
-		AND jobID = @jobID
-		AND operation != 'revert'
-		ORDER BY event_time DESC
-	`)
-	q.Parameters = []bigquery.QueryParameter{
-		{Name: "email", Value: email},
-		{Name: "jobID", Value: jobID},
-	}
-
-	job, err := q.Run(r.Context())
+	events, err := h.events.Timeline(r.Context(), userID, jobID)
 	if err != nil {
 		fmt.Printf("Error getting timeline: %v\n", err)
 		http.Error(w, "Error getting timeline", http.StatusInternalServerError)
 		return
 	}
 
-	status, err := job.Wait(r.Context())
-	if err != nil {
-		fmt.Printf("Error getting timeline: %v\n", err)
-		http.Error(w, "Error getting timeline", http.StatusInternalServerError)
-		return
-	}
-	if err := status.Err(); err != nil {
-		fmt.Printf("Job completed with erorr: %v\n", err)
-		http.Error(w, "Job completed with error", http.StatusInternalServerError)
-		return
-	}
-
-
-	it, err := job.Read(r.Context())
-	if err != nil {
-		fmt.Printf("Error reading timeline: %v\n", err)
-		http.Error(w, "Error reading timeline", http.StatusInternalServerError)
-		return
-	}
-
-	type Row struct {
-		OperationID string `bigquery:"operationID"`
-		Operation   string `bigquery:"operation"`
-		Status      string `bigquery:"status"`
-		EventTime   time.Time `bigquery:"event_time"`
-	}
-
 	var rows []Operation
 
-	for {
-		var row Row
-		err := it.Next(&row)
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			fmt.Printf("Error iterating timeline: %v\n", err)
-			http.Error(w, "Error iterating timeline", http.StatusInternalServerError)
-			return
-		}
+	for _, event := range events {
 		rows = append(rows, Operation{
-			OperationID: row.OperationID,
-			Operation:   row.Operation,
-			Status:      row.Status,
-			EventTime:   row.EventTime,
+			OperationID: event.OperationID,
+			Operation:   event.Operation,
+			Status:      event.Status,
+			EventTime:   event.EventTime,
 		})
 	}
 
@@ -1146,90 +907,37 @@ func (h *Handler) GetApplicationTimeline(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(rows)
 }
 
-// publishes to both algolia and bigquery topics
-func (h *Handler) publishMessage(message map[string]interface{}) error {
-	// new context here -- message should be published regardless of context cancellation
-	// for consistency enforcement. use 10 second timeout to prevent indefinite blocking
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	messageBody, err := json.Marshal(message)
-	if err != nil {
-		fmt.Printf("Error marshaling message: %v\n", err)
-		return err
+// builds the message for a store write; the outbox relay publishes it to the applications topic
+// (algolia and bigquery both subscribe to this topic) once the write is committed
+// the event is validated here, so a bad message fails the request (ErrInvalidRequest) instead of a consumer
+// each user has their own ordering key: their events stay in order, but other users' events don't
+// queue up behind them (or behind a failed publish of theirs, see outbox.Relay)
+func (h *Handler) newMessage(event events.Event) (userstore.OutboxMessage, error) {
+	messageBody, err := events.Encode(event)
+	if errors.Is(err, events.ErrInvalidEvent) {
+		return userstore.OutboxMessage{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
 	}
-
-	// hold the publish result. this is necessary for
-	// our strong consistency model
-	var result *pubsub.PublishResult
-
-	// attempt to publish message (algolia and bigquery both subscribe to this topic)
-	r := h.pubsubTopic.Publish(ctx, &pubsub.Message{
-		Data:        messageBody,
-		OrderingKey: h.orderingKey,
-	})
-
-	// if message publish fails, propagate an error to revert Firestore operation
-	result = r
-	id, err := result.Get(ctx)
 	if err != nil {
-		fmt.Printf("Error publishing message: %v\n", err)
-		return err
+		return userstore.OutboxMessage{}, err
 	}
 
-	fmt.Printf("Published message with ID: %s\n", id)
-
-	return nil
+	return userstore.NewOutboxMessage(messageBody, event.EventHeader().UserID), nil
 }
 
-// Firestore does not delete subcollections automatically
-// so, delete all documents in users/{email}/applications
-// then, delete users/{email}
-func (h *Handler) deleteUserFromFirestore(email string, batchSize int) error {
-	// a user might just close the tab after running delete, so we need to ensure
-	// that the context is not cancelled and the delete still goes through
-	ctx := context.Background()
-
-	// delete subcollection FIRST (just applications)
-	applicationsCollection := h.FirestoreClient.Collection("users").Doc(email).Collection("applications")
-	bulkWriter := h.FirestoreClient.BulkWriter(ctx)
-
-	// for each batch...
-	for {
-		iter := applicationsCollection.Limit(batchSize).Documents(ctx)
-		numDeleted := 0
-
-		// for each document...
-		for {
-			doc, err := iter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				return fmt.Errorf("Failed to iterate: %v", err)
-			}
-
-			bulkWriter.Delete(doc.Ref)
-			numDeleted++
-		}
-
-		if numDeleted == 0 {
-			bulkWriter.End()
-			break
-		}
-
-		bulkWriter.Flush()
+// for newMessage errors: the client gets the validation error, anything else is on us
+func messageError(w http.ResponseWriter, err error, message string) {
+	fmt.Printf("Error encoding message: %v\n", err)
+	if errors.Is(err, ErrInvalidRequest) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	http.Error(w, message, http.StatusInternalServerError)
+}
 
-	fmt.Println("Applications subcollection deleted for user", email)
-
-	// delete user document
-	_, err := h.FirestoreClient.Collection("users").Doc(email).Delete(ctx)
-	if err != nil {
-		return fmt.Errorf("Failed to delete user document: %v", err)
+// nothing to write when the deltas cancel out (e.g. a status "changed" to itself)
+func (h *Handler) incrementCounters(ctx context.Context, userID string, deltas map[string]int64) error {
+	if len(deltas) == 0 {
+		return nil
 	}
-
-	fmt.Println("User document deleted for user", email)
-
-	return nil
-}
\ No newline at end of file
+	return h.store.IncrementCounters(ctx, userID, deltas)
+}
@@ -0,0 +1,300 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/copium-dev/copium/go/service/auth"
+	"github.com/copium-dev/copium/go/service/user/outbox"
+	"github.com/copium-dev/copium/go/service/user/userstore"
+)
+
+const testUserID = "user-1"
+
+// a fixed status history for RevertStatus; nothing else reads the event log in these tests
+type fakeEventLog struct {
+	history []userstore.Event
+}
+
+func (l *fakeEventLog) Timeline(ctx context.Context, userID string, jobID string) ([]userstore.Event, error) {
+	return l.history, nil
+}
+
+func (l *fakeEventLog) StatusHistory(ctx context.Context, userID string, jobID string, limit int) ([]userstore.Event, error) {
+	if len(l.history) > limit {
+		return l.history[:limit], nil
+	}
+	return l.history, nil
+}
+
+// a handler over a MemoryStore with one user who has one application in the given status
+// and a matching counter. the relay is never run, so messages stay in the outbox
+func newTestHandler(t *testing.T, status ApplicationStatus, history ...userstore.Event) (*Handler, *userstore.MemoryStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	store := userstore.NewMemoryStore()
+	if err := store.CreateUser(ctx, testUserID, "user@example.com"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := store.AddApplication(ctx, testUserID, userstore.Application{ID: "app-1", Status: status}); err != nil {
+		t.Fatalf("AddApplication: %v", err)
+	}
+	if err := store.IncrementCounters(ctx, testUserID, map[string]int64{userstore.StatusCounter(status): 1}); err != nil {
+		t.Fatalf("IncrementCounters: %v", err)
+	}
+
+	handler := NewHandler(store, userstore.NewMemoryGrantStore(), &fakeEventLog{history: history}, nil, "users", outbox.NewRelay(store, nil), nil)
+	return handler, store
+}
+
+func serve(t *testing.T, handle http.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{
+		UserID: testUserID,
+		Scopes: []string{auth.ScopeRead, auth.ScopeWrite},
+	}))
+
+	w := httptest.NewRecorder()
+	handle(w, r)
+	return w
+}
+
+func counters(t *testing.T, store *userstore.MemoryStore) map[string]int64 {
+	t.Helper()
+
+	user, err := store.GetUser(context.Background(), testUserID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	counts := make(map[string]int64)
+	for _, counter := range userstore.StatusCounters() {
+		counts[counter], _ = user[counter].(int64)
+	}
+	return counts
+}
+
+func pending(t *testing.T, store *userstore.MemoryStore) int {
+	t.Helper()
+
+	msgs, err := store.PendingMessages(context.Background(), nil, 100)
+	if err != nil {
+		t.Fatalf("PendingMessages: %v", err)
+	}
+	return len(msgs)
+}
+
+func TestEditStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   ApplicationStatus
+		request   EditApplicationStatusRequest
+		wantCode  int
+		wantCount map[string]int64
+		wantMsgs  int
+	}{
+		{
+			name:      "moves the application between counters",
+			current:   "Applied",
+			request:   EditApplicationStatusRequest{ID: "app-1", Status: "Screen", OldStatus: "Applied", AppliedDate: 1700000000},
+			wantCode:  http.StatusOK,
+			wantCount: map[string]int64{"applied_count": 0, "screen_count": 1},
+			wantMsgs:  1,
+		},
+		{
+			name:      "same status is a no-op",
+			current:   "Applied",
+			request:   EditApplicationStatusRequest{ID: "app-1", Status: "Applied", OldStatus: "Applied", AppliedDate: 1700000000},
+			wantCode:  http.StatusOK,
+			wantCount: map[string]int64{"applied_count": 1},
+			wantMsgs:  0,
+		},
+		{
+			name:      "unknown status",
+			current:   "Applied",
+			request:   EditApplicationStatusRequest{ID: "app-1", Status: "Hired", OldStatus: "Applied", AppliedDate: 1700000000},
+			wantCode:  http.StatusBadRequest,
+			wantCount: map[string]int64{"applied_count": 1},
+			wantMsgs:  0,
+		},
+		{
+			name:      "missing application",
+			current:   "Applied",
+			request:   EditApplicationStatusRequest{ID: "app-2", Status: "Screen", OldStatus: "Applied", AppliedDate: 1700000000},
+			wantCode:  http.StatusInternalServerError,
+			wantCount: map[string]int64{"applied_count": 1, "screen_count": 0},
+			wantMsgs:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, store := newTestHandler(t, tt.current)
+
+			w := serve(t, handler.EditStatus, tt.request)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+
+			counts := counters(t, store)
+			for counter, want := range tt.wantCount {
+				if counts[counter] != want {
+					t.Errorf("%s = %d, want %d", counter, counts[counter], want)
+				}
+			}
+			if got := pending(t, store); got != tt.wantMsgs {
+				t.Errorf("outbox has %d messages, want %d", got, tt.wantMsgs)
+			}
+		})
+	}
+}
+
+func TestRevertStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     ApplicationStatus
+		history     []userstore.Event
+		operationID string
+		wantCode    int
+		wantStatus  ApplicationStatus
+		wantCount   map[string]int64
+	}{
+		{
+			name:    "latest operation moves the application back",
+			current: "Screen",
+			history: []userstore.Event{
+				{OperationID: "op-2", Status: "Screen"},
+				{OperationID: "op-1", Status: "Applied"},
+			},
+			operationID: "op-2",
+			wantCode:    http.StatusOK,
+			wantStatus:  "Applied",
+			wantCount:   map[string]int64{"applied_count": 1, "screen_count": 0},
+		},
+		{
+			// two edits in a row to the same status; reverting the latest can't drift the counter
+			name:    "latest operation with the same previous status",
+			current: "Screen",
+			history: []userstore.Event{
+				{OperationID: "op-2", Status: "Screen"},
+				{OperationID: "op-1", Status: "Screen"},
+			},
+			operationID: "op-2",
+			wantCode:    http.StatusOK,
+			wantStatus:  "Screen",
+			wantCount:   map[string]int64{"screen_count": 1},
+		},
+		{
+			name:    "older operation only flags the event",
+			current: "Screen",
+			history: []userstore.Event{
+				{OperationID: "op-2", Status: "Screen"},
+				{OperationID: "op-1", Status: "Applied"},
+			},
+			operationID: "op-1",
+			wantCode:    http.StatusOK,
+			wantStatus:  "Screen",
+			wantCount:   map[string]int64{"applied_count": 0, "screen_count": 1},
+		},
+		{
+			name:        "nothing to revert",
+			current:     "Applied",
+			operationID: "op-1",
+			wantCode:    http.StatusBadRequest,
+			wantStatus:  "Applied",
+			wantCount:   map[string]int64{"applied_count": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, store := newTestHandler(t, tt.current, tt.history...)
+
+			w := serve(t, handler.RevertStatus, RevertApplicationStatusRequest{OperationID: tt.operationID, ID: "app-1"})
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+
+			app, err := store.GetApplication(context.Background(), testUserID, "app-1")
+			if err != nil {
+				t.Fatalf("GetApplication: %v", err)
+			}
+			if app.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", app.Status, tt.wantStatus)
+			}
+
+			counts := counters(t, store)
+			for counter, want := range tt.wantCount {
+				if counts[counter] != want {
+					t.Errorf("%s = %d, want %d", counter, counts[counter], want)
+				}
+			}
+		})
+	}
+}
+
+func TestAddApplication(t *testing.T) {
+	handler, store := newTestHandler(t, "Applied")
+
+	w := serve(t, handler.AddApplication, AddApplicationRequest{
+		Role:        "Software Engineer",
+		Company:     "Copium",
+		Location:    "Remote",
+		AppliedDate: 1700000000,
+		Status:      "Applied",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d (%s)", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var response struct {
+		ObjectID string `json:"objectID"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if _, err := store.GetApplication(context.Background(), testUserID, response.ObjectID); err != nil {
+		t.Errorf("GetApplication(%q): %v", response.ObjectID, err)
+	}
+
+	if counts := counters(t, store); counts["applied_count"] != 2 {
+		t.Errorf("applied_count = %d, want 2", counts["applied_count"])
+	}
+	if got := pending(t, store); got != 1 {
+		t.Errorf("outbox has %d messages, want 1", got)
+	}
+}
+
+func TestAddApplicationInvalid(t *testing.T) {
+	requests := map[string]AddApplicationRequest{
+		"unknown status":       {Role: "Engineer", Company: "Copium", AppliedDate: 1700000000, Status: "Hired"},
+		"missing applied date": {Role: "Engineer", Company: "Copium", Status: "Applied"},
+	}
+
+	for name, request := range requests {
+		t.Run(name, func(t *testing.T) {
+			handler, store := newTestHandler(t, "Applied")
+
+			w := serve(t, handler.AddApplication, request)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status code = %d, want %d (%s)", w.Code, http.StatusBadRequest, w.Body.String())
+			}
+			if counts := counters(t, store); counts["applied_count"] != 1 {
+				t.Errorf("applied_count = %d, want 1", counts["applied_count"])
+			}
+			if got := pending(t, store); got != 0 {
+				t.Errorf("outbox has %d messages, want 0", got)
+			}
+		})
+	}
+}
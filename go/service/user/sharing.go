@@ -0,0 +1,380 @@
+package user
+
+// sharing lets a student give an advisor or mentor read-only access to their applications and/or
+// analytics (see userstore/grants.go):
+// - CreateInvitation: the owner invites an email; the invitation ID goes in the link they send
+// - AcceptInvitation: the invitee (signed in with that email) turns it into a grant
+// - ListSharing: pending invitations, grants given and grants received
+// - CancelInvitation / RevokeGrant: either side can end it at any time
+// Dashboard, Profile and GetApplicationTimeline take ?user={ownerID} to read someone else's data
+// (targetUser); nothing that writes does
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/copium-dev/copium/go/service/auth"
+	"github.com/copium-dev/copium/go/service/user/userstore"
+)
+
+const (
+	invitationTTL = 7 * 24 * time.Hour
+	// pending invitations per user, so nobody fills the table
+	maxInvitations = 20
+)
+
+var ErrNotShared = errors.New("this user has not shared that with you")
+
+type createInvitationRequest struct {
+	Email  string   `json:"email"`
+	Role   string   `json:"role"`
+	Scopes []string `json:"scopes"`
+}
+
+type invitationIDRequest struct {
+	ID string `json:"id"`
+}
+
+// the owner sends granteeID to stop sharing; the grantee sends ownerID to give access back
+type revokeGrantRequest struct {
+	OwnerID   string `json:"ownerID"`
+	GranteeID string `json:"granteeID"`
+}
+
+type invitationResponse struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// one side of a grant; the user is whoever is on the other side
+type grantResponse struct {
+	UserID    string    `json:"userID"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type sharingResponse struct {
+	Invitations []invitationResponse `json:"invitations"`
+	// people who can read this user's data
+	Given []grantResponse `json:"given"`
+	// people whose data this user can read
+	Received []grantResponse `json:"received"`
+}
+
+// the user whose data the request reads: the caller, or ?user={ownerID} if that user granted the
+// caller the scope. returns ErrNotShared otherwise
+func (h *Handler) targetUser(r *http.Request, scope string) (string, error) {
+	userID := auth.UserID(r)
+
+	ownerID := r.URL.Query().Get("user")
+	if ownerID == "" || ownerID == userID {
+		return userID, nil
+	}
+
+	grant, err := h.grants.GetGrant(r.Context(), ownerID, userID)
+	if errors.Is(err, userstore.ErrGrantNotFound) {
+		return "", ErrNotShared
+	}
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(grant.Scopes, scope) {
+		return "", ErrNotShared
+	}
+	return ownerID, nil
+}
+
+// writes the error from targetUser
+func targetUserError(w http.ResponseWriter, err error) {
+	fmt.Printf("Error: %v\n", err)
+	if errors.Is(err, ErrNotShared) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	http.Error(w, "Error checking access", http.StatusInternalServerError)
+}
+
+// POST /user/sharing/invite {"email": "...", "role": "advisor" | "mentor", "scopes": ["applications:read", "analytics:read"]}
+func (h *Handler) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] CreateInvitation [*]")
+	log.Println("-----------------")
+
+	userID := auth.UserID(r)
+
+	var request createInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(request.Email))
+	if !strings.Contains(email, "@") {
+		http.Error(w, "Invalid email", http.StatusBadRequest)
+		return
+	}
+	if request.Role != userstore.RoleAdvisor && request.Role != userstore.RoleMentor {
+		http.Error(w, "Role must be advisor or mentor", http.StatusBadRequest)
+		return
+	}
+	if len(request.Scopes) == 0 {
+		http.Error(w, "Share at least one of applications:read or analytics:read", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range request.Scopes {
+		if scope != userstore.GrantScopeApplications && scope != userstore.GrantScopeAnalytics {
+			http.Error(w, "Unknown scope: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// expired invitations can't be accepted, so they don't count
+	now := time.Now()
+	existing, err := h.grants.ListInvitations(r.Context(), userID, now)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error creating invitation", http.StatusInternalServerError)
+		return
+	}
+	if len(existing) >= maxInvitations {
+		http.Error(w, fmt.Sprintf("At most %d pending invitations, cancel one first", maxInvitations), http.StatusConflict)
+		return
+	}
+
+	invitation := userstore.Invitation{
+		ID:        userstore.NewID(),
+		OwnerID:   userID,
+		Email:     email,
+		Role:      request.Role,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(request.Scopes))),
+		CreatedAt: now,
+		ExpiresAt: now.Add(invitationTTL),
+	}
+	if err := h.grants.CreateInvitation(r.Context(), invitation); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error creating invitation", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Invitation created")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newInvitationResponse(invitation))
+}
+
+// POST /user/sharing/accept {"id": "..."}; the caller's email must be the one that was invited
+func (h *Handler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] AcceptInvitation [*]")
+	log.Println("-----------------")
+
+	userID := auth.UserID(r)
+
+	var request invitationIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	invitation, err := h.grants.GetInvitation(r.Context(), request.ID)
+	if errors.Is(err, userstore.ErrInvitationNotFound) {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error accepting invitation", http.StatusInternalServerError)
+		return
+	}
+	if invitation.Expired(time.Now()) {
+		http.Error(w, "Invitation expired", http.StatusGone)
+		return
+	}
+	if invitation.OwnerID == userID {
+		http.Error(w, "You can't accept your own invitation", http.StatusBadRequest)
+		return
+	}
+
+	email, err := h.userEmail(r, userID)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error accepting invitation", http.StatusInternalServerError)
+		return
+	}
+	if !strings.EqualFold(email, invitation.Email) {
+		http.Error(w, "This invitation is for another email", http.StatusForbidden)
+		return
+	}
+
+	_, err = h.grants.AcceptInvitation(r.Context(), invitation.ID, userID, time.Now())
+	if errors.Is(err, userstore.ErrInvitationNotFound) {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error accepting invitation", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Invitation accepted")
+	w.WriteHeader(http.StatusOK)
+}
+
+// GET /user/sharing
+func (h *Handler) ListSharing(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] ListSharing [*]")
+	log.Println("-----------------")
+
+	userID := auth.UserID(r)
+
+	invitations, err := h.grants.ListInvitations(r.Context(), userID, time.Now())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error listing sharing", http.StatusInternalServerError)
+		return
+	}
+	given, err := h.grants.ListGrantsByOwner(r.Context(), userID)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error listing sharing", http.StatusInternalServerError)
+		return
+	}
+	received, err := h.grants.ListGrantsByGrantee(r.Context(), userID)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error listing sharing", http.StatusInternalServerError)
+		return
+	}
+
+	response := sharingResponse{
+		Invitations: []invitationResponse{},
+		Given:       []grantResponse{},
+		Received:    []grantResponse{},
+	}
+	for _, invitation := range invitations {
+		response.Invitations = append(response.Invitations, newInvitationResponse(invitation))
+	}
+	for _, grant := range given {
+		response.Given = append(response.Given, h.newGrantResponse(r, grant, grant.GranteeID))
+	}
+	for _, grant := range received {
+		response.Received = append(response.Received, h.newGrantResponse(r, grant, grant.OwnerID))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// POST /user/sharing/cancel {"id": "..."}; cancels a pending invitation
+func (h *Handler) CancelInvitation(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] CancelInvitation [*]")
+	log.Println("-----------------")
+
+	userID := auth.UserID(r)
+
+	var request invitationIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.ID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := h.grants.DeleteInvitation(r.Context(), userID, request.ID)
+	if errors.Is(err, userstore.ErrInvitationNotFound) {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error cancelling invitation", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Invitation cancelled")
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /user/sharing/revoke {"granteeID": "..."} or {"ownerID": "..."}; takes effect on the next request
+func (h *Handler) RevokeGrant(w http.ResponseWriter, r *http.Request) {
+	log.Println("[*] RevokeGrant [*]")
+	log.Println("-----------------")
+
+	userID := auth.UserID(r)
+
+	var request revokeGrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var ownerID, granteeID string
+	switch {
+	case request.GranteeID != "" && request.OwnerID == "":
+		ownerID, granteeID = userID, request.GranteeID
+	case request.OwnerID != "" && request.GranteeID == "":
+		ownerID, granteeID = request.OwnerID, userID
+	default:
+		http.Error(w, "Send either ownerID or granteeID", http.StatusBadRequest)
+		return
+	}
+
+	err := h.grants.DeleteGrant(r.Context(), ownerID, granteeID)
+	if errors.Is(err, userstore.ErrGrantNotFound) {
+		http.Error(w, "Grant not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		http.Error(w, "Error revoking access", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Grant revoked")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) userEmail(r *http.Request, userID string) (string, error) {
+	userData, err := h.store.GetUser(r.Context(), userID)
+	if err != nil {
+		return "", err
+	}
+	email, _ := userData["email"].(string)
+	return email, nil
+}
+
+func newInvitationResponse(invitation userstore.Invitation) invitationResponse {
+	return invitationResponse{
+		ID:        invitation.ID,
+		Email:     invitation.Email,
+		Role:      invitation.Role,
+		Scopes:    invitation.Scopes,
+		CreatedAt: invitation.CreatedAt,
+		ExpiresAt: invitation.ExpiresAt,
+	}
+}
+
+// the email is looked up every time since it can change; a missing user just has none
+func (h *Handler) newGrantResponse(r *http.Request, grant userstore.Grant, otherID string) grantResponse {
+	email, err := h.userEmail(r, otherID)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+	return grantResponse{
+		UserID:    otherID,
+		Email:     email,
+		Role:      grant.Role,
+		Scopes:    grant.Scopes,
+		CreatedAt: grant.CreatedAt,
+	}
+}
@@ -0,0 +1,191 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/copium-dev/copium/go/service/auth"
+	"github.com/copium-dev/copium/go/service/user/userstore"
+)
+
+const advisorID = "user-2"
+
+// newTestHandler's user (the owner) plus an advisor with their own account
+func newSharingHandler(t *testing.T) (*Handler, *userstore.MemoryGrantStore) {
+	t.Helper()
+
+	handler, store := newTestHandler(t, "Applied")
+	if err := store.CreateUser(context.Background(), advisorID, "advisor@example.com"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return handler, handler.grants.(*userstore.MemoryGrantStore)
+}
+
+func serveAs(t *testing.T, handle http.HandlerFunc, userID string, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(data))
+	r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{
+		UserID: userID,
+		Scopes: []string{auth.ScopeRead, auth.ScopeWrite, auth.ScopeAccount},
+	}))
+
+	w := httptest.NewRecorder()
+	handle(w, r)
+	return w
+}
+
+func invite(t *testing.T, h *Handler, email string, scopes ...string) invitationResponse {
+	t.Helper()
+
+	w := serveAs(t, h.CreateInvitation, testUserID, "/", createInvitationRequest{Email: email, Role: userstore.RoleAdvisor, Scopes: scopes})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("CreateInvitation = %d, want 201 (%s)", w.Code, w.Body.String())
+	}
+	var invitation invitationResponse
+	if err := json.NewDecoder(w.Body).Decode(&invitation); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return invitation
+}
+
+func TestSharing(t *testing.T) {
+	h, _ := newSharingHandler(t)
+	invitation := invite(t, h, "Advisor@Example.com", userstore.GrantScopeAnalytics)
+
+	// nothing is shared until the invitation is accepted
+	if w := serveAs(t, h.Profile, advisorID, "/?user="+testUserID, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("Profile before accepting = %d, want 403", w.Code)
+	}
+
+	if w := serveAs(t, h.AcceptInvitation, advisorID, "/", invitationIDRequest{ID: invitation.ID}); w.Code != http.StatusOK {
+		t.Fatalf("AcceptInvitation = %d, want 200 (%s)", w.Code, w.Body.String())
+	}
+	if w := serveAs(t, h.Profile, advisorID, "/?user="+testUserID, nil); w.Code != http.StatusOK {
+		t.Fatalf("Profile with the grant = %d, want 200", w.Code)
+	}
+	// the grant only covers analytics
+	if w := serveAs(t, h.GetApplicationTimeline, advisorID, "/?user="+testUserID, ApplicationTimelineRequest{ID: "app-1"}); w.Code != http.StatusForbidden {
+		t.Errorf("GetApplicationTimeline without the scope = %d, want 403", w.Code)
+	}
+
+	// the invitation can only be used once
+	if w := serveAs(t, h.AcceptInvitation, advisorID, "/", invitationIDRequest{ID: invitation.ID}); w.Code != http.StatusNotFound {
+		t.Errorf("AcceptInvitation again = %d, want 404", w.Code)
+	}
+
+	if w := serveAs(t, h.RevokeGrant, testUserID, "/", revokeGrantRequest{GranteeID: advisorID}); w.Code != http.StatusOK {
+		t.Fatalf("RevokeGrant = %d, want 200", w.Code)
+	}
+	if w := serveAs(t, h.Profile, advisorID, "/?user="+testUserID, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Profile after revoking = %d, want 403", w.Code)
+	}
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string
+		email  string
+		expire bool
+		want   int
+	}{
+		{"expired", advisorID, "advisor@example.com", true, http.StatusGone},
+		{"another email", advisorID, "mentor@example.com", false, http.StatusForbidden},
+		{"the owner", testUserID, "user@example.com", false, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, grants := newSharingHandler(t)
+			invitation := invite(t, h, tt.email, userstore.GrantScopeApplications)
+			if tt.expire {
+				expire(t, grants, invitation.ID)
+			}
+
+			if w := serveAs(t, h.AcceptInvitation, tt.userID, "/", invitationIDRequest{ID: invitation.ID}); w.Code != tt.want {
+				t.Fatalf("AcceptInvitation = %d, want %d", w.Code, tt.want)
+			}
+			if _, err := grants.GetGrant(context.Background(), testUserID, tt.userID); !errors.Is(err, userstore.ErrGrantNotFound) {
+				t.Errorf("GetGrant = %v, want ErrGrantNotFound", err)
+			}
+		})
+	}
+}
+
+func TestAcceptMissingInvitation(t *testing.T) {
+	h, _ := newSharingHandler(t)
+	if w := serveAs(t, h.AcceptInvitation, advisorID, "/", invitationIDRequest{ID: "unknown"}); w.Code != http.StatusNotFound {
+		t.Errorf("AcceptInvitation = %d, want 404", w.Code)
+	}
+}
+
+func TestTargetUserWithoutGrant(t *testing.T) {
+	h, _ := newSharingHandler(t)
+
+	// reading your own data by ID is fine; someone else's needs a grant
+	if w := serveAs(t, h.Profile, testUserID, "/?user="+testUserID, nil); w.Code != http.StatusOK {
+		t.Errorf("Profile of yourself = %d, want 200", w.Code)
+	}
+	if w := serveAs(t, h.Profile, advisorID, "/?user="+testUserID, nil); w.Code != http.StatusForbidden {
+		t.Errorf("Profile without a grant = %d, want 403", w.Code)
+	}
+}
+
+// expired invitations can't be accepted, so they don't count toward maxInvitations or show up
+func TestMaxInvitations(t *testing.T) {
+	h, grants := newSharingHandler(t)
+
+	var first invitationResponse
+	for i := 0; i < maxInvitations; i++ {
+		invitation := invite(t, h, "advisor@example.com", userstore.GrantScopeApplications)
+		if i == 0 {
+			first = invitation
+		}
+	}
+
+	request := createInvitationRequest{Email: "advisor@example.com", Role: userstore.RoleAdvisor, Scopes: []string{userstore.GrantScopeApplications}}
+	if w := serveAs(t, h.CreateInvitation, testUserID, "/", request); w.Code != http.StatusConflict {
+		t.Fatalf("CreateInvitation past the limit = %d, want 409", w.Code)
+	}
+
+	expire(t, grants, first.ID)
+	invite(t, h, "advisor@example.com", userstore.GrantScopeApplications)
+
+	w := serveAs(t, h.ListSharing, testUserID, "/", nil)
+	var sharing sharingResponse
+	if err := json.NewDecoder(w.Body).Decode(&sharing); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(sharing.Invitations) != maxInvitations {
+		t.Errorf("ListSharing has %d invitations, want %d", len(sharing.Invitations), maxInvitations)
+	}
+	for _, invitation := range sharing.Invitations {
+		if invitation.ID == first.ID {
+			t.Error("ListSharing includes the expired invitation")
+		}
+	}
+}
+
+func expire(t *testing.T, grants *userstore.MemoryGrantStore, id string) {
+	t.Helper()
+
+	invitation, err := grants.GetInvitation(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetInvitation: %v", err)
+	}
+	invitation.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := grants.CreateInvitation(context.Background(), *invitation); err != nil {
+		t.Fatalf("CreateInvitation: %v", err)
+	}
+}
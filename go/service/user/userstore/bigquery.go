@@ -0,0 +1,109 @@
+package userstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+// reads the applications_data.applications table; writes happen in bigquery-consumer
+type BigQueryEventLog struct {
+	client *bigquery.Client
+}
+
+func NewBigQueryEventLog(client *bigquery.Client) *BigQueryEventLog {
+	return &BigQueryEventLog{
+		client: client,
+	}
+}
+
+type bigQueryEventRow struct {
+	OperationID string    `bigquery:"operationID"`
+	Operation   string    `bigquery:"operation"`
+	Status      string    `bigquery:"status"`
+	EventTime   time.Time `bigquery:"event_time"`
+}
+
+func (l *BigQueryEventLog) Timeline(ctx context.Context, userID string, jobID string) ([]Event, error) {
+	q := l.client.Query(`
+		SELECT operationID, operation, status, event_time
+		FROM applications_data.applications
+		WHERE userID = @userID
+		AND jobID = @jobID
+		AND operation != 'revert'
+		-- the consumer's batching writer is at-least-once, so drop repeated operations
+		QUALIFY ROW_NUMBER() OVER (PARTITION BY operationID) = 1
+		ORDER BY event_time DESC
+	`)
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "userID", Value: userID},
+		{Name: "jobID", Value: jobID},
+	}
+
+	return l.read(ctx, q, userID, jobID)
+}
+
+func (l *BigQueryEventLog) StatusHistory(ctx context.Context, userID string, jobID string, limit int) ([]Event, error) {
+	q := l.client.Query(`
+		SELECT operationID, operation, status, event_time
+		FROM applications_data.applications
+		WHERE userID = @userID
+		AND jobID = @jobID
+		AND operation NOT IN ('revert', 'add') -- cannot revert a revert or an add
+		QUALIFY ROW_NUMBER() OVER (PARTITION BY operationID) = 1
+		ORDER BY event_time DESC
+		LIMIT @limit
+	`)
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "userID", Value: userID},
+		{Name: "jobID", Value: jobID},
+		{Name: "limit", Value: limit},
+	}
+
+	return l.read(ctx, q, userID, jobID)
+}
+
+func (l *BigQueryEventLog) read(ctx context.Context, q *bigquery.Query, userID string, jobID string) ([]Event, error) {
+	job, err := q.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for job: %w", err)
+	}
+	if err := status.Err(); err != nil {
+		return nil, fmt.Errorf("job completed with error: %w", err)
+	}
+
+	it, err := job.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read query results: %w", err)
+	}
+
+	var events []Event
+	for {
+		var row bigQueryEventRow
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate query results: %w", err)
+		}
+		events = append(events, Event{
+			OperationID: row.OperationID,
+			UserID:      userID,
+			JobID:       jobID,
+			EventTime:   row.EventTime,
+			Status:      row.Status,
+			Operation:   row.Operation,
+		})
+	}
+
+	return events, nil
+}
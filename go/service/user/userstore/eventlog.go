@@ -0,0 +1,28 @@
+package userstore
+
+import (
+	"context"
+	"time"
+)
+
+// one row of the application event log; same columns as applications_data.applications in BigQuery
+// operation is one of add, edit, revert (plus delete/userDelete when carried by an
+// OutboxMessage to a store that records events itself)
+type Event struct {
+	OperationID string
+	UserID      string
+	JobID       string
+	EventTime   time.Time
+	AppliedDate time.Time
+	Status      string
+	Operation   string
+}
+
+// read side of the event log used by the user service
+// in the Firestore setup the log lives in BigQuery and is written by bigquery-consumer
+type EventLog interface {
+	// non-reverted events for a job, newest first
+	Timeline(ctx context.Context, userID string, jobID string) ([]Event, error)
+	// the most recent status changes (no adds, no reverts) for a job, newest first
+	StatusHistory(ctx context.Context, userID string, jobID string, limit int) ([]Event, error)
+}
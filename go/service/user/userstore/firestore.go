@@ -0,0 +1,444 @@
+package userstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// number of application documents deleted per batch when deleting a user
+const deleteBatchSize = 10
+
+type FirestoreStore struct {
+	client *firestore.Client
+}
+
+func NewFirestoreStore(client *firestore.Client) *FirestoreStore {
+	return &FirestoreStore{
+		client: client,
+	}
+}
+
+func (s *FirestoreStore) userDoc(userID string) *firestore.DocumentRef {
+	return s.client.Collection("users").Doc(userID)
+}
+
+func (s *FirestoreStore) applications(userID string) *firestore.CollectionRef {
+	return s.userDoc(userID).Collection("applications")
+}
+
+// emails/{email} -> {userID}; document IDs are unique, so this is what keeps two users from
+// having the same email (a field on users/{userID} can't enforce that)
+func (s *FirestoreStore) emailDoc(email string) *firestore.DocumentRef {
+	return s.client.Collection("emails").Doc(email)
+}
+
+type firestoreEmail struct {
+	UserID string `firestore:"userID"`
+}
+
+// returns the user ID the email belongs to, or "" if it's free
+func (s *FirestoreStore) emailOwner(tx *firestore.Transaction, email string) (string, error) {
+	doc, err := tx.Get(s.emailDoc(email))
+	if status.Code(err) == codes.NotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var owner firestoreEmail
+	if err := doc.DataTo(&owner); err != nil {
+		return "", err
+	}
+	return owner.UserID, nil
+}
+
+// top level so messages outlive the user (e.g. the userDelete message)
+func (s *FirestoreStore) outbox() *firestore.CollectionRef {
+	return s.client.Collection("outbox")
+}
+
+// runs a write together with its outbox messages in one transaction
+func (s *FirestoreStore) writeWithOutbox(ctx context.Context, msgs []OutboxMessage, write func(tx *firestore.Transaction) error) error {
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := write(tx); err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if err := tx.Create(s.outbox().Doc(msg.ID), msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// the user document and the email claim are created in one transaction
+func (s *FirestoreStore) CreateUser(ctx context.Context, userID string, email string) error {
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		owner, err := s.emailOwner(tx, email)
+		if err != nil {
+			return err
+		}
+		if owner != "" && owner != userID {
+			return ErrEmailTaken
+		}
+
+		// existing users are left alone instead of overwriting counters and analytics
+		_, err = tx.Get(s.userDoc(userID))
+		if err == nil {
+			return nil
+		}
+		if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		if err := tx.Set(s.emailDoc(email), firestoreEmail{UserID: userID}); err != nil {
+			return err
+		}
+		return tx.Create(s.userDoc(userID), map[string]interface{}{
+			"email":             email,
+			"applicationsCount": 0,
+		})
+	})
+}
+
+func (s *FirestoreStore) FindUserByEmail(ctx context.Context, email string) (string, error) {
+	doc, err := s.emailDoc(email).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+
+	var owner firestoreEmail
+	if err := doc.DataTo(&owner); err != nil {
+		return "", err
+	}
+	return owner.UserID, nil
+}
+
+// moves the email claim and updates the user document in one transaction
+func (s *FirestoreStore) SetEmail(ctx context.Context, userID string, email string) error {
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(s.userDoc(userID))
+		if status.Code(err) == codes.NotFound {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		owner, err := s.emailOwner(tx, email)
+		if err != nil {
+			return err
+		}
+		if owner != "" && owner != userID {
+			return ErrEmailTaken
+		}
+
+		if old, ok := doc.Data()["email"].(string); ok && old != email {
+			if err := tx.Delete(s.emailDoc(old)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Set(s.emailDoc(email), firestoreEmail{UserID: userID}); err != nil {
+			return err
+		}
+		return tx.Update(doc.Ref, []firestore.Update{{Path: "email", Value: email}})
+	})
+}
+
+func (s *FirestoreStore) UserExists(ctx context.Context, userID string) (bool, error) {
+	_, err := s.userDoc(userID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound { // not a real error
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *FirestoreStore) GetUser(ctx context.Context, userID string) (map[string]interface{}, error) {
+	doc, err := s.userDoc(userID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return doc.Data(), nil
+}
+
+// Firestore does not delete subcollections automatically
+// so, delete all documents in users/{userID}/applications
+// then, delete users/{userID} and its emails/{email} claim
+func (s *FirestoreStore) DeleteUser(ctx context.Context, userID string, msgs ...OutboxMessage) error {
+	// store messages FIRST; bulk deletes can't be part of a transaction
+	if err := s.Enqueue(ctx, msgs...); err != nil {
+		return fmt.Errorf("failed to enqueue messages: %w", err)
+	}
+
+	// delete subcollection (just applications)
+	applicationsCollection := s.applications(userID)
+	bulkWriter := s.client.BulkWriter(ctx)
+
+	// for each batch...
+	for {
+		iter := applicationsCollection.Limit(deleteBatchSize).Documents(ctx)
+		numDeleted := 0
+
+		// for each document...
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				bulkWriter.End()
+				return fmt.Errorf("failed to iterate: %w", err)
+			}
+
+			bulkWriter.Delete(doc.Ref)
+			numDeleted++
+		}
+
+		if numDeleted == 0 {
+			bulkWriter.End()
+			break
+		}
+
+		bulkWriter.Flush()
+	}
+
+	// delete user document and free up the email
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(s.userDoc(userID))
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if email, ok := doc.Data()["email"].(string); ok {
+			owner, err := s.emailOwner(tx, email)
+			if err != nil {
+				return err
+			}
+			if owner == userID {
+				if err := tx.Delete(s.emailDoc(email)); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Delete(doc.Ref)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete user document: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FirestoreStore) AddApplication(ctx context.Context, userID string, app Application, msgs ...OutboxMessage) (string, error) {
+	var doc *firestore.DocumentRef
+	if app.ID != "" {
+		doc = s.applications(userID).Doc(app.ID)
+	} else {
+		doc = s.applications(userID).NewDoc()
+	}
+
+	err := s.writeWithOutbox(ctx, msgs, func(tx *firestore.Transaction) error {
+		return tx.Create(doc, app)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return doc.ID, nil
+}
+
+func (s *FirestoreStore) GetApplication(ctx context.Context, userID string, id string) (*Application, error) {
+	doc, err := s.applications(userID).Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrApplicationNotFound
+		}
+		return nil, err
+	}
+
+	var app Application
+	if err := doc.DataTo(&app); err != nil {
+		return nil, err
+	}
+	app.ID = doc.Ref.ID
+
+	return &app, nil
+}
+
+func (s *FirestoreStore) SetApplication(ctx context.Context, userID string, app Application, msgs ...OutboxMessage) error {
+	return s.writeWithOutbox(ctx, msgs, func(tx *firestore.Transaction) error {
+		return tx.Set(s.applications(userID).Doc(app.ID), app)
+	})
+}
+
+func (s *FirestoreStore) UpdateApplication(ctx context.Context, userID string, id string, fields map[string]interface{}, msgs ...OutboxMessage) error {
+	updates := make([]firestore.Update, 0, len(fields))
+	for key, value := range fields {
+		updates = append(updates, firestore.Update{Path: key, Value: value})
+	}
+
+	err := s.writeWithOutbox(ctx, msgs, func(tx *firestore.Transaction) error {
+		return tx.Update(s.applications(userID).Doc(id), updates)
+	})
+	if status.Code(err) == codes.NotFound {
+		return ErrApplicationNotFound
+	}
+	return err
+}
+
+func (s *FirestoreStore) DeleteApplication(ctx context.Context, userID string, id string, msgs ...OutboxMessage) error {
+	return s.writeWithOutbox(ctx, msgs, func(tx *firestore.Transaction) error {
+		return tx.Delete(s.applications(userID).Doc(id))
+	})
+}
+
+func (s *FirestoreStore) IncrementCounters(ctx context.Context, userID string, deltas map[string]int64) error {
+	hasDecrement := false
+	for _, delta := range deltas {
+		if delta < 0 {
+			hasDecrement = true
+			break
+		}
+	}
+
+	// increments only; no need to read the user document first
+	if !hasDecrement {
+		updates := make([]firestore.Update, 0, len(deltas))
+		for key, delta := range deltas {
+			updates = append(updates, firestore.Update{Path: key, Value: firestore.Increment(delta)})
+		}
+		_, err := s.userDoc(userID).Update(ctx, updates)
+		return err
+	}
+
+	// transaction is used to ensure that if an increment fails, decrement wont happen and vice versa
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		userDoc := s.userDoc(userID)
+		doc, err := tx.Get(userDoc)
+		if err != nil {
+			return err
+		}
+
+		var updates []firestore.Update
+		for key, delta := range deltas {
+			if delta < 0 {
+				// only decrement if the counter is currently greater than 0
+				current := int64(0)
+				if val, exists := doc.Data()[key]; exists {
+					if count, ok := val.(int64); ok {
+						current = count
+					}
+				}
+				if current <= 0 {
+					continue
+				}
+			}
+			updates = append(updates, firestore.Update{Path: key, Value: firestore.Increment(delta)})
+		}
+
+		if len(updates) == 0 {
+			return nil
+		}
+
+		return tx.Update(userDoc, updates)
+	})
+}
+
+func (s *FirestoreStore) Enqueue(ctx context.Context, msgs ...OutboxMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	return s.writeWithOutbox(ctx, msgs, func(tx *firestore.Transaction) error {
+		return nil
+	})
+}
+
+func (s *FirestoreStore) PendingMessages(ctx context.Context, after *OutboxMessage, limit int) ([]OutboxMessage, error) {
+	query := s.outbox().OrderBy("createdAt", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc)
+	if after != nil {
+		query = query.StartAfter(after.CreatedAt, after.ID)
+	}
+	iter := query.Limit(limit).Documents(ctx)
+	defer iter.Stop()
+
+	var msgs []OutboxMessage
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var msg OutboxMessage
+		if err := doc.DataTo(&msg); err != nil {
+			return nil, err
+		}
+		msg.ID = doc.Ref.ID
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, nil
+}
+
+func (s *FirestoreStore) ClaimMessage(ctx context.Context, id string, until time.Time) (bool, error) {
+	claimed := false
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = false
+		doc, err := tx.Get(s.outbox().Doc(id))
+		if err != nil {
+			return err
+		}
+
+		var msg OutboxMessage
+		if err := doc.DataTo(&msg); err != nil {
+			return err
+		}
+		if msg.LeasedUntil.After(time.Now()) {
+			return nil
+		}
+
+		claimed = true
+		return tx.Update(doc.Ref, []firestore.Update{{Path: "leasedUntil", Value: until}})
+	})
+	// already published by another relay
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	return claimed, err
+}
+
+func (s *FirestoreStore) DeleteMessage(ctx context.Context, id string) error {
+	_, err := s.outbox().Doc(id).Delete(ctx)
+	return err
+}
+
+func (s *FirestoreStore) RetryMessage(ctx context.Context, id string, retryAt time.Time, lastError string) error {
+	_, err := s.outbox().Doc(id).Update(ctx, []firestore.Update{
+		{Path: "attempts", Value: firestore.Increment(1)},
+		{Path: "lastError", Value: lastError},
+		{Path: "leasedUntil", Value: retryAt},
+	})
+	return err
+}
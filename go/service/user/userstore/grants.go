@@ -0,0 +1,242 @@
+package userstore
+
+// sharing: a user (the owner, e.g. a student) invites someone by email (e.g. a career-center
+// advisor or a mentor); once that person accepts, they hold a grant that lets them read the owner's
+// data. grants are read-only, scoped to applications and/or analytics, and either side can revoke
+// them at any time
+//   invitations/{id}                       -> pending invitation; the ID is the secret in the invite link
+//   grants/{ownerID}_{granteeID}           -> accepted grant
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Dashboard and GetApplicationTimeline
+	GrantScopeApplications = "applications:read"
+	// Profile (counters and analytics)
+	GrantScopeAnalytics = "analytics:read"
+
+	RoleAdvisor = "advisor"
+	RoleMentor  = "mentor"
+)
+
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrGrantNotFound      = errors.New("grant not found")
+)
+
+type Invitation struct {
+	ID      string
+	OwnerID string
+	// only the account with this email can accept
+	Email     string
+	Role      string
+	Scopes    []string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+func (i *Invitation) Expired(now time.Time) bool {
+	return !now.Before(i.ExpiresAt)
+}
+
+type Grant struct {
+	// whose data is shared
+	OwnerID string
+	// who can read it
+	GranteeID string
+	Role      string
+	Scopes    []string
+	CreatedAt time.Time
+}
+
+type GrantStore interface {
+	CreateInvitation(ctx context.Context, invitation Invitation) error
+	// returns ErrInvitationNotFound if there is no such invitation
+	GetInvitation(ctx context.Context, id string) (*Invitation, error)
+	// the owner's pending invitations that haven't expired at now, newest first
+	ListInvitations(ctx context.Context, ownerID string, now time.Time) ([]Invitation, error)
+	// returns ErrInvitationNotFound if the invitation isn't the owner's
+	DeleteInvitation(ctx context.Context, ownerID string, id string) error
+	// deletes the invitation and grants its role and scopes to granteeID, replacing any existing grant
+	// between the two; returns ErrInvitationNotFound if it was already used or cancelled
+	AcceptInvitation(ctx context.Context, id string, granteeID string, at time.Time) (*Grant, error)
+
+	// returns ErrGrantNotFound if the owner hasn't shared anything with the grantee
+	GetGrant(ctx context.Context, ownerID string, granteeID string) (*Grant, error)
+	// grants the owner has given, oldest first
+	ListGrantsByOwner(ctx context.Context, ownerID string) ([]Grant, error)
+	// grants the grantee has received, oldest first
+	ListGrantsByGrantee(ctx context.Context, granteeID string) ([]Grant, error)
+	// returns ErrGrantNotFound if there is no such grant
+	DeleteGrant(ctx context.Context, ownerID string, granteeID string) error
+	// every invitation and grant the user is on either side of; for deleted accounts
+	DeleteUserGrants(ctx context.Context, userID string) error
+}
+
+// for local dev
+type MemoryGrantStore struct {
+	mu          sync.RWMutex
+	invitations map[string]Invitation
+	grants      map[string]Grant
+}
+
+func NewMemoryGrantStore() *MemoryGrantStore {
+	return &MemoryGrantStore{
+		invitations: make(map[string]Invitation),
+		grants:      make(map[string]Grant),
+	}
+}
+
+func grantKey(ownerID string, granteeID string) string {
+	return ownerID + "_" + granteeID
+}
+
+func (s *MemoryGrantStore) CreateInvitation(ctx context.Context, invitation Invitation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.invitations[invitation.ID] = invitation
+	return nil
+}
+
+func (s *MemoryGrantStore) GetInvitation(ctx context.Context, id string) (*Invitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	invitation, ok := s.invitations[id]
+	if !ok {
+		return nil, ErrInvitationNotFound
+	}
+	return &invitation, nil
+}
+
+func (s *MemoryGrantStore) ListInvitations(ctx context.Context, ownerID string, now time.Time) ([]Invitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	invitations := []Invitation{}
+	for _, invitation := range s.invitations {
+		if invitation.OwnerID == ownerID && !invitation.Expired(now) {
+			invitations = append(invitations, invitation)
+		}
+	}
+	sortInvitations(invitations)
+	return invitations, nil
+}
+
+func (s *MemoryGrantStore) DeleteInvitation(ctx context.Context, ownerID string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invitation, ok := s.invitations[id]
+	if !ok || invitation.OwnerID != ownerID {
+		return ErrInvitationNotFound
+	}
+	delete(s.invitations, id)
+	return nil
+}
+
+func (s *MemoryGrantStore) AcceptInvitation(ctx context.Context, id string, granteeID string, at time.Time) (*Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invitation, ok := s.invitations[id]
+	if !ok {
+		return nil, ErrInvitationNotFound
+	}
+	delete(s.invitations, id)
+
+	grant := grantFromInvitation(invitation, granteeID, at)
+	s.grants[grantKey(grant.OwnerID, grant.GranteeID)] = grant
+	return &grant, nil
+}
+
+func (s *MemoryGrantStore) GetGrant(ctx context.Context, ownerID string, granteeID string) (*Grant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	grant, ok := s.grants[grantKey(ownerID, granteeID)]
+	if !ok {
+		return nil, ErrGrantNotFound
+	}
+	return &grant, nil
+}
+
+func (s *MemoryGrantStore) ListGrantsByOwner(ctx context.Context, ownerID string) ([]Grant, error) {
+	return s.listGrants(func(grant Grant) bool { return grant.OwnerID == ownerID }), nil
+}
+
+func (s *MemoryGrantStore) ListGrantsByGrantee(ctx context.Context, granteeID string) ([]Grant, error) {
+	return s.listGrants(func(grant Grant) bool { return grant.GranteeID == granteeID }), nil
+}
+
+func (s *MemoryGrantStore) listGrants(match func(Grant) bool) []Grant {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	grants := []Grant{}
+	for _, grant := range s.grants {
+		if match(grant) {
+			grants = append(grants, grant)
+		}
+	}
+	sortGrants(grants)
+	return grants
+}
+
+func (s *MemoryGrantStore) DeleteGrant(ctx context.Context, ownerID string, granteeID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := grantKey(ownerID, granteeID)
+	if _, ok := s.grants[key]; !ok {
+		return ErrGrantNotFound
+	}
+	delete(s.grants, key)
+	return nil
+}
+
+func (s *MemoryGrantStore) DeleteUserGrants(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, invitation := range s.invitations {
+		if invitation.OwnerID == userID {
+			delete(s.invitations, id)
+		}
+	}
+	for key, grant := range s.grants {
+		if grant.OwnerID == userID || grant.GranteeID == userID {
+			delete(s.grants, key)
+		}
+	}
+	return nil
+}
+
+func grantFromInvitation(invitation Invitation, granteeID string, at time.Time) Grant {
+	return Grant{
+		OwnerID:   invitation.OwnerID,
+		GranteeID: granteeID,
+		Role:      invitation.Role,
+		Scopes:    invitation.Scopes,
+		CreatedAt: at,
+	}
+}
+
+func sortInvitations(invitations []Invitation) {
+	sort.Slice(invitations, func(i, j int) bool {
+		return invitations[i].CreatedAt.After(invitations[j].CreatedAt)
+	})
+}
+
+func sortGrants(grants []Grant) {
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].CreatedAt.Before(grants[j].CreatedAt)
+	})
+}
@@ -0,0 +1,269 @@
+package userstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// invitations/{id} and grants/{ownerID}_{granteeID}
+type FirestoreGrantStore struct {
+	client *firestore.Client
+}
+
+func NewFirestoreGrantStore(client *firestore.Client) *FirestoreGrantStore {
+	return &FirestoreGrantStore{
+		client: client,
+	}
+}
+
+type firestoreInvitation struct {
+	OwnerID   string    `firestore:"ownerID"`
+	Email     string    `firestore:"email"`
+	Role      string    `firestore:"role"`
+	Scopes    []string  `firestore:"scopes"`
+	CreatedAt time.Time `firestore:"createdAt"`
+	ExpiresAt time.Time `firestore:"expiresAt"`
+}
+
+type firestoreGrant struct {
+	OwnerID   string    `firestore:"ownerID"`
+	GranteeID string    `firestore:"granteeID"`
+	Role      string    `firestore:"role"`
+	Scopes    []string  `firestore:"scopes"`
+	CreatedAt time.Time `firestore:"createdAt"`
+}
+
+func (s *FirestoreGrantStore) invitations() *firestore.CollectionRef {
+	return s.client.Collection("invitations")
+}
+
+func (s *FirestoreGrantStore) grantDoc(ownerID string, granteeID string) *firestore.DocumentRef {
+	return s.client.Collection("grants").Doc(grantKey(ownerID, granteeID))
+}
+
+func (s *FirestoreGrantStore) CreateInvitation(ctx context.Context, invitation Invitation) error {
+	_, err := s.invitations().Doc(invitation.ID).Create(ctx, firestoreInvitation{
+		OwnerID:   invitation.OwnerID,
+		Email:     invitation.Email,
+		Role:      invitation.Role,
+		Scopes:    invitation.Scopes,
+		CreatedAt: invitation.CreatedAt,
+		ExpiresAt: invitation.ExpiresAt,
+	})
+	return err
+}
+
+func (s *FirestoreGrantStore) GetInvitation(ctx context.Context, id string) (*Invitation, error) {
+	return decodeInvitation(s.invitations().Doc(id).Get(ctx))
+}
+
+// expired ones are filtered here rather than in the query, which would need a composite index
+func (s *FirestoreGrantStore) ListInvitations(ctx context.Context, ownerID string, now time.Time) ([]Invitation, error) {
+	docs, err := s.invitations().Where("ownerID", "==", ownerID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	invitations := make([]Invitation, 0, len(docs))
+	for _, doc := range docs {
+		invitation, err := decodeInvitation(doc, nil)
+		if err != nil {
+			return nil, err
+		}
+		if !invitation.Expired(now) {
+			invitations = append(invitations, *invitation)
+		}
+	}
+	sortInvitations(invitations)
+	return invitations, nil
+}
+
+func (s *FirestoreGrantStore) DeleteInvitation(ctx context.Context, ownerID string, id string) error {
+	ref := s.invitations().Doc(id)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		invitation, err := decodeInvitation(tx.Get(ref))
+		if err != nil {
+			return err
+		}
+		if invitation.OwnerID != ownerID {
+			return ErrInvitationNotFound
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// the invitation is read and deleted in the same transaction, so it can only be accepted once
+func (s *FirestoreGrantStore) AcceptInvitation(ctx context.Context, id string, granteeID string, at time.Time) (*Grant, error) {
+	ref := s.invitations().Doc(id)
+
+	var grant Grant
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		invitation, err := decodeInvitation(tx.Get(ref))
+		if err != nil {
+			return err
+		}
+
+		grant = grantFromInvitation(*invitation, granteeID, at)
+		if err := tx.Delete(ref); err != nil {
+			return err
+		}
+		return tx.Set(s.grantDoc(grant.OwnerID, grant.GranteeID), firestoreGrant{
+			OwnerID:   grant.OwnerID,
+			GranteeID: grant.GranteeID,
+			Role:      grant.Role,
+			Scopes:    grant.Scopes,
+			CreatedAt: grant.CreatedAt,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+func (s *FirestoreGrantStore) GetGrant(ctx context.Context, ownerID string, granteeID string) (*Grant, error) {
+	return decodeGrant(s.grantDoc(ownerID, granteeID).Get(ctx))
+}
+
+func (s *FirestoreGrantStore) ListGrantsByOwner(ctx context.Context, ownerID string) ([]Grant, error) {
+	return s.listGrants(ctx, "ownerID", ownerID)
+}
+
+func (s *FirestoreGrantStore) ListGrantsByGrantee(ctx context.Context, granteeID string) ([]Grant, error) {
+	return s.listGrants(ctx, "granteeID", granteeID)
+}
+
+func (s *FirestoreGrantStore) listGrants(ctx context.Context, field string, userID string) ([]Grant, error) {
+	docs, err := s.client.Collection("grants").Where(field, "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	grants := make([]Grant, 0, len(docs))
+	for _, doc := range docs {
+		grant, err := decodeGrant(doc, nil)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, *grant)
+	}
+	sortGrants(grants)
+	return grants, nil
+}
+
+func (s *FirestoreGrantStore) DeleteGrant(ctx context.Context, ownerID string, granteeID string) error {
+	ref := s.grantDoc(ownerID, granteeID)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := decodeGrant(tx.Get(ref)); err != nil {
+			return err
+		}
+		return tx.Delete(ref)
+	})
+}
+
+func (s *FirestoreGrantStore) DeleteUserGrants(ctx context.Context, userID string) error {
+	queries := []firestore.Query{
+		s.invitations().Where("ownerID", "==", userID),
+		s.client.Collection("grants").Where("ownerID", "==", userID),
+		s.client.Collection("grants").Where("granteeID", "==", userID),
+	}
+
+	bulkWriter := s.client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for _, query := range queries {
+		iter := query.Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				bulkWriter.End()
+				return err
+			}
+			job, err := bulkWriter.Delete(doc.Ref)
+			if err != nil {
+				iter.Stop()
+				bulkWriter.End()
+				return err
+			}
+			jobs = append(jobs, job)
+		}
+		iter.Stop()
+	}
+	return endBulkWriter(bulkWriter, jobs)
+}
+
+// End waits for every write, so by then each job has its result; returns the first failure
+// (a BulkWriter only reports them per job)
+func endBulkWriter(bulkWriter *firestore.BulkWriter, jobs []*firestore.BulkWriterJob) error {
+	bulkWriter.End()
+
+	var failed int
+	var firstErr error
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%d of %d writes failed: %w", failed, len(jobs), firstErr)
+	}
+	return nil
+}
+
+func decodeInvitation(doc *firestore.DocumentSnapshot, err error) (*Invitation, error) {
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored firestoreInvitation
+	if err := doc.DataTo(&stored); err != nil {
+		return nil, err
+	}
+
+	return &Invitation{
+		ID:        doc.Ref.ID,
+		OwnerID:   stored.OwnerID,
+		Email:     stored.Email,
+		Role:      stored.Role,
+		Scopes:    stored.Scopes,
+		CreatedAt: stored.CreatedAt,
+		ExpiresAt: stored.ExpiresAt,
+	}, nil
+}
+
+func decodeGrant(doc *firestore.DocumentSnapshot, err error) (*Grant, error) {
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrGrantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored firestoreGrant
+	if err := doc.DataTo(&stored); err != nil {
+		return nil, err
+	}
+
+	return &Grant{
+		OwnerID:   stored.OwnerID,
+		GranteeID: stored.GranteeID,
+		Role:      stored.Role,
+		Scopes:    stored.Scopes,
+		CreatedAt: stored.CreatedAt,
+	}, nil
+}
@@ -0,0 +1,169 @@
+package userstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// invitations and grants tables, see migrations/0008_sharing.sql
+type PostgresGrantStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresGrantStore(pool *pgxpool.Pool) *PostgresGrantStore {
+	return &PostgresGrantStore{
+		pool: pool,
+	}
+}
+
+func (s *PostgresGrantStore) CreateInvitation(ctx context.Context, invitation Invitation) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO invitations (id, owner_id, email, role, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, invitation.ID, invitation.OwnerID, invitation.Email, invitation.Role, invitation.Scopes, invitation.CreatedAt, invitation.ExpiresAt)
+	return err
+}
+
+func (s *PostgresGrantStore) GetInvitation(ctx context.Context, id string) (*Invitation, error) {
+	var invitation Invitation
+	err := s.pool.QueryRow(ctx, `
+		SELECT id, owner_id, email, role, scopes, created_at, expires_at FROM invitations WHERE id = $1
+	`, id).Scan(&invitation.ID, &invitation.OwnerID, &invitation.Email, &invitation.Role, &invitation.Scopes, &invitation.CreatedAt, &invitation.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (s *PostgresGrantStore) ListInvitations(ctx context.Context, ownerID string, now time.Time) ([]Invitation, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, owner_id, email, role, scopes, created_at, expires_at
+		FROM invitations WHERE owner_id = $1 AND expires_at > $2 ORDER BY created_at DESC
+	`, ownerID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		var invitation Invitation
+		if err := rows.Scan(&invitation.ID, &invitation.OwnerID, &invitation.Email, &invitation.Role, &invitation.Scopes, &invitation.CreatedAt, &invitation.ExpiresAt); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
+
+func (s *PostgresGrantStore) DeleteInvitation(ctx context.Context, ownerID string, id string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM invitations WHERE id = $1 AND owner_id = $2`, id, ownerID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// DELETE ... RETURNING makes sure only one request can use the invitation
+func (s *PostgresGrantStore) AcceptInvitation(ctx context.Context, id string, granteeID string, at time.Time) (*Grant, error) {
+	var grant Grant
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		var invitation Invitation
+		err := tx.QueryRow(ctx, `
+			DELETE FROM invitations WHERE id = $1 RETURNING owner_id, role, scopes
+		`, id).Scan(&invitation.OwnerID, &invitation.Role, &invitation.Scopes)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInvitationNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		grant = grantFromInvitation(invitation, granteeID, at)
+		_, err = tx.Exec(ctx, `
+			INSERT INTO grants (owner_id, grantee_id, role, scopes, created_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (owner_id, grantee_id) DO UPDATE
+			SET role = EXCLUDED.role, scopes = EXCLUDED.scopes, created_at = EXCLUDED.created_at
+		`, grant.OwnerID, grant.GranteeID, grant.Role, grant.Scopes, grant.CreatedAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+func (s *PostgresGrantStore) GetGrant(ctx context.Context, ownerID string, granteeID string) (*Grant, error) {
+	grants, err := s.queryGrants(ctx, `
+		SELECT owner_id, grantee_id, role, scopes, created_at FROM grants WHERE owner_id = $1 AND grantee_id = $2
+	`, ownerID, granteeID)
+	if err != nil {
+		return nil, err
+	}
+	if len(grants) == 0 {
+		return nil, ErrGrantNotFound
+	}
+	return &grants[0], nil
+}
+
+func (s *PostgresGrantStore) ListGrantsByOwner(ctx context.Context, ownerID string) ([]Grant, error) {
+	return s.queryGrants(ctx, `
+		SELECT owner_id, grantee_id, role, scopes, created_at FROM grants WHERE owner_id = $1 ORDER BY created_at
+	`, ownerID)
+}
+
+func (s *PostgresGrantStore) ListGrantsByGrantee(ctx context.Context, granteeID string) ([]Grant, error) {
+	return s.queryGrants(ctx, `
+		SELECT owner_id, grantee_id, role, scopes, created_at FROM grants WHERE grantee_id = $1 ORDER BY created_at
+	`, granteeID)
+}
+
+func (s *PostgresGrantStore) queryGrants(ctx context.Context, query string, args ...interface{}) ([]Grant, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []Grant{}
+	for rows.Next() {
+		var grant Grant
+		if err := rows.Scan(&grant.OwnerID, &grant.GranteeID, &grant.Role, &grant.Scopes, &grant.CreatedAt); err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
+
+func (s *PostgresGrantStore) DeleteGrant(ctx context.Context, ownerID string, granteeID string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM grants WHERE owner_id = $1 AND grantee_id = $2`, ownerID, granteeID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrGrantNotFound
+	}
+	return nil
+}
+
+func (s *PostgresGrantStore) DeleteUserGrants(ctx context.Context, userID string) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM invitations WHERE owner_id = $1`, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM grants WHERE owner_id = $1 OR grantee_id = $1`, userID)
+		return err
+	})
+}
@@ -0,0 +1,350 @@
+package userstore
+
+import (
+	"context"
+	"crypto/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// in-memory ApplicationStore for local dev and unit tests; nothing is persisted
+// across restarts. behaves like the Firestore store, including counters never going negative
+type MemoryStore struct {
+	mu    sync.RWMutex
+	users map[string]*memoryUser
+	// email -> user ID
+	emails map[string]string
+	outbox map[string]OutboxMessage
+}
+
+type memoryUser struct {
+	fields       map[string]interface{}
+	applications map[string]Application
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:  make(map[string]*memoryUser),
+		emails: make(map[string]string),
+		outbox: make(map[string]OutboxMessage),
+	}
+}
+
+// caller must hold the write lock
+func (s *MemoryStore) getOrCreateUser(userID string) *memoryUser {
+	user, ok := s.users[userID]
+	if !ok {
+		user = &memoryUser{
+			fields: map[string]interface{}{
+				"applicationsCount": int64(0),
+			},
+			applications: make(map[string]Application),
+		}
+		s.users[userID] = user
+	}
+	return user
+}
+
+// caller must hold the write lock
+func (s *MemoryStore) enqueue(msgs []OutboxMessage) {
+	for _, msg := range msgs {
+		s.outbox[msg.ID] = msg
+	}
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, userID string, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if owner, ok := s.emails[email]; ok && owner != userID {
+		return ErrEmailTaken
+	}
+	if user, ok := s.users[userID]; ok && user.fields["email"] != nil {
+		return nil
+	}
+
+	user := s.getOrCreateUser(userID)
+	user.fields["email"] = email
+	s.emails[email] = userID
+	return nil
+}
+
+func (s *MemoryStore) UserExists(ctx context.Context, userID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.users[userID]
+	return ok, nil
+}
+
+func (s *MemoryStore) FindUserByEmail(ctx context.Context, email string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	userID, ok := s.emails[email]
+	if !ok {
+		return "", ErrUserNotFound
+	}
+	return userID, nil
+}
+
+func (s *MemoryStore) SetEmail(ctx context.Context, userID string, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if owner, ok := s.emails[email]; ok && owner != userID {
+		return ErrEmailTaken
+	}
+
+	if old, ok := user.fields["email"].(string); ok {
+		delete(s.emails, old)
+	}
+	user.fields["email"] = email
+	s.emails[email] = userID
+	return nil
+}
+
+func (s *MemoryStore) GetUser(ctx context.Context, userID string) (map[string]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	// copy so callers can't mutate the store
+	fields := make(map[string]interface{}, len(user.fields))
+	for key, value := range user.fields {
+		fields[key] = value
+	}
+	return fields, nil
+}
+
+func (s *MemoryStore) DeleteUser(ctx context.Context, userID string, msgs ...OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		if email, ok := user.fields["email"].(string); ok {
+			delete(s.emails, email)
+		}
+	}
+	delete(s.users, userID)
+	s.enqueue(msgs)
+	return nil
+}
+
+func (s *MemoryStore) AddApplication(ctx context.Context, userID string, app Application, msgs ...OutboxMessage) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if app.ID == "" {
+		app.ID = NewID()
+	}
+
+	// like Firestore, adding an application implicitly creates the parent document
+	user := s.getOrCreateUser(userID)
+	user.applications[app.ID] = app
+	s.enqueue(msgs)
+
+	return app.ID, nil
+}
+
+func (s *MemoryStore) GetApplication(ctx context.Context, userID string, id string) (*Application, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, ErrApplicationNotFound
+	}
+	app, ok := user.applications[id]
+	if !ok {
+		return nil, ErrApplicationNotFound
+	}
+	return &app, nil
+}
+
+func (s *MemoryStore) SetApplication(ctx context.Context, userID string, app Application, msgs ...OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.getOrCreateUser(userID)
+	user.applications[app.ID] = app
+	s.enqueue(msgs)
+	return nil
+}
+
+func (s *MemoryStore) UpdateApplication(ctx context.Context, userID string, id string, fields map[string]interface{}, msgs ...OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrApplicationNotFound
+	}
+	app, ok := user.applications[id]
+	if !ok {
+		return ErrApplicationNotFound
+	}
+
+	for key, value := range fields {
+		setApplicationField(&app, key, value)
+	}
+	user.applications[id] = app
+	s.enqueue(msgs)
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteApplication(ctx context.Context, userID string, id string, msgs ...OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// deleting a missing document is not an error in Firestore either
+	if user, ok := s.users[userID]; ok {
+		delete(user.applications, id)
+	}
+	s.enqueue(msgs)
+	return nil
+}
+
+func (s *MemoryStore) IncrementCounters(ctx context.Context, userID string, deltas map[string]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	for key, delta := range deltas {
+		current, _ := user.fields[key].(int64)
+		if delta < 0 && current <= 0 {
+			continue
+		}
+		user.fields[key] = current + delta
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Enqueue(ctx context.Context, msgs ...OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enqueue(msgs)
+	return nil
+}
+
+func (s *MemoryStore) PendingMessages(ctx context.Context, after *OutboxMessage, limit int) ([]OutboxMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	msgs := make([]OutboxMessage, 0, len(s.outbox))
+	for _, msg := range s.outbox {
+		if after == nil || outboxBefore(*after, msg) {
+			msgs = append(msgs, msg)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return outboxBefore(msgs[i], msgs[j])
+	})
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+	return msgs, nil
+}
+
+func outboxBefore(a OutboxMessage, b OutboxMessage) bool {
+	if a.CreatedAt.Equal(b.CreatedAt) {
+		return a.ID < b.ID
+	}
+	return a.CreatedAt.Before(b.CreatedAt)
+}
+
+func (s *MemoryStore) ClaimMessage(ctx context.Context, id string, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.outbox[id]
+	if !ok || msg.LeasedUntil.After(time.Now()) {
+		return false, nil
+	}
+	msg.LeasedUntil = until
+	s.outbox[id] = msg
+	return true, nil
+}
+
+func (s *MemoryStore) DeleteMessage(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.outbox, id)
+	return nil
+}
+
+func (s *MemoryStore) RetryMessage(ctx context.Context, id string, retryAt time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.outbox[id]
+	if !ok {
+		return nil
+	}
+	msg.Attempts++
+	msg.LastError = lastError
+	msg.LeasedUntil = retryAt
+	s.outbox[id] = msg
+	return nil
+}
+
+// field names match the firestore tags on Application
+func setApplicationField(app *Application, key string, value interface{}) {
+	switch key {
+	case "role":
+		app.Role = toString(value)
+	case "company":
+		app.Company = toString(value)
+	case "location":
+		app.Location = toString(value)
+	case "link":
+		app.Link = toString(value)
+	case "status":
+		app.Status = ApplicationStatus(toString(value))
+	case "appliedDate":
+		if date, ok := value.(int64); ok {
+			app.AppliedDate = date
+		}
+	}
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case ApplicationStatus:
+		return string(v)
+	}
+	return ""
+}
+
+const idAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// generates a random 20 character ID, same shape as Firestore auto IDs
+func NewID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = idAlphabet[int(b[i])%len(idAlphabet)]
+	}
+	return string(b)
+}
@@ -0,0 +1,115 @@
+package userstore
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrations are plain SQL files named {version}_{description}.sql and are applied in order
+// a migration is never edited once merged; add a new file instead
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// arbitrary key so only one API instance runs migrations at a time
+const migrationLockID = 7253001
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// applies every migration that hasn't been applied yet, each in its own transaction
+func Migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	for _, m := range migrations {
+		err := pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+			// released automatically at the end of the transaction
+			if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+				return err
+			}
+
+			var applied bool
+			err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, m.version).Scan(&applied)
+			if err != nil {
+				return err
+			}
+			if applied {
+				return nil
+			}
+
+			if _, err := tx.Exec(ctx, m.sql); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name); err != nil {
+				return err
+			}
+
+			log.Printf("Applied migration %04d_%s", m.version, m.name)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %04d_%s: %w", m.version, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, entry := range entries {
+		filename := entry.Name()
+		versionStr, name, ok := strings.Cut(strings.TrimSuffix(filename, ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration filename: %s", filename)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", filename, err)
+		}
+
+		contents, err := migrationFiles.ReadFile("migrations/" + filename)
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{version: version, name: name, sql: string(contents)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations, nil
+}
@@ -0,0 +1,52 @@
+-- users/{email} document; counters and analytics are split out so they can be
+-- updated independently of each other (counters by the API, analytics after every event)
+CREATE TABLE users (
+    email      TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- applicationsCount and the per-status {status}_count fields
+CREATE TABLE user_counters (
+    email TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    name  TEXT NOT NULL,
+    value BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (email, name)
+);
+
+-- users/{email}/applications/{id}
+CREATE TABLE applications (
+    email        TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    id           TEXT NOT NULL,
+    role         TEXT NOT NULL DEFAULT '',
+    company      TEXT NOT NULL DEFAULT '',
+    location     TEXT NOT NULL DEFAULT '',
+    applied_date BIGINT NOT NULL DEFAULT 0,
+    status       TEXT NOT NULL,
+    link         TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (email, id)
+);
+
+-- same columns as applications_data.applications in BigQuery
+-- not tied to users so history can be inspected independently of the current state
+CREATE TABLE application_events (
+    operation_id TEXT PRIMARY KEY DEFAULT gen_random_uuid()::text,
+    email        TEXT NOT NULL,
+    job_id       TEXT NOT NULL,
+    event_time   TIMESTAMPTZ NOT NULL,
+    applied_date TIMESTAMPTZ NOT NULL,
+    status       TEXT NOT NULL,
+    operation    TEXT NOT NULL
+);
+
+CREATE INDEX application_events_email_job_idx ON application_events (email, job_id, event_time DESC);
+CREATE INDEX application_events_email_applied_idx ON application_events (email, applied_date);
+
+-- output of the analytics query, same field names as the Firestore user document
+-- state is what they're computed from, kept up to date event by event (shared/analytics.State);
+-- NULL until the first event, or to have it rebuilt from application_events
+CREATE TABLE user_analytics (
+    email        TEXT PRIMARY KEY REFERENCES users (email) ON DELETE CASCADE,
+    analytics    JSONB NOT NULL DEFAULT '{}'::jsonb,
+    state        JSONB,
+    last_updated TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,15 @@
+-- messages waiting to be published to PubSub; written in the same transaction as the change
+-- they describe and deleted by the relay once published
+CREATE TABLE outbox (
+    id           TEXT PRIMARY KEY,
+    data         BYTEA NOT NULL,
+    ordering_key TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    last_error   TEXT NOT NULL DEFAULT '',
+    -- a relay is publishing the message (or waiting to retry it) until this time; the epoch
+    -- rather than '-infinity', which pgx can't scan into a time.Time
+    leased_until TIMESTAMPTZ NOT NULL DEFAULT 'epoch'
+);
+
+CREATE INDEX outbox_created_idx ON outbox (created_at, id);
@@ -0,0 +1,14 @@
+-- login sessions (see auth/authstore); access tokens are only accepted while their session
+-- is active, and the refresh token hash is replaced on every refresh
+CREATE TABLE sessions (
+    id            TEXT PRIMARY KEY,
+    email         TEXT NOT NULL,
+    refresh_hash  TEXT NOT NULL,
+    previous_hash TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    rotated_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at    TIMESTAMPTZ NOT NULL,
+    revoked_at    TIMESTAMPTZ
+);
+
+CREATE INDEX sessions_email_idx ON sessions (email) WHERE revoked_at IS NULL;
@@ -0,0 +1,9 @@
+-- one-time login codes (see auth/authstore/codes.go); a row is deleted when the code is redeemed
+CREATE TABLE auth_codes (
+    code_hash  TEXT PRIMARY KEY,
+    email      TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX auth_codes_expires_at_idx ON auth_codes (expires_at);
@@ -0,0 +1,19 @@
+-- logins linked to each account (see auth/authstore/identities.go); one account can sign in
+-- with several providers
+CREATE TABLE identities (
+    provider       TEXT NOT NULL,
+    subject        TEXT NOT NULL,
+    email          TEXT NOT NULL,
+    provider_email TEXT NOT NULL DEFAULT '',
+    linked_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX identities_email_idx ON identities (email);
+
+-- codes are also used to link a provider to an account now
+ALTER TABLE auth_codes ADD COLUMN purpose TEXT NOT NULL DEFAULT 'login';
+-- account links are confirmed by the browser that asked for them (see auth/identities.go): link
+-- codes carry the hash of that browser's nonce, and the confirmation code the provider login
+ALTER TABLE auth_codes ADD COLUMN binding TEXT NOT NULL DEFAULT '';
+ALTER TABLE auth_codes ADD COLUMN identity JSONB;
@@ -0,0 +1,61 @@
+-- users are keyed by an ID minted at signup instead of their email, so the email can change
+-- (see userstore/store.go). existing users get a generated ID and keep their email as a unique column
+ALTER TABLE users ADD COLUMN id TEXT NOT NULL DEFAULT gen_random_uuid()::text;
+ALTER TABLE users ALTER COLUMN id DROP DEFAULT;
+
+-- dropping each email column also drops the primary keys and foreign keys it was part of
+ALTER TABLE user_counters ADD COLUMN user_id TEXT;
+UPDATE user_counters SET user_id = users.id FROM users WHERE users.email = user_counters.email;
+ALTER TABLE user_counters DROP COLUMN email;
+ALTER TABLE user_counters ALTER COLUMN user_id SET NOT NULL;
+
+ALTER TABLE applications ADD COLUMN user_id TEXT;
+UPDATE applications SET user_id = users.id FROM users WHERE users.email = applications.email;
+ALTER TABLE applications DROP COLUMN email;
+ALTER TABLE applications ALTER COLUMN user_id SET NOT NULL;
+
+ALTER TABLE user_analytics ADD COLUMN user_id TEXT;
+UPDATE user_analytics SET user_id = users.id FROM users WHERE users.email = user_analytics.email;
+ALTER TABLE user_analytics DROP COLUMN email;
+ALTER TABLE user_analytics ALTER COLUMN user_id SET NOT NULL;
+
+ALTER TABLE users DROP CONSTRAINT users_pkey;
+ALTER TABLE users ADD PRIMARY KEY (id);
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+
+ALTER TABLE user_counters ADD PRIMARY KEY (user_id, name);
+ALTER TABLE user_counters ADD FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE applications ADD PRIMARY KEY (user_id, id);
+ALTER TABLE applications ADD FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE user_analytics ADD PRIMARY KEY (user_id);
+ALTER TABLE user_analytics ADD FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+-- events aren't tied to users; events of users that no longer exist are dropped
+ALTER TABLE application_events ADD COLUMN user_id TEXT;
+UPDATE application_events SET user_id = users.id FROM users WHERE users.email = application_events.email;
+DELETE FROM application_events WHERE user_id IS NULL;
+ALTER TABLE application_events DROP COLUMN email;
+ALTER TABLE application_events ALTER COLUMN user_id SET NOT NULL;
+
+CREATE INDEX application_events_user_job_idx ON application_events (user_id, job_id, event_time DESC);
+CREATE INDEX application_events_user_applied_idx ON application_events (user_id, applied_date);
+
+ALTER TABLE identities ADD COLUMN user_id TEXT;
+UPDATE identities SET user_id = users.id FROM users WHERE users.email = identities.email;
+DELETE FROM identities WHERE user_id IS NULL;
+ALTER TABLE identities DROP COLUMN email;
+ALTER TABLE identities ALTER COLUMN user_id SET NOT NULL;
+
+CREATE INDEX identities_user_id_idx ON identities (user_id);
+
+-- only an email the provider verified can become the account's email (POST /auth/email)
+ALTER TABLE identities ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT false;
+
+-- access tokens now carry the user ID, so everyone logs in again; sessions and codes are short-lived
+-- anyway, so they're dropped instead of re-keyed
+DELETE FROM sessions;
+ALTER TABLE sessions RENAME COLUMN email TO user_id;
+ALTER INDEX sessions_email_idx RENAME TO sessions_user_id_idx;
+
+DELETE FROM auth_codes;
+ALTER TABLE auth_codes RENAME COLUMN email TO user_id;
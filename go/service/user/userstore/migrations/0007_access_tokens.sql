@@ -0,0 +1,13 @@
+-- personal access tokens (see auth/authstore/tokens.go); only the hash of the secret is stored
+CREATE TABLE access_tokens (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL,
+    name         TEXT NOT NULL,
+    scopes       TEXT[] NOT NULL,
+    token_hash   TEXT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX access_tokens_user_id_idx ON access_tokens (user_id);
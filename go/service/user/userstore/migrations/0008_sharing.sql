@@ -0,0 +1,23 @@
+-- sharing (see grants.go): pending invitations and the read-only grants they turn into
+CREATE TABLE invitations (
+    id         TEXT PRIMARY KEY,
+    owner_id   TEXT NOT NULL,
+    email      TEXT NOT NULL,
+    role       TEXT NOT NULL,
+    scopes     TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX invitations_owner_id_idx ON invitations (owner_id);
+
+CREATE TABLE grants (
+    owner_id   TEXT NOT NULL,
+    grantee_id TEXT NOT NULL,
+    role       TEXT NOT NULL,
+    scopes     TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (owner_id, grantee_id)
+);
+
+CREATE INDEX grants_grantee_id_idx ON grants (grantee_id);
@@ -0,0 +1,51 @@
+package userstore
+
+// transactional outbox: instead of writing to the store and then publishing to PubSub (and trying
+// to undo the write if the publish fails), a write and the message describing it are stored together
+// in one transaction. the relay (service/user/outbox) publishes stored messages in the background
+// and deletes them once PubSub has them, so a crash at any point can only delay a message, never lose it
+
+import (
+	"context"
+	"time"
+)
+
+type OutboxMessage struct {
+	ID          string    `firestore:"-"`
+	Data        []byte    `firestore:"data"`
+	OrderingKey string    `firestore:"orderingKey"`
+	CreatedAt   time.Time `firestore:"createdAt"`
+	Attempts    int       `firestore:"attempts"`
+	LastError   string    `firestore:"lastError"`
+	// a relay is publishing the message (or waiting to retry it) until this time
+	LeasedUntil time.Time `firestore:"leasedUntil"`
+	// the event log row for the write, for stores that keep the event log themselves (Postgres)
+	// and record it in the same transaction. not stored with the message; elsewhere
+	// bigquery-consumer writes the log from the published message instead
+	Event *Event `firestore:"-"`
+}
+
+// builds a message ready to be passed to a store write
+func NewOutboxMessage(data []byte, orderingKey string) OutboxMessage {
+	return OutboxMessage{
+		ID:          NewID(),
+		Data:        data,
+		OrderingKey: orderingKey,
+		CreatedAt:   time.Now(),
+	}
+}
+
+type Outbox interface {
+	// stores messages on their own, for events that don't change anything in the store
+	Enqueue(ctx context.Context, msgs ...OutboxMessage) error
+	// oldest first (by CreatedAt, then ID), including messages currently leased by a relay. starts
+	// after the given message, or at the oldest one if it's nil, so callers can page past messages
+	// they can't publish yet
+	PendingMessages(ctx context.Context, after *OutboxMessage, limit int) ([]OutboxMessage, error)
+	// leases a message until the given time; false if someone else holds an unexpired lease
+	ClaimMessage(ctx context.Context, id string, until time.Time) (bool, error)
+	// called once the message is published
+	DeleteMessage(ctx context.Context, id string) error
+	// records a failed publish and keeps the message leased until retryAt
+	RetryMessage(ctx context.Context, id string, retryAt time.Time, lastError string) error
+}
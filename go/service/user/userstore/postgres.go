@@ -0,0 +1,470 @@
+package userstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres replaces all three stores for the user service: applications and counters (Firestore),
+// the event log (BigQuery) and analytics (bigquery-consumer). so it implements ApplicationStore,
+// EventLog, and it records the Event carried by each outbox message (see OutboxMessage.Event) in the
+// same transaction as the write. schema is in migrations/, run Migrate before using the store
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{
+		pool: pool,
+	}
+}
+
+func (s *PostgresStore) CreateUser(ctx context.Context, userID string, email string) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `INSERT INTO users (id, email) VALUES ($1, $2) ON CONFLICT DO NOTHING`, userID, email)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			// either the user exists already or someone else has the email
+			var owner string
+			err := tx.QueryRow(ctx, `SELECT id FROM users WHERE email = $1`, email).Scan(&owner)
+			if err == nil && owner != userID {
+				return ErrEmailTaken
+			}
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+			return nil
+		}
+
+		_, err = tx.Exec(ctx, `INSERT INTO user_counters (user_id, name, value) VALUES ($1, 'applicationsCount', 0)`, userID)
+		return err
+	})
+}
+
+// applications can only be added for users that went through CreateUser, since the user row needs an email
+func requireUser(ctx context.Context, tx pgx.Tx, userID string) error {
+	var exists bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) UserExists(ctx context.Context, userID string) (bool, error) {
+	var exists bool
+	err := s.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists)
+	return exists, err
+}
+
+func (s *PostgresStore) FindUserByEmail(ctx context.Context, email string) (string, error) {
+	var userID string
+	err := s.pool.QueryRow(ctx, `SELECT id FROM users WHERE email = $1`, email).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+	return userID, err
+}
+
+func (s *PostgresStore) SetEmail(ctx context.Context, userID string, email string) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE users SET email = $2 WHERE id = $1`, userID, email)
+	// unique_violation on users_email_key
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// flattens counters and analytics into one map so it looks like the Firestore user document
+func (s *PostgresStore) GetUser(ctx context.Context, userID string) (map[string]interface{}, error) {
+	var email string
+	err := s.pool.QueryRow(ctx, `SELECT email FROM users WHERE id = $1`, userID).Scan(&email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{
+		"email": email,
+	}
+
+	var analytics map[string]interface{}
+	err = s.pool.QueryRow(ctx, `SELECT analytics FROM user_analytics WHERE user_id = $1`, userID).Scan(&analytics)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	for key, value := range analytics {
+		fields[key] = value
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT name, value FROM user_counters WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var value int64
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		fields[name] = value
+	}
+
+	return fields, rows.Err()
+}
+
+// runs a write together with its outbox messages in one transaction
+func (s *PostgresStore) writeWithOutbox(ctx context.Context, msgs []OutboxMessage, write func(tx pgx.Tx) error) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		if err := write(tx); err != nil {
+			return err
+		}
+		return enqueue(ctx, tx, msgs)
+	})
+}
+
+func enqueue(ctx context.Context, tx pgx.Tx, msgs []OutboxMessage) error {
+	for _, msg := range msgs {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO outbox (id, data, ordering_key, created_at)
+			VALUES ($1, $2, $3, $4)
+		`, msg.ID, msg.Data, msg.OrderingKey, msg.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if msg.Event != nil {
+			if err := recordEvent(ctx, tx, *msg.Event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applications, counters and analytics are removed by ON DELETE CASCADE; events are
+// removed when the userDelete event is recorded
+func (s *PostgresStore) DeleteUser(ctx context.Context, userID string, msgs ...OutboxMessage) error {
+	return s.writeWithOutbox(ctx, msgs, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+		return err
+	})
+}
+
+func (s *PostgresStore) AddApplication(ctx context.Context, userID string, app Application, msgs ...OutboxMessage) (string, error) {
+	if app.ID == "" {
+		app.ID = NewID()
+	}
+
+	err := s.writeWithOutbox(ctx, msgs, func(tx pgx.Tx) error {
+		if err := requireUser(ctx, tx, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO applications (user_id, id, role, company, location, applied_date, status, link)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, userID, app.ID, app.Role, app.Company, app.Location, app.AppliedDate, string(app.Status), app.Link)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return app.ID, nil
+}
+
+func (s *PostgresStore) GetApplication(ctx context.Context, userID string, id string) (*Application, error) {
+	var app Application
+	var status string
+	err := s.pool.QueryRow(ctx, `
+		SELECT id, role, company, location, applied_date, status, link
+		FROM applications
+		WHERE user_id = $1 AND id = $2
+	`, userID, id).Scan(&app.ID, &app.Role, &app.Company, &app.Location, &app.AppliedDate, &status, &app.Link)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrApplicationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	app.Status = ApplicationStatus(status)
+
+	return &app, nil
+}
+
+func (s *PostgresStore) SetApplication(ctx context.Context, userID string, app Application, msgs ...OutboxMessage) error {
+	return s.writeWithOutbox(ctx, msgs, func(tx pgx.Tx) error {
+		if err := requireUser(ctx, tx, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO applications (user_id, id, role, company, location, applied_date, status, link)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (user_id, id) DO UPDATE SET
+				role = EXCLUDED.role,
+				company = EXCLUDED.company,
+				location = EXCLUDED.location,
+				applied_date = EXCLUDED.applied_date,
+				status = EXCLUDED.status,
+				link = EXCLUDED.link
+		`, userID, app.ID, app.Role, app.Company, app.Location, app.AppliedDate, string(app.Status), app.Link)
+		return err
+	})
+}
+
+// maps Firestore field names to columns; anything else is rejected rather than silently dropped
+var applicationColumns = map[string]string{
+	"role":        "role",
+	"company":     "company",
+	"location":    "location",
+	"link":        "link",
+	"status":      "status",
+	"appliedDate": "applied_date",
+}
+
+func (s *PostgresStore) UpdateApplication(ctx context.Context, userID string, id string, fields map[string]interface{}, msgs ...OutboxMessage) error {
+	if len(fields) == 0 {
+		return s.Enqueue(ctx, msgs...)
+	}
+
+	query := `UPDATE applications SET `
+	args := []interface{}{userID, id}
+	i := 0
+	for key, value := range fields {
+		column, ok := applicationColumns[key]
+		if !ok {
+			return fmt.Errorf("unknown application field: %s", key)
+		}
+		if status, ok := value.(ApplicationStatus); ok {
+			value = string(status)
+		}
+		if i > 0 {
+			query += ", "
+		}
+		args = append(args, value)
+		query += fmt.Sprintf("%s = $%d", column, len(args))
+		i++
+	}
+	query += ` WHERE user_id = $1 AND id = $2`
+
+	return s.writeWithOutbox(ctx, msgs, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrApplicationNotFound
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStore) DeleteApplication(ctx context.Context, userID string, id string, msgs ...OutboxMessage) error {
+	return s.writeWithOutbox(ctx, msgs, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM applications WHERE user_id = $1 AND id = $2`, userID, id)
+		return err
+	})
+}
+
+func (s *PostgresStore) IncrementCounters(ctx context.Context, userID string, deltas map[string]int64) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		for name, delta := range deltas {
+			var err error
+			if delta >= 0 {
+				_, err = tx.Exec(ctx, `
+					INSERT INTO user_counters (user_id, name, value) VALUES ($1, $2, $3)
+					ON CONFLICT (user_id, name) DO UPDATE SET value = user_counters.value + EXCLUDED.value
+				`, userID, name, delta)
+			} else {
+				// only decrement if the counter is currently greater than 0
+				_, err = tx.Exec(ctx, `
+					UPDATE user_counters SET value = value + $3
+					WHERE user_id = $1 AND name = $2 AND value > 0
+				`, userID, name, delta)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStore) Enqueue(ctx context.Context, msgs ...OutboxMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		return enqueue(ctx, tx, msgs)
+	})
+}
+
+func (s *PostgresStore) PendingMessages(ctx context.Context, after *OutboxMessage, limit int) ([]OutboxMessage, error) {
+	// a NULL cursor starts at the oldest message
+	var afterCreatedAt *time.Time
+	var afterID string
+	if after != nil {
+		afterCreatedAt, afterID = &after.CreatedAt, after.ID
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, data, ordering_key, created_at, attempts, last_error, leased_until
+		FROM outbox
+		WHERE $2::timestamptz IS NULL OR (created_at, id) > ($2, $3)
+		ORDER BY created_at, id
+		LIMIT $1
+	`, limit, afterCreatedAt, afterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []OutboxMessage
+	for rows.Next() {
+		var msg OutboxMessage
+		err := rows.Scan(&msg.ID, &msg.Data, &msg.OrderingKey, &msg.CreatedAt, &msg.Attempts, &msg.LastError, &msg.LeasedUntil)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, rows.Err()
+}
+
+func (s *PostgresStore) ClaimMessage(ctx context.Context, id string, until time.Time) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE outbox SET leased_until = $2
+		WHERE id = $1 AND leased_until <= now()
+	`, id, until)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *PostgresStore) DeleteMessage(ctx context.Context, id string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM outbox WHERE id = $1`, id)
+	return err
+}
+
+func (s *PostgresStore) RetryMessage(ctx context.Context, id string, retryAt time.Time, lastError string) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE outbox SET attempts = attempts + 1, last_error = $3, leased_until = $2
+		WHERE id = $1
+	`, id, retryAt, lastError)
+	return err
+}
+
+func (s *PostgresStore) Timeline(ctx context.Context, userID string, jobID string) ([]Event, error) {
+	return s.queryEvents(ctx, `
+		SELECT operation_id, user_id, job_id, event_time, applied_date, status, operation
+		FROM application_events
+		WHERE user_id = $1
+		AND job_id = $2
+		AND operation != 'revert'
+		ORDER BY event_time DESC
+	`, userID, jobID)
+}
+
+func (s *PostgresStore) StatusHistory(ctx context.Context, userID string, jobID string, limit int) ([]Event, error) {
+	return s.queryEvents(ctx, `
+		SELECT operation_id, user_id, job_id, event_time, applied_date, status, operation
+		FROM application_events
+		WHERE user_id = $1
+		AND job_id = $2
+		AND operation NOT IN ('revert', 'add') -- cannot revert a revert or an add
+		ORDER BY event_time DESC
+		LIMIT $3
+	`, userID, jobID, limit)
+}
+
+func (s *PostgresStore) queryEvents(ctx context.Context, query string, args ...interface{}) ([]Event, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var event Event
+		err := rows.Scan(&event.OperationID, &event.UserID, &event.JobID, &event.EventTime, &event.AppliedDate, &event.Status, &event.Operation)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// same semantics as bigquery-consumer's job handlers. runs inside the transaction of the write the
+// event describes, so the log and analytics can't fall behind (or get ahead of) the store
+func recordEvent(ctx context.Context, tx pgx.Tx, event Event) error {
+	var (
+		tag pgconn.CommandTag
+		err error
+	)
+
+	switch event.Operation {
+	case "add", "edit":
+		if event.OperationID == "" {
+			tag, err = tx.Exec(ctx, `
+				INSERT INTO application_events (user_id, job_id, event_time, applied_date, status, operation)
+				VALUES ($1, $2, $3, $4, $5, $6)
+			`, event.UserID, event.JobID, event.EventTime, event.AppliedDate, event.Status, event.Operation)
+		} else {
+			// recording the same operation twice is a no-op
+			tag, err = tx.Exec(ctx, `
+				INSERT INTO application_events (operation_id, user_id, job_id, event_time, applied_date, status, operation)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (operation_id) DO NOTHING
+			`, event.OperationID, event.UserID, event.JobID, event.EventTime, event.AppliedDate, event.Status, event.Operation)
+		}
+	case "delete":
+		_, err = tx.Exec(ctx, `DELETE FROM application_events WHERE user_id = $1 AND job_id = $2`, event.UserID, event.JobID)
+	case "revert":
+		_, err = tx.Exec(ctx, `
+			UPDATE application_events SET operation = 'revert'
+			WHERE user_id = $1 AND job_id = $2 AND operation_id = $3
+		`, event.UserID, event.JobID, event.OperationID)
+	case "userDelete":
+		_, err = tx.Exec(ctx, `DELETE FROM application_events WHERE user_id = $1`, event.UserID)
+		// nothing left to recalculate
+		return err
+	default:
+		return fmt.Errorf("unknown operation: %s", event.Operation)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to record event: %w", err)
+	}
+
+	applied := tag.RowsAffected() > 0
+	if err := updateAnalytics(ctx, tx, event, applied); err != nil {
+		return fmt.Errorf("failed to update analytics: %w", err)
+	}
+	return nil
+}
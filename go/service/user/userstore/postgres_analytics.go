@@ -0,0 +1,120 @@
+package userstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/copium-dev/copium/shared/analytics"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// brings the user's analytics up to date with an event that was just recorded, the way
+// bigquery-consumer's aggregator does: adds and edits are folded into the stored state
+// (user_analytics.state), then every metric registered in shared/analytics is computed from it.
+// deletes and reverts can't be undone incrementally, so those (and a user with no state yet)
+// rebuild the state from the event log instead. applied is false for an event that was already
+// recorded, which mustn't be counted twice
+func updateAnalytics(ctx context.Context, tx pgx.Tx, event Event, applied bool) error {
+	now := time.Now()
+
+	var state *analytics.State
+	if event.Operation == "add" || event.Operation == "edit" {
+		var err error
+		state, err = loadAnalyticsState(ctx, tx, event.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if state == nil {
+		var err error
+		state, err = rebuildAnalyticsState(ctx, tx, event.UserID, now)
+		if err != nil {
+			return err
+		}
+	} else {
+		if applied {
+			state.Apply(analytics.Row{
+				JobID:       event.JobID,
+				EventTime:   event.EventTime.Unix(),
+				AppliedDate: event.AppliedDate.Unix(),
+				Status:      event.Status,
+				Operation:   event.Operation,
+			})
+		}
+		state.Prune(now)
+	}
+
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode analytics state: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_analytics (user_id, analytics, state, last_updated) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET analytics = EXCLUDED.analytics, state = EXCLUDED.state, last_updated = EXCLUDED.last_updated
+	`, event.UserID, analytics.Compute(state, now), encoded, now)
+	if err != nil {
+		return fmt.Errorf("failed to store analytics: %w", err)
+	}
+	return nil
+}
+
+// the stored state, locked until the transaction ends so concurrent writes for the user apply
+// one after the other; nil if there is none yet
+func loadAnalyticsState(ctx context.Context, tx pgx.Tx, userID string) (*analytics.State, error) {
+	var encoded []byte
+	err := tx.QueryRow(ctx, `SELECT state FROM user_analytics WHERE user_id = $1 FOR UPDATE`, userID).Scan(&encoded)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && encoded == nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read analytics state: %w", err)
+	}
+
+	state := analytics.NewState()
+	if err := json.Unmarshal(encoded, state); err != nil {
+		return nil, fmt.Errorf("failed to decode analytics state: %w", err)
+	}
+	return state, nil
+}
+
+// replays the user's event log, minus reverted operations (the same rows the consumer's rebuild reads)
+func rebuildAnalyticsState(ctx context.Context, tx pgx.Tx, userID string, now time.Time) (*analytics.State, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT job_id, event_time, applied_date, status, operation
+		FROM application_events
+		WHERE user_id = $1
+		AND operation != 'revert'
+		ORDER BY event_time
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []analytics.Row
+	for rows.Next() {
+		var jobID, status, operation string
+		var eventTime, appliedDate time.Time
+		if err := rows.Scan(&jobID, &eventTime, &appliedDate, &status, &operation); err != nil {
+			return nil, fmt.Errorf("failed to read event: %w", err)
+		}
+		events = append(events, analytics.Row{
+			JobID:       jobID,
+			EventTime:   eventTime.Unix(),
+			AppliedDate: appliedDate.Unix(),
+			Status:      status,
+			Operation:   operation,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+
+	return analytics.FromRows(events, now), nil
+}
@@ -0,0 +1,110 @@
+package userstore
+
+// this package hides where user and application data actually lives from the HTTP handlers
+// the handlers only talk to an ApplicationStore, so the same handler code can run against
+// Firestore (prod and emulator) or a plain in-memory map (local dev and unit tests)
+// layout mirrors the original Firestore layout:
+//   users/{userID}                        -> user document (email, counters + analytics written by bigquery-consumer)
+//   users/{userID}/applications/{id}      -> application document
+//   emails/{email}                        -> {userID}; keeps emails unique and finds a user by email
+//   outbox/{id}                           -> messages waiting to be published to PubSub (see outbox.go)
+//
+// users are keyed by an ID minted at signup (NewUserID) instead of their email, so the email can
+// change without moving any data. users from before that were re-keyed by cmd/migrate-user-ids
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/copium-dev/copium/go/service/user/userutils"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUserNotFound        = errors.New("user not found")
+	ErrApplicationNotFound = errors.New("application not found")
+	// the email belongs to another user
+	ErrEmailTaken = errors.New("email is already used by another account")
+)
+
+type ApplicationStatus = userutils.ApplicationStatus
+
+type Application struct {
+	ID          string            `json:"id" firestore:"-"`
+	Role        string            `json:"role" firestore:"role"`
+	Company     string            `json:"company" firestore:"company"`
+	Location    string            `json:"location" firestore:"location"`
+	AppliedDate int64             `json:"appliedDate" firestore:"appliedDate"`
+	Status      ApplicationStatus `json:"status" firestore:"status"`
+	Link        string            `json:"link" firestore:"link"`
+}
+
+// every write that other services need to hear about takes outbox messages, which are stored
+// atomically with the write itself. the outbox relay publishes them afterwards
+type ApplicationStore interface {
+	Outbox
+
+	// creates the user document if it does not exist yet; existing users are left untouched
+	// returns ErrEmailTaken if another user already has the email
+	CreateUser(ctx context.Context, userID string, email string) error
+	UserExists(ctx context.Context, userID string) (bool, error)
+	// returns the ID of the user with this email, or ErrUserNotFound
+	FindUserByEmail(ctx context.Context, email string) (string, error)
+	// changes the user's email; returns ErrEmailTaken if another user already has it
+	SetEmail(ctx context.Context, userID string, email string) error
+	// returns every field on the user document (email, counters, analytics, etc.)
+	GetUser(ctx context.Context, userID string) (map[string]interface{}, error)
+	// deletes the user document and all of its applications
+	// NOTE: Firestore can't delete a user in one transaction, so there the messages are stored
+	// first; a failed delete can be retried and consumers will just see the message twice
+	DeleteUser(ctx context.Context, userID string, msgs ...OutboxMessage) error
+
+	// adds a new application and returns its ID; if app.ID is set it is used as the ID
+	AddApplication(ctx context.Context, userID string, app Application, msgs ...OutboxMessage) (string, error)
+	GetApplication(ctx context.Context, userID string, id string) (*Application, error)
+	// overwrites (or recreates) an application
+	SetApplication(ctx context.Context, userID string, app Application, msgs ...OutboxMessage) error
+	// updates only the given fields (keyed by the firestore field name, e.g. "status")
+	UpdateApplication(ctx context.Context, userID string, id string, fields map[string]interface{}, msgs ...OutboxMessage) error
+	DeleteApplication(ctx context.Context, userID string, id string, msgs ...OutboxMessage) error
+
+	// applies deltas to counters on the user document (e.g. applicationsCount, applied_count)
+	// negative deltas are only applied when the counter is currently > 0 so counts never go negative
+	IncrementCounters(ctx context.Context, userID string, deltas map[string]int64) error
+}
+
+// a new user ID; never reused, never changes, and isn't derived from anything about the user
+func NewUserID() string {
+	return uuid.NewString()
+}
+
+// returns the counter name for a status, e.g. "Applied" -> "applied_count"
+func StatusCounter(status ApplicationStatus) string {
+	return fmt.Sprintf("%s_count", strings.ToLower(string(status)))
+}
+
+// counter deltas for moving one application from one status to another. built up one entry at a
+// time so from == to nets out to nothing instead of one key overwriting the other
+func StatusChange(from ApplicationStatus, to ApplicationStatus) map[string]int64 {
+	deltas := map[string]int64{}
+	deltas[StatusCounter(to)] += 1
+	deltas[StatusCounter(from)] -= 1
+	for counter, delta := range deltas {
+		if delta == 0 {
+			delete(deltas, counter)
+		}
+	}
+	return deltas
+}
+
+// counter names for every status
+func StatusCounters() []string {
+	counters := make([]string, 0, len(userutils.Statuses))
+	for _, status := range userutils.Statuses {
+		counters = append(counters, StatusCounter(status))
+	}
+	return counters
+}
@@ -16,6 +16,11 @@ const (
     StatusGhosted      ApplicationStatus = "Ghosted"
 )
 
+// every status, in pipeline order
+var Statuses = []ApplicationStatus{
+    StatusApplied, StatusScreen, StatusInterviewing, StatusOffer, StatusRejected, StatusGhosted,
+}
+
 func (s ApplicationStatus) IsValid() bool {
     switch s {
     case StatusApplied, StatusScreen, StatusInterviewing, StatusOffer, StatusRejected, StatusGhosted:
@@ -5,12 +5,17 @@ import (
     "sync"
     "net/http"
     "os"
+    "strings"
+
+    "github.com/copium-dev/copium/shared/config"
 
     "github.com/gorilla/sessions"
-    "github.com/joho/godotenv"
     "github.com/markbates/goth"
     "github.com/markbates/goth/gothic"
+    "github.com/markbates/goth/providers/github"
     "github.com/markbates/goth/providers/google"
+    "github.com/markbates/goth/providers/microsoftonline"
+    "github.com/markbates/goth/providers/openidConnect"
 )
 
 type AuthHandler struct {
@@ -19,10 +24,13 @@ type AuthHandler struct {
     CallbackUrl        string
     JwtSecret          string
     Store              *sessions.CookieStore
+    // names of the configured login providers, google first
+    Providers          []string
 }
 
 var (
     store *sessions.CookieStore
+    providers []string
     once sync.Once
 )
 
@@ -31,34 +39,31 @@ var (
 // the nature of gorilla/mux is that it spawns a new goroutine for each request
 // as such, each goroutine could potentially create its own handler and store
 // and overwrite the global gothic.Store (we want to use the same store for all requests)
-func NewAuthHandler() *AuthHandler {
+// deployed (staging or prod, see shared/config) means cookies only go over HTTPS; .env is
+// already loaded by config.Load
+// SESSION_SECRET keys the cookie store, which gothic keeps the OAuth state in and cookie mode
+// keeps sessions in; not JWT_SECRET, which isn't set when tokens are signed with JWT_KEYS_DIR
+// the optional providers come from cfg too, already checked by cfg.ValidateAPI
+func NewAuthHandler(cfg *config.Config) *AuthHandler {
+    deployed := cfg.Deployed()
     once.Do(func() {
-		if os.Getenv("ENVIRONMENT") != "prod" {
-			err := godotenv.Load()
-			if err != nil {
-				log.Fatal("Error loading .env file")
-			}
-		}
-
         googleClientID := os.Getenv("GOOGLE_CLIENT_ID")
         googleClientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
         callbackUrl := os.Getenv("CALLBACK_URL")
-        jwtSecret := os.Getenv("JWT_SECRET")
 
-		isProd := os.Getenv("ENVIRONMENT") == "prod"
 		var sameSite http.SameSite
-		if isProd {
+		if deployed {
 			sameSite = http.SameSiteNoneMode
 		} else {
 			sameSite = http.SameSiteLaxMode
 		}
 
-        store = sessions.NewCookieStore([]byte(jwtSecret))
+        store = sessions.NewCookieStore([]byte(cfg.SessionSecret))
         store.Options = &sessions.Options{
             Path:     "/",
             MaxAge:   86400 * 30,
             HttpOnly: true,
-            Secure:   isProd,
+            Secure:   deployed,
             SameSite: sameSite,
         }
 
@@ -67,6 +72,10 @@ func NewAuthHandler() *AuthHandler {
         goth.UseProviders(
             google.New(googleClientID, googleClientSecret, callbackUrl),
         )
+        providers = append(providers, "google")
+
+        // the rest are optional; each is only enabled if its client ID is set
+        useOptionalProviders(cfg, callbackUrl)
     })
 
     return &AuthHandler{
@@ -75,5 +84,45 @@ func NewAuthHandler() *AuthHandler {
         CallbackUrl:        os.Getenv("CALLBACK_URL"),
         JwtSecret:          os.Getenv("JWT_SECRET"),
         Store:              store,
+        Providers:          providers,
     }
-}
\ No newline at end of file
+}
+
+// GitHub, Microsoft and any other OpenID Connect provider, each enabled by its client ID (see
+// shared/config)
+// callback URLs default to CALLBACK_URL with "google" swapped for the provider's name
+func useOptionalProviders(cfg *config.Config, googleCallbackUrl string) {
+    callbackFor := func(name string, configured string) string {
+        if configured != "" {
+            return configured
+        }
+        return strings.Replace(googleCallbackUrl, "/auth/google/", "/auth/" + name + "/", 1)
+    }
+
+    if cfg.GitHubClientID != "" {
+        // user:email so goth can get the verified primary email of users who hide it
+        goth.UseProviders(github.New(cfg.GitHubClientID, cfg.GitHubClientSecret, callbackFor("github", cfg.GitHubCallbackURL), "user:email"))
+        providers = append(providers, "github")
+    }
+
+    if cfg.MicrosoftClientID != "" {
+        provider := microsoftonline.New(cfg.MicrosoftClientID, cfg.MicrosoftClientSecret, callbackFor("microsoft", cfg.MicrosoftCallbackURL))
+        provider.SetName("microsoft")
+        goth.UseProviders(provider)
+        providers = append(providers, "microsoft")
+    }
+
+    if cfg.OIDCClientID != "" {
+        name := cfg.OIDCName
+        // fetches the discovery document, so a bad URL fails here rather than on first login
+        provider, err := openidConnect.New(cfg.OIDCClientID, cfg.OIDCClientSecret, callbackFor(name, cfg.OIDCCallbackURL), cfg.OIDCDiscoveryURL, "openid", "email", "profile")
+        if err != nil {
+            log.Fatalf("Error configuring OIDC provider %s: %v", name, err)
+        }
+        provider.SetName(name)
+        goth.UseProviders(provider)
+        providers = append(providers, name)
+    }
+
+    log.Printf("Login providers: %s", strings.Join(providers, ", "))
+}
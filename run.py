@@ -44,7 +44,9 @@ kill_processes_by_keyword("firebase emulators:start", cwd="go")
 kill_processes_by_keyword("go run cmd/main.go", cwd="go")
 kill_processes_by_keyword("go run main.go", cwd="algolia-consumer")
 kill_processes_by_keyword("go run main.go", cwd="bigquery-consumer")
+kill_processes_by_keyword("go run .", cwd="dev")
 kill_processes_by_keyword("gcloud beta emulators pubsub start")
+kill_processes_by_keyword("nats-server -js")
 
 if is_windows:
     ports = ["8080", "8085", "9000", "9099", "9199"]
@@ -73,41 +75,63 @@ firebase_proc = subprocess.Popen("firebase emulators:start", cwd="go", shell=Tru
 child_procs.append(firebase_proc)
 time.sleep(3)
 
-# 2. Start Pub/Sub emulator externally.
-#    NOTE: You must have exported:
-#          PUBSUB_EMULATOR_HOST=localhost:8085
-#          PUBSUB_PROJECT_ID=jtrackerkimpark
-#    and run: gcloud beta emulators pubsub env-init before starting.
+# 2. Start the message bus.
+#    MESSAGE_BUS=nats runs a local NATS server with JetStream instead of the Pub/Sub emulator
+#    (needs nats-server on PATH). MESSAGE_BUS=memory needs neither; the API and both consumers
+#    then run in one process (dev/), connected in memory
+message_bus = os.environ.get("MESSAGE_BUS", "pubsub")
 env = os.environ.copy()
-env["PUBSUB_EMULATOR_HOST"] = "localhost:8085"
-env["PUBSUB_PROJECT_ID"] = "jtrackerkimpark"
-if is_windows:
-    # for some reason if windows we don't run env-init. but this obviously means you should have run it before manually
+if message_bus == "memory":
     pass
+elif message_bus == "nats":
+    nats_proc = subprocess.Popen("nats-server -js", shell=True)
+    child_procs.append(nats_proc)
+    time.sleep(1)
 else:
-    subprocess.Popen("gcloud beta emulators pubsub env-init", shell=True, env=env)
-pubsub_emulator_proc = subprocess.Popen("gcloud beta emulators pubsub start --project=jtrackerkimpark", shell=True, env=env)
-child_procs.append(pubsub_emulator_proc)
-time.sleep(3)
+    # Start Pub/Sub emulator externally.
+    #    NOTE: You must have exported:
+    #          PUBSUB_EMULATOR_HOST=localhost:8085
+    #          PUBSUB_PROJECT_ID=jtrackerkimpark
+    #    and run: gcloud beta emulators pubsub env-init before starting.
+    env["PUBSUB_EMULATOR_HOST"] = "localhost:8085"
+    env["PUBSUB_PROJECT_ID"] = "jtrackerkimpark"
+    if is_windows:
+        # for some reason if windows we don't run env-init. but this obviously means you should have run it before manually
+        pass
+    else:
+        subprocess.Popen("gcloud beta emulators pubsub env-init", shell=True, env=env)
+    pubsub_emulator_proc = subprocess.Popen("gcloud beta emulators pubsub start --project=jtrackerkimpark", shell=True, env=env)
+    child_procs.append(pubsub_emulator_proc)
+    time.sleep(3)
 
 # 3. Start main API server in go/ 
-#    with FIRESTORE_EMULATOR_HOST and PUBSUB_EMULATOR_HOST set.
+#    with FIRESTORE_EMULATOR_HOST and PUBSUB_EMULATOR_HOST (or NATS_URL) set.
+#    with MESSAGE_BUS=memory, dev/ runs it and both consumers instead (steps 4 and 5)
 env_go = os.environ.copy()
 env_go["FIRESTORE_EMULATOR_HOST"] = "localhost:8080"
-env_go["PUBSUB_EMULATOR_HOST"] = "localhost:8085"
-env_go["PUBSUB_ORDERING_KEY"] = "insecure_local_key"
 env_go["FRONTEND_URL"] = "http://localhost:5173"
-go_main = subprocess.Popen("go run cmd/main.go", cwd="go", shell=True, env=env_go)
-child_procs.append(go_main)
-time.sleep(3)
+if message_bus == "memory":
+    # dev/ always uses the memory bus; MESSAGE_BUS=memory is rejected anywhere else
+    env_go.pop("MESSAGE_BUS", None)
+    dev_main = subprocess.Popen("go run .", cwd="dev", shell=True, env=env_go)
+    child_procs.append(dev_main)
+else:
+    env_go["MESSAGE_BUS"] = message_bus
+    if message_bus == "nats":
+        env_go["NATS_URL"] = os.environ.get("NATS_URL", "nats://localhost:4222")
+    else:
+        env_go["PUBSUB_EMULATOR_HOST"] = "localhost:8085"
+    go_main = subprocess.Popen("go run cmd/main.go", cwd="go", shell=True, env=env_go)
+    child_procs.append(go_main)
+    time.sleep(3)
 
-# 4. Start algolia-consumer
-algolia_consumer = subprocess.Popen("go run main.go", cwd="algolia-consumer", shell=True, env=env_go)
-child_procs.append(algolia_consumer)
+    # 4. Start algolia-consumer
+    algolia_consumer = subprocess.Popen("go run main.go", cwd="algolia-consumer", shell=True, env=env_go)
+    child_procs.append(algolia_consumer)
 
-# 5. Start bigquery-consumer
-bigquery_consumer = subprocess.Popen("go run main.go", cwd="bigquery-consumer", shell=True, env=env_go)
-child_procs.append(bigquery_consumer)
+    # 5. Start bigquery-consumer
+    bigquery_consumer = subprocess.Popen("go run main.go", cwd="bigquery-consumer", shell=True, env=env_go)
+    child_procs.append(bigquery_consumer)
 
 # 6. Start frontend dev server
 npm_dev = subprocess.Popen("npm run dev -- --open", cwd="frontend", shell=True)
@@ -0,0 +1,157 @@
+package analytics
+
+// the built-in metrics; same definitions as the SQL in bigquery-consumer/job.recalculateAnalytics
+//   - application velocity: applications with an applied date in the last 30 days (vs the 30 before)
+//   - resume effectiveness: jobs with an interview event in the last 30 days (vs the 30 before)
+//   - interview effectiveness: jobs with an offer event in the last 30 days (vs the 30 before)
+//   - response time: average days from applying to the first response, by applied date window
+//   - monthly trends: applications, interviews and offers per applied month over the last year
+
+import (
+	"sort"
+	"time"
+)
+
+// stored in Firestore with the Go field names, which is what the frontend reads
+type MonthlyTrend struct {
+	Month        string
+	Applications int64
+	Interviews   int64
+	Offers       int64
+}
+
+func init() {
+	Register(Int("application_velocity", func(s *State, w Window) int64 {
+		return applications(s, w.Current, 0)
+	}))
+	Register(Int("application_velocity_trend", func(s *State, w Window) int64 {
+		return applications(s, w.Current, 0) - applications(s, w.Previous, w.Current)
+	}))
+	Register(Int("resume_effectiveness", func(s *State, w Window) int64 {
+		return jobsWithInterview(s, w.Current, 0)
+	}))
+	Register(Int("resume_effectiveness_trend", func(s *State, w Window) int64 {
+		return jobsWithInterview(s, w.Current, 0) - jobsWithInterview(s, w.Previous, w.Current)
+	}))
+	Register(Int("interview_effectiveness", func(s *State, w Window) int64 {
+		return jobsWithOffer(s, w.Current, 0)
+	}))
+	Register(Int("interview_effectiveness_trend", func(s *State, w Window) int64 {
+		return jobsWithOffer(s, w.Current, 0) - jobsWithOffer(s, w.Previous, w.Current)
+	}))
+	Register(OptionalFloat("avg_response_time", func(s *State, w Window) (float64, bool) {
+		return averageResponse(s, w.Current, 0)
+	}))
+	// NULL if either side is, like the SQL
+	Register(OptionalFloat("avg_response_time_trend", func(s *State, w Window) (float64, bool) {
+		current, ok := averageResponse(s, w.Current, 0)
+		if !ok {
+			return 0, false
+		}
+		previous, ok := averageResponse(s, w.Previous, w.Current)
+		if !ok {
+			return 0, false
+		}
+		return current - previous, true
+	}))
+	Register(MonthlyTrends("monthly_trends", monthlyTrends))
+}
+
+// add rows for jobs applied to in [from, to); to == 0 means no upper bound
+func applications(s *State, from int64, to int64) int64 {
+	var count int64
+	for _, job := range s.Jobs {
+		if between(job.AppliedDate, from, to) {
+			count += job.Applications
+		}
+	}
+	return count
+}
+
+// distinct jobs with an interview event in [from, to)
+func jobsWithInterview(s *State, from int64, to int64) int64 {
+	var count int64
+	for _, job := range s.Jobs {
+		if anyBetween(job.Interviews, from, to) {
+			count++
+		}
+	}
+	return count
+}
+
+// distinct jobs with an offer event in [from, to)
+func jobsWithOffer(s *State, from int64, to int64) int64 {
+	var count int64
+	for _, job := range s.Jobs {
+		if anyBetween(job.Offers, from, to) {
+			count++
+		}
+	}
+	return count
+}
+
+// average whole days to first response for jobs applied to in [from, to); false if there are none
+// TIMESTAMP_DIFF(..., DAY) counts whole days, hence the integer division
+func averageResponse(s *State, from int64, to int64) (float64, bool) {
+	var sum float64
+	var count int
+	for _, job := range s.Jobs {
+		if job.FirstResponse == 0 || !between(job.AppliedDate, from, to) {
+			continue
+		}
+		sum += float64((job.FirstResponse - job.AppliedDate) / int64(day.Seconds()))
+		count++
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return sum / float64(count), true
+}
+
+// one entry per applied month in the last year, oldest first; nil (not empty) when there are
+// no months, like ARRAY_AGG over no rows
+func monthlyTrends(s *State, w Window) []MonthlyTrend {
+	months := make(map[string]*MonthlyTrend)
+	for _, job := range s.Jobs {
+		if job.AppliedDate < w.Year {
+			continue
+		}
+
+		month := time.Unix(job.AppliedDate, 0).UTC().Format("2006-01")
+		trend, ok := months[month]
+		if !ok {
+			trend = &MonthlyTrend{Month: month}
+			months[month] = trend
+		}
+		trend.Applications += job.Applications
+		if job.HasInterview {
+			trend.Interviews++
+		}
+		if job.HasOffer {
+			trend.Offers++
+		}
+	}
+
+	var trends []MonthlyTrend
+	for _, trend := range months {
+		trends = append(trends, *trend)
+	}
+	sort.Slice(trends, func(i, j int) bool {
+		return trends[i].Month < trends[j].Month
+	})
+	return trends
+}
+
+func between(t int64, from int64, to int64) bool {
+	return t >= from && (to == 0 || t < to)
+}
+
+// whether any time is in [from, to)
+func anyBetween(times []int64, from int64, to int64) bool {
+	for _, t := range times {
+		if between(t, from, to) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package analytics
+
+// every analytic the app shows is a registered Metric: a name (the field it's stored under on
+// the user document), an output type and how to compute it from a user's State
+// the consumer computes every registered metric and the API's Profile returns every registered
+// name, so a new metric is one Register call (plus a JobState field if it needs data the state
+// doesn't keep yet)
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// always written alongside the metrics
+const LastUpdatedField = "last_updated"
+
+type Type string
+
+const (
+	TypeInt Type = "int"
+	// float64, or nil when there's nothing to compute it from
+	TypeOptionalFloat Type = "optionalFloat"
+	TypeMonthlyTrends Type = "monthlyTrends"
+)
+
+// the moment analytics are computed and the window boundaries relative to it (unix seconds)
+type Window struct {
+	Now time.Time
+	// start of the last 30 days
+	Current int64
+	// start of the 30 days before that
+	Previous int64
+	// start of the last 365 days
+	Year int64
+}
+
+func NewWindow(now time.Time) Window {
+	return Window{
+		Now:      now,
+		Current:  now.Add(-30 * day).Unix(),
+		Previous: now.Add(-60 * day).Unix(),
+		Year:     now.Add(-365 * day).Unix(),
+	}
+}
+
+type Metric struct {
+	Name    string
+	Type    Type
+	compute func(s *State, w Window) interface{}
+}
+
+// the constructors below are the only way to build a Metric, so the output always matches Type
+
+func Int(name string, compute func(s *State, w Window) int64) Metric {
+	return Metric{
+		Name: name,
+		Type: TypeInt,
+		compute: func(s *State, w Window) interface{} {
+			return compute(s, w)
+		},
+	}
+}
+
+// compute returns false when the value is undefined (stored as null)
+func OptionalFloat(name string, compute func(s *State, w Window) (float64, bool)) Metric {
+	return Metric{
+		Name: name,
+		Type: TypeOptionalFloat,
+		compute: func(s *State, w Window) interface{} {
+			value, ok := compute(s, w)
+			if !ok {
+				return nil
+			}
+			return value
+		},
+	}
+}
+
+func MonthlyTrends(name string, compute func(s *State, w Window) []MonthlyTrend) Metric {
+	return Metric{
+		Name: name,
+		Type: TypeMonthlyTrends,
+		compute: func(s *State, w Window) interface{} {
+			return compute(s, w)
+		},
+	}
+}
+
+var (
+	mu      sync.RWMutex
+	metrics []Metric
+)
+
+// adds a metric; panics on a duplicate name since that's a programming error
+// meant to be called from init
+func Register(metric Metric) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if metric.Name == "" || metric.Name == LastUpdatedField || metric.compute == nil {
+		panic(fmt.Sprintf("analytics: invalid metric %q", metric.Name))
+	}
+	for _, existing := range metrics {
+		if existing.Name == metric.Name {
+			panic(fmt.Sprintf("analytics: metric %q registered twice", metric.Name))
+		}
+	}
+	metrics = append(metrics, metric)
+}
+
+// registered metrics, in registration order
+func Metrics() []Metric {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return append([]Metric(nil), metrics...)
+}
+
+// the fields analytics are stored under: every metric name plus last_updated
+func Fields() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	fields := make([]string, 0, len(metrics)+1)
+	for _, metric := range metrics {
+		fields = append(fields, metric.Name)
+	}
+	return append(fields, LastUpdatedField)
+}
+
+// every registered metric as of now, keyed by field name, plus last_updated
+func Compute(s *State, now time.Time) map[string]interface{} {
+	window := NewWindow(now)
+
+	analytics := make(map[string]interface{})
+	for _, metric := range Metrics() {
+		analytics[metric.Name] = metric.compute(s, window)
+	}
+	analytics[LastUpdatedField] = now.Unix()
+
+	return analytics
+}
@@ -0,0 +1,135 @@
+package analytics
+
+// per-user rolling aggregates, kept up to date one event at a time instead of rescanning the
+// user's whole history. windows are relative to when analytics are computed, so the state keeps
+// what's needed to evaluate them at any time (applied dates, recent event times) rather than
+// the results themselves; metrics (see metrics.go) are computed from it on demand
+// the field tags are for Firestore, where bigquery-consumer keeps the state
+
+import (
+	"time"
+)
+
+const (
+	day = 24 * time.Hour
+	// event times older than this never count towards a window again
+	windowRetention = 60 * day
+	// jobs applied to before this only count through interview/offer events still in a window
+	jobRetention = 365 * day
+)
+
+// statuses as the original SQL grouped them
+var (
+	interviewStatuses = map[string]bool{"Interviewing": true, "Screen": true}
+	offerStatuses     = map[string]bool{"Offer": true}
+	responseStatuses  = map[string]bool{"Interviewing": true, "Screen": true, "Offer": true, "Rejected": true, "Ghosted": true}
+)
+
+// one row of the event log (BigQuery applications table or Postgres application_events),
+// as far as analytics care; times are unix seconds
+type Row struct {
+	JobID       string
+	EventTime   int64
+	AppliedDate int64
+	Status      string
+	// "add" or "edit"
+	Operation string
+}
+
+type JobState struct {
+	AppliedDate int64 `firestore:"appliedDate"`
+	// add rows; normally 1
+	Applications int64 `firestore:"applications"`
+	HasInterview bool  `firestore:"hasInterview"`
+	HasOffer     bool  `firestore:"hasOffer"`
+	// interview/offer event times within windowRetention
+	Interviews []int64 `firestore:"interviews"`
+	Offers     []int64 `firestore:"offers"`
+	// earliest response after the applied date, 0 if none yet
+	FirstResponse int64 `firestore:"firstResponse"`
+}
+
+type State struct {
+	Jobs map[string]JobState `firestore:"jobs"`
+	// bumped on every change so a rebuild can tell whether it raced with one
+	Version int64 `firestore:"version"`
+	// set by deletes and reverts, which can't be applied incrementally; the state is
+	// rebuilt from the event log before it's used again
+	Stale bool `firestore:"stale"`
+}
+
+func NewState() *State {
+	return &State{
+		Jobs: make(map[string]JobState),
+	}
+}
+
+// replays rows (e.g. a user's whole event log) into a fresh state
+func FromRows(rows []Row, now time.Time) *State {
+	state := NewState()
+	for _, row := range rows {
+		state.Apply(row)
+	}
+	state.Prune(now)
+	return state
+}
+
+// folds one row into the state. not idempotent: the caller makes sure each row is applied once
+func (s *State) Apply(row Row) {
+	if s.Jobs == nil {
+		s.Jobs = make(map[string]JobState)
+	}
+
+	job := s.Jobs[row.JobID]
+	if row.AppliedDate != 0 {
+		job.AppliedDate = row.AppliedDate
+	}
+
+	switch row.Operation {
+	case "add":
+		job.Applications++
+	case "edit":
+		if interviewStatuses[row.Status] {
+			job.HasInterview = true
+			job.Interviews = append(job.Interviews, row.EventTime)
+		}
+		if offerStatuses[row.Status] {
+			job.HasOffer = true
+			job.Offers = append(job.Offers, row.EventTime)
+		}
+		if responseStatuses[row.Status] && row.EventTime > job.AppliedDate &&
+			(job.FirstResponse == 0 || row.EventTime < job.FirstResponse) {
+			job.FirstResponse = row.EventTime
+		}
+	}
+
+	s.Jobs[row.JobID] = job
+}
+
+// drops event times that can no longer fall in a window, and jobs that no metric can count
+// anymore, so the state stays bounded by the last year of applications
+func (s *State) Prune(now time.Time) {
+	cutoff := now.Add(-windowRetention).Unix()
+	jobCutoff := now.Add(-jobRetention).Unix()
+	for jobID, job := range s.Jobs {
+		job.Interviews = since(job.Interviews, cutoff)
+		job.Offers = since(job.Offers, cutoff)
+		// older than the year of monthly trends and both applied date windows; a later edit
+		// brings it back with the edit's applied date, which is all the SQL would count it for
+		if job.AppliedDate < jobCutoff && len(job.Interviews) == 0 && len(job.Offers) == 0 {
+			delete(s.Jobs, jobID)
+			continue
+		}
+		s.Jobs[jobID] = job
+	}
+}
+
+func since(times []int64, cutoff int64) []int64 {
+	kept := times[:0]
+	for _, t := range times {
+		if t >= cutoff {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,290 @@
+package analytics
+
+// the expected values are what the SQL in bigquery-consumer/job.recalculateAnalytics returns for
+// the same rows at the same moment, worked out by hand from its definitions:
+//   - windows are [now-30d, now) and [now-60d, now-30d), inclusive at the start
+//   - TIMESTAMP_DIFF(..., DAY) counts whole days and AVG skips jobs without a response
+//   - monthly trends group applied months in the last 365 days and are NULL when there are none
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+
+// unix seconds the given number of days before testNow
+func ago(days float64) int64 {
+	return testNow.Add(-time.Duration(days * float64(day))).Unix()
+}
+
+func add(jobID string, applied int64) Row {
+	return Row{JobID: jobID, EventTime: applied, AppliedDate: applied, Status: "Applied", Operation: "add"}
+}
+
+func edit(jobID string, applied int64, at int64, status string) Row {
+	return Row{JobID: jobID, EventTime: at, AppliedDate: applied, Status: status, Operation: "edit"}
+}
+
+var computeTests = []struct {
+	name string
+	rows []Row
+	want map[string]interface{}
+}{
+	{
+		name: "no rows",
+		want: map[string]interface{}{
+			"application_velocity":          int64(0),
+			"application_velocity_trend":    int64(0),
+			"resume_effectiveness":          int64(0),
+			"resume_effectiveness_trend":    int64(0),
+			"interview_effectiveness":       int64(0),
+			"interview_effectiveness_trend": int64(0),
+			"avg_response_time":             nil,
+			"avg_response_time_trend":       nil,
+			"monthly_trends":                []MonthlyTrend(nil),
+		},
+	},
+	{
+		name: "windows",
+		rows: []Row{
+			add("a", ago(10)),
+			add("b", ago(45)),
+			add("c", ago(90)),
+			add("d", ago(30)),
+			add("e", ago(60)),
+			add("f", ago(5)),
+			edit("c", ago(90), ago(20), "Interviewing"),
+			edit("b", ago(45), ago(40), "Interviewing"),
+			edit("b", ago(45), ago(35), "Screen"),
+			edit("a", ago(10), ago(8), "Screen"),
+			edit("a", ago(10), ago(2), "Offer"),
+		},
+		want: map[string]interface{}{
+			// a, d (exactly 30 days ago) and f vs b and e (exactly 60 days ago)
+			"application_velocity":       int64(3),
+			"application_velocity_trend": int64(1),
+			// a and c vs b, counted once for two interview events
+			"resume_effectiveness":          int64(2),
+			"resume_effectiveness_trend":    int64(1),
+			"interview_effectiveness":       int64(1),
+			"interview_effectiveness_trend": int64(1),
+			// a after 2 days vs b after 5; c is outside both windows
+			"avg_response_time":       2.0,
+			"avg_response_time_trend": -3.0,
+			"monthly_trends": []MonthlyTrend{
+				{Month: "2025-03", Applications: 1, Interviews: 1},
+				{Month: "2025-04", Applications: 1},
+				{Month: "2025-05", Applications: 2, Interviews: 1},
+				{Month: "2025-06", Applications: 2, Interviews: 1, Offers: 1},
+			},
+		},
+	},
+	{
+		name: "window boundaries",
+		rows: []Row{
+			add("a", ago(30)),
+			add("b", ago(30)-1),
+			add("c", ago(60)),
+			add("d", ago(60)-1),
+		},
+		want: map[string]interface{}{
+			"application_velocity":          int64(1),
+			"application_velocity_trend":    int64(-1),
+			"resume_effectiveness":          int64(0),
+			"resume_effectiveness_trend":    int64(0),
+			"interview_effectiveness":       int64(0),
+			"interview_effectiveness_trend": int64(0),
+			"avg_response_time":             nil,
+			"avg_response_time_trend":       nil,
+			"monthly_trends": []MonthlyTrend{
+				{Month: "2025-04", Applications: 2},
+				{Month: "2025-05", Applications: 2},
+			},
+		},
+	},
+	{
+		name: "first response",
+		rows: []Row{
+			add("a", ago(20)),
+			add("b", ago(25)),
+			add("c", ago(10)),
+			// out of order; the earliest response counts
+			edit("a", ago(20), ago(5), "Rejected"),
+			edit("a", ago(20), ago(15), "Screen"),
+			edit("a", ago(20), ago(3), "Ghosted"),
+			// not a response, then one a day and a half in
+			edit("b", ago(25), ago(24), "Applied"),
+			edit("b", ago(25), ago(23.5), "Interviewing"),
+			// at the applied date, not after it
+			edit("c", ago(10), ago(10), "Rejected"),
+		},
+		want: map[string]interface{}{
+			"application_velocity":          int64(3),
+			"application_velocity_trend":    int64(3),
+			"resume_effectiveness":          int64(2),
+			"resume_effectiveness_trend":    int64(2),
+			"interview_effectiveness":       int64(0),
+			"interview_effectiveness_trend": int64(0),
+			// a after 5 days, b after 1 (whole days)
+			"avg_response_time": 3.0,
+			// no responses in the previous window
+			"avg_response_time_trend": nil,
+			"monthly_trends": []MonthlyTrend{
+				{Month: "2025-05", Applications: 2, Interviews: 2},
+				{Month: "2025-06", Applications: 1},
+			},
+		},
+	},
+	{
+		name: "no responses",
+		rows: []Row{
+			add("a", ago(3)),
+			add("b", ago(40)),
+			edit("b", ago(40), ago(39), "Applied"),
+		},
+		want: map[string]interface{}{
+			"application_velocity":          int64(1),
+			"application_velocity_trend":    int64(0),
+			"resume_effectiveness":          int64(0),
+			"resume_effectiveness_trend":    int64(0),
+			"interview_effectiveness":       int64(0),
+			"interview_effectiveness_trend": int64(0),
+			"avg_response_time":             nil,
+			"avg_response_time_trend":       nil,
+			"monthly_trends": []MonthlyTrend{
+				{Month: "2025-05", Applications: 1},
+				{Month: "2025-06", Applications: 1},
+			},
+		},
+	},
+	{
+		name: "older than a year",
+		rows: []Row{
+			add("a", ago(400)),
+			add("b", ago(364)),
+			add("c", ago(500)),
+			edit("a", ago(400), ago(10), "Offer"),
+			edit("c", ago(500), ago(200), "Screen"),
+		},
+		want: map[string]interface{}{
+			"application_velocity":       int64(0),
+			"application_velocity_trend": int64(0),
+			"resume_effectiveness":       int64(0),
+			"resume_effectiveness_trend": int64(0),
+			// an old application still counts through a recent offer
+			"interview_effectiveness":       int64(1),
+			"interview_effectiveness_trend": int64(1),
+			"avg_response_time":             nil,
+			"avg_response_time_trend":       nil,
+			"monthly_trends": []MonthlyTrend{
+				{Month: "2024-06", Applications: 1},
+			},
+		},
+	},
+}
+
+func checkCompute(t *testing.T, state *State, want map[string]interface{}) {
+	t.Helper()
+
+	got := Compute(state, testNow)
+	if got[LastUpdatedField] != testNow.Unix() {
+		t.Errorf("%s = %v, want %d", LastUpdatedField, got[LastUpdatedField], testNow.Unix())
+	}
+	delete(got, LastUpdatedField)
+
+	if len(got) != len(want) {
+		t.Errorf("computed %d metrics, want %d", len(got), len(want))
+	}
+	for name, wantValue := range want {
+		if !reflect.DeepEqual(got[name], wantValue) {
+			t.Errorf("%s = %#v, want %#v", name, got[name], wantValue)
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	for _, tt := range computeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkCompute(t, FromRows(tt.rows, testNow), tt.want)
+		})
+	}
+}
+
+// the aggregator applies rows one at a time as they arrive, pruning as it goes; that has to end
+// up where a rebuild from the same rows does
+func TestApplyMatchesRebuild(t *testing.T) {
+	for _, tt := range computeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := NewState()
+			for _, row := range tt.rows {
+				state.Apply(row)
+				state.Prune(time.Unix(row.EventTime, 0))
+			}
+			state.Prune(testNow)
+
+			checkCompute(t, state, tt.want)
+		})
+	}
+}
+
+// deletes and reverts can't be applied; the rebuild reads the rows without them
+func TestRebuildAfterDelete(t *testing.T) {
+	rows := []Row{
+		add("a", ago(10)),
+		add("b", ago(12)),
+		edit("a", ago(10), ago(8), "Screen"),
+		edit("b", ago(12), ago(4), "Offer"),
+	}
+
+	state := FromRows(rows, testNow)
+	if got := Compute(state, testNow)["interview_effectiveness"]; got != int64(1) {
+		t.Fatalf("interview_effectiveness before delete = %v, want 1", got)
+	}
+
+	// b deleted
+	var remaining []Row
+	for _, row := range rows {
+		if row.JobID != "b" {
+			remaining = append(remaining, row)
+		}
+	}
+	checkCompute(t, FromRows(remaining, testNow), map[string]interface{}{
+		"application_velocity":          int64(1),
+		"application_velocity_trend":    int64(1),
+		"resume_effectiveness":          int64(1),
+		"resume_effectiveness_trend":    int64(1),
+		"interview_effectiveness":       int64(0),
+		"interview_effectiveness_trend": int64(0),
+		"avg_response_time":             2.0,
+		"avg_response_time_trend":       nil,
+		"monthly_trends": []MonthlyTrend{
+			{Month: "2025-06", Applications: 1, Interviews: 1},
+		},
+	})
+}
+
+func TestPrune(t *testing.T) {
+	state := FromRows([]Row{
+		add("recent", ago(100)),
+		add("old", ago(400)),
+		add("old-with-offer", ago(400)),
+		edit("old-with-offer", ago(400), ago(10), "Offer"),
+		add("old-with-stale-interview", ago(400)),
+		edit("old-with-stale-interview", ago(400), ago(90), "Screen"),
+	}, testNow)
+
+	want := map[string]bool{"recent": true, "old-with-offer": true}
+	if len(state.Jobs) != len(want) {
+		t.Errorf("kept %d jobs, want %d", len(state.Jobs), len(want))
+	}
+	for jobID := range state.Jobs {
+		if !want[jobID] {
+			t.Errorf("kept %q", jobID)
+		}
+	}
+	if interviews := state.Jobs["recent"].Interviews; len(interviews) != 0 {
+		t.Errorf("recent interviews = %v, want none", interviews)
+	}
+}
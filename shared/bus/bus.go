@@ -0,0 +1,171 @@
+package bus
+
+// the message bus between the API and the consumers, behind a Publisher/Subscriber pair so the
+// transport can be swapped by configuration (Config.Kind, MESSAGE_BUS in shared/config):
+//   pubsub (default) -> Google Cloud Pub/Sub, or its emulator when PUBSUB_EMULATOR_HOST is set
+//   nats             -> NATS JetStream at Config.NATSURL (`nats-server -js` runs one locally)
+//   memory           -> in-process channels; publisher and subscribers have to share the process
+//                       (tests, and dev/, which runs the API and both consumers together)
+// every transport keeps messages with the same ordering key in order, and redelivers a message
+// until it's acked
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	KindPubSub = "pubsub"
+	KindNATS   = "nats"
+	KindMemory = "memory"
+)
+
+type Message struct {
+	// assigned by the transport on delivery; when publishing, transports that deduplicate (NATS)
+	// use it to drop a message published twice
+	ID          string
+	Data        []byte
+	Attributes  map[string]string
+	OrderingKey string
+}
+
+type Delivery struct {
+	Message
+	// 1 on the first delivery; 0 if the transport doesn't track it
+	DeliveryAttempt int
+
+	ack  func()
+	nack func()
+}
+
+// the message is done with and won't be delivered again
+func (d *Delivery) Ack() { d.ack() }
+
+// the message is redelivered later
+func (d *Delivery) Nack() { d.nack() }
+
+type Publisher interface {
+	// returns once the bus has the message, with the ID it was given
+	Publish(ctx context.Context, msg Message) (string, error)
+	// flushes anything still being published and releases the connection
+	Close() error
+}
+
+type Subscriber interface {
+	// calls handle for every message until ctx is done or receiving fails; handle has to Ack or
+	// Nack each delivery. handle runs concurrently, but never for two messages with the same ordering key
+	Receive(ctx context.Context, handle func(ctx context.Context, d *Delivery)) error
+	Close() error
+}
+
+type ReceiveSettings struct {
+	// unacked messages held at once
+	MaxOutstandingMessages int
+	// messages handled at once
+	NumGoroutines int
+}
+
+type Config struct {
+	// KindPubSub, KindNATS or KindMemory; KindPubSub if empty
+	Kind string
+	// Pub/Sub only
+	ProjectID string
+	// NATS only; nats.DefaultURL if empty
+	NATSURL string
+}
+
+// a publisher to topic on the configured transport, creating the topic if needed
+func NewPublisher(ctx context.Context, config Config, topic string) (Publisher, error) {
+	switch config.Kind {
+	case KindNATS:
+		return NewNATSPublisher(ctx, config.NATSURL, topic)
+	case KindMemory:
+		return DefaultMemory.Publisher(topic), nil
+	case "", KindPubSub:
+		return NewPubSubPublisher(ctx, config.ProjectID, topic)
+	default:
+		return nil, fmt.Errorf("unknown message bus %q", config.Kind)
+	}
+}
+
+// a subscriber to topic on the configured transport, creating the subscription if needed;
+// subscribers with the same subscription name share its messages
+func NewSubscriber(ctx context.Context, config Config, topic string, subscription string, settings ReceiveSettings) (Subscriber, error) {
+	switch config.Kind {
+	case KindNATS:
+		return NewNATSSubscriber(ctx, config.NATSURL, topic, subscription, settings)
+	case KindMemory:
+		return DefaultMemory.Subscriber(topic, subscription, settings), nil
+	case "", KindPubSub:
+		return NewPubSubSubscriber(ctx, config.ProjectID, topic, subscription, settings)
+	default:
+		return nil, fmt.Errorf("unknown message bus %q", config.Kind)
+	}
+}
+
+// runs work on a fixed set of goroutines; work with the same ordering key always goes to the
+// same goroutine, so it runs one at a time and in order. used by the transports whose client
+// doesn't do this itself (Pub/Sub's does)
+type dispatcher struct {
+	workers []chan func()
+	next    atomic.Uint32
+	wg      sync.WaitGroup
+
+	// held for reading while dispatching, so stop can't close a channel mid-send
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newDispatcher(n int) *dispatcher {
+	if n <= 0 {
+		n = 1
+	}
+
+	d := &dispatcher{workers: make([]chan func(), n)}
+	for i := range d.workers {
+		d.workers[i] = make(chan func(), 1)
+		d.wg.Add(1)
+		go func(work chan func()) {
+			defer d.wg.Done()
+			for fn := range work {
+				fn()
+			}
+		}(d.workers[i])
+	}
+	return d
+}
+
+// blocks while the key's goroutine is busy; returns false (and drops work) once stopped
+func (d *dispatcher) dispatch(orderingKey string, work func()) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return false
+	}
+
+	var i uint32
+	if orderingKey == "" {
+		i = d.next.Add(1)
+	} else {
+		h := fnv.New32a()
+		h.Write([]byte(orderingKey))
+		i = h.Sum32()
+	}
+	d.workers[i%uint32(len(d.workers))] <- work
+	return true
+}
+
+// waits for dispatched work to finish; nothing can be dispatched after
+func (d *dispatcher) stop() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+
+	for _, work := range d.workers {
+		close(work)
+	}
+	d.wg.Wait()
+}
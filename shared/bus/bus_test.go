@@ -0,0 +1,264 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/pstest"
+	natsserver "github.com/nats-io/nats-server/v2/server"
+)
+
+// the same checks run against every transport: Pub/Sub on pstest, NATS on an embedded server
+// with JetStream, and the memory bus
+type transport struct {
+	name string
+	// a publisher and a subscriber on topic, on a bus (or server) of the test's own
+	connect func(t *testing.T, topic string, subscription string) (Publisher, Subscriber)
+}
+
+var testSettings = ReceiveSettings{MaxOutstandingMessages: 100, NumGoroutines: 4}
+
+func transports() []transport {
+	return []transport{
+		{KindMemory, memoryTransport},
+		{KindPubSub, pubSubTransport},
+		{KindNATS, natsTransport},
+	}
+}
+
+func memoryTransport(t *testing.T, topic string, subscription string) (Publisher, Subscriber) {
+	m := NewMemory()
+	return m.Publisher(topic), m.Subscriber(topic, subscription, testSettings)
+}
+
+func pubSubTransport(t *testing.T, topic string, subscription string) (Publisher, Subscriber) {
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	t.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+
+	// creates the topic, so it comes first
+	publisher, err := NewPubSubPublisher(context.Background(), "test-project", topic)
+	if err != nil {
+		t.Fatalf("NewPubSubPublisher: %v", err)
+	}
+	t.Cleanup(func() { publisher.Close() })
+
+	subscriber, err := NewPubSubSubscriber(context.Background(), "test-project", topic, subscription, testSettings)
+	if err != nil {
+		t.Fatalf("NewPubSubSubscriber: %v", err)
+	}
+	t.Cleanup(func() { subscriber.Close() })
+	return publisher, subscriber
+}
+
+func natsTransport(t *testing.T, topic string, subscription string) (Publisher, Subscriber) {
+	srv, err := natsserver.NewServer(&natsserver.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("nats-server: %v", err)
+	}
+	srv.Start()
+	t.Cleanup(srv.Shutdown)
+	if !srv.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats-server not ready")
+	}
+
+	publisher, err := NewNATSPublisher(context.Background(), srv.ClientURL(), topic)
+	if err != nil {
+		t.Fatalf("NewNATSPublisher: %v", err)
+	}
+	t.Cleanup(func() { publisher.Close() })
+
+	subscriber, err := NewNATSSubscriber(context.Background(), srv.ClientURL(), topic, subscription, testSettings)
+	if err != nil {
+		t.Fatalf("NewNATSSubscriber: %v", err)
+	}
+	t.Cleanup(func() { subscriber.Close() })
+	return publisher, subscriber
+}
+
+// receives until stop is called (or the test times out), then waits for Receive to return
+func receive(t *testing.T, subscriber Subscriber, handle func(stop func(), d *Delivery)) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := subscriber.Receive(ctx, func(_ context.Context, d *Delivery) {
+		handle(cancel, d)
+	})
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Fatal("timed out receiving")
+	}
+}
+
+func TestPublishReceive(t *testing.T) {
+	const (
+		keys     = 3
+		messages = 10
+	)
+
+	for _, tr := range transports() {
+		t.Run(tr.name, func(t *testing.T) {
+			publisher, subscriber := tr.connect(t, "applications", "test-sub")
+
+			ctx := context.Background()
+			for i := 0; i < messages; i++ {
+				for k := 0; k < keys; k++ {
+					_, err := publisher.Publish(ctx, Message{
+						Data:        []byte(strconv.Itoa(i)),
+						Attributes:  map[string]string{"key": fmt.Sprintf("user-%d", k)},
+						OrderingKey: fmt.Sprintf("user-%d", k),
+					})
+					if err != nil {
+						t.Fatalf("Publish: %v", err)
+					}
+				}
+			}
+
+			var (
+				mu       sync.Mutex
+				received = make(map[string][]string)
+				inFlight = make(map[string]bool)
+				total    int
+			)
+			receive(t, subscriber, func(stop func(), d *Delivery) {
+				mu.Lock()
+				if inFlight[d.OrderingKey] {
+					t.Errorf("two messages for %s handled at once", d.OrderingKey)
+				}
+				inFlight[d.OrderingKey] = true
+				mu.Unlock()
+
+				// long enough for an overlap to show
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				defer mu.Unlock()
+				inFlight[d.OrderingKey] = false
+				if d.Attributes["key"] != d.OrderingKey {
+					t.Errorf("attributes %v on a message for %s", d.Attributes, d.OrderingKey)
+				}
+				received[d.OrderingKey] = append(received[d.OrderingKey], string(d.Data))
+				d.Ack()
+
+				if total++; total == keys*messages {
+					stop()
+				}
+			})
+
+			for k := 0; k < keys; k++ {
+				key := fmt.Sprintf("user-%d", k)
+				got := received[key]
+				if len(got) != messages {
+					t.Errorf("received %d messages for %s, want %d: %v", len(got), key, messages, got)
+					continue
+				}
+				for i, data := range got {
+					if data != strconv.Itoa(i) {
+						t.Errorf("messages for %s arrived as %v, want them in publish order", key, got)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNackRedelivers(t *testing.T) {
+	for _, tr := range transports() {
+		t.Run(tr.name, func(t *testing.T) {
+			publisher, subscriber := tr.connect(t, "applications", "test-sub")
+
+			if _, err := publisher.Publish(context.Background(), Message{Data: []byte("event"), OrderingKey: "user-1"}); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+
+			var (
+				mu       sync.Mutex
+				attempts []int
+			)
+			receive(t, subscriber, func(stop func(), d *Delivery) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				if string(d.Data) != "event" {
+					t.Errorf("received %q", d.Data)
+				}
+				attempts = append(attempts, d.DeliveryAttempt)
+				if len(attempts) == 1 {
+					d.Nack()
+					return
+				}
+				d.Ack()
+				stop()
+			})
+
+			if len(attempts) != 2 {
+				t.Fatalf("delivered %d times, want 2", len(attempts))
+			}
+			// Pub/Sub only counts attempts with a dead letter policy
+			if attempts[0] != 0 && attempts[1] != attempts[0]+1 {
+				t.Errorf("delivery attempts %v, want the redelivery counted", attempts)
+			}
+		})
+	}
+}
+
+// the API and the consumers in separate processes; the memory bus can't deliver what it publishes,
+// so it has to fail rather than let the outbox delete it
+func TestMemoryPublishWithoutSubscribers(t *testing.T) {
+	m := NewMemory()
+	publisher := m.Publisher("applications")
+
+	_, err := publisher.Publish(context.Background(), Message{Data: []byte("event")})
+	if !errors.Is(err, ErrNoSubscribers) {
+		t.Fatalf("Publish = %v, want ErrNoSubscribers", err)
+	}
+
+	// kept for a subscription nothing receives from yet
+	m.CreateSubscription("applications", "test-sub")
+	if _, err := publisher.Publish(context.Background(), Message{Data: []byte("event")}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	receive(t, m.Subscriber("applications", "test-sub", testSettings), func(stop func(), d *Delivery) {
+		d.Ack()
+		stop()
+	})
+}
+
+// a message nacked as Receive returns is still there for the next Receive
+func TestMemoryRedeliveryAfterReceive(t *testing.T) {
+	m := NewMemory()
+	subscriber := m.Subscriber("applications", "test-sub", testSettings)
+	if _, err := m.Publisher("applications").Publish(context.Background(), Message{Data: []byte("event")}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	receive(t, subscriber, func(stop func(), d *Delivery) {
+		stop()
+		d.Nack()
+	})
+
+	receive(t, subscriber, func(stop func(), d *Delivery) {
+		if d.DeliveryAttempt != 2 {
+			t.Errorf("DeliveryAttempt = %d, want 2", d.DeliveryAttempt)
+		}
+		d.Ack()
+		stop()
+	})
+}
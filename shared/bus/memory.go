@@ -0,0 +1,172 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// a subscription holds at most this many undelivered messages; Publish waits for room
+	memoryQueueSize = 10000
+	// how long a nacked message waits before it's delivered again
+	memoryRedeliveryDelay = time.Second
+)
+
+// used by NewPublisher and NewSubscriber with Config.Kind KindMemory
+var DefaultMemory = NewMemory()
+
+// a topic nothing subscribes to; Pub/Sub would just drop the message, but here that's almost
+// always a publisher in another process than its consumers, which the memory bus can't reach
+var ErrNoSubscribers = errors.New("no subscriptions to topic")
+
+// an in-process bus for tests and single-binary dev (see dev/). like Pub/Sub, a message goes to
+// every subscription on its topic (published before a subscription exists, it never reaches it),
+// and subscribers with the same subscription name share it. nothing survives a restart, and a
+// nacked message goes to the back of the queue, so it can end up behind later messages
+type Memory struct {
+	mu            sync.Mutex
+	subscriptions map[string]map[string]*memoryQueue
+	ids           atomic.Uint64
+}
+
+type memoryQueue struct {
+	deliveries chan memoryDelivery
+}
+
+type memoryDelivery struct {
+	msg     Message
+	attempt int
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		subscriptions: make(map[string]map[string]*memoryQueue),
+	}
+}
+
+func (m *Memory) Publisher(topic string) Publisher {
+	return &memoryPublisher{bus: m, topic: topic}
+}
+
+func (m *Memory) Subscriber(topic string, subscription string, settings ReceiveSettings) Subscriber {
+	return &memorySubscriber{queue: m.subscription(topic, subscription), settings: settings}
+}
+
+// creates the subscription if it doesn't exist; messages published from then on are kept for it
+// even before anything receives them
+func (m *Memory) CreateSubscription(topic string, subscription string) {
+	m.subscription(topic, subscription)
+}
+
+func (m *Memory) subscription(topic string, subscription string) *memoryQueue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.subscriptions[topic] == nil {
+		m.subscriptions[topic] = make(map[string]*memoryQueue)
+	}
+	queue, ok := m.subscriptions[topic][subscription]
+	if !ok {
+		queue = &memoryQueue{deliveries: make(chan memoryDelivery, memoryQueueSize)}
+		m.subscriptions[topic][subscription] = queue
+	}
+	return queue
+}
+
+func (m *Memory) queues(topic string) []*memoryQueue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queues := make([]*memoryQueue, 0, len(m.subscriptions[topic]))
+	for _, queue := range m.subscriptions[topic] {
+		queues = append(queues, queue)
+	}
+	return queues
+}
+
+type memoryPublisher struct {
+	bus   *Memory
+	topic string
+}
+
+func (p *memoryPublisher) Publish(ctx context.Context, msg Message) (string, error) {
+	queues := p.bus.queues(p.topic)
+	if len(queues) == 0 {
+		return "", fmt.Errorf("%w %s", ErrNoSubscribers, p.topic)
+	}
+
+	msg.ID = strconv.FormatUint(p.bus.ids.Add(1), 10)
+	for _, queue := range queues {
+		select {
+		case queue.deliveries <- memoryDelivery{msg: msg, attempt: 1}:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	return msg.ID, nil
+}
+
+func (p *memoryPublisher) Close() error {
+	return nil
+}
+
+type memorySubscriber struct {
+	queue    *memoryQueue
+	settings ReceiveSettings
+}
+
+func (s *memorySubscriber) Receive(ctx context.Context, handle func(ctx context.Context, d *Delivery)) error {
+	workers := newDispatcher(s.settings.NumGoroutines)
+	defer workers.stop()
+
+	// closed once this returns, for nacks that are redelivered after
+	done := make(chan struct{})
+	defer close(done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case next := <-s.queue.deliveries:
+			workers.dispatch(next.msg.OrderingKey, func() {
+				var once sync.Once
+				handle(ctx, &Delivery{
+					Message:         next.msg,
+					DeliveryAttempt: next.attempt,
+					ack:             func() {},
+					nack: func() {
+						once.Do(func() { s.redeliver(next, done) })
+					},
+				})
+			})
+		}
+	}
+}
+
+// back on the queue after memoryRedeliveryDelay, for whoever receives from the subscription
+// next. if the queue is full by then and done is closed (nothing receives anymore) it's dropped
+// rather than waiting forever
+func (s *memorySubscriber) redeliver(d memoryDelivery, done <-chan struct{}) {
+	d.attempt++
+	time.AfterFunc(memoryRedeliveryDelay, func() {
+		select {
+		case s.queue.deliveries <- d:
+			return
+		default:
+		}
+
+		select {
+		case s.queue.deliveries <- d:
+		case <-done:
+		}
+	})
+}
+
+func (s *memorySubscriber) Close() error {
+	return nil
+}
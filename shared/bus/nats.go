@@ -0,0 +1,197 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// carries Message.OrderingKey, which NATS has no field for
+	natsOrderingKeyHeader = "Copium-Ordering-Key"
+	// how long a delivery can go unacked before JetStream redelivers it
+	natsAckWait = 30 * time.Second
+)
+
+// a topic is a JetStream stream named after it (upper case, e.g. APPLICATIONS) holding the one
+// subject of the same name, created if it doesn't exist
+func natsStream(ctx context.Context, js jetstream.JetStream, topic string) (string, error) {
+	name := strings.ToUpper(topic)
+	_, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     name,
+		Subjects: []string{topic},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create stream %s: %w", name, err)
+	}
+	return name, nil
+}
+
+// nats://127.0.0.1:4222 if url is empty
+func connectNATS(url string) (*nats.Conn, jetstream.JetStream, error) {
+	if url == "" {
+		url = nats.DefaultURL
+	}
+	log.Printf("Connecting to NATS at %s", url)
+
+	nc, err := nats.Connect(url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, nil, fmt.Errorf("failed to initialize JetStream: %w", err)
+	}
+	return nc, js, nil
+}
+
+type NATSPublisher struct {
+	conn  *nats.Conn
+	js    jetstream.JetStream
+	topic string
+}
+
+func NewNATSPublisher(ctx context.Context, url string, topic string) (*NATSPublisher, error) {
+	nc, js, err := connectNATS(url)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := natsStream(ctx, js, topic); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	return &NATSPublisher{
+		conn:  nc,
+		js:    js,
+		topic: topic,
+	}, nil
+}
+
+// JetStream drops a message whose ID it has already seen (within its duplicate window), so
+// publishing again after a lost ack doesn't deliver it twice
+func (p *NATSPublisher) Publish(ctx context.Context, msg Message) (string, error) {
+	natsMsg := nats.NewMsg(p.topic)
+	natsMsg.Data = msg.Data
+	for key, value := range msg.Attributes {
+		natsMsg.Header.Set(key, value)
+	}
+	if msg.OrderingKey != "" {
+		natsMsg.Header.Set(natsOrderingKeyHeader, msg.OrderingKey)
+	}
+
+	var opts []jetstream.PublishOpt
+	if msg.ID != "" {
+		opts = append(opts, jetstream.WithMsgID(msg.ID))
+	}
+
+	ack, err := p.js.PublishMsg(ctx, natsMsg, opts...)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(ack.Sequence, 10), nil
+}
+
+func (p *NATSPublisher) Close() error {
+	return p.conn.Drain()
+}
+
+type NATSSubscriber struct {
+	conn     *nats.Conn
+	consumer jetstream.Consumer
+	settings ReceiveSettings
+}
+
+// the subscription is a durable consumer on the topic's stream
+func NewNATSSubscriber(ctx context.Context, url string, topic string, subscription string, settings ReceiveSettings) (*NATSSubscriber, error) {
+	nc, js, err := connectNATS(url)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := natsStream(ctx, js, topic)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	consumer, err := js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+		Durable:       subscription,
+		FilterSubject: topic,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       natsAckWait,
+		MaxAckPending: settings.MaxOutstandingMessages,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to create consumer %s: %w", subscription, err)
+	}
+
+	return &NATSSubscriber{
+		conn:     nc,
+		consumer: consumer,
+		settings: settings,
+	}, nil
+}
+
+// JetStream hands messages over one at a time, so they're spread over NumGoroutines by ordering key
+func (s *NATSSubscriber) Receive(ctx context.Context, handle func(ctx context.Context, d *Delivery)) error {
+	workers := newDispatcher(s.settings.NumGoroutines)
+	defer workers.stop()
+
+	consumeCtx, err := s.consumer.Consume(func(m jetstream.Msg) {
+		delivery := &Delivery{
+			Message: Message{
+				Data:        m.Data(),
+				Attributes:  map[string]string{},
+				OrderingKey: m.Headers().Get(natsOrderingKeyHeader),
+			},
+			ack: func() {
+				if err := m.Ack(); err != nil {
+					log.Printf("Error acking NATS message: %v", err)
+				}
+			},
+			nack: func() {
+				if err := m.Nak(); err != nil {
+					log.Printf("Error nacking NATS message: %v", err)
+				}
+			},
+		}
+		for key := range m.Headers() {
+			if key != natsOrderingKeyHeader && !strings.HasPrefix(key, "Nats-") {
+				delivery.Attributes[key] = m.Headers().Get(key)
+			}
+		}
+		if metadata, err := m.Metadata(); err == nil {
+			delivery.ID = strconv.FormatUint(metadata.Sequence.Stream, 10)
+			delivery.DeliveryAttempt = int(metadata.NumDelivered)
+		}
+
+		dispatched := workers.dispatch(delivery.OrderingKey, func() {
+			handle(ctx, delivery)
+		})
+		if !dispatched {
+			// arrived while shutting down; hand it back rather than wait out AckWait
+			delivery.Nack()
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start consuming: %w", err)
+	}
+
+	// stop taking messages; the deferred workers.stop waits for the ones being handled
+	<-ctx.Done()
+	consumeCtx.Stop()
+	return nil
+}
+
+func (s *NATSSubscriber) Close() error {
+	return s.conn.Drain()
+}
@@ -0,0 +1,161 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+)
+
+// if PUBSUB_EMULATOR_HOST is set, use it; otherwise use credentials file
+// if credentials file is used WE ARE WORKING IN PROD so be careful
+func newPubSubClient(ctx context.Context, projectID string) (*pubsub.Client, error) {
+	var opts []option.ClientOption
+	if pubsubEmulatorHost := os.Getenv("PUBSUB_EMULATOR_HOST"); pubsubEmulatorHost != "" {
+		log.Printf("Connecting to Pub/Sub emulator at %s", pubsubEmulatorHost)
+		opts = append(opts,
+			option.WithEndpoint(pubsubEmulatorHost),
+			option.WithoutAuthentication(),
+		)
+	} else {
+		log.Println("PUBSUB_EMULATOR_HOST not set; using service account credentials, nothing to pass in")
+	}
+
+	client, err := pubsub.NewClient(ctx, projectID, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	}
+	return client, nil
+}
+
+type PubSubPublisher struct {
+	client *pubsub.Client
+	topic  *pubsub.Topic
+}
+
+// connects to the topic (with message ordering), creating it if it doesn't exist
+func NewPubSubPublisher(ctx context.Context, projectID string, topicName string) (*PubSubPublisher, error) {
+	client, err := newPubSubClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	topic, err := client.CreateTopic(ctx, topicName)
+	if err != nil {
+		if !strings.Contains(err.Error(), "AlreadyExists") {
+			client.Close()
+			return nil, fmt.Errorf("failed to create topic: %w", err)
+		}
+		log.Printf("%s topic already exists, connecting to it", topicName)
+		topic = client.Topic(topicName)
+	}
+
+	topic.EnableMessageOrdering = true
+
+	return &PubSubPublisher{
+		client: client,
+		topic:  topic,
+	}, nil
+}
+
+func (p *PubSubPublisher) Publish(ctx context.Context, msg Message) (string, error) {
+	result := p.topic.Publish(ctx, &pubsub.Message{
+		Data:        msg.Data,
+		Attributes:  msg.Attributes,
+		OrderingKey: msg.OrderingKey,
+	})
+
+	id, err := result.Get(ctx)
+	if err != nil && msg.OrderingKey != "" {
+		// the client pauses an ordering key after a failed publish and rejects everything on it,
+		// retries included, until it's resumed. whoever retries (the outbox relay) is the one
+		// keeping the key's later messages behind this one
+		p.topic.ResumePublish(msg.OrderingKey)
+	}
+	return id, err
+}
+
+// the first Publish starts goroutines in the background (see pubsub.Topic.Publish); Stop
+// waits for anything still being sent and cleans them up
+func (p *PubSubPublisher) Close() error {
+	p.topic.Stop()
+	return p.client.Close()
+}
+
+type PubSubSubscriber struct {
+	client *pubsub.Client
+	sub    *pubsub.Subscription
+}
+
+// connects to the subscription (ordered), creating it on the topic if it doesn't exist
+func NewPubSubSubscriber(ctx context.Context, projectID string, topicName string, subscription string, settings ReceiveSettings) (*PubSubSubscriber, error) {
+	client, err := newPubSubClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := client.CreateSubscription(ctx, subscription, pubsub.SubscriptionConfig{
+		Topic:                 client.Topic(topicName),
+		AckDeadline:           10 * time.Second,
+		EnableMessageOrdering: true,
+	})
+	if err != nil {
+		if !strings.Contains(err.Error(), "AlreadyExists") {
+			client.Close()
+			return nil, fmt.Errorf("failed to create subscription: %w", err)
+		}
+		log.Printf("Subscription already exists, connecting to it")
+		sub = client.Subscription(subscription)
+	}
+
+	// double check the sub even exists
+	exists, err := sub.Exists(ctx)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to verify subscription existence: %w", err)
+	}
+	if !exists {
+		client.Close()
+		return nil, fmt.Errorf("subscription %q does not exist", subscription)
+	}
+
+	sub.ReceiveSettings.MaxOutstandingMessages = settings.MaxOutstandingMessages
+	sub.ReceiveSettings.NumGoroutines = settings.NumGoroutines
+
+	return &PubSubSubscriber{
+		client: client,
+		sub:    sub,
+	}, nil
+}
+
+// sub.Receive already runs handlers concurrently and keeps ordering keys in order
+func (s *PubSubSubscriber) Receive(ctx context.Context, handle func(ctx context.Context, d *Delivery)) error {
+	return s.sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		// only set when the subscription has a dead letter policy
+		deliveryAttempt := 0
+		if m.DeliveryAttempt != nil {
+			deliveryAttempt = *m.DeliveryAttempt
+		}
+
+		handle(ctx, &Delivery{
+			Message: Message{
+				ID:          m.ID,
+				Data:        m.Data,
+				Attributes:  m.Attributes,
+				OrderingKey: m.OrderingKey,
+			},
+			DeliveryAttempt: deliveryAttempt,
+			ack:             m.Ack,
+			nack:            m.Nack,
+		})
+	})
+}
+
+func (s *PubSubSubscriber) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,58 @@
+package bus
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fails publishes while failing is set
+type publishFailure struct {
+	failing atomic.Bool
+}
+
+func (f *publishFailure) React(_ interface{}) (bool, interface{}, error) {
+	if f.failing.Load() {
+		return true, nil, status.Error(codes.InvalidArgument, "publish rejected")
+	}
+	return false, nil, nil
+}
+
+// the client pauses an ordering key after a failed publish; without ResumePublish every retry
+// the outbox relay makes would fail too
+func TestPubSubPublisherResumesOrderingKey(t *testing.T) {
+	failure := &publishFailure{}
+	srv := pstest.NewServer(pstest.ServerReactorOption{FuncName: "Publish", Reactor: failure})
+	t.Cleanup(func() { srv.Close() })
+	t.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	publisher, err := NewPubSubPublisher(ctx, "test-project", "applications")
+	if err != nil {
+		t.Fatalf("NewPubSubPublisher: %v", err)
+	}
+	defer publisher.Close()
+
+	msg := Message{Data: []byte("{}"), OrderingKey: "user-1"}
+
+	failure.failing.Store(true)
+	if _, err := publisher.Publish(ctx, msg); err == nil {
+		t.Fatal("Publish succeeded while the server rejects publishes")
+	}
+
+	failure.failing.Store(false)
+	if _, err := publisher.Publish(ctx, msg); err != nil {
+		t.Fatalf("Publish after a failure on the same ordering key: %v", err)
+	}
+	// other keys were never affected
+	if _, err := publisher.Publish(ctx, Message{Data: []byte("{}"), OrderingKey: "user-2"}); err != nil {
+		t.Fatalf("Publish on another ordering key: %v", err)
+	}
+}
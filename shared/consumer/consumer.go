@@ -0,0 +1,232 @@
+package consumer
+
+// the runtime every consumer of the applications topic shares: messages come in over HTTP from a
+// Pub/Sub push subscription when deployed (Config.Push) or are pulled from a subscription on the
+// configured message bus otherwise (see shared/bus), are decoded into events and go to the handler
+// registered for their operation. a consumer is just its handlers:
+//
+//	c, err := consumer.New(consumer.Config{Name: "ALGOLIA", Topic: cfg.Topic, Subscription: cfg.AlgoliaSubscription}, consumer.Handlers{
+//		events.OpAdd:    consumer.Typed(indexer.add),
+//		events.OpDelete: consumer.Typed(indexer.delete),
+//	})
+//	c.Run(ctx, drain)
+//
+// ack/nack: a message is acked once its handler succeeds, when no handler is registered for its
+// operation, or when it's quarantined (see shared/quarantine); otherwise it's nacked (5xx for push)
+// and the bus redelivers it
+//
+// shutdown: once ctx is done no more messages are taken, and in-flight jobs get until drain is
+// done (see shared/shutdown) before their contexts are cancelled and they're nacked
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/copium-dev/copium/shared/bus"
+	"github.com/copium-dev/copium/shared/events"
+	"github.com/copium-dev/copium/shared/pushauth"
+	"github.com/copium-dev/copium/shared/quarantine"
+)
+
+const (
+	DefaultMaxOutstandingMessages = 1000
+	DefaultNumGoroutines          = 100
+)
+
+// one decoded message
+type Job struct {
+	// assigned per delivery for logging; a redelivered message gets a new one
+	ID int32
+	// the bus message ID; empty for quarantine replays
+	MessageID string
+	Event     events.Event
+	Operation events.Operation
+	Data      []byte
+}
+
+// handles one event; an error retries the message, or quarantines it if it's quarantine.Permanent
+// or has run out of attempts
+type Handler func(ctx context.Context, job *Job) error
+
+// handlers by operation; operations without one are acked without doing anything
+type Handlers map[events.Operation]Handler
+
+// adapts a handler for one event type, e.g. Typed(func(ctx context.Context, event *events.Add) error {...})
+func Typed[E events.Event](handle func(ctx context.Context, event E) error) Handler {
+	return func(ctx context.Context, job *Job) error {
+		event, ok := job.Event.(E)
+		if !ok {
+			return quarantine.Permanent(fmt.Errorf("unexpected event type %T for operation %s", job.Event, job.Operation))
+		}
+		return handle(ctx, event)
+	}
+}
+
+type Config struct {
+	// shows up in logs, e.g. "ALGOLIA"
+	Name string
+	// serve a push endpoint instead of pulling; set when deployed (see config.Config.Deployed)
+	Push bool
+	// push mode: the port to serve on and what requests' tokens are checked against
+	Port     string
+	PushAuth pushauth.Config
+	// where messages that keep failing go (see shared/quarantine)
+	Quarantine quarantine.Config
+	// pull mode: the bus, the topic and the subscription to it (created if it doesn't exist)
+	Bus          bus.Config
+	Topic        string
+	Subscription string
+	// pull mode: messages held at once and goroutines handling them (see shared/config),
+	// DefaultMaxOutstandingMessages and DefaultNumGoroutines if unset
+	MaxOutstandingMessages int
+	NumGoroutines          int
+	// how long a handler gets before its context is cancelled; 0 for no limit
+	JobTimeout time.Duration
+	// pull mode has no HTTP server of its own, so the quarantine admin endpoints get one on
+	// this port
+	AdminPort string
+	// what requests to the quarantine admin endpoints have to bear; they're off if it's empty
+	AdminToken string
+}
+
+type Consumer struct {
+	config     Config
+	handlers   Handlers
+	quarantine *quarantine.Quarantine
+	// quarantine admin endpoints, plus the push endpoint in push mode
+	mux *http.ServeMux
+	// set when Config.AdminToken is
+	admin   bool
+	counter atomic.Int32
+}
+
+// also sets up quarantine (see quarantine.Open) and, when Config.AdminToken is set, its admin endpoints
+func New(config Config, handlers Handlers) (*Consumer, error) {
+	if config.MaxOutstandingMessages <= 0 {
+		config.MaxOutstandingMessages = DefaultMaxOutstandingMessages
+	}
+	if config.NumGoroutines <= 0 {
+		config.NumGoroutines = DefaultNumGoroutines
+	}
+
+	q, err := quarantine.Open(context.Background(), config.Quarantine, strings.ToLower(config.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize quarantine: %w", err)
+	}
+
+	c := &Consumer{
+		config:     config,
+		handlers:   handlers,
+		quarantine: q,
+		mux:        http.NewServeMux(),
+	}
+
+	// admin endpoints to list, inspect and replay quarantined messages
+	if config.AdminToken != "" {
+		q.RegisterRoutes(c.mux, config.AdminToken, c.Process)
+		c.admin = true
+	} else {
+		log.Println("ADMIN_TOKEN not set; quarantine admin endpoints disabled")
+	}
+
+	return c, nil
+}
+
+// push when deployed, pull otherwise; blocks until ctx is done and in-flight jobs have drained
+func (c *Consumer) Run(ctx context.Context, drain context.Context) {
+	if c.config.Push {
+		c.RunPush(ctx, drain)
+	} else {
+		c.RunPull(ctx, drain)
+	}
+}
+
+// stops server once ctx is done, waiting for in-flight requests until drain is done
+func (c *Consumer) serve(ctx context.Context, drain context.Context, server *http.Server) {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("[*] %s [*] Shutting down server on %s", c.config.Name, server.Addr)
+	if err := server.Shutdown(drain); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+		server.Close()
+	}
+}
+
+// decodes a message and runs its handler; also what quarantine replays go through
+func (c *Consumer) Process(ctx context.Context, data []byte) error {
+	return c.process(ctx, "", data)
+}
+
+// a message that can't be decoded will never succeed, so that error is permanent
+func (c *Consumer) process(ctx context.Context, messageID string, data []byte) error {
+	jobID := c.counter.Add(1)
+
+	event, err := events.Decode(data)
+	if err != nil {
+		return quarantine.Permanent(fmt.Errorf("failed to decode job %d: %w", jobID, err))
+	}
+
+	job := &Job{
+		ID:        jobID,
+		MessageID: messageID,
+		Event:     event,
+		Operation: event.EventHeader().Operation,
+		Data:      data,
+	}
+
+	handler, ok := c.handlers[job.Operation]
+	if !ok {
+		log.Printf("[*] %s [*] %s is not handled here, skipping job %d", c.config.Name, job.Operation, jobID)
+		return nil
+	}
+
+	if c.config.JobTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.JobTimeout)
+		defer cancel()
+	}
+
+	log.Printf("[*] %s [*] Processing job %d (%s): %s", c.config.Name, jobID, job.Operation, data)
+
+	if err := handler(ctx, job); err != nil {
+		return fmt.Errorf("failed to process job %d: %w", jobID, err)
+	}
+	return nil
+}
+
+// processes a received message; returns whether to ack it
+func (c *Consumer) handle(ctx context.Context, msg quarantine.Message, deliveryAttempt int) bool {
+	err := c.process(ctx, msg.ID, msg.Data)
+	if err != nil {
+		log.Printf("%s", err)
+
+		quarantined, qErr := c.quarantine.Failed(ctx, msg, deliveryAttempt, err)
+		if qErr != nil {
+			log.Printf("%s", qErr)
+		}
+		if quarantined {
+			// ack so the bus stops redelivering it
+			fmt.Printf("Message quarantined, acking message (%s)\n", c.config.Name)
+			return true
+		}
+		return false
+	}
+
+	c.quarantine.Succeeded(msg.ID)
+	fmt.Printf("Job done, acking message (%s)\n", c.config.Name)
+	return true
+}
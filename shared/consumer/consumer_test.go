@@ -0,0 +1,423 @@
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/copium-dev/copium/shared/bus"
+	"github.com/copium-dev/copium/shared/events"
+	"github.com/copium-dev/copium/shared/pushauth"
+	"github.com/copium-dev/copium/shared/quarantine"
+)
+
+const pushToken = "push-token"
+
+var header = events.Header{UserID: "user-1"}
+
+// records the jobs it runs; each run fails with the next error in errs until they run out
+type recorder struct {
+	mu   sync.Mutex
+	jobs []*Job
+	errs []error
+}
+
+func (r *recorder) handle(ctx context.Context, job *Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.jobs = append(r.jobs, job)
+	if len(r.errs) == 0 {
+		return nil
+	}
+	err := r.errs[0]
+	r.errs = r.errs[1:]
+	return err
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.jobs)
+}
+
+// quarantines to memory after 3 attempts
+func newTestConsumer(t *testing.T, config Config, handlers Handlers) *Consumer {
+	t.Helper()
+
+	config.Name = "TEST"
+	config.Quarantine = quarantine.Config{Store: quarantine.StoreMemory, MaxDeliveryAttempts: 3}
+	c, err := New(config, handlers)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c
+}
+
+func encode(t *testing.T, event events.Event) []byte {
+	t.Helper()
+
+	data, err := events.Encode(event)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return data
+}
+
+func quarantined(t *testing.T, c *Consumer) []quarantine.Message {
+	t.Helper()
+
+	msgs, err := c.quarantine.Store().List(context.Background())
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	return msgs
+}
+
+// the push subscription's token is just pushToken here
+func verifyPushToken(r *http.Request) error {
+	if r.Header.Get("Authorization") != "Bearer "+pushToken {
+		return pushauth.ErrUnauthorized
+	}
+	return nil
+}
+
+func push(t *testing.T, server *httptest.Server, data []byte, deliveryAttempt int) int {
+	t.Helper()
+
+	var message PubSubMessage
+	message.Message.ID = "message-1"
+	message.Message.Data = data
+	message.DeliveryAttempt = deliveryAttempt
+	body, err := json.Marshal(message)
+	if err != nil {
+		t.Fatalf("marshal message: %v", err)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+pushToken)
+	resp, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestPush(t *testing.T) {
+	add := &events.Add{Header: header, ObjectID: "job-1", Role: "SWE", Company: "Acme", AppliedDate: 1700000000, Status: "Applied", Timestamp: 1700000001}
+	failed := errors.New("index failed")
+
+	tests := []struct {
+		name            string
+		data            []byte
+		deliveryAttempt int
+		errs            []error
+		wantCode        int
+		wantRuns        int
+		wantQuarantined bool
+	}{
+		{"handled", encode(t, add), 1, nil, http.StatusOK, 1, false},
+		{"retryable error", encode(t, add), 1, []error{failed}, http.StatusInternalServerError, 1, false},
+		{"out of attempts", encode(t, add), 3, []error{failed}, http.StatusOK, 1, true},
+		{"permanent error", encode(t, add), 1, []error{quarantine.Permanent(failed)}, http.StatusOK, 1, true},
+		{"undecodable", []byte("not an event"), 1, nil, http.StatusOK, 0, true},
+		// nothing here handles deletes; another consumer on the topic does
+		{"unknown operation", encode(t, &events.Delete{Header: header, ObjectID: "job-1"}), 1, nil, http.StatusOK, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{errs: tt.errs}
+			c := newTestConsumer(t, Config{Push: true}, Handlers{events.OpAdd: r.handle})
+			server := httptest.NewServer(c.pushHandler(context.Background(), verifyPushToken))
+			defer server.Close()
+
+			if code := push(t, server, tt.data, tt.deliveryAttempt); code != tt.wantCode {
+				t.Errorf("push = %d, want %d", code, tt.wantCode)
+			}
+			if got := r.count(); got != tt.wantRuns {
+				t.Errorf("handler ran %d times, want %d", got, tt.wantRuns)
+			}
+			if got := len(quarantined(t, c)) == 1; got != tt.wantQuarantined {
+				t.Errorf("quarantined = %v, want %v", got, tt.wantQuarantined)
+			}
+		})
+	}
+}
+
+func TestPushRejects(t *testing.T) {
+	r := &recorder{}
+	c := newTestConsumer(t, Config{Push: true}, Handlers{events.OpDelete: r.handle})
+	server := httptest.NewServer(c.pushHandler(context.Background(), verifyPushToken))
+	defer server.Close()
+
+	data := encode(t, &events.Delete{Header: header, ObjectID: "job-1"})
+
+	// a forged message without the subscription's token
+	resp, err := server.Client().Post(server.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("push without a token = %d, want 401", resp.StatusCode)
+	}
+
+	resp, err = server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET = %d, want 405", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader([]byte(`{"message":`)))
+	req.Header.Set("Authorization", "Bearer "+pushToken)
+	resp, err = server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("push with a malformed body = %d, want 400", resp.StatusCode)
+	}
+
+	if r.count() != 0 {
+		t.Errorf("handler ran %d times for rejected requests", r.count())
+	}
+}
+
+// a memory bus of the test's own, so nothing is left over from other tests
+func useMemoryBus(t *testing.T) {
+	previous := bus.DefaultMemory
+	bus.DefaultMemory = bus.NewMemory()
+	t.Cleanup(func() { bus.DefaultMemory = previous })
+}
+
+// the same routing over the memory bus: acked, retried after a nack, quarantined and skipped
+func TestPull(t *testing.T) {
+	useMemoryBus(t)
+	bus.DefaultMemory.CreateSubscription("applications", "test-sub")
+
+	var added []*events.Add
+	var mu sync.Mutex
+	deletes := &recorder{errs: []error{errors.New("delete failed")}}
+	edits := &recorder{errs: []error{quarantine.Permanent(errors.New("bad edit"))}}
+
+	c := newTestConsumer(t, Config{
+		Bus:          bus.Config{Kind: bus.KindMemory},
+		Topic:        "applications",
+		Subscription: "test-sub",
+	}, Handlers{
+		events.OpAdd: Typed(func(ctx context.Context, event *events.Add) error {
+			mu.Lock()
+			defer mu.Unlock()
+			added = append(added, event)
+			return nil
+		}),
+		events.OpDelete:     deletes.handle,
+		events.OpEditStatus: edits.handle,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx, context.Background())
+	}()
+
+	publisher, err := bus.NewPublisher(context.Background(), bus.Config{Kind: bus.KindMemory}, "applications")
+	if err != nil {
+		t.Fatalf("NewPublisher: %v", err)
+	}
+	for i, event := range []events.Event{
+		&events.Add{Header: header, ObjectID: "job-1", Role: "SWE", Company: "Acme", AppliedDate: 1700000000, Status: "Applied", Timestamp: 1700000001},
+		&events.Delete{Header: header, ObjectID: "job-2"},
+		&events.EditStatus{Header: header, ObjectID: "job-3", Status: "Interviewing", AppliedDate: 1700000000, Timestamp: 1700000002},
+		&events.UserDelete{Header: header},
+	} {
+		// separate keys, so the nacked delete doesn't hold up the rest
+		if _, err := publisher.Publish(context.Background(), bus.Message{Data: encode(t, event), OrderingKey: string(rune('a' + i))}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	// the delete fails once and comes back after the memory bus's redelivery delay
+	deadline := time.Now().Add(5 * time.Second)
+	for deletes.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	mu.Lock()
+	if len(added) != 1 || added[0].ObjectID != "job-1" {
+		t.Errorf("added %+v, want job-1 once", added)
+	}
+	mu.Unlock()
+	if got := deletes.count(); got != 2 {
+		t.Errorf("delete ran %d times, want 2", got)
+	}
+	if len(deletes.jobs) == 2 && deletes.jobs[0].MessageID != deletes.jobs[1].MessageID {
+		t.Errorf("redelivered delete has message ID %q, want %q", deletes.jobs[1].MessageID, deletes.jobs[0].MessageID)
+	}
+	if got := edits.count(); got != 1 {
+		t.Errorf("edit ran %d times, want 1 before it was quarantined", got)
+	}
+	if msgs := quarantined(t, c); len(msgs) != 1 || msgs[0].ID != edits.jobs[0].MessageID {
+		t.Errorf("quarantined %+v, want just the edit", msgs)
+	}
+}
+
+func TestTyped(t *testing.T) {
+	var got *events.Delete
+	handler := Typed(func(ctx context.Context, event *events.Delete) error {
+		got = event
+		return nil
+	})
+
+	event := &events.Delete{Header: header, ObjectID: "job-1"}
+	if err := handler(context.Background(), &Job{Event: event, Operation: events.OpDelete}); err != nil {
+		t.Fatalf("handler: %v", err)
+	}
+	if got != event {
+		t.Errorf("handler got %+v, want %+v", got, event)
+	}
+
+	// registered for the wrong operation; retrying won't fix that
+	err := handler(context.Background(), &Job{Event: &events.UserDelete{Header: header}, Operation: events.OpUserDelete})
+	if !quarantine.IsPermanent(err) {
+		t.Errorf("handler = %v, want a permanent error", err)
+	}
+}
+
+// a pull consumer on its own subscription to the memory bus, and a publisher to its topic
+func newPullConsumer(t *testing.T, handlers Handlers) (*Consumer, bus.Publisher) {
+	t.Helper()
+
+	useMemoryBus(t)
+	bus.DefaultMemory.CreateSubscription("applications", "test-sub")
+	c := newTestConsumer(t, Config{
+		Bus:          bus.Config{Kind: bus.KindMemory},
+		Topic:        "applications",
+		Subscription: "test-sub",
+	}, handlers)
+
+	publisher, err := bus.NewPublisher(context.Background(), bus.Config{Kind: bus.KindMemory}, "applications")
+	if err != nil {
+		t.Fatalf("NewPublisher: %v", err)
+	}
+	return c, publisher
+}
+
+// a job still running when shutdown starts gets to finish, and Run waits for it
+func TestPullShutdownWaitsForJobs(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var jobErr error
+	c, publisher := newPullConsumer(t, Handlers{
+		events.OpDelete: func(ctx context.Context, job *Job) error {
+			close(started)
+			<-release
+			jobErr = ctx.Err()
+			return nil
+		},
+	})
+
+	ctx, stop := context.WithCancel(context.Background())
+	drain, cancelDrain := context.WithCancel(context.Background())
+	defer cancelDrain()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx, drain)
+	}()
+
+	if _, err := publisher.Publish(context.Background(), bus.Message{Data: encode(t, &events.Delete{Header: header, ObjectID: "job-1"})}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	<-started
+	stop()
+
+	select {
+	case <-done:
+		t.Fatal("Run returned while a job was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return once the job finished")
+	}
+	if jobErr != nil {
+		t.Errorf("job's context = %v once shutdown started, want it still running", jobErr)
+	}
+}
+
+// a job still running once drain is done is cancelled and nacked, so the bus redelivers it
+func TestPullShutdownCancelsLateJobs(t *testing.T) {
+	started := make(chan struct{})
+	redelivered := make(chan *Job, 1)
+	c, publisher := newPullConsumer(t, Handlers{
+		events.OpDelete: func(ctx context.Context, job *Job) error {
+			select {
+			case <-started:
+				redelivered <- job
+				return nil
+			default:
+			}
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+
+	ctx, stop := context.WithCancel(context.Background())
+	drain, cancelDrain := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx, drain)
+	}()
+
+	if _, err := publisher.Publish(context.Background(), bus.Message{Data: encode(t, &events.Delete{Header: header, ObjectID: "job-1"})}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	<-started
+	stop()
+	cancelDrain()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return once drain was done")
+	}
+	if msgs := quarantined(t, c); len(msgs) != 0 {
+		t.Errorf("quarantined %+v, want the cancelled job left for redelivery", msgs)
+	}
+
+	// whoever receives from the subscription next gets it again
+	ctx, stop = context.WithCancel(context.Background())
+	defer stop()
+	go c.Run(ctx, context.Background())
+	select {
+	case job := <-redelivered:
+		if job.Operation != events.OpDelete {
+			t.Errorf("redelivered %s, want the delete", job.Operation)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled job was never redelivered")
+	}
+}
@@ -0,0 +1,62 @@
+package consumer
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/copium-dev/copium/shared/bus"
+	"github.com/copium-dev/copium/shared/quarantine"
+)
+
+// receives from the subscription on the configured bus (see shared/bus) until ctx is done
+func (c *Consumer) RunPull(ctx context.Context, drain context.Context) {
+	// pull mode has no HTTP server of its own, so serve the admin endpoints separately
+	adminDone := make(chan struct{})
+	if c.admin {
+		log.Printf("[*] %s [*] Starting admin server on port %s", c.config.Name, c.config.AdminPort)
+		go func() {
+			defer close(adminDone)
+			c.serve(ctx, drain, &http.Server{Addr: ":" + c.config.AdminPort, Handler: c.mux})
+		}()
+	} else {
+		close(adminDone)
+	}
+
+	// subscribe to the topic (the subscription is created if it doesnt exist)
+	subscriber, err := bus.NewSubscriber(context.Background(), c.config.Bus, c.config.Topic, c.config.Subscription, bus.ReceiveSettings{
+		// limit max number of msgs we can receive at once
+		MaxOutstandingMessages: c.config.MaxOutstandingMessages,
+		// limit max number of goroutines spawned to process messages
+		NumGoroutines: c.config.NumGoroutines,
+	})
+	if err != nil {
+		log.Fatalf("Failed to subscribe to message bus: %v", err)
+	}
+	defer subscriber.Close()
+
+	// Receive calls the callback concurrently, so there's no worker pool of our own. once ctx is
+	// done it stops taking messages and returns when the callbacks in flight have
+	err = subscriber.Receive(ctx, func(_ context.Context, d *bus.Delivery) {
+		log.Printf("[*] %s [*] Received message: %s", c.config.Name, d.Data)
+
+		// not Receive's context, which is done as soon as shutdown starts
+		ack := c.handle(drain, quarantine.Message{
+			ID:         d.ID,
+			Data:       d.Data,
+			Attributes: d.Attributes,
+		}, d.DeliveryAttempt)
+		if ack {
+			d.Ack()
+		} else {
+			d.Nack()
+		}
+	})
+	if err != nil {
+		log.Printf("Error receiving messages: %v", err)
+		return
+	}
+
+	log.Printf("[*] %s [*] Stopped receiving, in-flight jobs finished", c.config.Name)
+	<-adminDone
+}
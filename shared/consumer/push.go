@@ -0,0 +1,77 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/copium-dev/copium/shared/pushauth"
+	"github.com/copium-dev/copium/shared/quarantine"
+)
+
+// the body of a push request
+type PubSubMessage struct {
+	Message struct {
+		Data       []byte            `json:"data,omitempty"`
+		ID         string            `json:"id"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+	// only set when the subscription has a dead letter policy
+	DeliveryAttempt int `json:"deliveryAttempt,omitempty"`
+}
+
+// serves the push endpoint (and the admin endpoints) on Config.Port until ctx is done
+func (c *Consumer) RunPush(ctx context.Context, drain context.Context) {
+	// the push subscription's OIDC token; without it anyone could post messages here
+	verifier, err := pushauth.New(c.config.PushAuth)
+	if err != nil {
+		log.Fatalf("Error initializing push authentication: %v", err)
+	}
+
+	c.mux.HandleFunc("/", c.pushHandler(drain, verifier.Verify))
+
+	log.Printf("[*] %s [*] Starting push subscription server on port %s", c.config.Name, c.config.Port)
+	c.serve(ctx, drain, &http.Server{Addr: ":" + c.config.Port, Handler: c.mux})
+}
+
+// the push endpoint: returns 2XX for ack (including quarantined messages), 5xx for retryable error
+// verify checks the request's push token before anything is read
+func (c *Consumer) pushHandler(drain context.Context, verify func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// only allow POST requests
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := verify(r); err != nil {
+			log.Printf("[*] %s [*] Rejected push request: %v", c.config.Name, err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var pubSubMessage PubSubMessage
+		if err := json.NewDecoder(r.Body).Decode(&pubSubMessage); err != nil {
+			log.Printf("Error parsing Pub/Sub message: %v", err)
+			http.Error(w, fmt.Sprintf("Error parsing message: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("[*] %s [*] Received Pub/Sub message: %s", c.config.Name, pubSubMessage.Message.Data)
+
+		// not the request's context: a job keeps going through shutdown until drain is done
+		ack := c.handle(drain, quarantine.Message{
+			ID:         pubSubMessage.Message.ID,
+			Data:       pubSubMessage.Message.Data,
+			Attributes: pubSubMessage.Message.Attributes,
+		}, pubSubMessage.DeliveryAttempt)
+		if !ack {
+			http.Error(w, "Failed to process job", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,319 @@
+package events
+
+// typed versions of the messages the API publishes to the applications topic
+// the API encodes them and both consumers decode them, so a field that is renamed or
+// removed here breaks the build everywhere instead of silently breaking a consumer
+// wire format is the same flat JSON object the API has always sent, e.g.
+//   {"version":2,"operation":"delete","eventID":"...","userID":"...","objectID":"abc"}
+// messages published before versioning have no "version" field and are read as version 1
+// version 1 messages were keyed by the user's email instead of their user ID; Decode reads it
+// into UserID, so they still apply to whatever is keyed by that email (cmd/migrate-user-ids re-keys it)
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// bump when a change is not backwards compatible (renamed/removed field, changed meaning)
+// adding an optional field does not need a new version
+// 2: events are keyed by userID instead of email
+const SchemaVersion = 2
+
+type Operation string
+
+const (
+	OpAdd             Operation = "add"
+	OpEditStatus      Operation = "editStatus"
+	OpEditApplication Operation = "editApplication"
+	OpDelete          Operation = "delete"
+	OpUserDelete      Operation = "userDelete"
+	OpRevert          Operation = "revert"
+	OpRevertLatest    Operation = "revertLatest"
+)
+
+var (
+	// the message is malformed; retrying it will never succeed
+	ErrInvalidEvent = errors.New("invalid event")
+	// the message was written by a newer API than this consumer understands
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+)
+
+// same values as userutils.ApplicationStatus in the API
+var validStatuses = map[string]bool{
+	"Applied":      true,
+	"Screen":       true,
+	"Interviewing": true,
+	"Offer":        true,
+	"Rejected":     true,
+	"Ghosted":      true,
+}
+
+// fields every event has; Version, Operation and (if empty) EventID are filled in by Encode
+type Header struct {
+	Version   int       `json:"version"`
+	Operation Operation `json:"operation"`
+	// unique per event and identical across redeliveries, so consumers can deduplicate on it
+	// it is also the operationID of the event in the event log. assigned by Encode if empty;
+	// only missing on messages published before it was added
+	EventID string `json:"eventID,omitempty"`
+	// the user's immutable ID; everything downstream is keyed by it
+	UserID string `json:"userID"`
+	// only set on version 1 messages, see Decode
+	Email string `json:"email,omitempty"`
+}
+
+func (h *Header) EventHeader() *Header {
+	return h
+}
+
+func (h *Header) validate() error {
+	if h.UserID == "" {
+		return invalid("missing userID")
+	}
+	return nil
+}
+
+// implemented by every event type in this package (and only those)
+type Event interface {
+	EventHeader() *Header
+	Validate() error
+	op() Operation
+}
+
+type Add struct {
+	Header
+	ObjectID    string `json:"objectID"`
+	Role        string `json:"role"`
+	Company     string `json:"company"`
+	Location    string `json:"location"`
+	Link        string `json:"link"`
+	AppliedDate int64  `json:"appliedDate"`
+	Status      string `json:"status"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+type EditStatus struct {
+	Header
+	ObjectID string `json:"objectID"`
+	Status   string `json:"status"`
+	// not used by Algolia, just to satisfy the BigQuery schema
+	AppliedDate int64 `json:"appliedDate"`
+	Timestamp   int64 `json:"timestamp"`
+}
+
+// status is edited separately (EditStatus), so it's not part of this event
+type EditApplication struct {
+	Header
+	ObjectID  string `json:"objectID"`
+	Role      string `json:"role"`
+	Company   string `json:"company"`
+	Location  string `json:"location"`
+	Link      string `json:"link"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type Delete struct {
+	Header
+	ObjectID string `json:"objectID"`
+}
+
+type UserDelete struct {
+	Header
+}
+
+// reverts an operation deeper in the history; current state doesn't change
+type Revert struct {
+	Header
+	ObjectID    string `json:"objectID"`
+	OperationID string `json:"operationID"`
+	Status      string `json:"status"`
+}
+
+// reverts the most recent operation; Status is the status the application goes back to
+type RevertLatest struct {
+	Header
+	ObjectID    string `json:"objectID"`
+	OperationID string `json:"operationID"`
+	Status      string `json:"status"`
+}
+
+func (e *Add) op() Operation             { return OpAdd }
+func (e *EditStatus) op() Operation      { return OpEditStatus }
+func (e *EditApplication) op() Operation { return OpEditApplication }
+func (e *Delete) op() Operation          { return OpDelete }
+func (e *UserDelete) op() Operation      { return OpUserDelete }
+func (e *Revert) op() Operation          { return OpRevert }
+func (e *RevertLatest) op() Operation    { return OpRevertLatest }
+
+func (e *Add) Validate() error {
+	if err := e.Header.validate(); err != nil {
+		return err
+	}
+	if e.ObjectID == "" {
+		return invalid("missing objectID")
+	}
+	if !validStatuses[e.Status] {
+		return invalid("invalid status %q", e.Status)
+	}
+	if e.AppliedDate <= 0 {
+		return invalid("missing appliedDate")
+	}
+	if e.Timestamp <= 0 {
+		return invalid("missing timestamp")
+	}
+	return nil
+}
+
+func (e *EditStatus) Validate() error {
+	if err := e.Header.validate(); err != nil {
+		return err
+	}
+	if e.ObjectID == "" {
+		return invalid("missing objectID")
+	}
+	if !validStatuses[e.Status] {
+		return invalid("invalid status %q", e.Status)
+	}
+	if e.Timestamp <= 0 {
+		return invalid("missing timestamp")
+	}
+	return nil
+}
+
+func (e *EditApplication) Validate() error {
+	if err := e.Header.validate(); err != nil {
+		return err
+	}
+	if e.ObjectID == "" {
+		return invalid("missing objectID")
+	}
+	if e.Timestamp <= 0 {
+		return invalid("missing timestamp")
+	}
+	return nil
+}
+
+func (e *Delete) Validate() error {
+	if err := e.Header.validate(); err != nil {
+		return err
+	}
+	if e.ObjectID == "" {
+		return invalid("missing objectID")
+	}
+	return nil
+}
+
+func (e *UserDelete) Validate() error {
+	return e.Header.validate()
+}
+
+func (e *Revert) Validate() error {
+	if err := e.Header.validate(); err != nil {
+		return err
+	}
+	if e.ObjectID == "" {
+		return invalid("missing objectID")
+	}
+	if e.OperationID == "" {
+		return invalid("missing operationID")
+	}
+	return nil
+}
+
+func (e *RevertLatest) Validate() error {
+	if err := e.Header.validate(); err != nil {
+		return err
+	}
+	if e.ObjectID == "" {
+		return invalid("missing objectID")
+	}
+	if e.OperationID == "" {
+		return invalid("missing operationID")
+	}
+	// empty when reverting the only status change; the application goes back to no status
+	if e.Status != "" && !validStatuses[e.Status] {
+		return invalid("invalid status %q", e.Status)
+	}
+	return nil
+}
+
+// random UUID (v4), same format the event log has always used for operationIDs
+func NewEventID() string {
+	return uuid.NewString()
+}
+
+// stamps the version, operation and event ID, validates, and marshals the event
+// the event ID is left on the event so the caller can use it too
+func Encode(e Event) ([]byte, error) {
+	header := e.EventHeader()
+	header.Version = SchemaVersion
+	header.Operation = e.op()
+	if header.EventID == "" {
+		header.EventID = NewEventID()
+	}
+
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(e)
+}
+
+// parses and validates a message; errors wrap ErrInvalidEvent or ErrUnsupportedVersion
+// use a type switch on the result to get at the operation-specific fields
+func Decode(data []byte) (Event, error) {
+	var header Header
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, invalid("failed to parse event: %v", err)
+	}
+
+	if header.Version == 0 {
+		header.Version = 1
+	}
+	if header.Version > SchemaVersion {
+		return nil, fmt.Errorf("%w: %d (latest supported is %d)", ErrUnsupportedVersion, header.Version, SchemaVersion)
+	}
+
+	var event Event
+	switch header.Operation {
+	case OpAdd:
+		event = &Add{}
+	case OpEditStatus:
+		event = &EditStatus{}
+	case OpEditApplication:
+		event = &EditApplication{}
+	case OpDelete:
+		event = &Delete{}
+	case OpUserDelete:
+		event = &UserDelete{}
+	case OpRevert:
+		event = &Revert{}
+	case OpRevertLatest:
+		event = &RevertLatest{}
+	case "":
+		return nil, invalid("missing operation")
+	default:
+		return nil, invalid("unknown operation: %s", header.Operation)
+	}
+
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, invalid("failed to parse %s event: %v", header.Operation, err)
+	}
+	event.EventHeader().Version = header.Version
+	if header.Version == 1 {
+		event.EventHeader().UserID = event.EventHeader().Email
+	}
+
+	if err := event.Validate(); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidEvent, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,161 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var header = Header{UserID: "user-1"}
+
+// one valid event per operation
+func validEvents() []Event {
+	return []Event{
+		&Add{Header: header, ObjectID: "job-1", Role: "SWE", Company: "Acme", AppliedDate: 1700000000, Status: "Applied", Timestamp: 1700000001},
+		&EditStatus{Header: header, ObjectID: "job-1", Status: "Interviewing", AppliedDate: 1700000000, Timestamp: 1700000002},
+		&EditApplication{Header: header, ObjectID: "job-1", Role: "Senior SWE", Timestamp: 1700000003},
+		&Delete{Header: header, ObjectID: "job-1"},
+		&UserDelete{Header: header},
+		&Revert{Header: header, ObjectID: "job-1", OperationID: "op-1", Status: "Applied"},
+		&RevertLatest{Header: header, ObjectID: "job-1", OperationID: "op-2", Status: "Applied"},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, event := range validEvents() {
+		t.Run(string(event.op()), func(t *testing.T) {
+			data, err := Encode(event)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if event.EventHeader().Version != SchemaVersion || event.EventHeader().Operation != event.op() || event.EventHeader().EventID == "" {
+				t.Errorf("Encode left header %+v, want version, operation and event ID stamped", *event.EventHeader())
+			}
+
+			decoded, err := Decode(data)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, event) {
+				t.Errorf("Decode(Encode(event)) = %+v, want %+v", decoded, event)
+			}
+		})
+	}
+}
+
+func TestEncodeKeepsEventID(t *testing.T) {
+	event := &Delete{Header: Header{UserID: "user-1", EventID: "event-1"}, ObjectID: "job-1"}
+	if _, err := Encode(event); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if event.EventID != "event-1" {
+		t.Errorf("EventID = %q, want the one set by the caller", event.EventID)
+	}
+}
+
+func TestNewEventID(t *testing.T) {
+	uuidV4 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	first, second := NewEventID(), NewEventID()
+	if !uuidV4.MatchString(first) {
+		t.Errorf("NewEventID() = %q, want a UUIDv4", first)
+	}
+	if first == second {
+		t.Errorf("NewEventID() returned %q twice", first)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+	}{
+		{"add without userID", &Add{ObjectID: "job-1", AppliedDate: 1, Status: "Applied", Timestamp: 1}},
+		{"add without objectID", &Add{Header: header, AppliedDate: 1, Status: "Applied", Timestamp: 1}},
+		{"add with unknown status", &Add{Header: header, ObjectID: "job-1", AppliedDate: 1, Status: "Hired", Timestamp: 1}},
+		{"add without appliedDate", &Add{Header: header, ObjectID: "job-1", Status: "Applied", Timestamp: 1}},
+		{"add without timestamp", &Add{Header: header, ObjectID: "job-1", AppliedDate: 1, Status: "Applied"}},
+		{"editStatus without userID", &EditStatus{ObjectID: "job-1", Status: "Offer", Timestamp: 1}},
+		{"editStatus without objectID", &EditStatus{Header: header, Status: "Offer", Timestamp: 1}},
+		{"editStatus without status", &EditStatus{Header: header, ObjectID: "job-1", Timestamp: 1}},
+		{"editStatus without timestamp", &EditStatus{Header: header, ObjectID: "job-1", Status: "Offer"}},
+		{"editApplication without userID", &EditApplication{ObjectID: "job-1", Timestamp: 1}},
+		{"editApplication without objectID", &EditApplication{Header: header, Timestamp: 1}},
+		{"editApplication without timestamp", &EditApplication{Header: header, ObjectID: "job-1"}},
+		{"delete without userID", &Delete{ObjectID: "job-1"}},
+		{"delete without objectID", &Delete{Header: header}},
+		{"userDelete without userID", &UserDelete{}},
+		{"revert without userID", &Revert{ObjectID: "job-1", OperationID: "op-1"}},
+		{"revert without objectID", &Revert{Header: header, OperationID: "op-1"}},
+		{"revert without operationID", &Revert{Header: header, ObjectID: "job-1"}},
+		{"revertLatest without userID", &RevertLatest{ObjectID: "job-1", OperationID: "op-1"}},
+		{"revertLatest without objectID", &RevertLatest{Header: header, OperationID: "op-1"}},
+		{"revertLatest without operationID", &RevertLatest{Header: header, ObjectID: "job-1"}},
+		{"revertLatest with unknown status", &RevertLatest{Header: header, ObjectID: "job-1", OperationID: "op-1", Status: "Hired"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Encode(tt.event); !errors.Is(err, ErrInvalidEvent) {
+				t.Errorf("Encode = %v, want ErrInvalidEvent", err)
+			}
+
+			// the same event from an API that didn't validate it
+			tt.event.EventHeader().Operation = tt.event.op()
+			data, err := json.Marshal(tt.event)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if _, err := Decode(data); !errors.Is(err, ErrInvalidEvent) {
+				t.Errorf("Decode = %v, want ErrInvalidEvent", err)
+			}
+		})
+	}
+}
+
+func TestRevertLatestWithoutStatus(t *testing.T) {
+	// reverting the only status change leaves no status
+	event := &RevertLatest{Header: header, ObjectID: "job-1", OperationID: "op-1"}
+	if err := event.Validate(); err != nil {
+		t.Errorf("Validate = %v, want nil", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{"not JSON", `{"operation":`, ErrInvalidEvent},
+		{"missing operation", `{"version":2,"userID":"user-1"}`, ErrInvalidEvent},
+		{"unknown operation", `{"version":2,"operation":"archive","userID":"user-1"}`, ErrInvalidEvent},
+		{"wrong field type", `{"version":2,"operation":"delete","userID":"user-1","objectID":7}`, ErrInvalidEvent},
+		{"newer version", `{"version":3,"operation":"delete","userID":"user-1","objectID":"job-1"}`, ErrUnsupportedVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode([]byte(tt.data)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decode = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeVersion1(t *testing.T) {
+	// published before versioning, keyed by email
+	event, err := Decode([]byte(`{"operation":"delete","email":"someone@example.com","objectID":"job-1"}`))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	deleted, ok := event.(*Delete)
+	if !ok {
+		t.Fatalf("Decode = %T, want *Delete", event)
+	}
+	if deleted.Version != 1 || deleted.UserID != "someone@example.com" || deleted.ObjectID != "job-1" {
+		t.Errorf("Decode = %+v, want version 1 with the email as userID", deleted)
+	}
+}
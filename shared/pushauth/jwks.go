@@ -0,0 +1,151 @@
+package pushauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// used when the JWKS response doesn't say how long it can be cached
+	defaultKeyTTL = time.Hour
+	// an unknown kid refetches the keys (Google rotates them), but at most this often
+	minRefreshInterval = time.Minute
+	fetchTimeout       = 10 * time.Second
+)
+
+// RSA keys from a JWKS URL, cached per the response's Cache-Control max-age
+type keySet struct {
+	url    string
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	expiresAt   time.Time
+	lastFetched time.Time
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func newKeySet(url string) *keySet {
+	return &keySet{
+		url:    url,
+		client: &http.Client{Timeout: fetchTimeout},
+		keys:   map[string]*rsa.PublicKey{},
+	}
+}
+
+func (s *keySet) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	key, ok := s.keys[kid]
+	expired := now.After(s.expiresAt)
+	if ok && !expired {
+		return key, nil
+	}
+
+	if expired || now.Sub(s.lastFetched) >= minRefreshInterval {
+		if err := s.refresh(ctx, now); err != nil {
+			// keep using the keys we have if the JWKS endpoint is down
+			if ok {
+				return key, nil
+			}
+			return nil, err
+		}
+		key, ok = s.keys[kid]
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// called with mu held
+func (s *keySet) refresh(ctx context.Context, now time.Time) error {
+	s.lastFetched = now
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
+	}
+
+	var set jwks
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		key, err := rsaKey(jwk.N, jwk.E)
+		if err != nil {
+			return fmt.Errorf("invalid key %q in JWKS: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+
+	s.keys = keys
+	s.expiresAt = now.Add(maxAge(resp.Header.Get("Cache-Control")))
+	return nil
+}
+
+func rsaKey(n string, e string) (*rsa.PublicKey, error) {
+	modulus, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	exponent, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	if len(modulus) == 0 || len(exponent) == 0 || len(exponent) > 4 {
+		return nil, fmt.Errorf("malformed modulus or exponent")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(modulus),
+		E: int(new(big.Int).SetBytes(exponent).Int64()),
+	}, nil
+}
+
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(directive), "max-age=")
+		if !ok {
+			continue
+		}
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultKeyTTL
+}
@@ -0,0 +1,106 @@
+package pushauth
+
+// authentication for Pub/Sub push endpoints: a push subscription with authentication enabled
+// sends every request with "Authorization: Bearer {OIDC token}", signed by Google for the
+// subscription's service account. the consumers are public Cloud Run URLs, so without checking
+// that token anyone could POST a forged message (e.g. a userDelete) to them
+//
+// a token is accepted if its signature checks out against the JWKS (Google's certs by default),
+// it hasn't expired, and its audience, issuer and (verified) email are the configured ones
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// where Google publishes the keys its OIDC tokens are signed with
+	DefaultJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+)
+
+// Google uses both forms for the issuer
+var DefaultIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+var ErrUnauthorized = errors.New("invalid or missing push token")
+
+type Config struct {
+	// the audience set on the push subscription (the push endpoint URL unless set otherwise)
+	Audience string
+	// the service account the push subscription authenticates as
+	ServiceAccountEmail string
+	// DefaultIssuers if empty
+	Issuers []string
+	// DefaultJWKSURL if empty
+	JWKSURL string
+}
+
+type Verifier struct {
+	config Config
+	keys   *keySet
+}
+
+type pushClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	jwt.RegisteredClaims
+}
+
+func New(config Config) (*Verifier, error) {
+	if config.Audience == "" {
+		return nil, fmt.Errorf("push audience is required")
+	}
+	if config.ServiceAccountEmail == "" {
+		return nil, fmt.Errorf("push service account email is required")
+	}
+	if len(config.Issuers) == 0 {
+		config.Issuers = DefaultIssuers
+	}
+	if config.JWKSURL == "" {
+		config.JWKSURL = DefaultJWKSURL
+	}
+
+	return &Verifier{
+		config: config,
+		keys:   newKeySet(config.JWKSURL),
+	}, nil
+}
+
+// checks the request's bearer token; every failure wraps ErrUnauthorized
+func (v *Verifier) Verify(r *http.Request) error {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return fmt.Errorf("%w: no bearer token", ErrUnauthorized)
+	}
+	return v.VerifyToken(r.Context(), strings.TrimPrefix(authHeader, "Bearer "))
+}
+
+func (v *Verifier) VerifyToken(ctx context.Context, tokenString string) error {
+	var claims pushClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.keys.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithAudience(v.config.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+
+	if !slices.Contains(v.config.Issuers, claims.Issuer) {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrUnauthorized, claims.Issuer)
+	}
+	if claims.Email != v.config.ServiceAccountEmail || !claims.EmailVerified {
+		return fmt.Errorf("%w: unexpected service account %q", ErrUnauthorized, claims.Email)
+	}
+
+	return nil
+}
@@ -0,0 +1,347 @@
+package pushauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testAudience       = "https://bigquery-consumer.example.com/push"
+	testServiceAccount = "pubsub-push@test-project.iam.gserviceaccount.com"
+)
+
+// a JWKS endpoint serving whichever keys are currently set, counting fetches
+type jwksServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int
+	down    bool
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	t.Helper()
+
+	s := &jwksServer{keys: map[string]*rsa.PrivateKey{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.fetches++
+		if s.down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		var set jwks
+		for kid, key := range s.keys {
+			set.Keys = append(set.Keys, struct {
+				Kty string `json:"kty"`
+				Kid string `json:"kid"`
+				N   string `json:"n"`
+				E   string `json:"e"`
+			}{
+				Kty: "RSA",
+				Kid: kid,
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// adds a new signing key under kid and returns it
+func (s *jwksServer) addKey(t *testing.T, kid string) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[kid] = key
+	return key
+}
+
+func (s *jwksServer) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+// claims of a valid push token
+func validClaims() pushClaims {
+	now := time.Now()
+	return pushClaims{
+		Email:         testServiceAccount,
+		EmailVerified: true,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "https://accounts.google.com",
+			Audience:  jwt.ClaimStrings{testAudience},
+			IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims pushClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func newTestVerifier(t *testing.T, server *jwksServer) *Verifier {
+	t.Helper()
+
+	verifier, err := New(Config{
+		Audience:            testAudience,
+		ServiceAccountEmail: testServiceAccount,
+		JWKSURL:             server.URL,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return verifier
+}
+
+func TestVerifyToken(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.addKey(t, "key-1")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr bool
+	}{
+		{"valid", func() string { return sign(t, key, "key-1", validClaims()) }, false},
+		{"other Google issuer form", func() string {
+			claims := validClaims()
+			claims.Issuer = "accounts.google.com"
+			return sign(t, key, "key-1", claims)
+		}, false},
+		{"wrong audience", func() string {
+			claims := validClaims()
+			claims.Audience = jwt.ClaimStrings{"https://algolia-consumer.example.com/push"}
+			return sign(t, key, "key-1", claims)
+		}, true},
+		{"wrong issuer", func() string {
+			claims := validClaims()
+			claims.Issuer = "https://evil.example.com"
+			return sign(t, key, "key-1", claims)
+		}, true},
+		{"wrong email", func() string {
+			claims := validClaims()
+			claims.Email = "someone@test-project.iam.gserviceaccount.com"
+			return sign(t, key, "key-1", claims)
+		}, true},
+		{"unverified email", func() string {
+			claims := validClaims()
+			claims.EmailVerified = false
+			return sign(t, key, "key-1", claims)
+		}, true},
+		{"expired", func() string {
+			claims := validClaims()
+			claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(-2 * time.Hour))
+			claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+			return sign(t, key, "key-1", claims)
+		}, true},
+		{"no expiry", func() string {
+			claims := validClaims()
+			claims.ExpiresAt = nil
+			return sign(t, key, "key-1", claims)
+		}, true},
+		{"unknown kid", func() string { return sign(t, key, "key-2", validClaims()) }, true},
+		{"signed by another key", func() string { return sign(t, otherKey, "key-1", validClaims()) }, true},
+		{"HMAC", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+			token.Header["kid"] = "key-1"
+			signed, err := token.SignedString([]byte("secret"))
+			if err != nil {
+				t.Fatalf("sign token: %v", err)
+			}
+			return signed
+		}, true},
+		{"garbage", func() string { return "not-a-jwt" }, true},
+	}
+
+	verifier := newTestVerifier(t, server)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifier.VerifyToken(context.Background(), tt.token())
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnauthorized) {
+					t.Errorf("VerifyToken = %v, want ErrUnauthorized", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("VerifyToken: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.addKey(t, "key-1")
+	verifier := newTestVerifier(t, server)
+
+	for _, header := range []string{"", "Basic abc", "Bearer"} {
+		r := httptest.NewRequest(http.MethodPost, "/push", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if err := verifier.Verify(r); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("Verify with Authorization %q = %v, want ErrUnauthorized", header, err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/push", nil)
+	r.Header.Set("Authorization", "Bearer "+sign(t, key, "key-1", validClaims()))
+	if err := verifier.Verify(r); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+// Google rotates its keys; a token with a new kid refetches the JWKS, but not more than once
+// per minRefreshInterval
+func TestKeyRotation(t *testing.T) {
+	server := newJWKSServer(t)
+	key1 := server.addKey(t, "key-1")
+	verifier := newTestVerifier(t, server)
+	ctx := context.Background()
+
+	if err := verifier.VerifyToken(ctx, sign(t, key1, "key-1", validClaims())); err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	if err := verifier.VerifyToken(ctx, sign(t, key1, "key-1", validClaims())); err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	if got := server.fetchCount(); got != 1 {
+		t.Fatalf("fetched the JWKS %d times, want 1 (cached)", got)
+	}
+
+	key2 := server.addKey(t, "key-2")
+	token := sign(t, key2, "key-2", validClaims())
+
+	// just fetched, so an unknown kid doesn't refetch yet
+	if err := verifier.VerifyToken(ctx, token); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("VerifyToken right after a fetch = %v, want ErrUnauthorized", err)
+	}
+	if got := server.fetchCount(); got != 1 {
+		t.Fatalf("fetched the JWKS %d times, want 1 (rate limited)", got)
+	}
+
+	verifier.keys.mu.Lock()
+	verifier.keys.lastFetched = time.Now().Add(-2 * minRefreshInterval)
+	verifier.keys.mu.Unlock()
+
+	if err := verifier.VerifyToken(ctx, token); err != nil {
+		t.Fatalf("VerifyToken after the refresh interval: %v", err)
+	}
+	if got := server.fetchCount(); got != 2 {
+		t.Errorf("fetched the JWKS %d times, want 2", got)
+	}
+}
+
+// keys past their max-age are refetched, and still used if the refetch fails
+func TestKeyExpiry(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.addKey(t, "key-1")
+	verifier := newTestVerifier(t, server)
+	ctx := context.Background()
+	token := sign(t, key, "key-1", validClaims())
+
+	if err := verifier.VerifyToken(ctx, token); err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	if ttl := time.Until(verifier.keys.expiresAt); ttl < 59*time.Minute || ttl > time.Hour {
+		t.Errorf("keys expire in %v, want the response's max-age (1h)", ttl)
+	}
+
+	expire := func() {
+		verifier.keys.mu.Lock()
+		verifier.keys.expiresAt = time.Now().Add(-time.Second)
+		verifier.keys.mu.Unlock()
+	}
+
+	expire()
+	if err := verifier.VerifyToken(ctx, token); err != nil {
+		t.Fatalf("VerifyToken after expiry: %v", err)
+	}
+	if got := server.fetchCount(); got != 2 {
+		t.Errorf("fetched the JWKS %d times, want 2", got)
+	}
+
+	expire()
+	server.setDown(true)
+	if err := verifier.VerifyToken(ctx, token); err != nil {
+		t.Errorf("VerifyToken with the JWKS endpoint down: %v", err)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	tests := map[string]time.Duration{
+		"public, max-age=19800, must-revalidate": 19800 * time.Second,
+		"max-age=60":                             time.Minute,
+		"no-cache":                               defaultKeyTTL,
+		"max-age=0":                              defaultKeyTTL,
+		"":                                       defaultKeyTTL,
+	}
+	for header, want := range tests {
+		if got := maxAge(header); got != want {
+			t.Errorf("maxAge(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{ServiceAccountEmail: testServiceAccount}); err == nil {
+		t.Error("New succeeded without an audience")
+	}
+	if _, err := New(Config{Audience: testAudience}); err == nil {
+		t.Error("New succeeded without a service account")
+	}
+
+	verifier, err := New(Config{Audience: testAudience, ServiceAccountEmail: testServiceAccount})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if verifier.keys.url != DefaultJWKSURL || len(verifier.config.Issuers) != len(DefaultIssuers) {
+		t.Errorf("defaults not applied: %+v", verifier.config)
+	}
+}
@@ -0,0 +1,129 @@
+package quarantine
+
+// admin endpoints for quarantined messages; every request needs "Authorization: Bearer {ADMIN_TOKEN}"
+//   GET    /admin/quarantine             -> list quarantined messages (without data)
+//   GET    /admin/quarantine/{id}        -> full message, including data
+//   POST   /admin/quarantine/{id}/replay -> process the message again; removed from quarantine on success
+//   DELETE /admin/quarantine/{id}        -> discard the message
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type summary struct {
+	ID               string `json:"id"`
+	DeliveryAttempts int    `json:"deliveryAttempts"`
+	Reason           string `json:"reason"`
+	QuarantinedAt    int64  `json:"quarantinedAt"`
+	Replays          int    `json:"replays"`
+}
+
+// registers the admin endpoints on mux; token must not be empty
+func (q *Quarantine) RegisterRoutes(mux *http.ServeMux, token string, process Processor) {
+	mux.Handle("GET /admin/quarantine", q.requireToken(token, q.list))
+	mux.Handle("GET /admin/quarantine/{id}", q.requireToken(token, q.inspect))
+	mux.Handle("POST /admin/quarantine/{id}/replay", q.requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		q.replay(w, r, process)
+	}))
+	mux.Handle("DELETE /admin/quarantine/{id}", q.requireToken(token, q.discard))
+}
+
+func (q *Quarantine) requireToken(token string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (q *Quarantine) list(w http.ResponseWriter, r *http.Request) {
+	msgs, err := q.store.List(r.Context())
+	if err != nil {
+		log.Printf("Error listing quarantined messages: %v", err)
+		http.Error(w, "Error listing quarantined messages", http.StatusInternalServerError)
+		return
+	}
+
+	summaries := make([]summary, 0, len(msgs))
+	for _, msg := range msgs {
+		summaries = append(summaries, summary{
+			ID:               msg.ID,
+			DeliveryAttempts: msg.DeliveryAttempts,
+			Reason:           msg.Reason,
+			QuarantinedAt:    msg.QuarantinedAt.Unix(),
+			Replays:          msg.Replays,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
+
+func (q *Quarantine) inspect(w http.ResponseWriter, r *http.Request) {
+	msg, err := q.store.Get(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting quarantined message: %v", err)
+		http.Error(w, "Error getting quarantined message", http.StatusInternalServerError)
+		return
+	}
+
+	// data is shown as a string since every message we publish is JSON
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":               msg.ID,
+		"data":             string(msg.Data),
+		"attributes":       msg.Attributes,
+		"deliveryAttempts": msg.DeliveryAttempts,
+		"reason":           msg.Reason,
+		"quarantinedAt":    msg.QuarantinedAt.Unix(),
+		"replays":          msg.Replays,
+	})
+}
+
+func (q *Quarantine) replay(w http.ResponseWriter, r *http.Request, process Processor) {
+	id := r.PathValue("id")
+
+	err := q.Replay(r.Context(), id, process)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Replay of quarantined message %s failed: %v", id, err)
+		http.Error(w, "Replay failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Quarantined message %s replayed", id)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (q *Quarantine) discard(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	err := q.store.Delete(r.Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting quarantined message: %v", err)
+		http.Error(w, "Error deleting quarantined message", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Quarantined message %s discarded", id)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,89 @@
+package quarantine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// quarantine/{consumer}/messages/{id}; survives restarts and is shared by every instance of the
+// consumer, which the memory and file stores aren't on Cloud Run
+type FirestoreStore struct {
+	client     *firestore.Client
+	collection *firestore.CollectionRef
+}
+
+// the client picks up FIRESTORE_EMULATOR_HOST by itself
+func NewFirestoreStore(ctx context.Context, projectID string, consumer string) (*FirestoreStore, error) {
+	if emulatorHost := os.Getenv("FIRESTORE_EMULATOR_HOST"); emulatorHost != "" {
+		log.Printf("Quarantining messages to the Firestore emulator at %s", emulatorHost)
+	}
+
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Firestore client: %w", err)
+	}
+
+	return &FirestoreStore{
+		client:     client,
+		collection: client.Collection("quarantine").Doc(consumer).Collection("messages"),
+	}, nil
+}
+
+func (s *FirestoreStore) Put(ctx context.Context, msg Message) error {
+	_, err := s.collection.Doc(msg.ID).Set(ctx, msg)
+	return err
+}
+
+func (s *FirestoreStore) List(ctx context.Context) ([]Message, error) {
+	docs, err := s.collection.OrderBy("quarantinedAt", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]Message, 0, len(docs))
+	for _, doc := range docs {
+		var msg Message
+		if err := doc.DataTo(&msg); err != nil {
+			return nil, fmt.Errorf("failed to parse quarantined message %s: %w", doc.Ref.ID, err)
+		}
+		msg.ID = doc.Ref.ID
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func (s *FirestoreStore) Get(ctx context.Context, id string) (*Message, error) {
+	doc, err := s.collection.Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	if err := doc.DataTo(&msg); err != nil {
+		return nil, fmt.Errorf("failed to parse quarantined message %s: %w", id, err)
+	}
+	msg.ID = id
+	return &msg, nil
+}
+
+func (s *FirestoreStore) Delete(ctx context.Context, id string) error {
+	// Exists makes a missing message an error instead of a silent no-op
+	_, err := s.collection.Doc(id).Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *FirestoreStore) Close() error {
+	return s.client.Close()
+}
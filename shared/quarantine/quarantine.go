@@ -0,0 +1,243 @@
+package quarantine
+
+// bounded retries for consumers: instead of nacking a message that keeps failing forever
+// (or returning 4xx to a push subscription, which Pub/Sub retries just the same), a message
+// is moved to a quarantine store once it has been delivered MAX_DELIVERY_ATTEMPTS times, or
+// right away if the error is permanent (e.g. the message can't be decoded). quarantined
+// messages are acked so they stop blocking the ordering key, and can be inspected and
+// replayed through the admin endpoints (see admin.go)
+// delivery attempts are what the bus reports: NATS and the memory bus always count them, Pub/Sub
+// only does when the subscription has a dead letter policy (give every deployed subscription one).
+// without a count from the bus, failures are counted here per message ID instead. that count is
+// per instance and forgotten on restart, so a message can be retried up to MAX_DELIVERY_ATTEMPTS
+// times on each instance it lands on; still bounded, just not as tightly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// used when Config.MaxDeliveryAttempts is not set
+const DefaultMaxDeliveryAttempts = 5
+
+var ErrNotFound = errors.New("quarantined message not found")
+
+type Message struct {
+	// Pub/Sub message ID
+	ID         string            `json:"id" firestore:"-"`
+	Data       []byte            `json:"data" firestore:"data"`
+	Attributes map[string]string `json:"attributes,omitempty" firestore:"attributes,omitempty"`
+	// delivery attempts when the message was quarantined
+	DeliveryAttempts int       `json:"deliveryAttempts" firestore:"deliveryAttempts"`
+	Reason           string    `json:"reason" firestore:"reason"`
+	QuarantinedAt    time.Time `json:"quarantinedAt" firestore:"quarantinedAt"`
+	// failed admin replays since the message was quarantined
+	Replays int `json:"replays" firestore:"replays"`
+}
+
+type Store interface {
+	// inserts or overwrites the message with the same ID
+	Put(ctx context.Context, msg Message) error
+	// oldest first
+	List(ctx context.Context) ([]Message, error)
+	Get(ctx context.Context, id string) (*Message, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// what the consumer does with a message; also used to replay quarantined messages
+type Processor func(ctx context.Context, data []byte) error
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// marks an error that retrying can never fix, so the message is quarantined on the first attempt
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// failures counted here for messages the bus reports no delivery attempt for; entries not seen
+// for localAttemptsTTL are dropped once there are more than maxLocalAttempts, so messages that
+// were retried on another instance don't pile up
+const (
+	maxLocalAttempts = 10000
+	localAttemptsTTL = time.Hour
+)
+
+type localAttempt struct {
+	count    int
+	lastSeen time.Time
+}
+
+type Quarantine struct {
+	store       Store
+	maxAttempts int
+
+	mu    sync.Mutex
+	local map[string]*localAttempt
+}
+
+func New(store Store, maxAttempts int) *Quarantine {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxDeliveryAttempts
+	}
+	return &Quarantine{
+		store:       store,
+		maxAttempts: maxAttempts,
+		local:       make(map[string]*localAttempt),
+	}
+}
+
+// store kinds for Config.Store
+const (
+	StoreFirestore = "firestore"
+	StoreFile      = "file"
+	StoreMemory    = "memory"
+)
+
+// where quarantined messages go (see shared/config for the MAX_DELIVERY_ATTEMPTS and QUARANTINE_*
+// settings it comes from)
+type Config struct {
+	// StoreFirestore, StoreFile or StoreMemory. deployed consumers have to use firestore, since
+	// Cloud Run instances lose their memory and disk
+	Store string
+	// StoreFile's directory
+	Dir string
+	// StoreFirestore's project
+	ProjectID string
+	// DefaultMaxDeliveryAttempts if unset
+	MaxDeliveryAttempts int
+}
+
+// consumer names the Firestore collection (quarantine/{consumer}/messages), so consumers sharing
+// a project don't replay each other's messages
+func Open(ctx context.Context, config Config, consumer string) (*Quarantine, error) {
+	var store Store
+	switch config.Store {
+	case StoreFirestore:
+		firestoreStore, err := NewFirestoreStore(ctx, config.ProjectID, consumer)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Quarantining messages to Firestore (quarantine/%s/messages)", consumer)
+		store = firestoreStore
+	case StoreFile:
+		if config.Dir == "" {
+			return nil, fmt.Errorf("the file store needs a directory")
+		}
+		fileStore, err := NewFileStore(config.Dir)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Quarantining messages to %s", config.Dir)
+		store = fileStore
+	case StoreMemory:
+		log.Println("Quarantined messages are kept in memory only")
+		store = NewMemoryStore()
+	default:
+		return nil, fmt.Errorf("unknown quarantine store: %q (expected %q, %q or %q)", config.Store, StoreFirestore, StoreFile, StoreMemory)
+	}
+
+	return New(store, config.MaxDeliveryAttempts), nil
+}
+
+func (q *Quarantine) Store() Store {
+	return q.store
+}
+
+// called when processing a message failed; deliveryAttempt is what the bus reported (0 if unknown)
+// returns true if the message is now quarantined and should be acked, false if it should be retried
+// if storing the message fails the error is returned and the message should be retried
+func (q *Quarantine) Failed(ctx context.Context, msg Message, deliveryAttempt int, processErr error) (bool, error) {
+	attempts := deliveryAttempt
+	if attempts <= 0 {
+		attempts = q.countLocally(msg.ID)
+	}
+
+	if !IsPermanent(processErr) && attempts < q.maxAttempts {
+		log.Printf("Message %s failed (attempt %d of %d), retrying: %v", msg.ID, attempts, q.maxAttempts, processErr)
+		return false, nil
+	}
+
+	msg.DeliveryAttempts = attempts
+	msg.Reason = processErr.Error()
+	msg.QuarantinedAt = time.Now()
+	if err := q.store.Put(ctx, msg); err != nil {
+		return false, fmt.Errorf("failed to quarantine message %s: %w", msg.ID, err)
+	}
+	q.Succeeded(msg.ID)
+
+	log.Printf("Message %s quarantined after %d attempt(s): %v", msg.ID, attempts, processErr)
+	return true, nil
+}
+
+// called once a message was processed (or quarantined), so its local failure count is dropped
+func (q *Quarantine) Succeeded(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.local, id)
+}
+
+// counts a failure of a message the bus gave no delivery attempt for; returns the attempts so far
+// messages without an ID can't be told apart, so each of their failures is the first
+func (q *Quarantine) countLocally(id string) int {
+	if id == "" {
+		return 1
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	if len(q.local) >= maxLocalAttempts {
+		for key, attempt := range q.local {
+			if now.Sub(attempt.lastSeen) > localAttemptsTTL {
+				delete(q.local, key)
+			}
+		}
+	}
+
+	attempt, ok := q.local[id]
+	if !ok {
+		attempt = &localAttempt{}
+		q.local[id] = attempt
+	}
+	attempt.count++
+	attempt.lastSeen = now
+	return attempt.count
+}
+
+// runs a quarantined message through process again; on success it's removed from quarantine,
+// on failure it stays quarantined with the new error as its reason
+func (q *Quarantine) Replay(ctx context.Context, id string, process Processor) error {
+	msg, err := q.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if processErr := process(ctx, msg.Data); processErr != nil {
+		msg.Reason = processErr.Error()
+		msg.Replays++
+		if err := q.store.Put(ctx, *msg); err != nil {
+			log.Printf("Failed to update quarantined message %s: %v", id, err)
+		}
+		return processErr
+	}
+
+	return q.store.Delete(ctx, id)
+}
@@ -0,0 +1,151 @@
+package quarantine
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFailed(t *testing.T) {
+	transient := errors.New("index unavailable")
+
+	tests := []struct {
+		name            string
+		deliveryAttempt int
+		err             error
+		wantQuarantined bool
+	}{
+		{"first attempt is retried", 1, transient, false},
+		{"attempts below the limit are retried", 2, transient, false},
+		{"last attempt is quarantined", 3, transient, true},
+		{"past the limit is quarantined", 7, transient, true},
+		{"permanent errors are quarantined right away", 1, Permanent(transient), true},
+		// no dead letter policy; counted locally, and this is the first failure
+		{"unknown attempt is retried", 0, transient, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			q := New(store, 3)
+			ctx := context.Background()
+
+			quarantined, err := q.Failed(ctx, Message{ID: "1", Data: []byte("{}")}, tt.deliveryAttempt, tt.err)
+			if err != nil {
+				t.Fatalf("Failed: %v", err)
+			}
+			if quarantined != tt.wantQuarantined {
+				t.Fatalf("quarantined = %v, want %v", quarantined, tt.wantQuarantined)
+			}
+
+			msg, err := store.Get(ctx, "1")
+			if !tt.wantQuarantined {
+				if !errors.Is(err, ErrNotFound) {
+					t.Fatalf("Get = %v, %v; want ErrNotFound", msg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if msg.DeliveryAttempts != tt.deliveryAttempt || msg.Reason != tt.err.Error() {
+				t.Errorf("stored %+v, want %d attempts and reason %q", msg, tt.deliveryAttempt, tt.err)
+			}
+		})
+	}
+}
+
+// attempts come from the bus only, so the same message failing on another instance (or after a
+// restart) is counted the same way
+func TestFailedUsesDeliveryAttempt(t *testing.T) {
+	q := New(NewMemoryStore(), 3)
+	ctx := context.Background()
+	msg := Message{ID: "1"}
+	err := errors.New("index unavailable")
+
+	for i := 0; i < 10; i++ {
+		quarantined, qErr := q.Failed(ctx, msg, 1, err)
+		if qErr != nil || quarantined {
+			t.Fatalf("call %d: Failed = %v, %v; want a retry every time the bus reports attempt 1", i, quarantined, qErr)
+		}
+	}
+}
+
+// without a delivery attempt from the bus the failures are counted per message ID
+func TestFailedCountsLocally(t *testing.T) {
+	store := NewMemoryStore()
+	q := New(store, 3)
+	ctx := context.Background()
+	err := errors.New("index unavailable")
+
+	for attempt := 1; attempt < 3; attempt++ {
+		if quarantined, qErr := q.Failed(ctx, Message{ID: "1"}, 0, err); qErr != nil || quarantined {
+			t.Fatalf("attempt %d: Failed = %v, %v; want a retry", attempt, quarantined, qErr)
+		}
+		// other messages have counts of their own
+		if quarantined, _ := q.Failed(ctx, Message{ID: "2"}, 0, err); quarantined {
+			t.Fatalf("attempt %d: message 2 quarantined", attempt)
+		}
+		q.Succeeded("2")
+	}
+
+	quarantined, qErr := q.Failed(ctx, Message{ID: "1"}, 0, err)
+	if qErr != nil || !quarantined {
+		t.Fatalf("Failed = %v, %v; want the third failure quarantined", quarantined, qErr)
+	}
+	msg, getErr := store.Get(ctx, "1")
+	if getErr != nil || msg.DeliveryAttempts != 3 {
+		t.Fatalf("Get = %+v, %v; want 3 attempts", msg, getErr)
+	}
+
+	// the count starts over once the message is gone
+	if quarantined, _ := q.Failed(ctx, Message{ID: "1"}, 0, err); quarantined {
+		t.Error("redelivered message quarantined on its first failure after being quarantined")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		store   string
+		dir     bool
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "memory", store: StoreMemory, want: &MemoryStore{}},
+		{name: "file", store: StoreFile, dir: true, want: &FileStore{}},
+		{name: "file without a directory", store: StoreFile, wantErr: true},
+		{name: "unknown store", store: "s3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{Store: tt.store, ProjectID: "test-project"}
+			if tt.dir {
+				config.Dir = t.TempDir()
+			}
+
+			q, err := Open(context.Background(), config, "test")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Open succeeded with store %T", q.Store())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+
+			switch tt.want.(type) {
+			case *MemoryStore:
+				if _, ok := q.Store().(*MemoryStore); !ok {
+					t.Errorf("store = %T, want *MemoryStore", q.Store())
+				}
+			case *FileStore:
+				if _, ok := q.Store().(*FileStore); !ok {
+					t.Errorf("store = %T, want *FileStore", q.Store())
+				}
+			}
+		})
+	}
+}
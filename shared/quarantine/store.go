@@ -0,0 +1,186 @@
+package quarantine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// for local dev or when losing quarantined messages on restart is acceptable
+type MemoryStore struct {
+	mu       sync.RWMutex
+	messages map[string]Message
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		messages: make(map[string]Message),
+	}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages[msg.ID] = msg
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	msgs := make([]Message, 0, len(s.messages))
+	for _, msg := range s.messages {
+		msgs = append(msgs, msg)
+	}
+	sortOldestFirst(msgs)
+	return msgs, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	msg, ok := s.messages[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &msg, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.messages[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.messages, id)
+	return nil
+}
+
+// one JSON file per message, named {id}.json
+type FileStore struct {
+	dir string
+	// serializes writes; reads go straight to disk
+	mu sync.Mutex
+}
+
+// Pub/Sub message IDs are numeric; anything that could escape the directory is rejected
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	return &FileStore{
+		dir: dir,
+	}, nil
+}
+
+func (s *FileStore) path(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", fmt.Errorf("invalid message ID: %q", id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *FileStore) Put(ctx context.Context, msg Message) error {
+	path, err := s.path(msg.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// write then rename so a crash never leaves a half-written message behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileStore) List(ctx context.Context) ([]Message, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []Message
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		msg, err := s.Get(ctx, strings.TrimSuffix(entry.Name(), ".json"))
+		if errors.Is(err, ErrNotFound) {
+			// replayed or deleted since ReadDir
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, *msg)
+	}
+
+	sortOldestFirst(msgs)
+	return msgs, nil
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (*Message, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to parse quarantined message %s: %w", id, err)
+	}
+	return &msg, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func sortOldestFirst(msgs []Message) {
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].QuarantinedAt.Before(msgs[j].QuarantinedAt)
+	})
+}
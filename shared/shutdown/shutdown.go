@@ -0,0 +1,50 @@
+package shutdown
+
+// graceful shutdown for the API and the consumers: the first SIGINT/SIGTERM stops intake (no new
+// requests or messages), then whatever is in flight gets SHUTDOWN_TIMEOUT to finish and flush
+// before its context is cancelled. Cloud Run kills the container 10s after SIGTERM, so the
+// default leaves no room to spare. a second signal kills the process right away
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// SHUTDOWN_TIMEOUT unless set (see shared/config)
+const DefaultTimeout = 10 * time.Second
+
+// cancelled on the first SIGINT or SIGTERM
+func Signal() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		// back to the default behaviour, so the next signal terminates
+		stop()
+	}()
+	return ctx, stop
+}
+
+// a context cancelled timeout after ctx is done; the one deadline everything draining on
+// shutdown shares, so the steps together never take longer than timeout
+func Deadline(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	drain, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-drain.Done():
+			return
+		}
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-drain.Done():
+		}
+	}()
+	return drain, cancel
+}
@@ -0,0 +1,45 @@
+package shutdown
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeadline(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	drain, cancel := Deadline(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	// the timeout only starts once ctx is done
+	select {
+	case <-drain.Done():
+		t.Fatal("drain done before shutdown started")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	stop()
+	start := time.Now()
+	select {
+	case <-drain.Done():
+	case <-time.After(time.Second):
+		t.Fatal("drain not done after the timeout")
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("drain done %s after shutdown started, want about the timeout", waited)
+	}
+}
+
+// everything finished early, so main cancels the deadline instead of waiting it out
+func TestDeadlineCancel(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	stop()
+	drain, cancel := Deadline(ctx, time.Hour)
+
+	cancel()
+	select {
+	case <-drain.Done():
+	case <-time.After(time.Second):
+		t.Fatal("drain not done after cancel")
+	}
+}